@@ -0,0 +1,174 @@
+// Команда mpy6a-bench прогоняет синтетическую нагрузку через весь конвейер
+// обработки сессий: запись лога операций, применение операций, сброс
+// сессий в источники, слияние источников и выдачу на повтор, см. bench.Run.
+//
+// Использование:
+//
+//	mpy6a-bench [flags]
+//
+// Нагрузка задаётся флагами -active, -themes, -data, -append, -store,
+// -delays и -interval, политика сброса лога – флагом -sync:
+//
+//   - none – данные уходят на диск только при заполнении буфера;
+//   - flush[:N] – сброс буфера в файл после каждых N событий;
+//   - fsync[:N] – сброс с синхронизацией с диском после каждых N событий.
+//
+// Распределение задержек повтора задаётся одним из видов fixed:<delay>,
+// uniform:<min>-<max> или exp:<mean>[,<min>[-<max>]]. При одинаковом
+// зерне -seed операции нагрузки совпадают между запусками.
+//
+// Без флага -dir файлы прогона создаются во временной директории, которая
+// удаляется по его окончании. С флагом -mem файлы хранятся в памяти, так
+// что в отчёте остаются только затраты самого конвейера. Отчёт содержит p50 и p99 задержки каждой
+// стадии, пропускную способность и объём записанных данных на операцию,
+// с флагом -json – в виде JSON.
+//
+// Коды завершения:
+//
+//   - 0 прогон завершён;
+//   - 2 прогон провести не удалось.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/bench"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Коды завершения.
+const (
+	exitOK    = 0
+	exitError = 2
+)
+
+func main() {
+	cfg := bench.DefaultConfig("")
+
+	var dir, data, delays, sync string
+	var res time.Duration
+	var asJSON, mem bool
+	flag.StringVar(&dir, "dir", "", "directory for the run files, a temporary one is used if empty")
+	flag.BoolVar(&mem, "mem", false, "keep the run files in memory, -dir is ignored")
+	flag.IntVar(&cfg.Ops, "ops", cfg.Ops, "number of operations")
+	flag.Int64Var(&cfg.Workload.Seed, "seed", cfg.Workload.Seed, "workload random seed")
+	flag.IntVar(&cfg.Workload.Active, "active", cfg.Workload.Active, "number of concurrently active sessions")
+	flag.IntVar(&cfg.Workload.Themes, "themes", cfg.Workload.Themes, "number of session themes")
+	flag.StringVar(
+		&data,
+		"data",
+		fmt.Sprintf("%d-%d", cfg.Workload.DataMin, cfg.Workload.DataMax),
+		"size range of appended data in bytes: <min>-<max>",
+	)
+	flag.IntVar(&cfg.Workload.Append, "append", cfg.Workload.Append, "relative weight of appends")
+	flag.IntVar(&cfg.Workload.Store, "store", cfg.Workload.Store, "relative weight of stores")
+	flag.StringVar(&delays, "delays", cfg.Workload.Delays.String(), "repeat delays distribution")
+	flag.DurationVar(&cfg.Workload.Interval, "interval", cfg.Workload.Interval, "virtual time step per operation")
+	flag.StringVar(&sync, "sync", cfg.Sync.String(), "operations log sync policy")
+	flag.DurationVar(&res, "resolution", time.Duration(cfg.Resolution), "repeat time resolution")
+	flag.IntVar(&cfg.FlushLimit, "flush-limit", cfg.FlushLimit, "saved sessions memory to flush them, 0 disables flushes")
+	flag.IntVar(&cfg.MergeAt, "merge-at", cfg.MergeAt, "number of sources to merge the two oldest, less than 2 disables merges")
+	flag.IntVar(&cfg.ReleaseEvery, "release-every", cfg.ReleaseEvery, "release due sessions every this many operations")
+	flag.IntVar(&cfg.ReleaseBatch, "release-batch", cfg.ReleaseBatch, "maximal number of sessions released at once")
+	flag.BoolVar(&asJSON, "json", false, "print report in JSON")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	if err := parseFlags(&cfg, data, delays, sync, res); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "parse flags"))
+		os.Exit(exitError)
+	}
+
+	report, err := run(cfg, dir, mem)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "run benchmark"))
+		os.Exit(exitError)
+	}
+
+	printReport := printText
+	if asJSON {
+		printReport = printJSON
+	}
+	if err := printReport(os.Stdout, report); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "print report"))
+		os.Exit(exitError)
+	}
+
+	os.Exit(exitOK)
+}
+
+// parseFlags заполнение cfg значениями флагов требующих разбора.
+func parseFlags(cfg *bench.Config, data, delays, sync string, res time.Duration) error {
+	lo, hi, ok := strings.Cut(data, "-")
+	if !ok {
+		return errors.New("data size range must be <min>-<max>").Str("data", data)
+	}
+	var err error
+	if cfg.Workload.DataMin, err = strconv.Atoi(lo); err != nil {
+		return errors.Wrap(err, "parse min data size").Str("data", data)
+	}
+	if cfg.Workload.DataMax, err = strconv.Atoi(hi); err != nil {
+		return errors.Wrap(err, "parse max data size").Str("data", data)
+	}
+
+	if cfg.Workload.Delays, err = bench.ParseDelays(delays); err != nil {
+		return errors.Wrap(err, "parse repeat delays")
+	}
+
+	if cfg.Sync, err = bench.ParseSyncPolicy(sync); err != nil {
+		return errors.Wrap(err, "parse sync policy")
+	}
+
+	cfg.Resolution = types.RepeatResolution(res)
+	return nil
+}
+
+// run прогон в директории dir, при пустом dir – во временной, с mem –
+// в памяти.
+func run(cfg bench.Config, dir string, mem bool) (*bench.Report, error) {
+	if mem {
+		fsys := fsio.NewMem()
+		cfg.Dir = "/bench"
+		if err := fsys.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, errors.Wrap(err, "create run directory in memory")
+		}
+		cfg.FS = fsys
+		return bench.Run(cfg)
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrap(err, "create run directory").Str("run-dir", dir)
+		}
+		cfg.Dir = dir
+		return bench.Run(cfg)
+	}
+
+	tmp, err := os.MkdirTemp("", "mpy6a-bench-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary directory")
+	}
+	cfg.Dir = tmp
+
+	report, err := bench.Run(cfg)
+	if rErr := os.RemoveAll(tmp); rErr != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(rErr, "remove temporary directory").Str("run-dir", tmp))
+	}
+
+	return report, err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/bench"
+)
+
+// printText вывод отчёта в читаемом виде: параметры прогона, таблица
+// задержек по стадиям и итоги.
+func printText(w io.Writer, r *bench.Report) error {
+	wl := r.Workload
+	lines := []string{
+		fmt.Sprintf(
+			"workload seed=%d active=%d themes=%d data=%d-%d append:store=%d:%d delays=%s interval=%s sync=%s",
+			wl.Seed, wl.Active, wl.Themes, wl.DataMin, wl.DataMax, wl.Append, wl.Store, wl.Delays, wl.Interval, r.Sync,
+		),
+		fmt.Sprintf("operations %d: new=%d append=%d store=%d", r.Ops, r.New, r.Appends, r.Stores),
+		fmt.Sprintf("%-8s %10s %12s %12s %12s", "stage", "count", "p50", "p99", "max"),
+	}
+	for _, st := range r.Stages {
+		lines = append(lines, fmt.Sprintf("%-8s %10d %12s %12s %12s", st.Name, st.Count, st.P50, st.P99, st.Max))
+	}
+	lines = append(
+		lines,
+		fmt.Sprintf("flushes=%d merges=%d released=%d", r.Flushes, r.Merges, r.Released),
+		fmt.Sprintf("degraded log: rejected=%d delayed-releases=%d", r.Rejected, r.DelayedReleases),
+		fmt.Sprintf("elapsed %s, %.0f ops/s", r.Elapsed, r.Throughput()),
+		fmt.Sprintf("log %.1f B/op, disk total %.1f B/op", r.LogBytesPerOp(), r.BytesPerOp()),
+	)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// jsonReport представление отчёта в JSON, длительности в наносекундах.
+type jsonReport struct {
+	Ops             int         `json:"ops"`
+	Seed            int64       `json:"seed"`
+	Sync            string      `json:"sync"`
+	Delays          string      `json:"delays"`
+	New             int         `json:"new"`
+	Appends         int         `json:"appends"`
+	Stores          int         `json:"stores"`
+	ElapsedNs       int64       `json:"elapsed_ns"`
+	OpsPerSecond    float64     `json:"ops_per_second"`
+	LogBytesPerOp   float64     `json:"log_bytes_per_op"`
+	BytesPerOp      float64     `json:"bytes_per_op"`
+	Flushes         int         `json:"flushes"`
+	Merges          int         `json:"merges"`
+	Released        int         `json:"released"`
+	Rejected        int         `json:"rejected"`
+	DelayedReleases int         `json:"delayed_releases"`
+	Stages          []jsonStage `json:"stages"`
+}
+
+// jsonStage представление сводки стадии в JSON.
+type jsonStage struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	P50Ns int64  `json:"p50_ns"`
+	P99Ns int64  `json:"p99_ns"`
+	MaxNs int64  `json:"max_ns"`
+}
+
+// printJSON вывод отчёта в JSON.
+func printJSON(w io.Writer, r *bench.Report) error {
+	res := jsonReport{
+		Ops:             r.Ops,
+		Seed:            r.Workload.Seed,
+		Sync:            r.Sync.String(),
+		Delays:          r.Workload.Delays.String(),
+		New:             r.New,
+		Appends:         r.Appends,
+		Stores:          r.Stores,
+		ElapsedNs:       r.Elapsed.Nanoseconds(),
+		OpsPerSecond:    r.Throughput(),
+		LogBytesPerOp:   r.LogBytesPerOp(),
+		BytesPerOp:      r.BytesPerOp(),
+		Flushes:         r.Flushes,
+		Merges:          r.Merges,
+		Released:        r.Released,
+		Rejected:        r.Rejected,
+		DelayedReleases: r.DelayedReleases,
+		Stages:          []jsonStage{},
+	}
+	for _, st := range r.Stages {
+		res.Stages = append(res.Stages, jsonStage{
+			Name:  st.Name,
+			Count: st.Count,
+			P50Ns: st.P50.Nanoseconds(),
+			P99Ns: st.P99.Nanoseconds(),
+			MaxNs: st.Max.Nanoseconds(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
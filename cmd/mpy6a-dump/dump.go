@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// header заголовок файла лога.
+type header struct {
+	Frame int    `json:"frame"`
+	Evlim int    `json:"evlim"`
+	Codec string `json:"codec"`
+}
+
+// event событие лога с раскодированной операцией.
+type event struct {
+	Index  types.Index `json:"index"`
+	Offset uint64      `json:"offset"`
+	Size   int         `json:"size"`
+
+	Op       string        `json:"op"`
+	Session  *types.Index  `json:"session,omitempty"`
+	Sessions []types.Index `json:"sessions,omitempty"`
+	Theme    *uint32       `json:"theme,omitempty"`
+	Repeat   *uint64       `json:"repeat,omitempty"`
+	Priority *uint8        `json:"priority,omitempty"`
+	Count    *uint32       `json:"count,omitempty"`
+	Source   *types.Index  `json:"source,omitempty"`
+	Digest   *uint32       `json:"digest,omitempty"`
+	Data     []byte        `json:"data,omitempty"`
+}
+
+// filter отбор выводимых событий. Нулевые значения означают
+// отсутствие ограничения.
+type filter struct {
+	from    *types.Index
+	to      *types.Index
+	session *types.Index
+	theme   *uint32
+
+	// Сессии заведённые в просмотренной части лога с темой theme.
+	themed map[types.Index]struct{}
+}
+
+// match проверка соответствия события фильтру.
+func (f *filter) match(e *event) bool {
+	if f.from != nil && types.IndexLess(e.Index, *f.from) {
+		return false
+	}
+
+	if f.session != nil && !e.hasSession(func(sid types.Index) bool { return sid == *f.session }) {
+		return false
+	}
+
+	if f.theme != nil {
+		if e.Op == opNew && *e.Theme == *f.theme {
+			if f.themed == nil {
+				f.themed = map[types.Index]struct{}{}
+			}
+			f.themed[*e.Session] = struct{}{}
+			return true
+		}
+
+		themed := e.hasSession(func(sid types.Index) bool {
+			_, ok := f.themed[sid]
+			return ok
+		})
+		if !themed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasSession проверка, что среди сессий события есть удовлетворяющая match.
+func (e *event) hasSession(match func(sid types.Index) bool) bool {
+	if e.Session != nil && match(*e.Session) {
+		return true
+	}
+	for _, sid := range e.Sessions {
+		if match(sid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dump вывод событий лога name прошедших фильтр f.
+func dump(name string, f *filter, p printer) (err error) {
+	var opts []logio.ReaderOption
+	if f.to != nil {
+		opts = append(opts, logio.ReaderReadTo(*f.to))
+	}
+
+	it, err := logio.NewReader(name, opts...)
+	if err != nil {
+		return errors.Wrap(err, "open log")
+	}
+	defer func() {
+		if cErr := it.Close(); cErr != nil && err == nil {
+			err = errors.Wrap(cErr, "close log")
+		}
+	}()
+
+	if err := p.header(header{
+		Frame: it.Frame(),
+		Evlim: it.EventLimit(),
+		Codec: it.Codec().String(),
+	}); err != nil {
+		return errors.Wrap(err, "print header")
+	}
+
+	for it.Next() {
+		id, data, size := it.Event()
+		e := event{
+			Index:  id,
+			Offset: it.Offset(),
+			Size:   size,
+		}
+		if err := logop.RecorderDispatch(&decoder{e: &e}, data); err != nil {
+			return errors.Wrap(err, "decode operation").Stg("event-id", id).Uint64("event-offset", e.Offset)
+		}
+
+		if !f.match(&e) {
+			continue
+		}
+
+		if err := p.event(&e); err != nil {
+			return errors.Wrap(err, "print event").Stg("event-id", id)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "read log")
+	}
+
+	return nil
+}
+
+// Названия операций, см. logop.
+const (
+	opNew             = "NEW"
+	opRecord          = "RECORD"
+	opRestore         = "RESTORE"
+	opDelete          = "DELETE"
+	opStore           = "STORE"
+	opStorePriority   = "STORE_PRIORITY"
+	opCancel          = "CANCEL"
+	opReschedule      = "RESCHEDULE"
+	opImport          = "IMPORT"
+	opRestoreSessions = "RESTORE_SESSIONS"
+)
+
+// decoder заполнение события данными операции.
+type decoder struct {
+	e *event
+}
+
+func (d *decoder) New(theme uint32) error {
+	// Идентификатор новой сессии равен индексу события.
+	sid := d.e.Index
+	d.e.Op = opNew
+	d.e.Session = &sid
+	d.e.Theme = &theme
+	return nil
+}
+
+func (d *decoder) Record(sid types.Index, data []byte) error {
+	d.e.Op = opRecord
+	d.e.Session = &sid
+	d.e.Data = append([]byte(nil), data...)
+	return nil
+}
+
+func (d *decoder) Restore(n uint32) error {
+	d.e.Op = opRestore
+	d.e.Count = &n
+	return nil
+}
+
+func (d *decoder) RestoreSessions(sids []types.Index) error {
+	d.e.Op = opRestoreSessions
+	d.e.Sessions = append([]types.Index(nil), sids...)
+	return nil
+}
+
+func (d *decoder) Delete(sid types.Index) error {
+	d.e.Op = opDelete
+	d.e.Session = &sid
+	return nil
+}
+
+func (d *decoder) Store(sid types.Index, repeat logop.OptionalRepeat) error {
+	d.e.Op = opStore
+	d.e.Session = &sid
+	if repeat != 0 {
+		r := uint64(repeat)
+		d.e.Repeat = &r
+	}
+	return nil
+}
+
+func (d *decoder) StorePriority(sid types.Index, repeat logop.OptionalRepeat, priority uint8) error {
+	if err := d.Store(sid, repeat); err != nil {
+		return err
+	}
+
+	d.e.Op = opStorePriority
+	d.e.Priority = &priority
+	return nil
+}
+
+func (d *decoder) Cancel(sid types.Index) error {
+	d.e.Op = opCancel
+	d.e.Session = &sid
+	return nil
+}
+
+func (d *decoder) Reschedule(sid types.Index, repeat uint64) error {
+	d.e.Op = opReschedule
+	d.e.Session = &sid
+	d.e.Repeat = &repeat
+	return nil
+}
+
+func (d *decoder) Import(src types.Index, digest uint32) error {
+	d.e.Op = opImport
+	d.e.Source = &src
+	d.e.Digest = &digest
+	return nil
+}
+
+// printer вывод заголовка и событий лога.
+type printer interface {
+	header(h header) error
+	event(e *event) error
+}
+
+// textPrinter вывод в читаемом виде.
+type textPrinter struct {
+	w io.Writer
+}
+
+func (p textPrinter) header(h header) error {
+	_, err := fmt.Fprintf(p.w, "frame=%d evlim=%d codec=%s\n", h.Frame, h.Evlim, h.Codec)
+	return err
+}
+
+func (p textPrinter) event(e *event) error {
+	_, err := fmt.Fprintf(p.w, "%s offset=%d size=%d %s\n", e.Index, e.Offset, e.Size, e.operation())
+	return err
+}
+
+// operation представление операции события.
+func (e *event) operation() string {
+	switch e.Op {
+	case opNew:
+		return fmt.Sprintf("%s theme=%d session=%s", e.Op, *e.Theme, e.Session)
+	case opRecord:
+		return fmt.Sprintf("%s session=%s data=%q", e.Op, e.Session, e.Data)
+	case opRestore:
+		return fmt.Sprintf("%s count=%d", e.Op, *e.Count)
+	case opStore, opStorePriority:
+		res := fmt.Sprintf("%s session=%s", e.Op, e.Session)
+		if e.Priority != nil {
+			res += fmt.Sprintf(" priority=%d", *e.Priority)
+		}
+		if e.Repeat != nil {
+			res += fmt.Sprintf(" repeat=%d", *e.Repeat)
+		}
+		return res
+	case opReschedule:
+		return fmt.Sprintf("%s session=%s repeat=%d", e.Op, e.Session, *e.Repeat)
+	case opImport:
+		return fmt.Sprintf("%s source=%s digest=%08x", e.Op, e.Source, *e.Digest)
+	case opRestoreSessions:
+		return fmt.Sprintf("%s sessions=%s", e.Op, e.Sessions)
+	default:
+		return fmt.Sprintf("%s session=%s", e.Op, e.Session)
+	}
+}
+
+// jsonPrinter вывод JSON объектов по одному в строке.
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func newJSONPrinter(w io.Writer) jsonPrinter {
+	return jsonPrinter{enc: json.NewEncoder(w)}
+}
+
+func (p jsonPrinter) header(h header) error {
+	return p.enc.Encode(h)
+}
+
+func (p jsonPrinter) event(e *event) error {
+	return p.enc.Encode(e)
+}
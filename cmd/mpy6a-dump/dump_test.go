@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestDump(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "oplog")
+	w, err := logio.NewWriter(name, 128, 64)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	var rec logop.Recorder
+	ops := [][]byte{
+		rec.New(1),
+		rec.New(2),
+		rec.Record(types.NewIndex(1, 0), []byte("data")),
+		rec.Record(types.NewIndex(1, 1), []byte("other")),
+		rec.StorePriority(types.NewIndex(1, 0), 5, 2),
+		rec.Delete(types.NewIndex(1, 1)),
+		rec.RestoreSessions([]types.Index{types.NewIndex(1, 0)}),
+	}
+	for i, op := range ops {
+		if _, err := w.WriteEvent(types.NewIndex(1, uint64(i)), op); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Int("event-no", i))
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	theme := uint32(1)
+	session := types.NewIndex(1, 0)
+	to := types.NewIndex(1, 4)
+	tests := []struct {
+		name   string
+		filter filter
+		want   []string
+	}{
+		{
+			name:   "all",
+			filter: filter{},
+			want: []string{
+				"NEW theme=1 session=0000000000000001-0000000000000000",
+				"NEW theme=2 session=0000000000000001-0000000000000001",
+				`RECORD session=0000000000000001-0000000000000000 data="data"`,
+				`RECORD session=0000000000000001-0000000000000001 data="other"`,
+				"STORE_PRIORITY session=0000000000000001-0000000000000000 priority=2 repeat=5",
+				"DELETE session=0000000000000001-0000000000000001",
+				"RESTORE_SESSIONS sessions=[0000000000000001-0000000000000000]",
+			},
+		},
+		{
+			name:   "theme",
+			filter: filter{theme: &theme, to: &to},
+			want: []string{
+				"NEW theme=1 session=0000000000000001-0000000000000000",
+				`RECORD session=0000000000000001-0000000000000000 data="data"`,
+				"STORE_PRIORITY session=0000000000000001-0000000000000000 priority=2 repeat=5",
+			},
+		},
+		{
+			name:   "session",
+			filter: filter{session: &session},
+			want: []string{
+				"NEW theme=1 session=0000000000000001-0000000000000000",
+				`RECORD session=0000000000000001-0000000000000000 data="data"`,
+				"STORE_PRIORITY session=0000000000000001-0000000000000000 priority=2 repeat=5",
+				"RESTORE_SESSIONS sessions=[0000000000000001-0000000000000000]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := dump(name, &tt.filter, textPrinter{w: &buf}); err != nil {
+				tlog.Error(t, errors.Wrap(err, "dump log"))
+				return
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if !strings.HasPrefix(lines[0], "frame=128 evlim=64") {
+				t.Errorf("unexpected header %q", lines[0])
+			}
+
+			var got []string
+			for _, line := range lines[1:] {
+				// Отбрасываем индекс, смещение и размер события.
+				fields := strings.SplitN(line, " ", 4)
+				got = append(got, fields[3])
+			}
+			if !deepequal.Equal(tt.want, got) {
+				t.Error("dump mismatch")
+				deepequal.SideBySide(t, "operations", tt.want, got)
+			}
+		})
+	}
+}
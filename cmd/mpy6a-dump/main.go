@@ -0,0 +1,100 @@
+// Команда mpy6a-dump выводит содержимое файла лога операций: заголовок
+// и события с раскодированными операциями.
+//
+// Использование:
+//
+//	mpy6a-dump [-from <index>] [-to <index>] [-session <sid>] [-theme <theme>] [-json] <log-file>
+//
+// Индексы задаются в виде <term>-<index> шестнадцатеричными числами, как
+// их выводит types.Index.String, ведущие нули можно опускать.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func main() {
+	var f filter
+	var from, to, session string
+	var theme int64
+	var asJSON bool
+	flag.StringVar(&from, "from", "", "dump events starting from this index")
+	flag.StringVar(&to, "to", "", "dump events up to this index inclusive")
+	flag.StringVar(&session, "session", "", "dump only operations with this session")
+	flag.Int64Var(&theme, "theme", -1, "dump only operations with sessions of this theme")
+	flag.BoolVar(&asJSON, "json", false, "dump as JSON lines")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <log-file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if f.from, err = parseOptionalIndex(from); err != nil {
+		fatal(errors.Wrap(err, "parse -from"))
+	}
+	if f.to, err = parseOptionalIndex(to); err != nil {
+		fatal(errors.Wrap(err, "parse -to"))
+	}
+	if f.session, err = parseOptionalIndex(session); err != nil {
+		fatal(errors.Wrap(err, "parse -session"))
+	}
+	if theme >= 0 {
+		if theme > 1<<32-1 {
+			fatal(errors.New("theme is out of range").Int64("invalid-theme", theme))
+		}
+		t := uint32(theme)
+		f.theme = &t
+	}
+
+	var p printer = textPrinter{w: os.Stdout}
+	if asJSON {
+		p = newJSONPrinter(os.Stdout)
+	}
+
+	if err := dump(flag.Arg(0), &f, p); err != nil {
+		fatal(errors.Wrap(err, "dump log").Str("log-name", flag.Arg(0)))
+	}
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// parseOptionalIndex разбор индекса в виде <term>-<index>, пустая
+// строка означает его отсутствие.
+func parseOptionalIndex(v string) (*types.Index, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	term, index, ok := strings.Cut(v, "-")
+	if !ok {
+		return nil, errors.New("index must be in <term>-<index> form").Str("invalid-index", v)
+	}
+
+	t, err := strconv.ParseUint(term, 16, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse term").Str("invalid-index", v)
+	}
+	i, err := strconv.ParseUint(index, 16, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse index").Str("invalid-index", v)
+	}
+
+	res := types.NewIndex(t, i)
+	return &res, nil
+}
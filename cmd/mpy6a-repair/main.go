@@ -0,0 +1,114 @@
+// Команда mpy6a-repair восстанавливает состояние директории с данными
+// при потерянном или повреждённом слепке: применяет все уцелевшие логи
+// операций с самого раннего события, регистрирует исправные источники и
+// записывает новый слепок, см. state.Repair.
+//
+// Использование:
+//
+//	mpy6a-repair [-resolution <duration>] <data-dir>
+//
+// Разрешение времени повтора в слепке не восстановить, оно задаётся
+// флагом -resolution и должно совпадать с настройками узла.
+//
+// Коды завершения:
+//
+//   - 0 состояние восстановлено без потерь;
+//   - 1 состояние восстановлено, но часть данных потеряна: остались
+//     разрывы в событиях, не применённые события или повреждённые источники;
+//   - 2 восстановление провести не удалось.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/state"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Коды завершения.
+const (
+	exitOK    = 0
+	exitLoss  = 1
+	exitError = 2
+)
+
+func main() {
+	var res time.Duration
+	flag.DurationVar(&res, "resolution", time.Second, "repeat time resolution of the node")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <data-dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	dir := flag.Arg(0)
+	report, err := state.Repair(dir, types.RepeatResolution(res), nil, func(err error) {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "repair data directory").Str("data-dir", dir))
+		os.Exit(exitError)
+	}
+
+	if err := printReport(os.Stdout, report); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "print report"))
+		os.Exit(exitError)
+	}
+
+	if !report.Clean() {
+		os.Exit(exitLoss)
+	}
+	os.Exit(exitOK)
+}
+
+// printReport вывод отчёта о восстановлении.
+func printReport(w io.Writer, r *state.RepairReport) error {
+	lines := []string{
+		fmt.Sprintf("snapshot %s id=%s events=%d", r.Snapshot, r.ID, r.Events),
+	}
+
+	for _, l := range r.Logs {
+		line := fmt.Sprintf("log %s first=%s last=%s", l.Name, l.First, l.Last)
+		if l.Err != nil {
+			line += ": " + l.Err.Error()
+		}
+		lines = append(lines, line)
+	}
+	for _, g := range r.Gaps {
+		lines = append(lines, fmt.Sprintf("gap after %s before %s", g.After, g.Before))
+	}
+	for _, e := range r.Failed {
+		lines = append(lines, fmt.Sprintf("failed event %s: %s", e.ID, e.Err))
+	}
+	for _, s := range r.Sources {
+		line := fmt.Sprintf("source %s %s", s.Name, s.Status)
+		if s.Err != nil {
+			line += ": " + s.Err.Error()
+		}
+		lines = append(lines, line)
+	}
+
+	result := "clean"
+	if !r.Clean() {
+		result = "data loss"
+	}
+	lines = append(lines, "result: "+result)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
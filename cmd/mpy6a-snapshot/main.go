@@ -0,0 +1,63 @@
+// Команда mpy6a-snapshot показывает содержимое слепков состояния.
+//
+// Использование:
+//
+//	mpy6a-snapshot inspect <snapshot>
+//	mpy6a-snapshot diff <snapshot-from> <snapshot-to>
+//
+// Подкоманда inspect выводит сводку по слепку: активные сессии по темам
+// с их возрастом и простоем в событиях, сохранённые сессии и описания
+// файлов. Подкоманда diff выводит различия второго слепка относительно
+// первого: добавленные и удалённые активные сессии, добавленные, удалённые
+// и перенесённые сохранённые сессии, изменения описаний файлов.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/state"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case os.Args[1] == "inspect" && len(os.Args) == 3:
+		summary, err := state.InspectSnapshot(os.Args[2])
+		if err != nil {
+			fatal(errors.Wrap(err, "inspect snapshot").Str("snapshot-name", os.Args[2]))
+		}
+		if err := printSummary(os.Stdout, summary); err != nil {
+			fatal(errors.Wrap(err, "print summary"))
+		}
+
+	case os.Args[1] == "diff" && len(os.Args) == 4:
+		diff, err := state.DiffSnapshots(os.Args[2], os.Args[3])
+		if err != nil {
+			fatal(errors.Wrap(err, "diff snapshots"))
+		}
+		if err := printDiff(os.Stdout, diff); err != nil {
+			fatal(errors.Wrap(err, "print diff"))
+		}
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage:\n")
+	_, _ = fmt.Fprintf(os.Stderr, "  %s inspect <snapshot>\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "  %s diff <snapshot-from> <snapshot-to>\n", os.Args[0])
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirkon/mpy6a/internal/state"
+)
+
+// printSummary вывод сводки по слепку.
+func printSummary(w io.Writer, s *state.SnapshotSummary) error {
+	p := &printer{w: w}
+	p.printf("snapshot id=%s resolution=%s\n", s.ID, s.Resolution.Interval())
+	p.printf("active sessions=%d\n", s.Active)
+
+	buckets := ageBucketNames()
+	for _, t := range s.Themes {
+		p.printf("  theme=%d sessions=%d oldest=%s stalest=%s\n", t.Theme, t.Sessions, t.Oldest, t.Stalest)
+		p.printf("    age:  %s\n", bucketCounts(buckets, t.Age))
+		p.printf("    idle: %s\n", bucketCounts(buckets, t.Idle))
+	}
+
+	p.printf("saved sessions=%d tombstones=%d bytes=%d\n", s.Saved, s.Tombstones, s.SavedBytes)
+	p.printf("files:\n")
+	for i := range s.Files {
+		p.printf("  %s\n", fileString(&s.Files[i]))
+	}
+
+	return p.err
+}
+
+// printDiff вывод различий слепков.
+func printDiff(w io.Writer, d *state.SnapshotDiff) error {
+	p := &printer{w: w}
+	p.printf("snapshots %s -> %s\n", d.From, d.To)
+	if d.Empty() {
+		p.printf("no differences\n")
+		return p.err
+	}
+
+	for _, sid := range d.ActiveAdded {
+		p.printf("+ active %s\n", sid)
+	}
+	for _, sid := range d.ActiveRemoved {
+		p.printf("- active %s\n", sid)
+	}
+	for _, s := range d.SavedAdded {
+		p.printf("+ saved %s repeat=%d priority=%d\n", s.ID, s.Repeat, s.Priority)
+	}
+	for _, s := range d.SavedRemoved {
+		p.printf("- saved %s repeat=%d priority=%d\n", s.ID, s.Repeat, s.Priority)
+	}
+	for _, m := range d.SavedMoved {
+		p.printf(
+			"~ saved %s repeat=%d priority=%d -> repeat=%d priority=%d\n",
+			m.ID,
+			m.From.Repeat,
+			m.From.Priority,
+			m.To.Repeat,
+			m.To.Priority,
+		)
+	}
+	for _, c := range d.Descriptors {
+		switch {
+		case c.Old == nil:
+			p.printf("+ %s\n", fileString(c.New))
+		case c.New == nil:
+			p.printf("- %s\n", fileString(c.Old))
+		default:
+			p.printf("~ %s\n    -> %s\n", fileString(c.Old), fileString(c.New))
+		}
+	}
+
+	return p.err
+}
+
+// fileString представление описания файла.
+func fileString(f *state.FileDescriptor) string {
+	var status string
+	switch {
+	case f.Current:
+		status = " current"
+	case f.Used:
+		status = " used"
+	}
+
+	if f.Kind == state.FileKindLog {
+		return fmt.Sprintf("%s %s%s first=%s last=%s len=%d", f.Kind, f.ID, status, f.First, f.Last, f.Len)
+	}
+	return fmt.Sprintf("%s %s%s pos=%d len=%d", f.Kind, f.ID, status, f.Pos, f.Len)
+}
+
+// ageBucketNames названия интервалов state.AgeBuckets.
+func ageBucketNames() []string {
+	res := make([]string, 0, len(state.AgeBuckets)+1)
+	for _, b := range state.AgeBuckets {
+		res = append(res, fmt.Sprintf("<%d", b))
+	}
+
+	return append(res, "older")
+}
+
+func bucketCounts(names []string, counts []int) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = fmt.Sprintf("%s=%d", names[i], c)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// printer форматированный вывод с запоминанием первой ошибки.
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, a ...any) {
+	if p.err != nil {
+		return
+	}
+
+	_, p.err = fmt.Fprintf(p.w, format, a...)
+}
@@ -0,0 +1,80 @@
+// Команда mpy6a-source показывает содержимое файлов источников и
+// расписание повторов сохранённых сессий.
+//
+// Использование:
+//
+//	mpy6a-source sessions [-now <time>] <source-file>
+//	mpy6a-source schedule [-now <time>] <data-dir>
+//
+// Подкоманда sessions выводит каждую сессию источника и сводку по нему.
+// Подкоманда schedule выводит сводку по всем сохранённым сессиям директории
+// с данными на момент последнего слепка: из зарегистрированных источников
+// и из памяти, отменённые сессии не учитываются.
+//
+// Сводка содержит количество сессий по темам и гистограммы времени
+// повтора на ближайшие час, сутки и неделю. Время отсчёта задаётся
+// флагом -now в формате RFC3339, по умолчанию это текущее время.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var run func(now time.Time, arg string) error
+	switch os.Args[1] {
+	case "sessions":
+		run = func(now time.Time, arg string) error {
+			return sessions(os.Stdout, arg, now)
+		}
+	case "schedule":
+		run = func(now time.Time, arg string) error {
+			return schedule(os.Stdout, arg, now)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	var now string
+	cmd.StringVar(&now, "now", "", "count repeat times relative to this moment (RFC3339)")
+	_ = cmd.Parse(os.Args[2:])
+	if cmd.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	at := time.Now()
+	if now != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, now); err != nil {
+			fatal(errors.Wrap(err, "parse -now").Str("invalid-time", now))
+		}
+	}
+
+	if err := run(at, cmd.Arg(0)); err != nil {
+		fatal(errors.Wrap(err, os.Args[1]).Str("argument", cmd.Arg(0)))
+	}
+}
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage:\n")
+	_, _ = fmt.Fprintf(os.Stderr, "  %s sessions [-now <time>] <source-file>\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "  %s schedule [-now <time>] <data-dir>\n", os.Args[0])
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
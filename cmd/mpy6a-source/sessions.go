@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/state"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// sessions вывод всех сессий источника name и сводки по ним.
+func sessions(w io.Writer, name string, now time.Time) error {
+	f, err := sourceio.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = f.Close()
+	}()
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		return errors.Wrap(err, "create iterator")
+	}
+
+	res := f.Resolution()
+	st := newStats(now)
+	for it.Next() {
+		_, repeat, sess := it.RepeatData()
+		at := res.Time(repeat)
+		_, err := fmt.Fprintf(
+			w,
+			"repeat=%d (%s) priority=%d id=%s change=%s repeats=%d theme=%d chunks=%d size=%d\n",
+			repeat,
+			at.UTC().Format(time.RFC3339Nano),
+			it.Priority(),
+			sess.ID,
+			sess.ChangeID,
+			sess.Repeats,
+			sess.Theme,
+			sess.Data.Chunks(),
+			sess.Data.Size(),
+		)
+		if err != nil {
+			return errors.Wrap(err, "print session")
+		}
+
+		st.add(at, &sess)
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "read sessions")
+	}
+
+	if _, err := fmt.Fprintln(w); err != nil {
+		return errors.Wrap(err, "print summary")
+	}
+	if err := st.print(w); err != nil {
+		return errors.Wrap(err, "print summary")
+	}
+
+	return nil
+}
+
+// schedule вывод сводки по всем сохранённым сессиям директории dir
+// на момент последнего слепка.
+func schedule(w io.Writer, dir string, now time.Time) error {
+	logger := func(err error) {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+
+	name, err := state.LatestSnapshot(dir, logger)
+	if err != nil {
+		return errors.Wrap(err, "look for the latest snapshot")
+	}
+
+	s, descs, err := state.ReadSnapshot(name, state.MemoryLimits{})
+	if err != nil {
+		return errors.Wrap(err, "read snapshot")
+	}
+	if err := s.LoadSources(dir, descs); err != nil {
+		return errors.Wrap(err, "load sources")
+	}
+
+	res := s.Resolution()
+	st := newStats(now)
+	err = s.ScanSaved(dir, descs, func(repeat uint64, _ types.Priority, sess *types.Session) error {
+		st.add(res.Time(repeat), sess)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "scan saved sessions")
+	}
+
+	if _, err := fmt.Fprintf(w, "snapshot %s id=%s\n", name, s.ID()); err != nil {
+		return errors.Wrap(err, "print summary")
+	}
+	if err := st.print(w); err != nil {
+		return errors.Wrap(err, "print summary")
+	}
+
+	return nil
+}
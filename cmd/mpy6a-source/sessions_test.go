@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestSessions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repeat := func(after time.Duration) uint64 {
+		return types.RepeatSecond.Repeat(now.Add(after))
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "source.src")
+	_, err := sourceio.WriteFile(
+		filepath.Join(dir, "tmp"),
+		name,
+		1024,
+		types.RepeatSecond,
+		func(w *sourceio.Writer) error {
+			saved := []struct {
+				repeat uint64
+				sess   types.Session
+			}{
+				{repeat(-time.Minute), types.NewSession(types.NewIndex(1, 1), 1, []byte("overdue"))},
+				{repeat(7 * time.Minute), types.NewSession(types.NewIndex(1, 2), 1, []byte("soon"))},
+				{repeat(3 * time.Hour), types.NewSession(types.NewIndex(1, 3), 2, []byte("later"))},
+				{repeat(30 * time.Hour), types.NewSession(types.NewIndex(1, 4), 2, []byte("tomorrow"))},
+				{repeat(10 * 24 * time.Hour), types.NewSession(types.NewIndex(1, 5), 3, []byte("someday"))},
+			}
+			for _, s := range saved {
+				if err := w.SaveSession(s.repeat, &s.sess); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write source"))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := sessions(&buf, name, now); err != nil {
+		tlog.Error(t, errors.Wrap(err, "print sessions"))
+		return
+	}
+	out := buf.String()
+	t.Log("\n" + out)
+
+	wants := []string{
+		"id=0000000000000001-0000000000000002 change=0000000000000001-0000000000000002 repeats=0 theme=1 chunks=1 size=4\n",
+		"sessions=5 size=31\n",
+		"  theme=1 sessions=2\n  theme=2 sessions=2\n  theme=3 sessions=1\n",
+		"  overdue=1\n  after a week=1\n",
+		"  next hour:\n    +0s       0\n    +5m0s     1\n",
+		"    +3h0m0s   1\n",
+		"  next week:\n    +0s       2\n    +24h0m0s  1\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("output has no %q", want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// histogram распределение времени повтора на отрезке от момента
+// отсчёта, разбитом на равные интервалы.
+type histogram struct {
+	name   string
+	step   time.Duration
+	counts []uint64
+}
+
+func newHistogram(name string, step time.Duration, n int) histogram {
+	return histogram{
+		name:   name,
+		step:   step,
+		counts: make([]uint64, n),
+	}
+}
+
+// add учёт сессии повторяемой через after после момента отсчёта.
+func (h *histogram) add(after time.Duration) {
+	if i := int(after / h.step); i < len(h.counts) {
+		h.counts[i]++
+	}
+}
+
+// stats сводка по сохранённым сессиям.
+type stats struct {
+	now time.Time
+
+	sessions uint64
+	size     uint64
+	themes   map[uint32]uint64
+
+	// overdue сессии время повтора которых уже наступило.
+	overdue uint64
+	// later сессии повторяемые позже чем через неделю.
+	later uint64
+
+	hour histogram
+	day  histogram
+	week histogram
+}
+
+func newStats(now time.Time) *stats {
+	return &stats{
+		now:    now,
+		themes: map[uint32]uint64{},
+		hour:   newHistogram("next hour", 5*time.Minute, 12),
+		day:    newHistogram("next day", time.Hour, 24),
+		week:   newHistogram("next week", 24*time.Hour, 7),
+	}
+}
+
+// add учёт сессии sess повторяемой в момент at.
+func (s *stats) add(at time.Time, sess *types.Session) {
+	s.sessions++
+	s.size += uint64(sess.Data.Size())
+	s.themes[uint32(sess.Theme)]++
+
+	after := at.Sub(s.now)
+	switch {
+	case after <= 0:
+		s.overdue++
+		return
+	case after > 7*24*time.Hour:
+		s.later++
+		return
+	}
+
+	s.hour.add(after)
+	s.day.add(after)
+	s.week.add(after)
+}
+
+// print вывод сводки.
+func (s *stats) print(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "sessions=%d size=%d\n", s.sessions, s.size); err != nil {
+		return err
+	}
+
+	themes := make([]uint32, 0, len(s.themes))
+	for theme := range s.themes {
+		themes = append(themes, theme)
+	}
+	sort.Slice(themes, func(i, j int) bool {
+		return themes[i] < themes[j]
+	})
+	if _, err := fmt.Fprintln(w, "sessions per theme:"); err != nil {
+		return err
+	}
+	for _, theme := range themes {
+		if _, err := fmt.Fprintf(w, "  theme=%d sessions=%d\n", theme, s.themes[theme]); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		"repeat schedule from %s:\n  overdue=%d\n  after a week=%d\n",
+		s.now.UTC().Format(time.RFC3339),
+		s.overdue,
+		s.later,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range []histogram{s.hour, s.day, s.week} {
+		if _, err := fmt.Fprintf(w, "  %s:\n", h.name); err != nil {
+			return err
+		}
+		for i, c := range h.counts {
+			_, err := fmt.Fprintf(w, "    +%-8s %d\n", time.Duration(i)*h.step, c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Команда mpy6a-verify проверяет директорию с данными без запуска: слепок,
+// наличие и целостность логов операций и источников, применимость лога
+// к слепку, см. state.Verify.
+//
+// Использование:
+//
+//	mpy6a-verify [-json] <data-dir>
+//
+// Коды завершения:
+//
+//   - 0 все проверки пройдены;
+//   - 1 найдены нарушения;
+//   - 2 проверку провести не удалось.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/state"
+)
+
+// Коды завершения.
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitError  = 2
+)
+
+func main() {
+	var asJSON bool
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <data-dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	dir := flag.Arg(0)
+	report, err := state.Verify(dir, nil, func(err error) {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "verify data directory").Str("data-dir", dir))
+		os.Exit(exitError)
+	}
+
+	if asJSON {
+		err = printJSON(os.Stdout, report)
+	} else {
+		err = printText(os.Stdout, report)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, errors.Wrap(err, "print report"))
+		os.Exit(exitError)
+	}
+
+	if report.Failed() {
+		os.Exit(exitFailed)
+	}
+	os.Exit(exitOK)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/state"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// printText вывод отчёта в читаемом виде, по проверке в строке.
+func printText(w io.Writer, r *state.VerifyReport) error {
+	if _, err := fmt.Fprintf(w, "snapshot %s id=%s\n", r.Snapshot, r.SnapshotID); err != nil {
+		return err
+	}
+
+	for _, c := range r.Checks {
+		status := "ok"
+		if c.Err != nil {
+			status = "FAIL"
+		}
+
+		line := fmt.Sprintf("%-4s %s", status, c.Kind)
+		if c.Name != "" {
+			line += " " + c.Name
+		}
+		if c.Err != nil {
+			line += ": " + c.Err.Error()
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	result := "OK"
+	if r.Failed() {
+		result = "FAILED"
+	}
+	_, err := fmt.Fprintf(w, "replayed %d events up to %s\nresult: %s\n", r.Events, r.LastID, result)
+	return err
+}
+
+// jsonReport представление отчёта в JSON.
+type jsonReport struct {
+	OK         bool        `json:"ok"`
+	Snapshot   string      `json:"snapshot"`
+	SnapshotID types.Index `json:"snapshot_id"`
+	LastID     types.Index `json:"last_id"`
+	Events     uint64      `json:"events"`
+	Checks     []jsonCheck `json:"checks"`
+}
+
+// jsonCheck представление результата проверки в JSON.
+type jsonCheck struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// printJSON вывод отчёта одним объектом JSON.
+func printJSON(w io.Writer, r *state.VerifyReport) error {
+	res := jsonReport{
+		OK:         !r.Failed(),
+		Snapshot:   r.Snapshot,
+		SnapshotID: r.SnapshotID,
+		LastID:     r.LastID,
+		Events:     r.Events,
+		Checks:     make([]jsonCheck, 0, len(r.Checks)),
+	}
+	for _, c := range r.Checks {
+		check := jsonCheck{
+			Kind: c.Kind,
+			Name: c.Name,
+			OK:   c.Err == nil,
+		}
+		if c.Err != nil {
+			check.Error = c.Err.Error()
+		}
+		res.Checks = append(res.Checks, check)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/golang/mock v1.6.0
+	github.com/golang/snappy v0.0.4
 	github.com/sirkon/deepequal v0.5.8
 	github.com/sirkon/errors v0.2.1
 	github.com/sirkon/intypes v0.0.3
@@ -0,0 +1,329 @@
+package bench
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestGeneratorReproducible(t *testing.T) {
+	w := DefaultWorkload()
+	w.Active = 16
+	w.Delays = Delays{Kind: DelayExponential, Mean: time.Second, Min: 100 * time.Millisecond, Max: time.Minute}
+
+	gen := func() []Op {
+		g, err := NewGenerator(w, types.RepeatMillisecond)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "create generator"))
+			return nil
+		}
+
+		var ops []Op
+		for i := 0; i < 1000; i++ {
+			op, err := g.Next()
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "generate operation"))
+				return nil
+			}
+			op.Data = bytes.Clone(op.Data)
+			ops = append(ops, op)
+		}
+
+		return ops
+	}
+
+	a := gen()
+	b := gen()
+	if len(a) == 0 {
+		return
+	}
+	deepequal.SideBySide(t, "operations", a, b)
+}
+
+func TestParseDelays(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Delays
+		wantErr bool
+	}{
+		{
+			in:   "fixed:30s",
+			want: Delays{Kind: DelayFixed, Min: 30 * time.Second},
+		},
+		{
+			in:   "uniform:1s-1m",
+			want: Delays{Kind: DelayUniform, Min: time.Second, Max: time.Minute},
+		},
+		{
+			in:   "exp:10s",
+			want: Delays{Kind: DelayExponential, Mean: 10 * time.Second},
+		},
+		{
+			in:   "exp:10s,1s-1h",
+			want: Delays{Kind: DelayExponential, Mean: 10 * time.Second, Min: time.Second, Max: time.Hour},
+		},
+		{
+			in:      "uniform:1m-1s",
+			wantErr: true,
+		},
+		{
+			in:      "normal:1s",
+			wantErr: true,
+		},
+		{
+			in:      "30s",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDelays(tt.in)
+			if err != nil {
+				if !tt.wantErr {
+					tlog.Error(t, errors.Wrap(err, "parse delays"))
+					return
+				}
+				tlog.Log(t, err)
+				return
+			}
+			if tt.wantErr {
+				t.Error("error expected")
+				return
+			}
+
+			deepequal.SideBySide(t, "delays", tt.want, got)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	cfg := DefaultConfig(t.TempDir())
+	cfg.Ops = 20000
+	cfg.Workload.Active = 100
+	cfg.FlushLimit = 64 * 1024
+	cfg.MergeAt = 3
+	// Часть сессий должна успевать повториться до сброса.
+	cfg.Workload.Delays = Delays{Kind: DelayExponential, Mean: time.Second}
+
+	report, err := Run(cfg)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "run workload"))
+		return
+	}
+
+	if report.New+report.Appends+report.Stores != cfg.Ops {
+		t.Errorf("operations count mismatch: %d new, %d appends, %d stores", report.New, report.Appends, report.Stores)
+	}
+	if report.Flushes == 0 || report.Merges == 0 || report.Released == 0 {
+		t.Errorf("all stages must run: %d flushes, %d merges, %d released", report.Flushes, report.Merges, report.Released)
+	}
+	for _, name := range []string{StageOp, StageLog, StageSync, StageApply} {
+		st, ok := report.Stage(name)
+		if !ok || st.Count != cfg.Ops {
+			t.Errorf("stage %s must be passed by every operation, got %d", name, st.Count)
+		}
+		if st.P50 > st.P99 || st.P99 > st.Max {
+			t.Errorf("stage %s has inconsistent percentiles: %s", name, st.Name)
+		}
+	}
+	if report.LogBytesPerOp() == 0 || report.BytesPerOp() <= report.LogBytesPerOp() {
+		t.Errorf("unexpected bytes per operation: log %f, total %f", report.LogBytesPerOp(), report.BytesPerOp())
+	}
+}
+
+func TestRunDegradedLog(t *testing.T) {
+	const dir = "/data"
+	fsys := &noSpaceFS{Mem: fsio.NewMem(), from: 300, to: 400}
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		tlog.Error(t, errors.Wrap(err, "create directory"))
+		return
+	}
+
+	cfg := DefaultConfig(dir)
+	cfg.FS = fsys
+	cfg.Ops = 2000
+	cfg.Workload.Active = 50
+	cfg.Workload.Delays = Delays{Kind: DelayFixed, Min: 10 * time.Millisecond}
+	cfg.FlushLimit = 0
+	cfg.MergeAt = 0
+	cfg.ReleaseEvery = 10
+
+	report, err := Run(cfg)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "run workload"))
+		return
+	}
+
+	// Место кончается на время, операции отклоняются, выдача
+	// откладывается, после освобождения места всё продолжается.
+	if report.Rejected == 0 || report.DelayedReleases == 0 || report.Released == 0 {
+		t.Errorf(
+			"expected rejected operations and delayed releases, got %d rejected, %d delayed, %d released",
+			report.Rejected,
+			report.DelayedReleases,
+			report.Released,
+		)
+	}
+	if report.New+report.Appends+report.Stores+report.Rejected != cfg.Ops {
+		t.Errorf(
+			"operations count mismatch: %d new, %d appends, %d stores, %d rejected",
+			report.New,
+			report.Appends,
+			report.Stores,
+			report.Rejected,
+		)
+	}
+
+	// Отклонённые операции не оставляют пропусков в логе.
+	it, err := logio.NewReaderFS(fsys, datadir.LogName(dir, types.NewIndex(1, 1)))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open log"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close log"))
+		}
+	}()
+
+	var count uint64
+	for it.Next() {
+		id, _, _ := it.Event()
+		count++
+		if id.Index != count {
+			t.Errorf("expected event %d, got %s", count, id)
+			return
+		}
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read log"))
+		return
+	}
+	if want := uint64(cfg.Ops - report.Rejected); count < want {
+		t.Errorf("expected at least %d events in the log, got %d", want, count)
+	}
+}
+
+// noSpaceFS файловая система в памяти, на которой нет места во время
+// записей в файлы с номерами от from до to, не включая to.
+type noSpaceFS struct {
+	*fsio.Mem
+	writes int
+	from   int
+	to     int
+}
+
+func (f *noSpaceFS) OpenFile(name string, flag int, perm os.FileMode) (fsio.File, error) {
+	file, err := f.Mem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &noSpaceFile{File: file, fs: f}, nil
+}
+
+func (f *noSpaceFS) write(name string) error {
+	f.writes++
+	if f.writes >= f.from && f.writes < f.to {
+		return &fs.PathError{Op: "write", Path: name, Err: syscall.ENOSPC}
+	}
+
+	return nil
+}
+
+type noSpaceFile struct {
+	fsio.File
+	fs *noSpaceFS
+}
+
+func (f *noSpaceFile) Write(p []byte) (int, error) {
+	if err := f.fs.write(f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *noSpaceFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.fs.write(f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.WriteAt(p, off)
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	policies := []SyncPolicy{
+		{Mode: SyncNone},
+		{Mode: SyncFlush, Every: 1},
+		{Mode: SyncFsync, Every: 100},
+		{Mode: SyncFsync, Every: 1},
+	}
+
+	for _, p := range policies {
+		b.Run(p.String(), func(b *testing.B) {
+			cfg := DefaultConfig(b.TempDir())
+			cfg.Ops = b.N
+			cfg.Sync = p
+			benchmarkRun(b, cfg)
+		})
+	}
+}
+
+func BenchmarkWorkload(b *testing.B) {
+	workloads := map[string]func(w *Workload){
+		"small-appends": func(w *Workload) {
+			w.DataMin, w.DataMax = 16, 64
+			w.Append = 8
+		},
+		"large-appends": func(w *Workload) {
+			w.DataMin, w.DataMax = 4096, 16384
+		},
+		"store-heavy": func(w *Workload) {
+			w.Append = 0
+		},
+		"many-themes": func(w *Workload) {
+			w.Themes = 1024
+		},
+		"long-delays": func(w *Workload) {
+			w.Delays = Delays{Kind: DelayExponential, Mean: time.Hour, Min: time.Minute}
+		},
+	}
+
+	for name, tune := range workloads {
+		b.Run(name, func(b *testing.B) {
+			cfg := DefaultConfig(b.TempDir())
+			cfg.Ops = b.N
+			tune(&cfg.Workload)
+			benchmarkRun(b, cfg)
+		})
+	}
+}
+
+func benchmarkRun(b *testing.B, cfg Config) {
+	b.ResetTimer()
+	report, err := Run(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+
+	op, _ := report.Stage(StageOp)
+	apply, _ := report.Stage(StageApply)
+	b.ReportMetric(float64(op.P50.Nanoseconds()), "p50-ns/op")
+	b.ReportMetric(float64(op.P99.Nanoseconds()), "p99-ns/op")
+	b.ReportMetric(float64(apply.P99.Nanoseconds()), "apply-p99-ns/op")
+	b.ReportMetric(report.BytesPerOp(), "disk-B/op")
+}
@@ -0,0 +1,24 @@
+// Package bench нагрузочное тестирование всего конвейера обработки
+// сессий на синтетической нагрузке.
+//
+// Нагрузка, см. Workload, задаётся числом одновременно активных сессий,
+// количеством тем, размерами дописываемых данных, соотношением дописываний
+// и сохранений и распределением задержек повтора. Генератор, см. Generator,
+// детерминирован: при одном и том же зерне выдаёт одни и те же операции,
+// так что результаты разных запусков сопоставимы.
+//
+// Прогон, см. Run, проводит каждую операцию через все стадии:
+//
+//   - запись события в лог операций с заданной политикой синхронизации,
+//     см. SyncPolicy;
+//   - применение операции к состоянию;
+//   - сброс сохранённых в памяти сессий в источник при превышении порога;
+//   - слияние накопившихся источников;
+//   - выдачу на повтор сессий, время повтора которых наступило.
+//
+// Время в прогоне виртуальное: каждая операция сдвигает его на
+// Workload.Interval, поэтому выдача на повтор не зависит от скорости
+// машины. Для каждой стадии отчёт содержит p50 и p99 задержки, для
+// прогона в целом – пропускную способность и объём записанных на диск
+// данных на операцию.
+package bench
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"sort"
+	"time"
+)
+
+// Latencies накопитель задержек одной стадии.
+type Latencies struct {
+	values []time.Duration
+	total  time.Duration
+}
+
+// Add учёт задержки d.
+func (l *Latencies) Add(d time.Duration) {
+	l.values = append(l.values, d)
+	l.total += d
+}
+
+// Count число учтённых задержек.
+func (l *Latencies) Count() int {
+	return len(l.values)
+}
+
+// Summary сводка по учтённым задержкам.
+func (l *Latencies) Summary() LatencySummary {
+	if len(l.values) == 0 {
+		return LatencySummary{}
+	}
+
+	sort.Slice(l.values, func(i, j int) bool {
+		return l.values[i] < l.values[j]
+	})
+
+	return LatencySummary{
+		Count: len(l.values),
+		P50:   l.percentile(50),
+		P99:   l.percentile(99),
+		Max:   l.values[len(l.values)-1],
+		Total: l.total,
+	}
+}
+
+// percentile значение перцентиля p по ближайшему рангу, задержки
+// должны быть отсортированы.
+func (l *Latencies) percentile(p int) time.Duration {
+	rank := (p*len(l.values) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return l.values[rank-1]
+}
+
+// LatencySummary сводка по задержкам стадии.
+type LatencySummary struct {
+	Count int
+	P50   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+	Total time.Duration
+}
@@ -0,0 +1,564 @@
+package bench
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/oplog"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/state"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Названия стадий в отчёте.
+const (
+	// StageOp обработка операции целиком, включая все стадии ниже.
+	StageOp = "op"
+
+	// StageLog запись события в лог операций без синхронизации.
+	StageLog = "log"
+
+	// StageSync сброс буфера лога или его синхронизация с диском.
+	StageSync = "sync"
+
+	// StageApply применение операции к состоянию.
+	StageApply = "apply"
+
+	// StageFlush сброс сохранённых в памяти сессий в источник.
+	StageFlush = "flush"
+
+	// StageMerge слияние двух источников.
+	StageMerge = "merge"
+
+	// StageRelease выдача на повтор сессий из памяти.
+	StageRelease = "release"
+)
+
+// sourceBlockSize размер блока создаваемых источников.
+const sourceBlockSize = 64 * 1024
+
+// SyncMode способ сброса лога операций на диск.
+type SyncMode int
+
+const (
+	// SyncNone данные лога уходят на диск только при заполнении буфера.
+	SyncNone SyncMode = iota
+
+	// SyncFlush сброс буфера лога в файл без синхронизации с диском.
+	SyncFlush
+
+	// SyncFsync сброс буфера лога с синхронизацией файла с диском.
+	SyncFsync
+)
+
+// SyncPolicy политика сброса лога операций: сброс способом Mode после
+// каждых Every событий.
+type SyncPolicy struct {
+	Mode  SyncMode
+	Every int
+}
+
+// ParseSyncPolicy разбор политики в виде none, flush[:<events>] или
+// fsync[:<events>]. По умолчанию сброс делается после каждого события.
+func ParseSyncPolicy(v string) (SyncPolicy, error) {
+	mode, every, ok := strings.Cut(v, ":")
+
+	res := SyncPolicy{Every: 1}
+	switch mode {
+	case "none":
+		if ok {
+			return SyncPolicy{}, errors.New("none policy takes no events count").Str("sync-policy", v)
+		}
+		return SyncPolicy{Mode: SyncNone}, nil
+	case "flush":
+		res.Mode = SyncFlush
+	case "fsync":
+		res.Mode = SyncFsync
+	default:
+		return SyncPolicy{}, errors.New("unknown sync mode").Str("sync-mode", mode)
+	}
+
+	if ok {
+		n, err := strconv.Atoi(every)
+		if err != nil {
+			return SyncPolicy{}, errors.Wrap(err, "parse events count").Str("sync-policy", v)
+		}
+		if n < 1 {
+			return SyncPolicy{}, errors.New("events count must be positive").Str("sync-policy", v)
+		}
+		res.Every = n
+	}
+
+	return res, nil
+}
+
+// String описание политики в виде принимаемом ParseSyncPolicy.
+func (p SyncPolicy) String() string {
+	switch p.Mode {
+	case SyncNone:
+		return "none"
+	case SyncFlush:
+		return "flush:" + strconv.Itoa(p.Every)
+	case SyncFsync:
+		return "fsync:" + strconv.Itoa(p.Every)
+	default:
+		return "unknown"
+	}
+}
+
+// Config параметры прогона.
+type Config struct {
+	// Dir директория для файлов прогона.
+	Dir string
+
+	// FS файловая система прогона, по умолчанию – файловая система ОС.
+	FS fsio.FS
+
+	// Ops число операций.
+	Ops int
+
+	Workload Workload
+	Sync     SyncPolicy
+
+	// Resolution разрешение времени повтора состояния.
+	Resolution types.RepeatResolution
+
+	// FlushLimit объём сохранённых в памяти сессий, при достижении
+	// которого они сбрасываются в источник. Ноль отключает сброс.
+	FlushLimit int
+
+	// MergeAt число источников, при достижении которого два соседних из
+	// них сливаются в один. Пара выбирается по подвалам источников, см.
+	// sourceio.PlanMerge. Значения меньше 2 отключают слияние.
+	MergeAt int
+
+	// ReleaseEvery и ReleaseBatch выдача на повтор не более ReleaseBatch
+	// сессий операцией лога после каждых ReleaseEvery операций. Выданные
+	// сессии снова участвуют в нагрузке. Нулевое значение любого из
+	// параметров отключает выдачу.
+	ReleaseEvery int
+	ReleaseBatch int
+}
+
+// DefaultConfig параметры прогона по умолчанию в директории dir.
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:          dir,
+		Ops:          100000,
+		Workload:     DefaultWorkload(),
+		Sync:         SyncPolicy{Mode: SyncFlush, Every: 1},
+		Resolution:   types.RepeatMillisecond,
+		FlushLimit:   4 * 1024 * 1024,
+		MergeAt:      4,
+		ReleaseEvery: 100,
+		ReleaseBatch: 1000,
+	}
+}
+
+// Report отчёт о прогоне.
+type Report struct {
+	Ops      int
+	Workload Workload
+	Sync     SyncPolicy
+
+	// New, Appends и Stores число операций каждого вида.
+	New     int
+	Appends int
+	Stores  int
+
+	// Elapsed суммарное время обработки операций без учёта их генерации.
+	Elapsed time.Duration
+
+	// Stages сводки задержек по стадиям, см. константы Stage*.
+	Stages []StageReport
+
+	// LogBytes объём записанных в лог данных, SourceBytes – объём
+	// созданных сбросами и слияниями источников.
+	LogBytes    uint64
+	SourceBytes uint64
+
+	Flushes  int
+	Merges   int
+	Released int
+
+	// Rejected операции отклонённые в деградированном режиме лога, см.
+	// oplog, DelayedReleases – отложенные по той же причине выдачи на
+	// повтор.
+	Rejected        int
+	DelayedReleases int
+}
+
+// StageReport сводка задержек стадии.
+type StageReport struct {
+	Name string
+	LatencySummary
+}
+
+// Throughput число операций в секунду.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// LogBytesPerOp объём записанных в лог данных на операцию.
+func (r *Report) LogBytesPerOp() float64 {
+	return perOp(r.LogBytes, r.Ops)
+}
+
+// BytesPerOp объём всех записанных на диск данных на операцию.
+func (r *Report) BytesPerOp() float64 {
+	return perOp(r.LogBytes+r.SourceBytes, r.Ops)
+}
+
+// Stage сводка стадии name.
+func (r *Report) Stage(name string) (StageReport, bool) {
+	for _, st := range r.Stages {
+		if st.Name == name {
+			return st, true
+		}
+	}
+
+	return StageReport{}, false
+}
+
+func perOp(v uint64, ops int) float64 {
+	if ops == 0 {
+		return 0
+	}
+
+	return float64(v) / float64(ops)
+}
+
+// Run прогон нагрузки с параметрами cfg.
+func Run(cfg Config) (*Report, error) {
+	gen, err := NewGenerator(cfg.Workload, cfg.Resolution)
+	if err != nil {
+		return nil, errors.Wrap(err, "create workload generator")
+	}
+
+	r, err := newRunner(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "set up pipeline")
+	}
+
+	report, err := r.run(gen)
+	if cErr := r.log.Close(); cErr != nil {
+		if err != nil {
+			return nil, errors.Wrap(err, "run workload").Str("close-error", cErr.Error())
+		}
+
+		return nil, errors.Wrap(cErr, "close log")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "run workload")
+	}
+
+	return report, nil
+}
+
+// runner конвейер обработки операций.
+type runner struct {
+	cfg     Config
+	log     *oplog.Log
+	state   *state.State
+	descs   *state.Descriptors
+	applier *state.Applier
+
+	// sources источники от более старых к более новым, footers – их
+	// подвалы.
+	sources []types.Index
+	footers []*sourceio.Footer
+	srcseq  uint64
+
+	stages map[string]*Latencies
+	report Report
+}
+
+func newRunner(cfg Config) (*runner, error) {
+	if cfg.FS == nil {
+		cfg.FS = fsio.OS{}
+	}
+
+	s, err := state.NewState(cfg.Resolution, state.MemoryLimits{Flush: cfg.FlushLimit})
+	if err != nil {
+		return nil, errors.Wrap(err, "create state")
+	}
+	s.SetTime(generatorStart)
+
+	// Операция с наибольшими данными: код, идентификатор сессии и
+	// длина данных.
+	evlim := cfg.Workload.DataMax + 4 + 16 + 10
+	frame := 16 + 10 + evlim
+	if frame < sourceBlockSize {
+		frame = sourceBlockSize
+	}
+	w, err := logio.NewWriterFS(cfg.FS, datadir.LogName(cfg.Dir, types.NewIndex(1, 1)), frame, evlim)
+	if err != nil {
+		return nil, errors.Wrap(err, "create operations log")
+	}
+
+	descs := &state.Descriptors{}
+	descs.SetFS(cfg.FS)
+
+	return &runner{
+		cfg:     cfg,
+		log:     oplog.New(cfg.FS, cfg.Dir, w),
+		state:   s,
+		descs:   descs,
+		applier: state.NewApplier(s, cfg.Dir, descs, nil),
+		stages: map[string]*Latencies{
+			StageOp:      {},
+			StageLog:     {},
+			StageSync:    {},
+			StageApply:   {},
+			StageFlush:   {},
+			StageMerge:   {},
+			StageRelease: {},
+		},
+		report: Report{
+			Ops:      cfg.Ops,
+			Workload: cfg.Workload,
+			Sync:     cfg.Sync,
+		},
+	}, nil
+}
+
+func (r *runner) run(gen *Generator) (*Report, error) {
+	for i := 1; i <= r.cfg.Ops; i++ {
+		op, err := gen.Next()
+		if err != nil {
+			return nil, errors.Wrap(err, "generate operation").Int("operation-number", i)
+		}
+
+		start := time.Now()
+		err = r.process(i, op, gen.Now())
+		switch {
+		case staterr.AsCode(err) == staterr.CodeStorageUnavailable:
+			// Операция не попала в лог и не применялась.
+			gen.Reject(op)
+			r.report.Rejected++
+		case err != nil:
+			return nil, errors.Wrap(err, "process operation").Stg("event-id", op.ID)
+		default:
+			r.stages[StageOp].Add(time.Since(start))
+			switch op.Kind {
+			case OpNew:
+				r.report.New++
+			case OpAppend:
+				r.report.Appends++
+			case OpStore:
+				r.report.Stores++
+			}
+		}
+
+		if r.cfg.ReleaseEvery > 0 && r.cfg.ReleaseBatch > 0 && i%r.cfg.ReleaseEvery == 0 {
+			if err := r.release(gen); err != nil {
+				return nil, errors.Wrap(err, "release due sessions").Int("operation-number", i)
+			}
+		}
+	}
+
+	// Все записанные события должны оказаться в файле.
+	if err := r.sync(SyncFlush); err != nil {
+		return nil, errors.Wrap(err, "flush log")
+	}
+
+	for _, name := range []string{StageOp, StageLog, StageSync, StageApply, StageFlush, StageMerge, StageRelease} {
+		r.report.Stages = append(r.report.Stages, StageReport{
+			Name:           name,
+			LatencySummary: r.stages[name].Summary(),
+		})
+	}
+	r.report.Elapsed = r.stages[StageOp].total
+
+	return &r.report, nil
+}
+
+// process обработка i-й операции op в момент виртуального времени now.
+// Операция отклонённая в деградированном режиме лога отдаётся ошибкой с
+// кодом staterr.CodeStorageUnavailable и не применяется.
+func (r *runner) process(i int, op Op, now time.Time) error {
+	start := time.Now()
+	n, err := r.log.WriteEvent(op.ID, op.Data)
+	if err != nil {
+		return errors.Wrap(err, "write event")
+	}
+	r.stages[StageLog].Add(time.Since(start))
+	r.report.LogBytes += uint64(n)
+
+	if r.cfg.Sync.Mode != SyncNone && i%r.cfg.Sync.Every == 0 {
+		start = time.Now()
+		err := r.sync(r.cfg.Sync.Mode)
+		if err != nil && staterr.AsCode(err) != staterr.CodeStorageUnavailable {
+			return errors.Wrap(err, "sync log")
+		}
+		// При сбое сброса событие остаётся в буфере лога и будет
+		// записано при восстановлении записи, см. oplog.
+		r.stages[StageSync].Add(time.Since(start))
+	}
+
+	start = time.Now()
+	if err := r.applier.Apply(op.ID, op.Data); err != nil {
+		return errors.Wrap(err, "apply operation")
+	}
+	r.stages[StageApply].Add(time.Since(start))
+
+	if r.state.NeedFlush() {
+		if err := r.flush(); err != nil {
+			return errors.Wrap(err, "flush saved sessions")
+		}
+	}
+
+	if r.cfg.MergeAt >= 2 && len(r.sources) >= r.cfg.MergeAt {
+		if err := r.merge(now); err != nil {
+			return errors.Wrap(err, "merge sources")
+		}
+	}
+
+	r.state.SetTime(now)
+	return nil
+}
+
+// release выдача на повтор наступивших сессий операцией лога. Если лог
+// в деградированном режиме и восстановить запись не удалось, выдача
+// откладывается: сессии остаются сохранёнными и будут найдены следующей
+// выдачей, а токены ограничения скорости не расходуются.
+func (r *runner) release(gen *Generator) error {
+	start := time.Now()
+	due, err := r.state.ReleaseDue(r.cfg.Dir, r.descs, r.cfg.ReleaseBatch)
+	if err != nil {
+		return errors.Wrap(err, "look for due sessions")
+	}
+	if len(due) == 0 {
+		r.stages[StageRelease].Add(time.Since(start))
+		return nil
+	}
+
+	sids := make([]types.Index, len(due))
+	for i := range due {
+		sids[i] = due[i].ID
+	}
+	op := gen.Restore(sids)
+	n, err := r.log.WriteEvent(op.ID, op.Data)
+	if staterr.AsCode(err) == staterr.CodeStorageUnavailable {
+		gen.Reject(op)
+		r.report.DelayedReleases++
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "write event").Stg("event-id", op.ID)
+	}
+	r.report.LogBytes += uint64(n)
+	if err := r.applier.Apply(op.ID, op.Data); err != nil {
+		return errors.Wrap(err, "apply operation").Stg("event-id", op.ID)
+	}
+	r.state.ReleaseCommitted(due)
+	r.stages[StageRelease].Add(time.Since(start))
+	r.report.Released += len(due)
+
+	return nil
+}
+
+func (r *runner) sync(mode SyncMode) error {
+	if mode == SyncFsync {
+		return r.log.Sync()
+	}
+
+	return r.log.Flush()
+}
+
+// flush сброс сохранённых в памяти сессий в новый источник.
+func (r *runner) flush() error {
+	start := time.Now()
+	tree, err := r.state.StartFlush()
+	if err != nil {
+		return errors.Wrap(err, "start flush")
+	}
+
+	id := r.nextSource()
+	footer, err := tree.DumpFileFS(r.cfg.FS, r.cfg.Dir, id, r.cfg.Resolution)
+	if err != nil {
+		return errors.Wrap(err, "dump sessions").Stg("source-id", id)
+	}
+	r.state.FinishFlush(id, footer)
+	r.stages[StageFlush].Add(time.Since(start))
+
+	if err := r.countSource(id); err != nil {
+		return errors.Wrap(err, "count source size")
+	}
+	r.sources = append(r.sources, id)
+	r.footers = append(r.footers, footer)
+	r.report.Flushes++
+
+	return nil
+}
+
+// merge слияние двух соседних источников выбранных по подвалам с учётом
+// сессий наступивших к моменту now. Результат слияния занимает место
+// слитых источников.
+func (r *runner) merge(now time.Time) error {
+	start := time.Now()
+	// Наступившие сессии будут выданы ближайшей выдачей, их перезапись
+	// при слиянии напрасна.
+	i := sourceio.PlanMerge(r.footers, r.cfg.Resolution.Repeat(now))
+	a, b := r.sources[i], r.sources[i+1]
+	id := r.nextSource()
+	footer, err := sourceio.MergeFilesFS(
+		r.cfg.FS,
+		datadir.TempName(r.cfg.Dir, datadir.TempMerge),
+		datadir.SourceName(r.cfg.Dir, id),
+		datadir.SourceName(r.cfg.Dir, a),
+		datadir.SourceName(r.cfg.Dir, b),
+		sourceBlockSize,
+		r.cfg.Resolution,
+	)
+	if err != nil {
+		return errors.Wrap(err, "merge files").Stg("source-a-id", a).Stg("source-b-id", b)
+	}
+	if err := r.state.SourcesMerged(r.cfg.Dir, r.descs, id, footer, a, b); err != nil {
+		return errors.Wrap(err, "account merged source").Stg("source-id", id)
+	}
+	r.stages[StageMerge].Add(time.Since(start))
+
+	if err := r.countSource(id); err != nil {
+		return errors.Wrap(err, "count source size")
+	}
+	for _, src := range []types.Index{a, b} {
+		if err := r.cfg.FS.Remove(datadir.SourceName(r.cfg.Dir, src)); err != nil {
+			return errors.Wrap(err, "remove merged source").Stg("source-id", src)
+		}
+	}
+	r.sources[i] = id
+	r.sources = append(r.sources[:i+1], r.sources[i+2:]...)
+	r.footers[i] = footer
+	r.footers = append(r.footers[:i+1], r.footers[i+2:]...)
+	r.report.Merges++
+
+	return nil
+}
+
+// nextSource идентификатор нового источника.
+func (r *runner) nextSource() types.Index {
+	r.srcseq++
+	return types.NewIndex(2, r.srcseq)
+}
+
+// countSource учёт объёма созданного источника id.
+func (r *runner) countSource(id types.Index) error {
+	stat, err := r.cfg.FS.Stat(datadir.SourceName(r.cfg.Dir, id))
+	if err != nil {
+		return errors.Wrap(err, "stat source").Stg("source-id", id)
+	}
+
+	r.report.SourceBytes += uint64(stat.Size())
+	return nil
+}
@@ -0,0 +1,387 @@
+package bench
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Workload описание синтетической нагрузки.
+type Workload struct {
+	// Seed зерно генератора случайных чисел.
+	Seed int64
+
+	// Active число одновременно активных сессий. Пока их меньше, вместо
+	// операции над существующей сессией создаётся новая.
+	Active int
+
+	// Themes число тем, тема новой сессии выбирается равновероятно.
+	Themes int
+
+	// DataMin и DataMax границы размера данных одного дописывания.
+	DataMin int
+	DataMax int
+
+	// Append и Store относительные веса дописываний и сохранений среди
+	// операций над активными сессиями. Так, при весах 3 и 1 сессия в
+	// среднем получает три дописывания перед сохранением.
+	Append int
+	Store  int
+
+	// Delays распределение задержки повтора сохраняемых сессий.
+	Delays Delays
+
+	// Interval шаг виртуального времени на одну операцию.
+	Interval time.Duration
+}
+
+// DefaultWorkload нагрузка по умолчанию.
+func DefaultWorkload() Workload {
+	return Workload{
+		Seed:     1,
+		Active:   1000,
+		Themes:   8,
+		DataMin:  64,
+		DataMax:  512,
+		Append:   3,
+		Store:    1,
+		Delays:   Delays{Kind: DelayUniform, Min: time.Second, Max: time.Minute},
+		Interval: time.Millisecond,
+	}
+}
+
+// Check проверка корректности нагрузки.
+func (w Workload) Check() error {
+	switch {
+	case w.Active < 1:
+		return errors.New("at least one active session is required").Int("active-sessions", w.Active)
+	case w.Themes < 1:
+		return errors.New("at least one theme is required").Int("themes", w.Themes)
+	case w.DataMin < 0 || w.DataMax < w.DataMin:
+		return errors.New("invalid data size range").Int("data-min", w.DataMin).Int("data-max", w.DataMax)
+	case w.Append < 0 || w.Store < 1:
+		return errors.New("invalid operation weights").Int("append-weight", w.Append).Int("store-weight", w.Store)
+	case w.Interval < 0:
+		return errors.New("negative virtual time interval").Int64("interval", int64(w.Interval))
+	}
+
+	if err := w.Delays.Check(); err != nil {
+		return errors.Wrap(err, "check repeat delays")
+	}
+
+	return nil
+}
+
+// DelayKind вид распределения задержек повтора.
+type DelayKind int
+
+const (
+	// DelayFixed одинаковая задержка Min.
+	DelayFixed DelayKind = iota
+
+	// DelayUniform задержка равномерно распределена на [Min, Max].
+	DelayUniform
+
+	// DelayExponential задержка Min плюс экспоненциально распределённая
+	// величина со средним Mean, не больше Max, если он задан.
+	DelayExponential
+)
+
+// Delays распределение задержек повтора.
+type Delays struct {
+	Kind DelayKind
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+}
+
+// ParseDelays разбор распределения задержек в одном из видов:
+//
+//	fixed:<delay>
+//	uniform:<min>-<max>
+//	exp:<mean>[,<min>[-<max>]]
+//
+// Длительности задаются в формате time.ParseDuration.
+func ParseDelays(v string) (Delays, error) {
+	kind, args, ok := strings.Cut(v, ":")
+	if !ok {
+		return Delays{}, errors.New("missing distribution kind").Str("delays", v)
+	}
+
+	var res Delays
+	var err error
+	switch kind {
+	case "fixed":
+		res.Kind = DelayFixed
+		res.Min, err = time.ParseDuration(args)
+	case "uniform":
+		res.Kind = DelayUniform
+		res.Min, res.Max, err = parseRange(args)
+	case "exp":
+		res.Kind = DelayExponential
+		mean, bounds, _ := strings.Cut(args, ",")
+		if res.Mean, err = time.ParseDuration(mean); err == nil && bounds != "" {
+			res.Min, res.Max, err = parseRange(bounds)
+		}
+	default:
+		return Delays{}, errors.New("unknown distribution kind").Str("delays-kind", kind)
+	}
+	if err != nil {
+		return Delays{}, errors.Wrap(err, "parse durations").Str("delays", v)
+	}
+
+	if err := res.Check(); err != nil {
+		return Delays{}, errors.Wrap(err, "check delays").Str("delays", v)
+	}
+
+	return res, nil
+}
+
+// parseRange разбор диапазона <min>-<max> или одиночного <min>.
+func parseRange(v string) (lo, hi time.Duration, err error) {
+	l, h, ok := strings.Cut(v, "-")
+	if lo, err = time.ParseDuration(l); err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return lo, 0, nil
+	}
+	if hi, err = time.ParseDuration(h); err != nil {
+		return 0, 0, err
+	}
+
+	return lo, hi, nil
+}
+
+// Check проверка корректности распределения.
+func (d Delays) Check() error {
+	if d.Min < 0 || d.Max < 0 || d.Mean < 0 {
+		return errors.New("delays must not be negative")
+	}
+
+	switch d.Kind {
+	case DelayFixed:
+	case DelayUniform:
+		if d.Max < d.Min {
+			return errors.New("max delay is less than min").Int64("delay-min", int64(d.Min)).Int64("delay-max", int64(d.Max))
+		}
+	case DelayExponential:
+		if d.Mean == 0 {
+			return errors.New("mean delay must be positive")
+		}
+		if d.Max != 0 && d.Max < d.Min {
+			return errors.New("max delay is less than min").Int64("delay-min", int64(d.Min)).Int64("delay-max", int64(d.Max))
+		}
+	default:
+		return errors.New("unknown distribution kind").Int("delays-kind", int(d.Kind))
+	}
+
+	return nil
+}
+
+// String описание распределения в виде принимаемом ParseDelays.
+func (d Delays) String() string {
+	switch d.Kind {
+	case DelayFixed:
+		return "fixed:" + d.Min.String()
+	case DelayUniform:
+		return "uniform:" + d.Min.String() + "-" + d.Max.String()
+	case DelayExponential:
+		res := "exp:" + d.Mean.String() + "," + d.Min.String()
+		if d.Max != 0 {
+			res += "-" + d.Max.String()
+		}
+		return res
+	default:
+		return "unknown"
+	}
+}
+
+// sample выборка задержки.
+func (d Delays) sample(rnd *rand.Rand) time.Duration {
+	switch d.Kind {
+	case DelayUniform:
+		return d.Min + time.Duration(rnd.Int63n(int64(d.Max-d.Min)+1))
+	case DelayExponential:
+		v := d.Min + time.Duration(rnd.ExpFloat64()*float64(d.Mean))
+		if d.Max != 0 && v > d.Max {
+			v = d.Max
+		}
+		return v
+	default:
+		return d.Min
+	}
+}
+
+// OpKind вид сгенерированной операции.
+type OpKind int
+
+const (
+	// OpNew создание сессии.
+	OpNew OpKind = iota
+
+	// OpAppend дописывание данных в сессию.
+	OpAppend
+
+	// OpStore сохранение сессии для повтора.
+	OpStore
+
+	// OpRestore выдача сохранённых сессий на повтор, см. Generator.Restore.
+	OpRestore
+
+	opKindCount
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpNew:
+		return "new"
+	case OpAppend:
+		return "append"
+	case OpStore:
+		return "store"
+	case OpRestore:
+		return "restore"
+	default:
+		return "unknown"
+	}
+}
+
+// Op сгенерированная операция: событие лога операций.
+type Op struct {
+	ID   types.Index
+	Kind OpKind
+	Data []byte // Кодированная операция, см. logop.Recorder.
+}
+
+// Generator генератор операций нагрузки. Время повтора сохраняемых
+// сессий отсчитывается от виртуального времени генератора.
+type Generator struct {
+	w   Workload
+	res types.RepeatResolution
+	rnd *rand.Rand
+	rec logop.Recorder
+
+	index  uint64
+	now    time.Time
+	active []types.Index
+	data   []byte
+
+	// undo откат изменений последней сгенерированной операции, см. Reject.
+	undo func()
+}
+
+// generatorStart начало виртуального времени генератора. Время близко
+// к настоящему, чтобы время повтора в операциях было таким же, как у
+// работающего сервиса.
+var generatorStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewGenerator конструктор генератора нагрузки w с временем повтора
+// в разрешении res. Виртуальное время начинается с generatorStart.
+func NewGenerator(w Workload, res types.RepeatResolution) (*Generator, error) {
+	if err := w.Check(); err != nil {
+		return nil, errors.Wrap(err, "check workload")
+	}
+	if err := res.Check(); err != nil {
+		return nil, errors.Wrap(err, "check repeat resolution")
+	}
+
+	return &Generator{
+		w:    w,
+		res:  res,
+		rnd:  rand.New(rand.NewSource(w.Seed)),
+		now:  generatorStart,
+		data: make([]byte, w.DataMax),
+	}, nil
+}
+
+// Now текущее виртуальное время.
+func (g *Generator) Now() time.Time {
+	return g.now
+}
+
+// Next генерация очередной операции. Данные операции действительны
+// до следующего вызова.
+func (g *Generator) Next() (Op, error) {
+	g.index++
+	g.now = g.now.Add(g.w.Interval)
+	g.undo = nil
+	op := Op{ID: types.NewIndex(1, g.index)}
+
+	if len(g.active) < g.w.Active {
+		op.Kind = OpNew
+		op.Data = g.rec.New(uint32(g.rnd.Intn(g.w.Themes)))
+		g.active = append(g.active, op.ID)
+		g.undo = func() {
+			g.active = g.active[:len(g.active)-1]
+		}
+		return op, nil
+	}
+
+	i := g.rnd.Intn(len(g.active))
+	sid := g.active[i]
+	if g.rnd.Intn(g.w.Append+g.w.Store) < g.w.Append {
+		size := g.w.DataMin + g.rnd.Intn(g.w.DataMax-g.w.DataMin+1)
+		g.rnd.Read(g.data[:size])
+		op.Kind = OpAppend
+		op.Data = g.rec.Record(sid, g.data[:size])
+		return op, nil
+	}
+
+	repeat := g.res.After(g.now, g.w.Delays.sample(g.rnd))
+	if repeat == 0 {
+		// Нулевое время повтора в операции означает его отсутствие.
+		return Op{}, errors.New("zero repeat time cannot be stored in operation").
+			Int64("repeat-resolution", int64(g.res))
+	}
+
+	op.Kind = OpStore
+	op.Data = g.rec.Store(sid, repeat)
+	g.active[i] = g.active[len(g.active)-1]
+	g.active = g.active[:len(g.active)-1]
+	g.undo = func() {
+		if i == len(g.active) {
+			g.active = append(g.active, sid)
+			return
+		}
+		g.active = append(g.active, g.active[i])
+		g.active[i] = sid
+	}
+	return op, nil
+}
+
+// Restore операция выдачи на повтор сессий sessions, найденных при
+// предложении операции, см. state.State.ReleaseDue. Выданные сессии
+// становятся активными и участвуют в дальнейшей нагрузке.
+func (g *Generator) Restore(sessions []types.Index) Op {
+	g.index++
+	g.active = append(g.active, sessions...)
+	g.undo = func() {
+		g.active = g.active[:len(g.active)-len(sessions)]
+	}
+	return Op{
+		ID:   types.NewIndex(1, g.index),
+		Kind: OpRestore,
+		Data: g.rec.RestoreSessions(sessions),
+	}
+}
+
+// Reject отмена последней сгенерированной операции op, отклонённой при
+// записи в лог, например в деградированном режиме, см. oplog. Её
+// идентификатор достанется следующей операции, а изменения набора
+// активных сессий откатываются.
+func (g *Generator) Reject(op Op) {
+	if op.ID.Index != g.index {
+		return
+	}
+
+	g.index--
+	if g.undo != nil {
+		g.undo()
+		g.undo = nil
+	}
+}
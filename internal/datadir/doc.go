@@ -0,0 +1,3 @@
+// Package datadir соглашения о раскладке файлов в директории с данными:
+// имена логов операций, источников, слепков и служебных файлов.
+package datadir
@@ -0,0 +1,146 @@
+package datadir
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+const (
+	// logSuffix расширение файлов логов операций.
+	logSuffix = ".log"
+
+	// sourceSuffix расширение файлов источников.
+	sourceSuffix = ".src"
+
+	// importSuffix расширение размещённых для загрузки источников.
+	importSuffix = ".import"
+
+	// snapshotSuffix расширение файлов слепков.
+	snapshotSuffix = ".snap"
+
+	// snapshotsLog имя лога имён слепков, см. logio.Snapshots.
+	snapshotsLog = "snapshots.journal"
+
+	// retentionJournal имя журнала удалённых файлов.
+	retentionJournal = "retention.journal"
+
+	// backupManifest имя манифеста резервной копии.
+	backupManifest = "backup.sha256"
+
+	// tempPrefix префикс имён временных файлов.
+	tempPrefix = "temporary-"
+)
+
+// Цели для которых создаются временные файлы, см. TempName.
+const (
+	// TempFlush сброс сохранённых сессий в источник.
+	TempFlush = "flush"
+
+	// TempMerge слияние источников.
+	TempMerge = "merge"
+
+	// TempSnapshot создание слепка.
+	TempSnapshot = "snapshot"
+
+	// TempExport выгрузка сохранённых сессий.
+	TempExport = "export"
+
+	// TempImport размещение загружаемого источника.
+	TempImport = "import"
+
+	// TempProbe пробная запись при проверке освобождения места на диске.
+	TempProbe = "probe"
+
+	// TempBackup копирование файла в резервную копию.
+	TempBackup = "backup"
+)
+
+// LogName имя файла лога операций с данным идентификатором.
+func LogName(dir string, id types.Index) string {
+	return filepath.Join(dir, id.String()+logSuffix)
+}
+
+// SourceName имя файла источника с данным идентификатором.
+func SourceName(dir string, id types.Index) string {
+	return filepath.Join(dir, id.String()+sourceSuffix)
+}
+
+// ImportName имя размещённого для загрузки файла источника с данным
+// идентификатором. Под именем источника, см. SourceName, файл появляется
+// только при загрузке.
+func ImportName(dir string, id types.Index) string {
+	return filepath.Join(dir, id.String()+importSuffix)
+}
+
+// SnapshotName имя файла слепка состояния с данным идентификатором.
+func SnapshotName(dir string, id types.Index) string {
+	return filepath.Join(dir, id.String()+snapshotSuffix)
+}
+
+// SnapshotsLogName имя лога имён слепков.
+func SnapshotsLogName(dir string) string {
+	return filepath.Join(dir, snapshotsLog)
+}
+
+// RetentionJournalName имя журнала файлов удалённых за ненадобностью.
+func RetentionJournalName(dir string) string {
+	return filepath.Join(dir, retentionJournal)
+}
+
+// BackupManifestName имя манифеста резервной копии в директории dir.
+func BackupManifestName(dir string) string {
+	return filepath.Join(dir, backupManifest)
+}
+
+// TempName имя временного файла для данной цели. Временные файлы всегда
+// имеют одно и то же имя, так что мусор из них не накапливается.
+func TempName(dir string, purpose string) string {
+	return filepath.Join(dir, tempPrefix+purpose)
+}
+
+// IsTempName проверка, является ли файл временным.
+func IsTempName(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), tempPrefix)
+}
+
+// ParseLogName извлечение идентификатора лога операций из имени его файла.
+func ParseLogName(name string) (types.Index, bool) {
+	base := filepath.Base(name)
+	if !strings.HasSuffix(base, logSuffix) {
+		return types.Index{}, false
+	}
+
+	return parseIndex(strings.TrimSuffix(base, logSuffix))
+}
+
+// ParseSourceName извлечение идентификатора источника из имени его файла.
+func ParseSourceName(name string) (types.Index, bool) {
+	base := filepath.Base(name)
+	if !strings.HasSuffix(base, sourceSuffix) {
+		return types.Index{}, false
+	}
+
+	return parseIndex(strings.TrimSuffix(base, sourceSuffix))
+}
+
+// parseIndex разбор индекса в представлении types.Index.String.
+func parseIndex(v string) (types.Index, bool) {
+	term, index, ok := strings.Cut(v, "-")
+	if !ok || len(term) != 16 || len(index) != 16 {
+		return types.Index{}, false
+	}
+
+	t, err := strconv.ParseUint(term, 16, 64)
+	if err != nil {
+		return types.Index{}, false
+	}
+	i, err := strconv.ParseUint(index, 16, 64)
+	if err != nil {
+		return types.Index{}, false
+	}
+
+	return types.NewIndex(t, i), true
+}
@@ -0,0 +1,120 @@
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+)
+
+// PendingFile файл записываемый под временным именем. После успешной
+// записи публикуется под постоянным именем с помощью Publish.
+type PendingFile struct {
+	fsio.File
+	fs fsio.FS
+}
+
+// CreatePending создаёт временный файл tmp для записи, существующий
+// файл с таким именем перезаписывается.
+func CreatePending(tmp string) (*PendingFile, error) {
+	return CreatePendingFS(fsio.OS{}, tmp)
+}
+
+// CreatePendingFS создаёт временный файл tmp в файловой системе fsys,
+// см. CreatePending.
+func CreatePendingFS(fsys fsio.FS, tmp string) (*PendingFile, error) {
+	file, err := fsys.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary file")
+	}
+
+	return &PendingFile{
+		File: file,
+		fs:   fsys,
+	}, nil
+}
+
+// Publish публикация записанного файла под именем name: данные файла
+// сбрасываются на диск, файл переименовывается, после чего на диск
+// сбрасывается и директория. Файл закрывается в любом случае.
+func (f *PendingFile) Publish(name string) error {
+	if err := f.Sync(); err != nil {
+		return f.cleanup(errors.Wrap(err, "sync file data"), f.Discard())
+	}
+	if err := f.Close(); err != nil {
+		return f.cleanup(errors.Wrap(err, "close file"), f.remove())
+	}
+
+	if err := f.fs.Rename(f.Name(), name); err != nil {
+		return f.cleanup(errors.Wrap(err, "rename file").Str("target-name", name), f.remove())
+	}
+
+	if err := f.fs.SyncDir(filepath.Dir(name)); err != nil {
+		return errors.Wrap(err, "sync directory")
+	}
+
+	return nil
+}
+
+// Discard отказ от публикации: файл закрывается и удаляется.
+func (f *PendingFile) Discard() error {
+	if err := f.Close(); err != nil {
+		return f.cleanup(errors.Wrap(err, "close file"), f.remove())
+	}
+
+	return f.remove()
+}
+
+// cleanup добавление к ошибке err ошибки удаления временного файла.
+func (f *PendingFile) cleanup(err errors.Error, cErr error) error {
+	if cErr != nil {
+		return err.Str("cleanup-error", cErr.Error())
+	}
+
+	return err
+}
+
+func (f *PendingFile) remove() error {
+	if err := f.fs.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove temporary file")
+	}
+
+	return nil
+}
+
+// SyncDir сброс на диск содержимого директории, т.е. изменений
+// в составе её файлов.
+func SyncDir(dir string) error {
+	return fsio.OS{}.SyncDir(dir)
+}
+
+// RemoveTemporary удаляет оставшиеся после аварийного завершения временные
+// файлы в dir. Возвращаются имена удалённых файлов.
+func RemoveTemporary(dir string) ([]string, error) {
+	return RemoveTemporaryFS(fsio.OS{}, dir)
+}
+
+// RemoveTemporaryFS удаляет временные файлы в директории dir файловой
+// системы fsys, см. RemoveTemporary.
+func RemoveTemporaryFS(fsys fsio.FS, dir string) ([]string, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read directory")
+	}
+
+	var res []string
+	for _, e := range entries {
+		if e.IsDir() || !IsTempName(e.Name()) {
+			continue
+		}
+
+		name := filepath.Join(dir, e.Name())
+		if err := fsys.Remove(name); err != nil && !os.IsNotExist(err) {
+			return res, errors.Wrap(err, "remove temporary file").Str("file-name", name)
+		}
+		res = append(res, name)
+	}
+
+	return res, nil
+}
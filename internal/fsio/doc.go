@@ -0,0 +1,8 @@
+// Package fsio абстракция файловой системы для всего файлового ввода-вывода
+// хранилища: открытие, переименование, синхронизация и обрезка файлов,
+// отображение файлов в память и синхронизация директорий.
+//
+// Реализация OS работает с файловой системой операционной системы, Mem
+// хранит файлы в памяти и позволяет ограничить их общий объём для проверки
+// реакции на нехватку места на диске.
+package fsio
@@ -0,0 +1,80 @@
+package fsio
+
+import (
+	"io"
+	"os"
+)
+
+// FS файловая система. Ошибки отдаются в том же виде, что и функциями
+// пакета os, т.е. для них работают os.IsNotExist и подобные проверки.
+type FS interface {
+	// Open открытие файла только на чтение.
+	Open(name string) (File, error)
+
+	// OpenFile открытие файла с флагами os.O_* и правами perm для
+	// создаваемого файла.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// Stat информация о файле или директории.
+	Stat(name string) (os.FileInfo, error)
+
+	// Rename переименование файла, существующий файл newname заменяется.
+	Rename(oldname, newname string) error
+
+	// Link создание жёсткой ссылки newname на файл oldname.
+	Link(oldname, newname string) error
+
+	// Remove удаление файла или пустой директории.
+	Remove(name string) error
+
+	// Truncate изменение размера файла.
+	Truncate(name string, size int64) error
+
+	// ReadDir список содержимого директории отсортированный по имени.
+	ReadDir(name string) ([]os.DirEntry, error)
+
+	// MkdirAll создание директории вместе с недостающими родительскими.
+	MkdirAll(name string, perm os.FileMode) error
+
+	// SyncDir сброс на диск содержимого директории, т.е. изменений
+	// в составе её файлов.
+	SyncDir(name string) error
+
+	// Mmap отображение файла в память только на чтение.
+	Mmap(name string) (ReaderAt, error)
+}
+
+// File открытый файл.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	// Name имя с которым файл был открыт.
+	Name() string
+
+	// Stat информация о файле.
+	Stat() (os.FileInfo, error)
+
+	// Sync синхронизация данных файла с диском.
+	Sync() error
+
+	// Truncate изменение размера файла.
+	Truncate(size int64) error
+}
+
+// ReaderAt отображённый в память файл. Размер данных фиксируется
+// в момент отображения.
+type ReaderAt interface {
+	io.ReaderAt
+	io.Closer
+
+	// Len длина данных.
+	Len() int
+
+	// At байт данных с индексом i.
+	At(i int) byte
+}
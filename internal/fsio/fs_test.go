@@ -0,0 +1,271 @@
+package fsio_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+)
+
+// Одни и те же сценарии для обеих реализаций: их поведение должно совпадать.
+func TestFS(t *testing.T) {
+	impls := []struct {
+		name string
+		fs   func(t *testing.T) (fsio.FS, string)
+	}{
+		{
+			name: "os",
+			fs: func(t *testing.T) (fsio.FS, string) {
+				return fsio.OS{}, t.TempDir()
+			},
+		},
+		{
+			name: "mem",
+			fs: func(t *testing.T) (fsio.FS, string) {
+				return fsio.NewMem(), "/data"
+			},
+		},
+	}
+
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			fsys, dir := impl.fs(t)
+			if err := fsys.MkdirAll(dir, 0755); err != nil {
+				tlog.Error(t, errors.Wrap(err, "create directory"))
+				return
+			}
+
+			testFS(t, fsys, dir)
+		})
+	}
+}
+
+func testFS(t *testing.T, fsys fsio.FS, dir string) {
+	name := filepath.Join(dir, "file")
+	if _, err := fsys.Open(name); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error opening missing file, got %v", err)
+	}
+
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create file"))
+		return
+	}
+	if _, err := file.Write([]byte("hello world")); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write file"))
+		return
+	}
+	if _, err := file.WriteAt([]byte("W"), 6); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write file at position"))
+		return
+	}
+	if err := file.Truncate(9); err != nil {
+		tlog.Error(t, errors.Wrap(err, "truncate file"))
+		return
+	}
+	if err := file.Sync(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "sync file"))
+		return
+	}
+	if err := file.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close file"))
+		return
+	}
+
+	if got := readAll(t, fsys, name); got != "hello Wor" {
+		t.Errorf("expected file content %q, got %q", "hello Wor", got)
+	}
+
+	mapped, err := fsys.Mmap(name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "map file"))
+		return
+	}
+	buf := make([]byte, 4)
+	if n, err := mapped.ReadAt(buf, 6); err != io.EOF || n != 3 || string(buf[:n]) != "Wor" {
+		t.Errorf("expected short read of %q with io.EOF, got %q and %v", "Wor", buf[:n], err)
+	}
+	if mapped.Len() != 9 || mapped.At(0) != 'h' {
+		t.Errorf("unexpected mapped data: length %d, first byte %q", mapped.Len(), mapped.At(0))
+	}
+	if err := mapped.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "unmap file"))
+		return
+	}
+
+	// Переименование заменяет существующий файл.
+	other := filepath.Join(dir, "other")
+	file, err = fsys.OpenFile(other, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create other file"))
+		return
+	}
+	if _, err := file.Write([]byte("other")); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write other file"))
+		return
+	}
+	if err := file.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close other file"))
+		return
+	}
+	if err := fsys.Rename(other, name); err != nil {
+		tlog.Error(t, errors.Wrap(err, "rename file"))
+		return
+	}
+	if err := fsys.SyncDir(dir); err != nil {
+		tlog.Error(t, errors.Wrap(err, "sync directory"))
+		return
+	}
+	if got := readAll(t, fsys, name); got != "other" {
+		t.Errorf("expected replaced file content %q, got %q", "other", got)
+	}
+	if _, err := fsys.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("expected renamed file to be missing, got %v", err)
+	}
+
+	// Жёсткая ссылка переживает удаление исходного имени.
+	link := filepath.Join(dir, "link")
+	if err := fsys.Link(name, link); err != nil {
+		tlog.Error(t, errors.Wrap(err, "link file"))
+		return
+	}
+	if err := fsys.Link(name, link); !os.IsExist(err) {
+		t.Errorf("expected exist error linking to existing name, got %v", err)
+	}
+	if err := fsys.Rename(link, name); err != nil {
+		tlog.Error(t, errors.Wrap(err, "rename link onto the same file"))
+		return
+	}
+	if err := fsys.Remove(link); err != nil {
+		tlog.Error(t, errors.Wrap(err, "remove link"))
+		return
+	}
+	if got := readAll(t, fsys, name); got != "other" {
+		t.Errorf("expected file content %q after link removal, got %q", "other", got)
+	}
+
+	if err := fsys.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		tlog.Error(t, errors.Wrap(err, "create subdirectory"))
+		return
+	}
+	if err := fsys.Truncate(name, 2); err != nil {
+		tlog.Error(t, errors.Wrap(err, "truncate file by name"))
+		return
+	}
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read directory"))
+		return
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if len(got) != 2 || got[0] != "file" || got[1] != "sub" || entries[0].IsDir() || !entries[1].IsDir() {
+		t.Errorf("unexpected directory entries %v", got)
+	}
+	stat, err := fsys.Stat(name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat file"))
+		return
+	}
+	if stat.Size() != 2 {
+		t.Errorf("expected file size 2, got %d", stat.Size())
+	}
+
+	if err := fsys.Remove(name); err != nil {
+		tlog.Error(t, errors.Wrap(err, "remove file"))
+		return
+	}
+	if err := fsys.Remove(name); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error removing missing file, got %v", err)
+	}
+}
+
+func TestMemLimit(t *testing.T) {
+	fsys := fsio.NewMem()
+	fsys.SetLimit(10)
+
+	file, err := fsys.OpenFile("log", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create file"))
+		return
+	}
+
+	if _, err := file.Write([]byte("12345678")); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write within limit"))
+		return
+	}
+
+	n, err := file.Write([]byte("abcd"))
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("expected ENOSPC error, got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected short write of 2 bytes, got %d", n)
+	}
+	if fsys.Used() != 10 {
+		t.Errorf("expected 10 bytes used, got %d", fsys.Used())
+	}
+
+	// Место освобождается удалением других файлов или снятием ограничения.
+	fsys.SetLimit(0)
+	if _, err := file.Write([]byte("cd")); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write after limit removal"))
+		return
+	}
+	if err := file.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close file"))
+		return
+	}
+
+	if got := readAll(t, fsys, "log"); got != "12345678abcd" {
+		t.Errorf("expected file content %q, got %q", "12345678abcd", got)
+	}
+
+	// Данные файла учитываются однократно, пока у него есть хоть одно имя.
+	if err := fsys.Link("log", "link"); err != nil {
+		tlog.Error(t, errors.Wrap(err, "link file"))
+		return
+	}
+	if err := fsys.Remove("log"); err != nil {
+		tlog.Error(t, errors.Wrap(err, "remove file"))
+		return
+	}
+	if fsys.Used() != 12 {
+		t.Errorf("expected 12 bytes used by the linked file, got %d", fsys.Used())
+	}
+	if err := fsys.Remove("link"); err != nil {
+		tlog.Error(t, errors.Wrap(err, "remove link"))
+		return
+	}
+	if fsys.Used() != 0 {
+		t.Errorf("expected no space used after removal, got %d", fsys.Used())
+	}
+}
+
+func readAll(t *testing.T, fsys fsio.FS, name string) string {
+	file, err := fsys.Open(name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open file").Str("file-name", name))
+		return ""
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close file").Str("file-name", name))
+		}
+	}()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read file").Str("file-name", name))
+		return ""
+	}
+
+	return string(data)
+}
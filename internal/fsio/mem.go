@@ -0,0 +1,391 @@
+package fsio
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Mem файловая система в памяти. Изначально в ней есть только корневая
+// и текущая директории, остальные создаются с помощью MkdirAll. Общий
+// объём данных файлов можно ограничить, см. SetLimit.
+type Mem struct {
+	lock  sync.Mutex
+	files map[string]*memNode
+	dirs  map[string]struct{}
+
+	limit int64 // Ограничение общего объёма данных, 0 – без ограничения.
+	used  int64
+}
+
+// memNode данные файла. Открытые файлы продолжают работать с данными
+// и после удаления или замены файла, как и в POSIX системах.
+type memNode struct {
+	data    []byte
+	modTime time.Time
+	links   int // Число имён файла, данные учитываются в объёме пока оно не ноль.
+}
+
+// NewMem конструктор файловой системы в памяти.
+func NewMem() *Mem {
+	return &Mem{
+		files: map[string]*memNode{},
+		dirs: map[string]struct{}{
+			string(filepath.Separator): {},
+			".":                        {},
+		},
+	}
+}
+
+// SetLimit ограничение общего объёма данных файлов limit байтами, 0
+// снимает ограничение. Запись сверх ограничения заканчивается ошибкой
+// syscall.ENOSPC, при этом записывается поместившаяся часть данных.
+// Уже записанные данные ограничение не затрагивает.
+func (m *Mem) SetLimit(limit int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.limit = limit
+}
+
+// Used общий объём данных файлов.
+func (m *Mem) Used() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.used
+}
+
+// Open для реализации FS.
+func (m *Mem) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile для реализации FS.
+func (m *Mem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := filepath.Clean(name)
+	if _, ok := m.dirs[path]; ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	node, ok := m.files[path]
+	switch {
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		if !m.dirExists(filepath.Dir(path)) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		node = &memNode{
+			modTime: time.Now(),
+			links:   1,
+		}
+		m.files[path] = node
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	if flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		m.resize(node, 0)
+	}
+
+	return &memFile{
+		fs:   m,
+		node: node,
+		name: name,
+		flag: flag,
+	}, nil
+}
+
+// Stat для реализации FS.
+func (m *Mem) Stat(name string) (os.FileInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := filepath.Clean(name)
+	if _, ok := m.dirs[path]; ok {
+		return memFileInfo{name: filepath.Base(path), dir: true}, nil
+	}
+
+	node, ok := m.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node.info(path), nil
+}
+
+// Rename для реализации FS. Переименовываются только файлы.
+func (m *Mem) Rename(oldname, newname string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	oldpath := filepath.Clean(oldname)
+	newpath := filepath.Clean(newname)
+	node, ok := m.files[oldpath]
+	if !ok {
+		err := error(fs.ErrNotExist)
+		if _, ok := m.dirs[oldpath]; ok {
+			err = syscall.EINVAL
+		}
+
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if _, ok := m.dirs[newpath]; ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EISDIR}
+	}
+	if !m.dirExists(filepath.Dir(newpath)) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if m.files[newpath] == node {
+		// Оба имени ссылаются на один и тот же файл.
+		return nil
+	}
+
+	if prev, ok := m.files[newpath]; ok {
+		m.unlink(prev)
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = node
+
+	return nil
+}
+
+// Link для реализации FS. Ссылки создаются только на файлы.
+func (m *Mem) Link(oldname, newname string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	oldpath := filepath.Clean(oldname)
+	newpath := filepath.Clean(newname)
+	node, ok := m.files[oldpath]
+	if !ok {
+		err := error(fs.ErrNotExist)
+		if _, ok := m.dirs[oldpath]; ok {
+			err = syscall.EPERM
+		}
+
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if _, ok := m.files[newpath]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if _, ok := m.dirs[newpath]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if !m.dirExists(filepath.Dir(newpath)) {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+
+	node.links++
+	m.files[newpath] = node
+	return nil
+}
+
+// Remove для реализации FS.
+func (m *Mem) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := filepath.Clean(name)
+	if node, ok := m.files[path]; ok {
+		m.unlink(node)
+		delete(m.files, path)
+		return nil
+	}
+
+	if _, ok := m.dirs[path]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(m.entries(path)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	delete(m.dirs, path)
+	return nil
+}
+
+// Truncate для реализации FS.
+func (m *Mem) Truncate(name string, size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: name, Err: syscall.EINVAL}
+	}
+
+	if !m.resize(node, size) {
+		return &fs.PathError{Op: "truncate", Path: name, Err: syscall.ENOSPC}
+	}
+
+	return nil
+}
+
+// ReadDir для реализации FS.
+func (m *Mem) ReadDir(name string) ([]os.DirEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := filepath.Clean(name)
+	if _, ok := m.dirs[path]; !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return m.entries(path), nil
+}
+
+// MkdirAll для реализации FS.
+func (m *Mem) MkdirAll(name string, perm os.FileMode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for path := filepath.Clean(name); !m.dirExists(path); path = filepath.Dir(path) {
+		if _, ok := m.files[path]; ok {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+
+		m.dirs[path] = struct{}{}
+	}
+
+	return nil
+}
+
+// SyncDir для реализации FS. Состав директорий в памяти меняется сразу,
+// поэтому проверяется только существование директории.
+func (m *Mem) SyncDir(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.dirs[filepath.Clean(name)]; !ok {
+		return &fs.PathError{Op: "sync", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+// Mmap для реализации FS. Отображение получает копию текущих данных файла.
+func (m *Mem) Mmap(name string) (ReaderAt, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "mmap", Path: name, Err: fs.ErrNotExist}
+	}
+
+	data := make([]byte, len(node.data))
+	copy(data, node.data)
+	return &memMmap{data: data}, nil
+}
+
+// dirExists проверка существования директории path, ожидается
+// очищенный путь.
+func (m *Mem) dirExists(path string) bool {
+	_, ok := m.dirs[path]
+	return ok
+}
+
+// entries содержимое директории path отсортированное по имени.
+func (m *Mem) entries(path string) []os.DirEntry {
+	var res []os.DirEntry
+	for name, node := range m.files {
+		if filepath.Dir(name) == path {
+			res = append(res, fs.FileInfoToDirEntry(node.info(name)))
+		}
+	}
+	for name := range m.dirs {
+		if name != path && filepath.Dir(name) == path {
+			res = append(res, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(name), dir: true}))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+	return res
+}
+
+// unlink удаление одного из имён файла node. Данные файла без имён
+// больше не учитываются в объёме.
+func (m *Mem) unlink(node *memNode) {
+	node.links--
+	if node.links == 0 {
+		m.used -= int64(len(node.data))
+	}
+}
+
+// resize изменение размера данных node до size. Возвращает false при
+// нехватке места для увеличения размера, тогда данные не меняются.
+func (m *Mem) resize(node *memNode, size int64) bool {
+	delta := size - int64(len(node.data))
+	if node.links > 0 && delta > 0 && m.limit > 0 && m.used+delta > m.limit {
+		return false
+	}
+
+	switch {
+	case delta <= 0:
+		node.data = node.data[:size]
+	case size <= int64(cap(node.data)):
+		// За длиной данных могут оставаться байты отрезанные ранее.
+		tail := node.data[len(node.data):size]
+		for i := range tail {
+			tail[i] = 0
+		}
+		node.data = node.data[:size]
+	default:
+		data := make([]byte, size, 2*size)
+		copy(data, node.data)
+		node.data = data
+	}
+
+	if node.links > 0 {
+		m.used += delta
+	}
+	node.modTime = time.Now()
+	return true
+}
+
+// writeAt запись p в node с позиции off. При нехватке места записывается
+// только поместившаяся часть, ok тогда равен false.
+func (m *Mem) writeAt(node *memNode, p []byte, off int64) (n int, ok bool) {
+	end := off + int64(len(p))
+	ok = true
+	if grow := end - int64(len(node.data)); node.links > 0 && grow > 0 && m.limit > 0 && m.used+grow > m.limit {
+		end -= m.used + grow - m.limit
+		if end < off {
+			end = off
+		}
+		ok = false
+	}
+
+	if end > int64(len(node.data)) && !m.resize(node, end) {
+		// Позиция записи сама лежит за пределами доступного места.
+		return 0, false
+	}
+	n = copy(node.data[off:end], p)
+	node.modTime = time.Now()
+
+	return n, ok
+}
+
+func (n *memNode) info(path string) memFileInfo {
+	return memFileInfo{
+		name:    filepath.Base(path),
+		size:    int64(len(n.data)),
+		modTime: n.modTime,
+	}
+}
+
+var _ FS = &Mem{}
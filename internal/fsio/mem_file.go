@@ -0,0 +1,292 @@
+package fsio
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+)
+
+// memFile открытый файл Mem.
+type memFile struct {
+	fs     *Mem
+	node   *memNode
+	name   string
+	flag   int
+	pos    int64
+	closed bool
+}
+
+// Read для реализации File.
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	n, err := f.readAt("read", p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// ReadAt для реализации File.
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if off < 0 {
+		return 0, f.error("readat", syscall.EINVAL)
+	}
+
+	n, err := f.readAt("readat", p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Write для реализации File.
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.node.data))
+	}
+
+	n, err := f.writeAt("write", p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// WriteAt для реализации File.
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errors.New("fsio: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, f.error("writeat", syscall.EINVAL)
+	}
+
+	return f.writeAt("writeat", p, off)
+}
+
+// Seek для реализации File.
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return 0, f.error("seek", fs.ErrClosed)
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	default:
+		return 0, f.error("seek", syscall.EINVAL)
+	}
+	if offset < 0 {
+		return 0, f.error("seek", syscall.EINVAL)
+	}
+
+	f.pos = offset
+	return offset, nil
+}
+
+// Close для реализации File.
+func (f *memFile) Close() error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return f.error("close", fs.ErrClosed)
+	}
+
+	f.closed = true
+	return nil
+}
+
+// Name для реализации File.
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Stat для реализации File.
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return nil, f.error("stat", fs.ErrClosed)
+	}
+
+	return f.node.info(f.name), nil
+}
+
+// Sync для реализации File. Данные в памяти всегда синхронизированы.
+func (f *memFile) Sync() error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return f.error("sync", fs.ErrClosed)
+	}
+
+	return nil
+}
+
+// Truncate для реализации File.
+func (f *memFile) Truncate(size int64) error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	switch {
+	case f.closed:
+		return f.error("truncate", fs.ErrClosed)
+	case !f.writable():
+		return f.error("truncate", syscall.EBADF)
+	case size < 0:
+		return f.error("truncate", syscall.EINVAL)
+	}
+
+	if !f.fs.resize(f.node, size) {
+		return f.error("truncate", syscall.ENOSPC)
+	}
+
+	return nil
+}
+
+func (f *memFile) readAt(op string, p []byte, off int64) (int, error) {
+	switch {
+	case f.closed:
+		return 0, f.error(op, fs.ErrClosed)
+	case f.flag&os.O_WRONLY != 0:
+		return 0, f.error(op, syscall.EBADF)
+	case off >= int64(len(f.node.data)):
+		if len(p) == 0 {
+			return 0, nil
+		}
+
+		return 0, io.EOF
+	}
+
+	return copy(p, f.node.data[off:]), nil
+}
+
+func (f *memFile) writeAt(op string, p []byte, off int64) (int, error) {
+	switch {
+	case f.closed:
+		return 0, f.error(op, fs.ErrClosed)
+	case !f.writable():
+		return 0, f.error(op, syscall.EBADF)
+	}
+
+	n, ok := f.fs.writeAt(f.node, p, off)
+	if !ok {
+		return n, f.error(op, syscall.ENOSPC)
+	}
+
+	return n, nil
+}
+
+func (f *memFile) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *memFile) error(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+// memFileInfo информация о файле или директории Mem.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// Name для реализации os.FileInfo.
+func (i memFileInfo) Name() string {
+	return i.name
+}
+
+// Size для реализации os.FileInfo.
+func (i memFileInfo) Size() int64 {
+	return i.size
+}
+
+// Mode для реализации os.FileInfo.
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+// ModTime для реализации os.FileInfo.
+func (i memFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+// IsDir для реализации os.FileInfo.
+func (i memFileInfo) IsDir() bool {
+	return i.dir
+}
+
+// Sys для реализации os.FileInfo.
+func (i memFileInfo) Sys() any {
+	return nil
+}
+
+// memMmap отображение файла Mem в память.
+type memMmap struct {
+	data []byte
+}
+
+// ReadAt для реализации ReaderAt.
+func (r *memMmap) ReadAt(p []byte, off int64) (int, error) {
+	if r.data == nil {
+		return 0, errors.New("mmap: closed")
+	}
+	if off < 0 || int64(len(r.data)) < off {
+		return 0, errors.Newf("mmap: invalid ReadAt offset %d", off)
+	}
+
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Close для реализации ReaderAt.
+func (r *memMmap) Close() error {
+	r.data = nil
+	return nil
+}
+
+// Len для реализации ReaderAt.
+func (r *memMmap) Len() int {
+	return len(r.data)
+}
+
+// At для реализации ReaderAt.
+func (r *memMmap) At(i int) byte {
+	return r.data[i]
+}
+
+var (
+	_ File     = &memFile{}
+	_ ReaderAt = &memMmap{}
+)
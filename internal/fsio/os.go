@@ -0,0 +1,103 @@
+package fsio
+
+import (
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"golang.org/x/exp/mmap"
+)
+
+// OS файловая система операционной системы.
+type OS struct{}
+
+// Open для реализации FS.
+func (OS) Open(name string) (File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// OpenFile для реализации FS.
+func (OS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Stat для реализации FS.
+func (OS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Rename для реализации FS.
+func (OS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+// Remove для реализации FS.
+func (OS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Truncate для реализации FS.
+func (OS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+// ReadDir для реализации FS.
+func (OS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// MkdirAll для реализации FS.
+func (OS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Link для реализации FS.
+func (OS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// SyncDir для реализации FS.
+func (OS) SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "open directory")
+	}
+
+	if err := d.Sync(); err != nil {
+		if cErr := d.Close(); cErr != nil {
+			return errors.Wrap(err, "sync directory").Str("close-error", cErr.Error())
+		}
+
+		return errors.Wrap(err, "sync directory")
+	}
+
+	if err := d.Close(); err != nil {
+		return errors.Wrap(err, "close directory")
+	}
+
+	return nil
+}
+
+// Mmap для реализации FS.
+func (OS) Mmap(name string) (ReaderAt, error) {
+	file, err := mmap.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+var (
+	_ FS   = OS{}
+	_ File = &os.File{}
+)
@@ -0,0 +1,201 @@
+package logio
+
+import (
+	"math"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// ChainLog описание одного файла из цепочки логов.
+type ChainLog struct {
+	// Name имя файла лога.
+	Name string
+
+	// First индекс первого события в логе.
+	First types.Index
+
+	// Last индекс последнего события в логе. Нулевое значение допустимо
+	// только для последнего лога в цепочке и означает, что лог всё ещё
+	// пополняется.
+	Last types.Index
+}
+
+// NewChainReader создаёт итератор по цепочке ротированных логов начиная
+// с первого события не раньше start. Логи должны быть упорядочены
+// и следовать друг за другом без пропусков.
+func NewChainReader(logs []ChainLog, start types.Index, logger func(error)) (*ChainIterator, error) {
+	return NewChainReaderFS(fsio.OS{}, logs, start, logger)
+}
+
+// NewChainReaderFS создаёт итератор по цепочке логов файловой
+// системы fsys, см. NewChainReader.
+func NewChainReaderFS(
+	fsys fsio.FS,
+	logs []ChainLog,
+	start types.Index,
+	logger func(error),
+) (*ChainIterator, error) {
+	if len(logs) == 0 {
+		return nil, errors.New("empty logs chain")
+	}
+
+	for i := 1; i < len(logs); i++ {
+		prev := logs[i-1]
+		if prev.Last.Term == 0 {
+			return nil, errors.New("only the last log in a chain can have no last event").
+				Str("log-name", prev.Name)
+		}
+
+		if !types.IndexFollows(prev.Last, logs[i].First) {
+			return nil, errors.Wrap(ErrorLogIntegrityCompromised{}, "gap between logs").
+				Str("previous-log-name", prev.Name).
+				Stg("previous-log-last-id", prev.Last).
+				Str("log-name", logs[i].Name).
+				Stg("log-first-id", logs[i].First)
+		}
+	}
+
+	if types.IndexLess(start, logs[0].First) {
+		return nil, errors.New("the start is before the first logged event").
+			Stg("start", start).
+			Stg("first-logged-id", logs[0].First)
+	}
+
+	// Ищем первый лог где может оказаться start.
+	cur := len(logs) - 1
+	for i, l := range logs {
+		if l.Last.Term == 0 || !types.IndexLess(l.Last, start) {
+			cur = i
+			break
+		}
+	}
+
+	res := &ChainIterator{
+		fs:     fsys,
+		logs:   logs,
+		cur:    cur,
+		logger: logger,
+	}
+
+	r, err := LookupRangeFS(fsys, logs[cur].Name, start, maxIndex, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "look for the start").Str("log-name", logs[cur].Name)
+	}
+
+	res.it, err = NewReaderFS(fsys, logs[cur].Name, ReaderStart(r.Start))
+	if err != nil {
+		return nil, errors.Wrap(err, "open log").Str("log-name", logs[cur].Name)
+	}
+
+	return res, nil
+}
+
+// maxIndex индекс заведомо больший любого индекса события.
+var maxIndex = types.NewIndex(math.MaxUint64, math.MaxUint64)
+
+// ChainIterator итератор по событиям цепочки логов.
+type ChainIterator struct {
+	fs     fsio.FS
+	logs   []ChainLog
+	cur    int
+	it     *ReadIterator
+	logger func(error)
+
+	prev types.Index
+	read bool // Были ли вычитаны события из текущего лога.
+	err  error
+}
+
+// Next вычитка следующего события.
+func (it *ChainIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for {
+		if it.it.Next() {
+			break
+		}
+
+		if err := it.it.Err(); err != nil {
+			it.err = errors.Wrap(err, "read log").Str("log-name", it.logs[it.cur].Name)
+			return false
+		}
+
+		if !it.switchLog() {
+			return false
+		}
+	}
+
+	id, _, _ := it.it.Event()
+	if it.prev.Term != 0 && !types.IndexFollows(it.prev, id) {
+		it.err = errors.Wrap(ErrorLogIntegrityCompromised{}, "gap between events").
+			Str("log-name", it.logs[it.cur].Name).
+			Stg("previous-event-id", it.prev).
+			Stg("event-id", id)
+		return false
+	}
+
+	it.prev = id
+	it.read = true
+	return true
+}
+
+// Event получить событие, аналогично ReadIterator.Event.
+func (it *ChainIterator) Event() (id types.Index, data []byte, size int) {
+	return it.it.Event()
+}
+
+// Name имя файла лога которому принадлежит текущее событие.
+func (it *ChainIterator) Name() string {
+	return it.logs[it.cur].Name
+}
+
+// Err возвращает ошибку итерирования.
+func (it *ChainIterator) Err() error {
+	return it.err
+}
+
+// Close закрытие текущего лога.
+func (it *ChainIterator) Close() error {
+	if it.it == nil {
+		return nil
+	}
+
+	return it.it.Close()
+}
+
+// switchLog переход к следующему логу цепочки. Возвращает false
+// если логов больше нет или переход не удался.
+func (it *ChainIterator) switchLog() bool {
+	l := it.logs[it.cur]
+	if it.read && l.Last.Term != 0 && !types.IndexEqual(it.prev, l.Last) {
+		it.err = errors.Wrap(ErrorLogIntegrityCompromised{}, "log ends before its last event").
+			Str("log-name", l.Name).
+			Stg("log-last-id", l.Last).
+			Stg("last-read-id", it.prev)
+		return false
+	}
+
+	if it.cur == len(it.logs)-1 {
+		return false
+	}
+
+	if err := it.it.Close(); err != nil {
+		it.logger(errors.Wrap(err, "close log").Str("log-name", l.Name))
+	}
+	it.it = nil
+
+	it.cur++
+	it.read = false
+	next, err := NewReaderFS(it.fs, it.logs[it.cur].Name)
+	if err != nil {
+		it.err = errors.Wrap(err, "open log").Str("log-name", it.logs[it.cur].Name)
+		return false
+	}
+	it.it = next
+
+	return true
+}
@@ -0,0 +1,185 @@
+package logio
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestChainReader(t *testing.T) {
+	ranges := [][2]types.Index{
+		{types.NewIndex(1, 0), types.NewIndex(1, 99)},
+		{types.NewIndex(2, 0), types.NewIndex(2, 49)},
+		{types.NewIndex(2, 50), types.NewIndex(2, 149)},
+	}
+
+	var logs []ChainLog
+	for i, r := range ranges {
+		name := "testdata/chain-" + r[0].String()
+		if err := writeChainLog(name, r[0], r[1]); err != nil {
+			tlog.Error(t, errors.Wrap(err, "prepare log").Str("log-name", name))
+			return
+		}
+
+		l := ChainLog{
+			Name:  name,
+			First: r[0],
+			Last:  r[1],
+		}
+		if i == len(ranges)-1 {
+			l.Last = types.Index{}
+		}
+		logs = append(logs, l)
+	}
+
+	type test struct {
+		name    string
+		logs    []ChainLog
+		start   types.Index
+		first   types.Index
+		count   int
+		wantErr bool
+	}
+
+	tests := []test{
+		{
+			name:  "from the beginning",
+			logs:  logs,
+			start: types.NewIndex(1, 0),
+			first: types.NewIndex(1, 0),
+			count: 250,
+		},
+		{
+			name:  "from the middle of the first",
+			logs:  logs,
+			start: types.NewIndex(1, 50),
+			first: types.NewIndex(1, 50),
+			count: 200,
+		},
+		{
+			name:  "from the last event of the first",
+			logs:  logs,
+			start: types.NewIndex(1, 99),
+			first: types.NewIndex(1, 99),
+			count: 151,
+		},
+		{
+			name:  "from the last log",
+			logs:  logs,
+			start: types.NewIndex(2, 140),
+			first: types.NewIndex(2, 140),
+			count: 10,
+		},
+		{
+			name:  "from the future",
+			logs:  logs,
+			start: types.NewIndex(3, 0),
+			count: 0,
+		},
+		{
+			name:    "before the history",
+			logs:    logs[1:],
+			start:   types.NewIndex(1, 0),
+			wantErr: true,
+		},
+		{
+			name:    "gap between logs",
+			logs:    []ChainLog{logs[0], logs[2]},
+			start:   types.NewIndex(1, 0),
+			wantErr: true,
+		},
+		{
+			name: "log is shorter than claimed",
+			logs: []ChainLog{
+				{
+					Name:  logs[0].Name,
+					First: logs[0].First,
+					Last:  types.NewIndex(1, 100),
+				},
+				{
+					Name:  logs[2].Name,
+					First: types.NewIndex(1, 101),
+				},
+			},
+			start:   types.NewIndex(1, 90),
+			first:   types.NewIndex(1, 90),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := func() error {
+				it, err := NewChainReader(tt.logs, tt.start, func(err error) {
+					tlog.Error(t, err)
+				})
+				if err != nil {
+					return errors.Wrap(err, "open chain reader")
+				}
+				defer func() {
+					if err := it.Close(); err != nil {
+						tlog.Error(t, errors.Wrap(err, "close chain reader"))
+					}
+				}()
+
+				var count int
+				for it.Next() {
+					id, _, _ := it.Event()
+					if count == 0 && !types.IndexEqual(id, tt.first) {
+						return errors.New("unexpected first event").
+							Stg("expected-event-id", tt.first).
+							Stg("actual-event-id", id)
+					}
+					count++
+				}
+				if err := it.Err(); err != nil {
+					return errors.Wrap(err, "iterate over logs chain")
+				}
+
+				if count != tt.count {
+					return errors.New("unexpected count of events").
+						Int("expected-count", tt.count).
+						Int("actual-count", count)
+				}
+
+				return nil
+			}()
+
+			switch {
+			case err != nil && tt.wantErr:
+				tlog.Log(t, errors.Wrap(err, "expected error"))
+			case err != nil:
+				tlog.Error(t, err)
+			case tt.wantErr:
+				t.Error("error was expected")
+			}
+		})
+	}
+}
+
+func writeChainLog(name string, first, last types.Index) error {
+	if err := os.RemoveAll(name); err != nil {
+		return errors.Wrap(err, "remove existing log")
+	}
+
+	w, err := NewWriter(name, 128, 32, WriterBufferSize(256))
+	if err != nil {
+		return errors.Wrap(err, "create writer")
+	}
+
+	for id := first; types.IndexLE(id, last); id = types.IndexIncIndex(id) {
+		if _, err := w.WriteEvent(id, []byte(id.String()[:8])); err != nil {
+			return errors.Wrap(err, "write event").Stg("event-id", id)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "close writer")
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package logio
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/sirkon/mpy6a/internal/errors"
+)
+
+// Codec алгоритм сжатия данных событий. Задаётся при создании файла
+// лога и хранится в старшем байте поля evlim заголовка файла.
+//
+// В файлах со сжатием данные каждого события предваряются байтом
+// указывающим, были ли они сжаты: несжимаемые данные хранятся как есть,
+// поэтому в худшем случае событие увеличивается лишь на один байт.
+type Codec uint8
+
+const (
+	// CodecNone данные событий не сжимаются.
+	CodecNone Codec = iota
+
+	// CodecSnappy данные событий сжимаются с помощью snappy.
+	CodecSnappy
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("codec(%d)", uint8(c))
+	}
+}
+
+const (
+	// codecShift сдвиг кода алгоритма сжатия в поле evlim заголовка.
+	codecShift = 56
+
+	// evlimMask маска значения evlim в поле заголовка.
+	evlimMask = 1<<codecShift - 1
+
+	// eventPlain признак несжатых данных события.
+	eventPlain = 0
+
+	// eventCompressed признак сжатых данных события.
+	eventCompressed = 1
+)
+
+// codecOverhead максимальное увеличение размера данных события при их
+// сохранении с данным алгоритмом.
+func codecOverhead(c Codec) int {
+	if c == CodecNone {
+		return 0
+	}
+
+	return 1
+}
+
+// splitEvlim разделение поля evlim заголовка на собственно evlim и
+// код алгоритма сжатия.
+func splitEvlim(v uint64) (evlim uint64, codec Codec, err error) {
+	codec = Codec(v >> codecShift)
+	switch codec {
+	case CodecNone, CodecSnappy:
+	default:
+		return 0, 0, errors.New("unsupported codec").Uint8("invalid-codec", uint8(codec))
+	}
+
+	return v & evlimMask, codec, nil
+}
+
+// joinEvlim сборка поля evlim заголовка.
+func joinEvlim(evlim int, codec Codec) uint64 {
+	return uint64(evlim) | uint64(codec)<<codecShift
+}
+
+// compressEvent кодирование данных события в dst с данным алгоритмом.
+func compressEvent(dst []byte, codec Codec, data []byte) []byte {
+	if codec == CodecNone {
+		return append(dst[:0], data...)
+	}
+
+	need := 1 + snappy.MaxEncodedLen(len(data))
+	if cap(dst) < need {
+		dst = make([]byte, need)
+	}
+	dst = dst[:need]
+
+	c := snappy.Encode(dst[1:], data)
+	if len(c) >= len(data) {
+		dst = append(dst[:1], data...)
+		dst[0] = eventPlain
+		return dst
+	}
+
+	dst[0] = eventCompressed
+	return dst[:1+len(c)]
+}
+
+// decompressEvent раскодирование данных события сохранённых с данным
+// алгоритмом. Результат может указывать как на dst, так и на data.
+func decompressEvent(dst []byte, codec Codec, evlim int, data []byte) ([]byte, error) {
+	if codec == CodecNone {
+		return data, nil
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("missing event compression flag")
+	}
+
+	switch data[0] {
+	case eventPlain:
+		return data[1:], nil
+	case eventCompressed:
+	default:
+		return nil, errors.New("invalid event compression flag").Uint8("invalid-flag", data[0])
+	}
+
+	l, err := snappy.DecodedLen(data[1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "get decompressed event length")
+	}
+	if l > evlim {
+		return nil, errors.New("decompressed event is out of limit").
+			Int("decompressed-length", l).
+			Int("evlim", evlim)
+	}
+
+	if cap(dst) < l {
+		dst = make([]byte, l)
+	}
+	res, err := snappy.Decode(dst[:l], data[1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress event")
+	}
+
+	return res, nil
+}
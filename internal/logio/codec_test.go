@@ -0,0 +1,149 @@
+package logio
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestCompressedLog(t *testing.T) {
+	const (
+		eventfile = "testdata/compressed"
+		N         = 300
+	)
+
+	if err := os.RemoveAll(eventfile); err != nil {
+		tlog.Error(t, errors.Wrap(err, "delete existing event file"))
+		return
+	}
+
+	eventData := func(i int) []byte {
+		if i%10 == 0 {
+			// Короткие события сжать не получится.
+			return []byte(strconv.Itoa(i))
+		}
+
+		return []byte(`{"session":` + strconv.Itoa(i) + `,"payload":"` + compressibleString(i) + `"}`)
+	}
+
+	w, err := NewWriter(eventfile, 512, 128, WriterCompression(CodecSnappy))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	var uncompressed int
+	for i := 0; i < N; i++ {
+		if i == N/2 {
+			// Переоткрываем лог: алгоритм сжатия должен браться из заголовка.
+			if err := w.Close(); err != nil {
+				tlog.Error(t, errors.Wrap(err, "close writer"))
+				return
+			}
+
+			w, err = NewWriter(eventfile, 512, 128)
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "reopen writer"))
+				return
+			}
+			if w.Codec() != CodecSnappy {
+				tlog.Error(t, errors.New("unexpected codec of a reopened log").Stg("codec", w.Codec()))
+				return
+			}
+		}
+
+		data := eventData(i)
+		uncompressed += eventLength(data)
+		if _, err := w.WriteEvent(types.NewIndex(1, uint64(i)), data); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Int("event-no", i))
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	stat, err := os.Stat(eventfile)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat log file"))
+		return
+	}
+	if stat.Size() >= int64(uncompressed) {
+		tlog.Error(t, errors.New("log was not compressed").
+			Int64("file-size", stat.Size()).
+			Int("uncompressed-size", uncompressed))
+		return
+	}
+
+	it, err := NewReader(eventfile)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open reader"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close reader"))
+		}
+	}()
+
+	var i int
+	for it.Next() {
+		id, data, _ := it.Event()
+		if !types.IndexEqual(id, types.NewIndex(1, uint64(i))) || !bytes.Equal(data, eventData(i)) {
+			tlog.Error(t, errors.New("unexpected event").
+				Int("event-no", i).
+				Stg("event-id", id).
+				Str("event-data", string(data)))
+			return
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read events"))
+		return
+	}
+	if i != N {
+		tlog.Error(t, errors.New("not all events were read").Int("events-read", i))
+		return
+	}
+
+	res, err := LookupRange(eventfile, types.NewIndex(1, 100), types.NewIndex(1, 200), func(err error) {
+		tlog.Error(t, err)
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "lookup range"))
+		return
+	}
+	if !types.IndexEqual(res.First, types.NewIndex(1, 100)) || !types.IndexEqual(res.Last, types.NewIndex(1, 200)) {
+		tlog.Error(t, errors.New("unexpected range").Stg("first", res.First).Stg("last", res.Last))
+	}
+}
+
+func TestWriterCompressionFrameCheck(t *testing.T) {
+	const eventfile = "testdata/compressed-frame"
+	if err := os.RemoveAll(eventfile); err != nil {
+		tlog.Error(t, errors.Wrap(err, "delete existing event file"))
+		return
+	}
+
+	// Кадр вмещает несжатое событие максимальной длины, но не
+	// несжимаемое событие со служебным байтом.
+	_, err := NewWriter(eventfile, 16+1+127, 127, WriterCompression(CodecSnappy))
+	if err == nil {
+		t.Error("error was expected")
+		return
+	}
+
+	tlog.Log(t, errors.Wrap(err, "expected error"))
+}
+
+// compressibleString строка неплохо поддающаяся сжатию.
+func compressibleString(i int) string {
+	return string(bytes.Repeat([]byte("abcd"), 10+i%10))
+}
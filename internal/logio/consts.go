@@ -4,6 +4,9 @@ const (
 	// fileMetaInfoHeaderSize размер метаданных в начале файла.
 	fileMetaInfoHeaderSize = 16
 
+	// eventMinSize размер наименьшего события: индекс, длина и пустые данные.
+	eventMinSize = 16 + 1
+
 	// frameSizeHardLimit максимальный размер кадра не должен превышать 32Мб.
 	frameSizeHardLimit = 1024 * 1024 * 32 // 32Мб
 
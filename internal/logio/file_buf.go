@@ -2,12 +2,13 @@ package logio
 
 import (
 	"bufio"
-	"os"
+
+	"github.com/sirkon/mpy6a/internal/fsio"
 )
 
 type fileBuf struct {
 	buf *bufio.Reader
-	src *os.File
+	src fsio.File
 }
 
 // ReadByte для реализации logReader.
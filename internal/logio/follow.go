@@ -0,0 +1,95 @@
+package logio
+
+import (
+	"context"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/mpio"
+)
+
+// Follow аналог Next ожидающий появления новых событий в логе вместо
+// завершения итерирования. Доступно только для итераторов созданных
+// с помощью NewReaderInProcess. При переключении писалки на новый файл
+// (см. Writer.SwitchTo) вычитка продолжается уже из него.
+//
+// Возвращает false если:
+//
+//   - Контекст был отменён. Err в этом случае возвращает nil и можно
+//     продолжить итерирование повторным вызовом Follow.
+//   - Достигнута граница чтения заданная опциями или лог закрыт без
+//     переключения на новый файл.
+//   - Произошла ошибка.
+func (it *ReadIterator) Follow(ctx context.Context) bool {
+	if it.tail == nil {
+		it.err = errors.New("follow mode requires a reader of the log in process")
+		return false
+	}
+
+	for {
+		// Канал берётся до проверки наличия данных, чтобы не пропустить
+		// запись произошедшую между проверкой и ожиданием.
+		changed := it.tail.changed()
+
+		if it.Next() {
+			return true
+		}
+		if it.err != nil {
+			return false
+		}
+
+		if it.pos >= it.tail.committed.Load() {
+			if next := it.tail.next.Load(); next != nil {
+				if err := it.switchWriter(next); err != nil {
+					it.err = errors.Wrap(err, "switch to the next log")
+					return false
+				}
+
+				continue
+			}
+
+			if it.tail.closed.Load() {
+				return false
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// switchWriter переход к вычитке лога следующего за текущим.
+func (it *ReadIterator) switchWriter(w *Writer) error {
+	r, err := mpio.NewSimReader(w.dst, mpio.SimReaderOptions())
+	if err != nil {
+		return errors.Wrap(err, "create log file reader")
+	}
+
+	frame, evlim, codec, err := readMetadata(r)
+	if err != nil {
+		if cErr := r.Close(); cErr != nil {
+			return errors.Wrap(err, "read log file metadata").Str("close-error", cErr.Error())
+		}
+
+		return errors.Wrap(err, "read log file metadata")
+	}
+
+	if err := it.src.Close(); err != nil {
+		if cErr := r.Close(); cErr != nil {
+			return errors.Wrap(err, "close previous log reader").Str("close-error", cErr.Error())
+		}
+
+		return errors.Wrap(err, "close previous log reader")
+	}
+
+	it.src = r
+	it.frame = int(frame)
+	it.evlim = int(evlim)
+	it.codec = codec
+	it.pos = fileMetaInfoHeaderSize
+	it.tail = w
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package logio_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestReadIteratorFollow(t *testing.T) {
+	names := []string{"testdata/follow-1", "testdata/follow-2"}
+	for _, name := range names {
+		if err := os.RemoveAll(name); err != nil {
+			tlog.Error(t, errors.Wrap(err, "remove existing file"))
+			return
+		}
+	}
+
+	w, err := logio.NewWriter(names[0], 128, 32)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	it, err := logio.NewReaderInProcess(w)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create follower"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close follower"))
+		}
+	}()
+
+	const N = 200
+	errs := make(chan error, 1)
+	go func() {
+		errs <- func() error {
+			cur := w
+			for i := uint64(0); i < N; i++ {
+				if i == N/2 {
+					// Ротация лога посередине.
+					next, err := logio.NewWriter(names[1], 128, 32)
+					if err != nil {
+						return errors.Wrap(err, "create next writer")
+					}
+
+					cur.SwitchTo(next)
+					if err := cur.Close(); err != nil {
+						return errors.Wrap(err, "close previous writer")
+					}
+					cur = next
+				}
+
+				index := types.NewIndex(1, i)
+				if _, err := cur.WriteEvent(index, []byte(strconv.Itoa(int(i)))); err != nil {
+					return errors.Wrap(err, "write event").Stg("event-id", index)
+				}
+
+				if i%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			return cur.Close()
+		}()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var i uint64
+	for i < N && it.Follow(ctx) {
+		id, data, _ := it.Event()
+		if !types.IndexEqual(id, types.NewIndex(1, i)) || string(data) != strconv.Itoa(int(i)) {
+			tlog.Error(t, errors.New("unexpected event").
+				Stg("expected-event-id", types.NewIndex(1, i)).
+				Stg("actual-event-id", id).
+				Str("actual-event-data", string(data)))
+			return
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "follow log"))
+		return
+	}
+	if err := <-errs; err != nil {
+		tlog.Error(t, errors.Wrap(err, "write events"))
+		return
+	}
+	if i != N {
+		tlog.Error(t, errors.New("not all events were read").Uint64("events-read", i))
+		return
+	}
+
+	// Все события вычитаны, новых не будет.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if it.Follow(ctx) {
+		id, _, _ := it.Event()
+		tlog.Error(t, errors.New("unexpected event after the end").Stg("event-id", id))
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "follow after the end"))
+	}
+}
@@ -5,17 +5,23 @@ import (
 	"io"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 	"github.com/sirkon/mpy6a/internal/types"
 	"github.com/sirkon/mpy6a/internal/uvarints"
-	"golang.org/x/exp/mmap"
 )
 
 // LookupNext поиск отступа следующего за данным события.
 // Событие НЕ ДОЛЖНО быть первым или последним в логе.
 // Файл ОБЯЗАТЕЛЬНО должен содержать записи событий относящихся
 // как к более раннему, так и к более позднему периоду.
-func LookupNext(name string, id types.Index, logger func(error)) (_ LookupResult, err error) {
-	file, err := mmap.Open(name)
+func LookupNext(name string, id types.Index, logger func(error)) (LookupResult, error) {
+	return LookupNextFS(fsio.OS{}, name, id, logger)
+}
+
+// LookupNextFS поиск аналогичный LookupNext в логе name файловой
+// системы fsys.
+func LookupNextFS(fsys fsio.FS, name string, id types.Index, logger func(error)) (_ LookupResult, err error) {
+	file, err := fsys.Mmap(name)
 	if err != nil {
 		return nil, errors.Wrap(err, "open file")
 	}
@@ -205,7 +211,9 @@ func LookupNext(name string, id types.Index, logger func(error)) (_ LookupResult
 	}
 }
 
-func readMmapedFileHeader(file *mmap.ReaderAt) (frame uint64, limit uint64, err error) {
+// readMmapedFileHeader чтение заголовка файла. Возвращаемый limit учитывает
+// возможное увеличение данных событий при их сжатии.
+func readMmapedFileHeader(file fsio.ReaderAt) (frame uint64, limit uint64, err error) {
 	var buf [fileMetaInfoHeaderSize]byte
 	read, err := file.ReadAt(buf[:], 0)
 	if err != nil {
@@ -218,7 +226,10 @@ func readMmapedFileHeader(file *mmap.ReaderAt) (frame uint64, limit uint64, err
 	}
 
 	frame = binary.LittleEndian.Uint64(buf[:8])
-	limit = binary.LittleEndian.Uint64(buf[8:])
+	limit, codec, err := splitEvlim(binary.LittleEndian.Uint64(buf[8:]))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "decode evlim")
+	}
 
 	if frame > frameSizeHardLimit {
 		return 0, 0, errors.New("invalid frame size").
@@ -235,7 +246,7 @@ func readMmapedFileHeader(file *mmap.ReaderAt) (frame uint64, limit uint64, err
 			Int("least-evlim", 18)
 	}
 
-	return frame, limit, nil
+	return frame, limit + uint64(codecOverhead(codec)), nil
 }
 
 // LookupResult обёртка для результата поиска.
@@ -0,0 +1,258 @@
+package logio
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+	"github.com/sirkon/mpy6a/internal/uvarints"
+)
+
+// LookupRange поиск отступов начала и конца диапазона событий [from, to]
+// в файле лога. В отличие от LookupNext, границы диапазона могут совпадать
+// с первым или последним событием файла, а также выходить за его пределы.
+//
+// Для вычитки найденного диапазона достаточно
+//
+//	NewReader(name, ReaderStart(res.Start), ReaderReadTo(to))
+func LookupRange(name string, from, to types.Index, logger func(error)) (*LookupRangeResult, error) {
+	return LookupRangeFS(fsio.OS{}, name, from, to, logger)
+}
+
+// LookupRangeFS поиск аналогичный LookupRange в логе name файловой
+// системы fsys.
+func LookupRangeFS(
+	fsys fsio.FS,
+	name string,
+	from types.Index,
+	to types.Index,
+	logger func(error),
+) (_ *LookupRangeResult, err error) {
+	if types.IndexLess(to, from) {
+		return nil, errors.New("invalid range, the end is before the start").
+			Stg("range-start", from).
+			Stg("range-finish", to)
+	}
+
+	file, err := fsys.Mmap(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+	defer func() {
+		if cErr := file.Close(); cErr != nil {
+			if err == nil {
+				err = cErr
+				return
+			}
+
+			logger(errors.Wrap(cErr, "close file"))
+		}
+	}()
+
+	frame, _, err := readMmapedFileHeader(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file metadata")
+	}
+
+	s := mmapScanner{
+		file:  file,
+		frame: frame,
+	}
+
+	res := &LookupRangeResult{
+		Start:  uint64(file.Len()),
+		Finish: uint64(file.Len()),
+	}
+
+	// Ищем первое событие не меньшее from.
+	if err := s.seekFrame(from); err != nil {
+		return nil, errors.Wrap(err, "look for the frame of the range start")
+	}
+	for {
+		id, pos, size, err := s.next()
+		if err != nil {
+			return nil, errors.Wrap(err, "look for the range start")
+		}
+		if size == 0 {
+			// Все события файла предшествуют диапазону.
+			return res, nil
+		}
+
+		if types.IndexLess(id, from) {
+			continue
+		}
+
+		if types.IndexLess(to, id) {
+			// Событий из диапазона в файле нет.
+			res.Start = pos
+			res.Finish = pos
+			return res, nil
+		}
+
+		res.Start = pos
+		res.First = id
+		res.Last = id
+		res.Finish = pos + uint64(size)
+		break
+	}
+
+	// Ищем последнее событие не большее to. Если конец диапазона лежит
+	// далеко, то сразу переходим к его кадру.
+	if err := s.seekFrameAfter(to, res.Finish); err != nil {
+		return nil, errors.Wrap(err, "look for the frame of the range finish")
+	}
+	for {
+		id, pos, size, err := s.next()
+		if err != nil {
+			return nil, errors.Wrap(err, "look for the range finish")
+		}
+		if size == 0 || types.IndexLess(to, id) {
+			return res, nil
+		}
+
+		if !types.IndexLess(res.Last, id) {
+			return nil, errors.Wrap(ErrorLogIntegrityCompromised{}, "events order violation").
+				Stg("previous-event-id", res.Last).
+				Stg("event-id", id).
+				Uint64("event-offset", pos)
+		}
+
+		res.Last = id
+		res.Finish = pos + uint64(size)
+	}
+}
+
+// LookupRangeResult результат поиска диапазона событий.
+type LookupRangeResult struct {
+	// Start отступ первого события диапазона. Если событий диапазона в файле
+	// нет, то это отступ первого события после диапазона, либо длина файла.
+	Start uint64
+
+	// Finish отступ сразу за последним событием диапазона.
+	Finish uint64
+
+	// First идентификатор первого события диапазона в файле.
+	First types.Index
+
+	// Last идентификатор последнего события диапазона в файле.
+	Last types.Index
+}
+
+// Empty проверка, что в файле не нашлось событий из диапазона.
+func (r *LookupRangeResult) Empty() bool {
+	return r.First.Term == 0
+}
+
+// mmapScanner последовательный просмотр событий отображённого в память
+// файла лога без вычитки их данных.
+type mmapScanner struct {
+	file  fsio.ReaderAt
+	frame uint64
+	pos   uint64
+}
+
+// seekFrame переход на начало последнего кадра, первое событие
+// которого не превосходит id. Если таких кадров нет, то переходит
+// на начало первого кадра.
+func (s *mmapScanner) seekFrame(id types.Index) error {
+	return s.seekFrameAfter(id, fileMetaInfoHeaderSize)
+}
+
+// seekFrameAfter аналогично seekFrame, но поиск ведётся только среди
+// кадров начиная с того, которому принадлежит позиция pos, при этом
+// просмотр не может переместиться раньше pos.
+func (s *mmapScanner) seekFrameAfter(id types.Index, pos uint64) error {
+	l := uint64(s.file.Len())
+	left := s.frameOf(pos)
+	right := (l - fileMetaInfoHeaderSize) / s.frame
+	if (l-fileMetaInfoHeaderSize)%s.frame != 0 {
+		// right это индекс следующего за последним кадра.
+		right++
+	}
+
+	for right-left > 1 {
+		c := left + (right-left)/2
+		cpos := c*s.frame + fileMetaInfoHeaderSize
+
+		var buf [16]byte
+		if _, err := s.file.ReadAt(buf[:], int64(cpos)); err != nil {
+			return errors.Wrap(err, "read first event index").
+				Uint64("frame-no", c).
+				Uint64("frame-offset", cpos)
+		}
+
+		var cid types.Index
+		types.IndexDecode(&cid, buf[:])
+		if cid.Term == 0 {
+			return errors.Wrap(ErrorLogIntegrityCompromised{}, "frame starts with an empty event").
+				Uint64("frame-no", c).
+				Uint64("frame-offset", cpos)
+		}
+
+		if types.IndexLess(id, cid) {
+			right = c
+		} else {
+			left = c
+		}
+	}
+
+	start := left*s.frame + fileMetaInfoHeaderSize
+	if start < pos {
+		start = pos
+	}
+	s.pos = start
+	return nil
+}
+
+// next возвращает идентификатор, отступ и размер очередного события.
+// Нулевой размер означает, что события закончились.
+func (s *mmapScanner) next() (id types.Index, pos uint64, size int, err error) {
+	l := uint64(s.file.Len())
+
+	for {
+		if s.pos+16 > l {
+			return id, s.pos, 0, nil
+		}
+
+		rest := s.frame - (s.pos-fileMetaInfoHeaderSize)%s.frame
+		if rest < eventMinSize {
+			s.pos += rest
+			continue
+		}
+
+		var buf [16 + 10]byte
+		n, err := s.file.ReadAt(buf[:], int64(s.pos))
+		if n < 16 {
+			return id, s.pos, 0, errors.Wrap(err, "read event header").Uint64("event-offset", s.pos)
+		}
+
+		types.IndexDecode(&id, buf[:16])
+		if id.Term == 0 {
+			// Остаток кадра заполнен нулями.
+			s.pos += rest
+			continue
+		}
+
+		length, _, err := uvarints.Read(buf[16:n])
+		if err != nil {
+			return id, s.pos, 0, errors.Wrap(err, "read event data length").
+				Stg("event-id", id).
+				Uint64("event-offset", s.pos)
+		}
+
+		size = 16 + uvarints.LengthInt(length) + int(length)
+		if uint64(size) > rest || s.pos+uint64(size) > l {
+			return id, s.pos, 0, errors.Wrap(ErrorLogIntegrityCompromised{}, "event is out of frame bounds").
+				Stg("event-id", id).
+				Uint64("event-offset", s.pos).
+				Int("event-size", size)
+		}
+
+		pos = s.pos
+		s.pos += uint64(size)
+		return id, pos, size, nil
+	}
+}
+
+func (s *mmapScanner) frameOf(pos uint64) uint64 {
+	return (pos - fileMetaInfoHeaderSize) / s.frame
+}
@@ -0,0 +1,257 @@
+package logio
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestLookupRange(t *testing.T) {
+	const eventfile = "testdata/lookup_range_test"
+	var (
+		eventData = []byte("Hello")
+		terms     = []int{1, 2, 4}
+	)
+
+	if err := os.RemoveAll(eventfile); err != nil {
+		tlog.Error(t, errors.Wrap(err, "delete existing event file"))
+	}
+
+	w, err := NewWriter(eventfile, 128, 32, WriterBufferSize(256))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	for _, term := range terms {
+		for i := 0; i < 100; i++ {
+			index := types.NewIndex(uint64(term), uint64(i))
+			if _, err := w.WriteEvent(index, eventData); err != nil {
+				tlog.Error(t, errors.Wrap(err, "write event").Stg("write-error-index", index))
+				return
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	type test struct {
+		name  string
+		from  types.Index
+		to    types.Index
+		first *types.Index
+		last  *types.Index
+		count int
+	}
+
+	tests := []test{
+		{
+			name:  "whole file",
+			from:  types.NewIndex(1, 0),
+			to:    types.NewIndex(4, 99),
+			first: ptrIndex(1, 0),
+			last:  ptrIndex(4, 99),
+			count: 300,
+		},
+		{
+			name:  "wider than file",
+			from:  types.NewIndex(0, 5),
+			to:    types.NewIndex(100, 0),
+			first: ptrIndex(1, 0),
+			last:  ptrIndex(4, 99),
+			count: 300,
+		},
+		{
+			name:  "first only",
+			from:  types.NewIndex(1, 0),
+			to:    types.NewIndex(1, 0),
+			first: ptrIndex(1, 0),
+			last:  ptrIndex(1, 0),
+			count: 1,
+		},
+		{
+			name:  "last only",
+			from:  types.NewIndex(4, 99),
+			to:    types.NewIndex(4, 99),
+			first: ptrIndex(4, 99),
+			last:  ptrIndex(4, 99),
+			count: 1,
+		},
+		{
+			name:  "inside",
+			from:  types.NewIndex(2, 17),
+			to:    types.NewIndex(2, 63),
+			first: ptrIndex(2, 17),
+			last:  ptrIndex(2, 63),
+			count: 47,
+		},
+		{
+			name:  "across terms with missing edges",
+			from:  types.NewIndex(1, 150),
+			to:    types.NewIndex(3, 10),
+			first: ptrIndex(2, 0),
+			last:  ptrIndex(2, 99),
+			count: 100,
+		},
+		{
+			name: "gap between terms",
+			from: types.NewIndex(3, 0),
+			to:   types.NewIndex(3, 1000),
+		},
+		{
+			name: "after the end",
+			from: types.NewIndex(5, 0),
+			to:   types.NewIndex(6, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := LookupRange(eventfile, tt.from, tt.to, func(err error) {
+				tlog.Error(t, err)
+			})
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "look for the range"))
+				return
+			}
+
+			if tt.first == nil {
+				if !res.Empty() {
+					tlog.Error(t, errors.New("unexpected events in the range").
+						Stg("range-first", res.First).
+						Stg("range-last", res.Last))
+				}
+				return
+			}
+
+			if !types.IndexEqual(res.First, *tt.first) || !types.IndexEqual(res.Last, *tt.last) {
+				tlog.Error(t, errors.New("range bounds mismatch").
+					Stg("expected-first", *tt.first).
+					Stg("actual-first", res.First).
+					Stg("expected-last", *tt.last).
+					Stg("actual-last", res.Last))
+				return
+			}
+
+			it, err := NewReader(eventfile, ReaderStart(res.Start), ReaderReadTo(tt.to))
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "open range reader"))
+				return
+			}
+			defer func() {
+				if err := it.Close(); err != nil {
+					tlog.Error(t, errors.Wrap(err, "close range reader"))
+				}
+			}()
+
+			var count int
+			var last types.Index
+			for it.Next() {
+				id, _, _ := it.Event()
+				if count == 0 && !types.IndexEqual(id, *tt.first) {
+					tlog.Error(t, errors.New("unexpected first event").
+						Stg("expected-event-id", *tt.first).
+						Stg("actual-event-id", id))
+					return
+				}
+				last = id
+				count++
+			}
+			if err := it.Err(); err != nil {
+				tlog.Error(t, errors.Wrap(err, "read range"))
+				return
+			}
+
+			if count != tt.count || !types.IndexEqual(last, *tt.last) {
+				tlog.Error(t, errors.New("range content mismatch").
+					Int("expected-count", tt.count).
+					Int("actual-count", count).
+					Stg("expected-last", *tt.last).
+					Stg("actual-last", last))
+			}
+		})
+	}
+}
+
+func TestLookupRangeEmptyEventAtFrameEnd(t *testing.T) {
+	const eventfile = "testdata/lookup_range_empty_test"
+
+	if err := os.RemoveAll(eventfile); err != nil {
+		tlog.Error(t, errors.Wrap(err, "delete existing event file"))
+	}
+
+	// Первое событие оставляет в кадре ровно место под событие с пустыми
+	// данными.
+	const frame = 128
+	w, err := NewWriter(eventfile, frame, frame-eventMinSize-17)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+	events := []struct {
+		id   types.Index
+		data []byte
+	}{
+		{id: types.NewIndex(1, 1), data: make([]byte, frame-eventMinSize-17)},
+		{id: types.NewIndex(1, 2)},
+		{id: types.NewIndex(1, 3), data: []byte("Hello")},
+	}
+	for _, e := range events {
+		if _, err := w.WriteEvent(e.id, e.data); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Stg("write-error-index", e.id))
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	res, err := LookupRange(eventfile, types.NewIndex(1, 2), types.NewIndex(1, 2), func(err error) {
+		tlog.Error(t, err)
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "look for the range"))
+		return
+	}
+	if !types.IndexEqual(res.First, types.NewIndex(1, 2)) || !types.IndexEqual(res.Last, types.NewIndex(1, 2)) {
+		tlog.Error(t, errors.New("empty event at the frame end was not found").
+			Stg("range-first", res.First).
+			Stg("range-last", res.Last))
+		return
+	}
+	if res.Finish != fileMetaInfoHeaderSize+frame {
+		t.Errorf("expected range finish at the frame end %d, got %d", fileMetaInfoHeaderSize+frame, res.Finish)
+	}
+
+	it, err := NewReader(eventfile)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open reader"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close reader"))
+		}
+	}()
+
+	var ids []types.Index
+	for it.Next() {
+		id, _, _ := it.Event()
+		ids = append(ids, id)
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read events"))
+		return
+	}
+	if len(ids) != len(events) {
+		t.Errorf("expected %d events, got %v", len(events), ids)
+	}
+}
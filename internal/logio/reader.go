@@ -4,17 +4,23 @@ import (
 	"bufio"
 	"encoding/binary"
 	"io"
-	"os"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 	"github.com/sirkon/mpy6a/internal/mpio"
 	"github.com/sirkon/mpy6a/internal/types"
 	"github.com/sirkon/mpy6a/internal/uvarints"
 )
 
 // NewReader создаёт итератор для чтения записанных в файл событий из лога.
-func NewReader(name string, opts ...ReaderOption) (_ *ReadIterator, err error) {
-	file, err := os.Open(name)
+func NewReader(name string, opts ...ReaderOption) (*ReadIterator, error) {
+	return NewReaderFS(fsio.OS{}, name, opts...)
+}
+
+// NewReaderFS создаёт итератор для чтения событий лога name
+// файловой системы fsys, см. NewReader.
+func NewReaderFS(fsys fsio.FS, name string, opts ...ReaderOption) (_ *ReadIterator, err error) {
+	file, err := fsys.Open(name)
 	if err != nil {
 		return nil, errors.Wrap(err, "open log file")
 	}
@@ -31,7 +37,7 @@ func NewReader(name string, opts ...ReaderOption) (_ *ReadIterator, err error) {
 
 	buf := bufio.NewReader(file)
 
-	frame, evlim, err := readMetadata(buf)
+	frame, evlim, codec, err := readMetadata(buf)
 	if err != nil {
 		return nil, errors.Wrap(err, "load file metadata")
 	}
@@ -43,6 +49,7 @@ func NewReader(name string, opts ...ReaderOption) (_ *ReadIterator, err error) {
 		},
 		frame: int(frame),
 		evlim: int(evlim),
+		codec: codec,
 		pos:   fileMetaInfoHeaderSize,
 	}
 	if err := res.applyOptions(opts...); err != nil {
@@ -60,7 +67,7 @@ func NewReaderInProcess(w *Writer, opts ...ReaderOption) (*ReadIterator, error)
 		return nil, errors.Wrap(err, "create log file reader")
 	}
 
-	frame, evlim, err := readMetadata(r)
+	frame, evlim, codec, err := readMetadata(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "read log file metadata")
 	}
@@ -69,7 +76,9 @@ func NewReaderInProcess(w *Writer, opts ...ReaderOption) (*ReadIterator, error)
 		src:   r,
 		frame: int(frame),
 		evlim: int(evlim),
+		codec: codec,
 		pos:   fileMetaInfoHeaderSize,
+		tail:  w,
 	}
 	if err := res.applyOptions(opts...); err != nil {
 		return nil, errors.Wrap(err, "apply options")
@@ -93,13 +102,20 @@ type ReadIterator struct {
 	src   logReader
 	frame int
 	evlim int
+	codec Codec
 	pos   uint64
 
+	offset uint64 // Смещение текущего события.
 	id     types.Index
 	before types.Index
 	data   []byte
+	plain  []byte // Буфер для раскодирования сжатых данных.
+	event  []byte // Данные текущего события.
 	delta  int
 	err    error
+
+	// tail писалка лога при вычитке лога всё ещё используемого системой.
+	tail *Writer
 }
 
 // Next вычитка следующего события.
@@ -107,10 +123,15 @@ func (it *ReadIterator) Next() bool {
 	if it.err != nil {
 		return false
 	}
+	if it.tail != nil && it.pos >= it.tail.committed.Load() {
+		// Вычитаны все полностью записанные события, но лог может
+		// пополняться и дальше.
+		return false
+	}
 	it.delta = 0
 
 	var passed bool
-	if it.frameRest() < 18 {
+	if it.frameRest() < eventMinSize {
 		passed = true
 		it.delta = it.frameRest()
 		if err := it.passBytes(it.frameRest()); err != nil {
@@ -173,17 +194,50 @@ func (it *ReadIterator) Next() bool {
 		return false
 	} else if n < l {
 		it.err = errors.New("missing event data").Int("expected-length", l).Int("actual-length", n)
+		return false
+	}
+
+	if it.codec != CodecNone && it.plain == nil {
+		it.plain = make([]byte, it.evlim)
+	}
+	it.event, err = decompressEvent(it.plain, it.codec, it.evlim, it.data)
+	if err != nil {
+		it.err = errors.Wrap(err, "decode event data").Stg("event-id", it.id)
+		return false
 	}
 
-	it.delta += 16 + uvarints.LengthInt(l) + l
+	length := 16 + uvarints.LengthInt(l) + l
+	it.delta += length
+	it.offset = it.pos + uint64(it.delta-length)
 	it.pos += uint64(it.delta)
 	return true
 }
 
 // Event получить событие. Кроме данных события возвращается так же
-// длина данных из файла, которые пришлось вычитать.
+// длина данных из файла, которые пришлось вычитать. Сжатые данные
+// возвращаются уже раскодированными.
 func (it *ReadIterator) Event() (id types.Index, data []byte, size int) {
-	return it.id, it.data, it.delta
+	return it.id, it.event, it.delta
+}
+
+// Offset смещение текущего события от начала файла.
+func (it *ReadIterator) Offset() uint64 {
+	return it.offset
+}
+
+// Frame размер кадра лога.
+func (it *ReadIterator) Frame() int {
+	return it.frame
+}
+
+// EventLimit ограничение на размер данных события.
+func (it *ReadIterator) EventLimit() int {
+	return it.evlim
+}
+
+// Codec алгоритм сжатия данных событий.
+func (it *ReadIterator) Codec() Codec {
+	return it.codec
 }
 
 func (it *ReadIterator) Err() error {
@@ -235,29 +289,32 @@ func (it *ReadIterator) frameRest() int {
 	return v
 }
 
-func readMetadata(buf io.Reader) (frame uint64, evlim uint64, err error) {
+func readMetadata(buf io.Reader) (frame uint64, evlim uint64, codec Codec, err error) {
 	var tmp [16]byte
 	if _, err := mpio.TryReadFull(buf, tmp[:]); err != nil {
-		return 0, 0, errors.Wrap(err, "read metadata")
+		return 0, 0, 0, errors.Wrap(err, "read metadata")
 	}
 
 	frame = binary.LittleEndian.Uint64(tmp[:8])
-	evlim = binary.LittleEndian.Uint64(tmp[8:])
+	evlim, codec, err = splitEvlim(binary.LittleEndian.Uint64(tmp[8:]))
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "decode evlim")
+	}
 
 	if frame > frameSizeHardLimit {
-		return 0, 0, errors.New("invalid frame size").
+		return 0, 0, 0, errors.New("invalid frame size").
 			Uint64("invalid-frame-size", frame)
 	}
 	if frame < evlim {
-		return 0, 0, errors.New("frame cannot be smaller than an event evlim").
+		return 0, 0, 0, errors.New("frame cannot be smaller than an event evlim").
 			Uint64("frame-size", frame).
 			Uint64("event-evlim-size", evlim)
 	}
 	if evlim < 18 {
-		return 0, 0, errors.New("event evlim is too small").
+		return 0, 0, 0, errors.New("event evlim is too small").
 			Uint64("invalid-evlim", evlim).
 			Int("least-event-evlim", 18)
 	}
 
-	return frame, evlim, nil
+	return frame, evlim, codec, nil
 }
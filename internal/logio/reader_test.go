@@ -132,6 +132,31 @@ func TestReadIterator(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("event offsets", func(t *testing.T) {
+		it, err := logio.NewReader(filename)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "create iterator"))
+			return
+		}
+		defer func() {
+			if err := it.Close(); err != nil {
+				tlog.Error(t, errors.Wrap(err, "close iterator"))
+			}
+		}()
+
+		for it.Next() {
+			index, data, _ := it.Event()
+			// Индекс, длина данных в один байт и сами данные.
+			end := it.Offset() + 16 + 1 + uint64(len(data))
+			if end != positions[index.Index] {
+				t.Errorf("event %s ends at %d, expected %d", index, end, positions[index.Index])
+			}
+		}
+		if err := it.Err(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "iterate over log"))
+		}
+	})
 }
 
 func checkIteratorEvents(t *testing.T, it *logio.ReadIterator, start, finish types.Index) error {
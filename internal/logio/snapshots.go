@@ -6,11 +6,14 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/sirkon/mpy6a/internal/datadir"
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 )
 
 // Snapshots объект для чтения имён слепков.
 type Snapshots struct {
+	fs       fsio.FS
 	name     string
 	lastSnap string
 	logger   func(err error)
@@ -18,7 +21,14 @@ type Snapshots struct {
 
 // NewSnapshots конструктор Snapshots.
 func NewSnapshots(name string, logger func(err error)) *Snapshots {
+	return NewSnapshotsFS(fsio.OS{}, name, logger)
+}
+
+// NewSnapshotsFS конструктор Snapshots для лога имён слепков name
+// файловой системы fsys.
+func NewSnapshotsFS(fsys fsio.FS, name string, logger func(err error)) *Snapshots {
 	return &Snapshots{
+		fs:     fsys,
 		name:   name,
 		logger: logger,
 	}
@@ -29,7 +39,7 @@ const maxFileNameSize = 1024
 
 // ReadName чтение имени последнего слепка из лога имён слепков.
 func (s *Snapshots) ReadName() (string, error) {
-	file, err := os.Open(s.name)
+	file, err := s.fs.Open(s.name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return "", nil
@@ -83,7 +93,7 @@ func (s *Snapshots) WriteName(name string) error {
 			Int("snapshot-name-length-limit", maxFileNameSize)
 	}
 
-	file, err := os.OpenFile(s.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := s.fs.OpenFile(s.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return errors.Wrapf(err, "open file")
 	}
@@ -116,23 +126,20 @@ func (s *Snapshots) Rotate() error {
 	}
 
 	dir, _ := filepath.Split(s.name)
-	tmpName := filepath.Join(dir, "temporary-snapshots-log-file")
-	if err := os.WriteFile(tmpName, []byte(s.lastSnap+"\n"), 0644); err != nil {
-		return errors.Wrapf(err, "write temporary file")
+	file, err := datadir.CreatePendingFS(s.fs, datadir.TempName(dir, "snapshots-log-file"))
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
 	}
 
-	var failed bool
-	defer func() {
-		if !failed {
-			return
+	if _, err := io.WriteString(file, s.lastSnap+"\n"); err != nil {
+		if dErr := file.Discard(); dErr != nil {
+			s.logger(errors.Wrapf(dErr, "remove temporary snapshots log file"))
 		}
 
-		if err := os.RemoveAll(tmpName); err != nil {
-			s.logger(errors.Wrapf(err, "remove temporary snapshots log file"))
-		}
-	}()
+		return errors.Wrapf(err, "write temporary file")
+	}
 
-	if err := os.Rename(tmpName, s.name); err != nil {
+	if err := file.Publish(s.name); err != nil {
 		return errors.Wrapf(err, "replace old snapshots log file content with temporary file data")
 	}
 
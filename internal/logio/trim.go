@@ -0,0 +1,83 @@
+package logio
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// TrimTail обрезка оборванного хвоста лога name после аварии: всё, что
+// идёт за последним полностью записанным событием, отбрасывается, и
+// запись в лог можно продолжать, см. NewWriter. Возвращает индекс этого
+// события, нулевой для лога без событий, и новый размер файла.
+func TrimTail(name string, logger func(error)) (last types.Index, size uint64, err error) {
+	return TrimTailFS(fsio.OS{}, name, logger)
+}
+
+// TrimTailFS обрезка оборванного хвоста лога name файловой системы
+// fsys, см. TrimTail.
+func TrimTailFS(fsys fsio.FS, name string, logger func(error)) (last types.Index, size uint64, err error) {
+	it, err := NewReaderFS(fsys, name)
+	if err != nil {
+		return last, 0, errors.Wrap(err, "open log")
+	}
+
+	size = fileMetaInfoHeaderSize
+	for it.Next() {
+		last, _, _ = it.Event()
+		size = it.pos
+	}
+	if err := it.Err(); err != nil {
+		// Это и есть оборванный хвост.
+		logger(errors.Wrap(err, "read events").Stg("last-read-id", last).Uint64("trim-position", size))
+	}
+
+	// Файл открыт только на чтение, ошибка его закрытия не важна.
+	_ = it.Close()
+
+	if err := fsys.Truncate(name, int64(size)); err != nil {
+		return last, 0, errors.Wrap(err, "truncate log").Uint64("trim-position", size)
+	}
+
+	return last, size, nil
+}
+
+// Head начало лога name по событие last включительно: возвращает индекс
+// последнего события начала, нулевой если все события лога идут после
+// last, и размер начала в байтах. События после last могут дописываться
+// в лог в это же время, но все события по last включительно должны
+// быть уже сброшены в файл.
+func Head(name string, last types.Index) (head types.Index, size uint64, err error) {
+	return HeadFS(fsio.OS{}, name, last)
+}
+
+// HeadFS начало лога name файловой системы fsys, см. Head.
+func HeadFS(fsys fsio.FS, name string, last types.Index) (head types.Index, size uint64, err error) {
+	it, err := NewReaderFS(fsys, name)
+	if err != nil {
+		return head, 0, errors.Wrap(err, "open log")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = it.Close()
+	}()
+
+	size = fileMetaInfoHeaderSize
+	for it.Next() {
+		id, _, _ := it.Event()
+		if types.IndexLess(last, id) {
+			return head, size, nil
+		}
+
+		head = id
+		size = it.pos
+		if types.IndexEqual(id, last) {
+			return head, size, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return head, 0, errors.Wrap(err, "read events").Stg("last-read-id", head)
+	}
+
+	return head, size, nil
+}
@@ -0,0 +1,166 @@
+package logio_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestTrimTail(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	w, err := logio.NewWriter(name, 128, 32)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	const N = 10
+	var positions []uint64
+	for i := uint64(0); i < N; i++ {
+		if _, err := w.WriteEvent(types.NewIndex(1, i), []byte(strconv.Itoa(int(i)))); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Uint64("event-seq", i))
+			return
+		}
+		positions = append(positions, w.Pos())
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	// Обрыв записи последнего события.
+	if err := os.Truncate(name, int64(positions[N-1]-3)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cut log tail"))
+		return
+	}
+
+	var logged int
+	last, size, err := logio.TrimTail(name, func(err error) {
+		logged++
+		tlog.Log(t, err)
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "trim log tail"))
+		return
+	}
+
+	if logged != 1 {
+		t.Errorf("expected torn tail to be reported once, got %d", logged)
+	}
+	if !types.IndexEqual(last, types.NewIndex(1, N-2)) {
+		t.Errorf("expected last event %s, got %s", types.NewIndex(1, N-2), last)
+	}
+	if size != positions[N-2] {
+		t.Errorf("expected size %d, got %d", positions[N-2], size)
+	}
+
+	// После обрезки запись продолжается с места обрыва.
+	w, err = logio.NewWriter(name, 128, 32)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "reopen writer"))
+		return
+	}
+	if _, err := w.WriteEvent(types.NewIndex(1, N-1), []byte("again")); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write event after trim"))
+		return
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close reopened writer"))
+		return
+	}
+
+	it, err := logio.NewReader(name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open reader"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close reader"))
+		}
+	}()
+
+	var count uint64
+	for it.Next() {
+		id, _, _ := it.Event()
+		if !types.IndexEqual(id, types.NewIndex(1, count)) {
+			t.Errorf("expected event %s, got %s", types.NewIndex(1, count), id)
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read events"))
+		return
+	}
+	if count != N {
+		t.Errorf("expected %d events, got %d", N, count)
+	}
+}
+
+func TestHead(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	w, err := logio.NewWriter(name, 128, 32)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+
+	const N = 10
+	positions := []uint64{w.Pos()}
+	for i := uint64(1); i <= N; i++ {
+		if _, err := w.WriteEvent(types.NewIndex(1, i), []byte(strconv.Itoa(int(i)))); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Uint64("event-seq", i))
+			return
+		}
+		positions = append(positions, w.Pos())
+	}
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close writer"))
+		return
+	}
+
+	tests := []struct {
+		name string
+		last types.Index
+		head types.Index
+		size uint64
+	}{
+		{
+			name: "before first event",
+			last: types.NewIndex(0, 5),
+			size: positions[0],
+		},
+		{
+			name: "middle event",
+			last: types.NewIndex(1, 4),
+			head: types.NewIndex(1, 4),
+			size: positions[4],
+		},
+		{
+			name: "after last event",
+			last: types.NewIndex(2, 0),
+			head: types.NewIndex(1, N),
+			size: positions[N],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, size, err := logio.Head(name, tt.last)
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "look for log head"))
+				return
+			}
+
+			if !types.IndexEqual(head, tt.head) || size != tt.size {
+				t.Errorf("expected head %s of %d bytes, got %s of %d bytes", tt.head, tt.size, head, size)
+			}
+		})
+	}
+}
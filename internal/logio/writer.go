@@ -6,8 +6,11 @@ import (
 	"encoding/binary"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 	"github.com/sirkon/mpy6a/internal/mpio"
 	"github.com/sirkon/mpy6a/internal/types"
 	"github.com/sirkon/mpy6a/internal/uvarints"
@@ -24,6 +27,18 @@ func NewWriter(
 	frame int,
 	evlim int,
 	opts ...WriterOption,
+) (*Writer, error) {
+	return NewWriterFS(fsio.OS{}, name, frame, evlim, opts...)
+}
+
+// NewWriterFS конструктор писалки в файл name файловой системы fsys,
+// параметры аналогичны NewWriter.
+func NewWriterFS(
+	fsys fsio.FS,
+	name string,
+	frame int,
+	evlim int,
+	opts ...WriterOption,
 ) (*Writer, error) {
 	eventMayNeed := 16 + uvarints.LengthInt(evlim) + evlim
 	if frame < eventMayNeed {
@@ -42,17 +57,18 @@ func NewWriter(
 			Int("evlim", evlim)
 	}
 
-	var file *os.File
+	var file fsio.File
 	var res Writer
+	res.fs = fsys
 	res.wtnid = types.NewIndexAtomic()
 
-	if _, err := os.Stat(name); err != nil {
+	if _, err := fsys.Stat(name); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, errors.Wrap(err, "test existing file")
 		}
 
 		// Файла не существует, создаём новый и пишем frame, evlim в его начале.
-		file, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		file, err = fsys.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			return nil, errors.Wrap(err, "create new file")
 		}
@@ -61,10 +77,11 @@ func NewWriter(
 			return nil, errors.Wrap(err, "write header into a new file")
 		}
 		res.pos = fileMetaInfoHeaderSize
+		res.created = true
 
 	} else {
 		// Файл существует, читаем параметры frame и evlim из него.
-		file, err = os.OpenFile(name, os.O_RDWR, 0644)
+		file, err = fsys.OpenFile(name, os.O_RDWR, 0644)
 		if err != nil {
 			return nil, errors.Wrap(err, "open existing file")
 		}
@@ -75,11 +92,17 @@ func NewWriter(
 			if err := writeHeader(file, frame, evlim); err != nil {
 				return nil, errors.Wrap(err, "write header into an existing empty file")
 			}
+			res.created = true
 		} else if err != nil {
 			return nil, errors.Wrap(err, "read header of an existing file")
 		} else {
 			frame = int(binary.LittleEndian.Uint64(buf[:8]))
-			evlim = int(binary.LittleEndian.Uint64(buf[8:]))
+			v, codec, err := splitEvlim(binary.LittleEndian.Uint64(buf[8:]))
+			if err != nil {
+				return nil, errors.Wrap(err, "decode evlim of an existing file")
+			}
+			evlim = int(v)
+			res.codec = codec
 		}
 
 		stat, err := file.Stat()
@@ -115,16 +138,25 @@ func NewWriter(
 		res.bufsize = defaultBufferCapacityInEvents * eventMayNeed
 	}
 
+	var dst mpio.File = file
+	if res.wrap != nil {
+		var err error
+		if dst, err = res.wrap(file); err != nil {
+			return nil, errors.Wrap(err, "wrap file")
+		}
+	}
+
 	res.dst = mpio.NewSimWriterFile(
-		file,
+		dst,
 		res.pos,
-		mpio.SimWriterOptions().BufferSize(res.bufsize).WritePosition(res.pos),
+		mpio.SimWriterOptions().BufferSize(res.bufsize).WritePosition(res.pos).FS(fsys),
 	)
+	res.committed.Store(res.pos)
 
 	return &res, nil
 }
 
-func readLastEventID(file *os.File, stat os.FileInfo, frame int) (id types.Index, err error) {
+func readLastEventID(file fsio.File, stat os.FileInfo, frame int) (id types.Index, err error) {
 	if stat.Size() <= fileMetaInfoHeaderSize {
 		// Файл был создан, но записей в него не было.
 		return id, nil
@@ -187,6 +219,7 @@ func readLastEventID(file *os.File, stat os.FileInfo, frame int) (id types.Index
 // Writer писалка логов.
 type Writer struct {
 	dst    *mpio.SimWriter
+	fs     fsio.FS
 	buf    *bytes.Buffer
 	zeroes []byte
 	wtnid  types.IndexAtomic
@@ -196,6 +229,17 @@ type Writer struct {
 	pos     uint64
 	lastid  types.Index
 	bufsize int
+	codec   Codec
+	cbuf    []byte
+	created bool // Файл был создан этой писалкой.
+	wrap    func(file fsio.File) (mpio.File, error)
+
+	// Данные для итераторов следящих за логом.
+	committed  atomic.Uint64 // Позиция за последним полностью записанным событием.
+	closed     atomic.Bool
+	next       atomic.Pointer[Writer]
+	notifyLock sync.Mutex
+	notify     chan struct{}
 }
 
 // WriteEvent запись события с данным идентификатором.
@@ -208,6 +252,11 @@ func (w *Writer) WriteEvent(id types.Index, data []byte) (int, error) {
 		}
 	}
 
+	if w.codec != CodecNone {
+		w.cbuf = compressEvent(w.cbuf, w.codec, data)
+		data = w.cbuf
+	}
+
 	var deltapos int
 	l := eventLength(data)
 	framerest := int(w.frame - (w.pos-fileMetaInfoHeaderSize)%w.frame)
@@ -234,6 +283,9 @@ func (w *Writer) WriteEvent(id types.Index, data []byte) (int, error) {
 
 	flushed, err := w.dst.WriteFA(w.buf.Bytes())
 	if err != nil {
+		// Заполнение кадра нулями уже в буфере и после восстановления
+		// записи попадёт в файл, см. Recover.
+		w.pos += uint64(deltapos)
 		return 0, errors.Wrap(err, "push encoded event data")
 	}
 
@@ -244,6 +296,8 @@ func (w *Writer) WriteEvent(id types.Index, data []byte) (int, error) {
 	deltapos += l
 	w.pos += uint64(deltapos)
 	w.lastid = id
+	w.committed.Store(w.pos)
+	w.signal()
 
 	return deltapos, nil
 }
@@ -261,6 +315,18 @@ func (w *Writer) Flush() error {
 	return nil
 }
 
+// Sync сброс буфера с синхронизацией файла с диском: записанные
+// до вызова события переживут и падение системы, а не только процесса.
+func (w *Writer) Sync() error {
+	if err := w.dst.Sync(); err != nil {
+		return err
+	}
+
+	w.wtnid.Set(w.lastid)
+
+	return nil
+}
+
 // LookupNext поиск события следующего за данным.
 func (w *Writer) LookupNext(id types.Index, logger func(err error)) (LookupResult, error) {
 	if types.IndexLess(w.wtnid.Get(), id) {
@@ -276,7 +342,7 @@ func (w *Writer) LookupNext(id types.Index, logger func(err error)) (LookupResul
 		}
 	}
 
-	res, err := LookupNext(w.dst.Name(), id, logger)
+	res, err := LookupNextFS(w.fs, w.dst.Name(), id, logger)
 	if err != nil {
 		return nil, errors.Wrap(err, "look for the next event in the file")
 	}
@@ -284,9 +350,42 @@ func (w *Writer) LookupNext(id types.Index, logger func(err error)) (LookupResul
 	return res, nil
 }
 
+// Failed проверка того, что запись в лог остановлена сбоем записи или
+// синхронизации файла.
+func (w *Writer) Failed() bool {
+	return w.dst.Failed()
+}
+
+// Recover восстановление записи в лог после сбоя записи в файл, например
+// при нехватке места на диске. Успешно записанные до сбоя события
+// сохраняются и будут сброшены в файл вместе со следующими. После сбоя
+// синхронизации запись не восстанавливается.
+func (w *Writer) Recover() error {
+	if err := w.dst.Recover(); err != nil {
+		return errors.Wrap(err, "recover file writer")
+	}
+
+	return nil
+}
+
 // Close закрытие записи лога.
 func (w *Writer) Close() error {
-	return w.dst.Close()
+	if err := w.dst.Close(); err != nil {
+		return err
+	}
+
+	w.closed.Store(true)
+	w.signal()
+	return nil
+}
+
+// SwitchTo сообщает следящим за логом итераторам, что запись событий
+// продолжается в next, например после ротации лога. После этого вызова
+// запись событий в w недопустима. Вызов должен предшествовать закрытию w,
+// иначе следящие итераторы посчитают лог завершённым.
+func (w *Writer) SwitchTo(next *Writer) {
+	w.next.Store(next)
+	w.signal()
 }
 
 // Pos текущая позиция записи в файл.
@@ -294,6 +393,29 @@ func (w *Writer) Pos() uint64 {
 	return w.pos
 }
 
+// changed возвращает канал, который будет закрыт при следующем
+// изменении состояния лога: записи события, закрытии или переключении.
+func (w *Writer) changed() <-chan struct{} {
+	w.notifyLock.Lock()
+	defer w.notifyLock.Unlock()
+
+	if w.notify == nil {
+		w.notify = make(chan struct{})
+	}
+
+	return w.notify
+}
+
+func (w *Writer) signal() {
+	w.notifyLock.Lock()
+	defer w.notifyLock.Unlock()
+
+	if w.notify != nil {
+		close(w.notify)
+		w.notify = nil
+	}
+}
+
 func (w *Writer) flush() error {
 	if err := w.dst.Flush(); err != nil {
 		return err
@@ -304,6 +426,11 @@ func (w *Writer) flush() error {
 	return nil
 }
 
+// Codec возвращает алгоритм сжатия данных событий в логе.
+func (w *Writer) Codec() Codec {
+	return w.codec
+}
+
 func writeHeader(dst io.WriteCloser, frame, limit int) error {
 	var buf [fileMetaInfoHeaderSize]byte
 	binary.LittleEndian.PutUint64(buf[:8], uint64(frame))
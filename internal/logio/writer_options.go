@@ -1,17 +1,19 @@
 package logio
 
 import (
+	"encoding/binary"
 	"fmt"
-	"os"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/mpio"
 	"github.com/sirkon/mpy6a/internal/uvarints"
 )
 
 // WriterOption тип опции для создания писалки логов.
 type WriterOption interface {
 	String() string
-	apply(w *Writer, file *os.File) error
+	apply(w *Writer, file fsio.File) error
 }
 
 // WriterBufferSize задаёт размер буфера в числе.
@@ -24,13 +26,27 @@ func WriterFileSize(size uint64) WriterOption {
 	return writerFileSize(size)
 }
 
+// WriterCompression задаёт алгоритм сжатия данных событий. Применяется
+// только к создаваемым файлам: для существующих используется алгоритм
+// записанный в их заголовке.
+func WriterCompression(codec Codec) WriterOption {
+	return writerCompression(codec)
+}
+
+// WriterFileWrap задаёт обёртку файла лога, через которую пойдёт
+// запись событий. Нужна, например, для внесения сбоев записи и
+// синхронизации при тестировании.
+func WriterFileWrap(wrap func(file fsio.File) (mpio.File, error)) WriterOption {
+	return writerFileWrap(wrap)
+}
+
 type writerBufferSize int
 
 func (o writerBufferSize) String() string {
 	return fmt.Sprintf("set writer buffer size to %d bytes", o)
 }
 
-func (o writerBufferSize) apply(w *Writer, _ *os.File) error {
+func (o writerBufferSize) apply(w *Writer, _ fsio.File) error {
 	if int(o) > frameSizeHardLimit {
 		return errors.Newf("buffer capacity cannot be larger than %d", frameSizeHardLimit)
 	}
@@ -56,7 +72,7 @@ func (s writerFileSize) String() string {
 	return fmt.Sprintf("force file size to %d", s)
 }
 
-func (s writerFileSize) apply(w *Writer, file *os.File) error {
+func (s writerFileSize) apply(w *Writer, file fsio.File) error {
 	if err := file.Truncate(int64(s)); err != nil {
 		return errors.Wrapf(err, "force file size to %d", s)
 	}
@@ -69,3 +85,51 @@ func (s writerFileSize) apply(w *Writer, file *os.File) error {
 
 	return nil
 }
+
+type writerCompression Codec
+
+func (c writerCompression) String() string {
+	return fmt.Sprintf("compress events with %s", Codec(c))
+}
+
+func (c writerCompression) apply(w *Writer, file fsio.File) error {
+	if !w.created {
+		return nil
+	}
+
+	codec := Codec(c)
+	if _, _, err := splitEvlim(joinEvlim(w.evlim, codec)); err != nil {
+		return errors.Wrap(err, "check codec")
+	}
+
+	// Несжимаемые события хранятся как есть, но с дополнительным байтом,
+	// кадр должен вмещать и их.
+	evmax := w.evlim + codecOverhead(codec)
+	eventMayNeed := 16 + uvarints.LengthInt(evmax) + evmax
+	if int(w.frame) < eventMayNeed {
+		return errors.Newf("frame is not sufficient to hold every compressed event with the current evlim").
+			Uint64("frame-size", w.frame).
+			Int("event-space", eventMayNeed)
+	}
+
+	var buf [fileMetaInfoHeaderSize]byte
+	binary.LittleEndian.PutUint64(buf[:8], w.frame)
+	binary.LittleEndian.PutUint64(buf[8:], joinEvlim(w.evlim, codec))
+	if _, err := file.WriteAt(buf[:], 0); err != nil {
+		return errors.Wrap(err, "rewrite log file header")
+	}
+
+	w.codec = codec
+	return nil
+}
+
+type writerFileWrap func(file fsio.File) (mpio.File, error)
+
+func (writerFileWrap) String() string {
+	return "wrap log file"
+}
+
+func (f writerFileWrap) apply(w *Writer, _ fsio.File) error {
+	w.wrap = f
+	return nil
+}
@@ -8,4 +8,18 @@
 //  - DELETE <sid>          : Считать сессию с идентификатором <sid> завершённой
 //  - STORE <sid> [repeat]  : Сохранить сессию с идентификатором <sid>, опционально может быть дано время повтора.
 //                          : По-умолчанию оно устанавливается по настройкам theme.
+//  - STORE_PRIORITY <sid> <priority> [repeat]
+//                          : То же что и STORE, но с приоритетом сессии среди сессий с тем же временем повтора.
+//  - CANCEL <sid>          : Отменить повтор сохранённой сессии с идентификатором <sid>.
+//  - RESCHEDULE <sid> <t>  : Перенести повтор сохранённой сессии с идентификатором <sid> на время <t>.
+//  - IMPORT <src> <digest> : Загрузить размещённый на узлах источник <src> с выгруженными сессиями
+//                          : и контрольной суммой содержимого <digest>.
+//  - RESTORE_SESSIONS <sid>...
+//                          : Отправить на восстановление сохранённые сессии с идентификаторами <sid>.
+//
+// Кодирование операций, см. Recorder и RecorderDispatch, изначально было
+// порождено fenneg, но теперь поддерживается вручную. Коды операций уже
+// записаны в логи и не меняются: новая операция получает следующий
+// свободный код, а её кодирование и разбор дописываются по образцу
+// имеющихся.
 package logop
@@ -10,9 +10,15 @@ type Logop interface {
 	Restore(n uint32) error
 	Delete(sid types.Index) error
 	Store(sid types.Index, repeat OptionalRepeat) error
+	StorePriority(sid types.Index, repeat OptionalRepeat, priority uint8) error
+	Cancel(sid types.Index) error
+	Reschedule(sid types.Index, repeat uint64) error
+	Import(src types.Index, digest uint32) error
+	RestoreSessions(sids []types.Index) error
 }
 
-// OptionalRepeat тип для продолжительности задержки
-// перед повтором. Нулевое значение указывает на
-// отсутствие параметра.
-type OptionalRepeat = uint32
+// OptionalRepeat тип для времени повтора в разрешении состояния.
+// Нулевое значение указывает на отсутствие параметра. Значение
+// кодируется как uvarint, поэтому записанные прежде 32-битные
+// значения читаются без изменений.
+type OptionalRepeat = uint64
@@ -0,0 +1,482 @@
+// Кодирование операций поддерживается вручную, см. описание пакета.
+
+package logop
+
+import (
+	"encoding/binary"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+	"github.com/sirkon/varsize"
+)
+
+const (
+	logopCodeDelete  = 1
+	logopCodeNew     = 2
+	logopCodeRecord  = 3
+	logopCodeRestore = 4
+	logopCodeStore   = 5
+
+	// Коды новых операций добавлены в конец, чтобы не менять коды
+	// уже записанных в логи.
+	logopCodeCancel          = 6
+	logopCodeReschedule      = 7
+	logopCodeStorePriority   = 8
+	logopCodeImport          = 9
+	logopCodeRestoreSessions = 10
+)
+
+// Cancel encodes arguments tuple of this method.
+func (r *Recorder) Cancel(sid types.Index) []byte {
+	buf := r.allocateBuffer(4 + 16)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeCancel))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	return buf
+}
+
+// Delete encodes arguments tuple of this method.
+func (r *Recorder) Delete(sid types.Index) []byte {
+	buf := r.allocateBuffer(4 + 16)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeDelete))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	return buf
+}
+
+// Import encodes arguments tuple of this method.
+func (r *Recorder) Import(src types.Index, digest uint32) []byte {
+	buf := r.allocateBuffer(4 + 16 + 4)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeImport))
+
+	// Encode src(types.Index).
+	buf = types.IndexEncodeAppend(buf, src)
+
+	// Encode digest(uint32).
+	buf = binary.LittleEndian.AppendUint32(buf, digest)
+
+	return buf
+}
+
+// New encodes arguments tuple of this method.
+func (r *Recorder) New(theme uint32) []byte {
+	buf := r.allocateBuffer(4 + 4)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeNew))
+
+	// Encode theme(uint32).
+	buf = binary.LittleEndian.AppendUint32(buf, theme)
+
+	return buf
+}
+
+// Record encodes arguments tuple of this method.
+func (r *Recorder) Record(sid types.Index, data []byte) []byte {
+	lenData := varsize.Len(data) + len(data)
+	buf := r.allocateBuffer(4 + 16 + lenData)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeRecord))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	// Encode data([]byte).
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	buf = append(buf, data...)
+
+	return buf
+}
+
+// Reschedule encodes arguments tuple of this method.
+func (r *Recorder) Reschedule(sid types.Index, repeat uint64) []byte {
+	buf := r.allocateBuffer(4 + 16 + 8)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeReschedule))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	// Encode repeat(uint64).
+	buf = binary.LittleEndian.AppendUint64(buf, repeat)
+
+	return buf
+}
+
+// Restore encodes arguments tuple of this method.
+func (r *Recorder) Restore(n uint32) []byte {
+	buf := r.allocateBuffer(4 + 4)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeRestore))
+
+	// Encode n(uint32).
+	buf = binary.LittleEndian.AppendUint32(buf, n)
+
+	return buf
+}
+
+// RestoreSessions encodes arguments tuple of this method.
+func (r *Recorder) RestoreSessions(sids []types.Index) []byte {
+	buf := r.allocateBuffer(4 + varsize.Uint(uint64(len(sids))) + 16*len(sids))
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeRestoreSessions))
+
+	// Encode sids([]types.Index).
+	buf = binary.AppendUvarint(buf, uint64(len(sids)))
+	for _, sid := range sids {
+		buf = types.IndexEncodeAppend(buf, sid)
+	}
+
+	return buf
+}
+
+// Store encodes arguments tuple of this method.
+func (r *Recorder) Store(sid types.Index, repeat OptionalRepeat) []byte {
+	var key int
+	if repeat != 0 {
+		key = varsize.Uint(repeat)
+	}
+	buf := r.allocateBuffer(4 + 16 + key)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeStore))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	// Encode repeat(OptionalRepeat).
+	if repeat != 0 {
+		buf = binary.AppendUvarint(buf, repeat)
+	}
+
+	return buf
+}
+
+// StorePriority encodes arguments tuple of this method.
+func (r *Recorder) StorePriority(sid types.Index, repeat OptionalRepeat, priority uint8) []byte {
+	var key int
+	if repeat != 0 {
+		key = varsize.Uint(repeat)
+	}
+	buf := r.allocateBuffer(4 + 16 + 1 + key)
+
+	// Encode branch (method) code.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(logopCodeStorePriority))
+
+	// Encode sid(types.Index).
+	buf = types.IndexEncodeAppend(buf, sid)
+
+	// Encode priority(uint8).
+	buf = append(buf, priority)
+
+	// Encode repeat(OptionalRepeat).
+	if repeat != 0 {
+		buf = binary.AppendUvarint(buf, repeat)
+	}
+
+	return buf
+}
+
+// RecorderDispatch dispatches encoded data made with Recorder
+func RecorderDispatch(disp Logop, rec []byte) error {
+	if len(rec) < 4 {
+		return errors.New("decode branch code: record buffer is too small").Uint64("length-required", uint64(4)).Int("length-actual", len(rec))
+	}
+
+	branch := binary.LittleEndian.Uint32(rec[:4])
+	rec = rec[4:]
+
+	switch branch {
+	case logopCodeCancel:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Cancel.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		if len(rec) > 0 {
+			return errors.New("decode Cancel: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Cancel(sid); err != nil {
+			return errors.Wrap(err, "call Cancel")
+		}
+
+		return nil
+
+	case logopCodeDelete:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Delete.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		if len(rec) > 0 {
+			return errors.New("decode Delete: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Delete(sid); err != nil {
+			return errors.Wrap(err, "call Delete")
+		}
+
+		return nil
+
+	case logopCodeImport:
+		// Decode src(types.Index).
+		var src types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Import.src(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&src, rec)
+		rec = rec[16:]
+
+		// Decode digest(uint32).
+		var digest uint32
+		if len(rec) < 4 {
+			return errors.New("decode Import.digest(uint32): record buffer is too small").Uint64("length-required", uint64(4)).Int("length-actual", len(rec))
+		}
+		digest = binary.LittleEndian.Uint32(rec)
+		rec = rec[4:]
+
+		if len(rec) > 0 {
+			return errors.New("decode Import: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Import(src, digest); err != nil {
+			return errors.Wrap(err, "call Import")
+		}
+
+		return nil
+
+	case logopCodeNew:
+		// Decode theme(uint32).
+		var theme uint32
+		if len(rec) < 4 {
+			return errors.New("decode New.theme(uint32): record buffer is too small").Uint64("length-required", uint64(4)).Int("length-actual", len(rec))
+		}
+		theme = binary.LittleEndian.Uint32(rec)
+		rec = rec[4:]
+
+		if len(rec) > 0 {
+			return errors.New("decode New: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.New(theme); err != nil {
+			return errors.Wrap(err, "call New")
+		}
+
+		return nil
+
+	case logopCodeRecord:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Record.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		// Decode data([]byte).
+		var data []byte
+		{
+			size, off := binary.Uvarint(rec)
+			if off <= 0 {
+				if off == 0 {
+					return errors.New("decode Record.data([]byte) length: record buffer is too small")
+				}
+				return errors.New("decode Record.data([]byte) length: malformed uvarint sequence")
+			}
+			rec = rec[off:]
+			if uint64(len(rec)) < size {
+				return errors.New("decode Record.data([]byte) content: record buffer is too small").Uint64("length-required", uint64(size)).Int("length-actual", len(rec))
+			}
+			data = rec[:size]
+			rec = rec[size:]
+		}
+
+		if len(rec) > 0 {
+			return errors.New("decode Record: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Record(sid, data); err != nil {
+			return errors.Wrap(err, "call Record")
+		}
+
+		return nil
+
+	case logopCodeReschedule:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Reschedule.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		// Decode repeat(uint64).
+		var repeat uint64
+		if len(rec) < 8 {
+			return errors.New("decode Reschedule.repeat(uint64): record buffer is too small").Uint64("length-required", uint64(8)).Int("length-actual", len(rec))
+		}
+		repeat = binary.LittleEndian.Uint64(rec)
+		rec = rec[8:]
+
+		if len(rec) > 0 {
+			return errors.New("decode Reschedule: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Reschedule(sid, repeat); err != nil {
+			return errors.Wrap(err, "call Reschedule")
+		}
+
+		return nil
+
+	case logopCodeRestore:
+		// Decode n(uint32).
+		var n uint32
+		if len(rec) < 4 {
+			return errors.New("decode Restore.n(uint32): record buffer is too small").Uint64("length-required", uint64(4)).Int("length-actual", len(rec))
+		}
+		n = binary.LittleEndian.Uint32(rec)
+		rec = rec[4:]
+
+		if len(rec) > 0 {
+			return errors.New("decode Restore: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Restore(n); err != nil {
+			return errors.Wrap(err, "call Restore")
+		}
+
+		return nil
+
+	case logopCodeRestoreSessions:
+		// Decode sids([]types.Index).
+		var sids []types.Index
+		{
+			size, off := binary.Uvarint(rec)
+			if off <= 0 {
+				if off == 0 {
+					return errors.New("decode RestoreSessions.sids([]types.Index) length: record buffer is too small")
+				}
+				return errors.New("decode RestoreSessions.sids([]types.Index) length: malformed uvarint sequence")
+			}
+			rec = rec[off:]
+			if uint64(len(rec))/16 < size {
+				return errors.New("decode RestoreSessions.sids([]types.Index) content: record buffer is too small").Uint64("length-required", 16*size).Int("length-actual", len(rec))
+			}
+			sids = make([]types.Index, size)
+			for i := range sids {
+				types.IndexDecode(&sids[i], rec)
+				rec = rec[16:]
+			}
+		}
+
+		if len(rec) > 0 {
+			return errors.New("decode RestoreSessions: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.RestoreSessions(sids); err != nil {
+			return errors.Wrap(err, "call RestoreSessions")
+		}
+
+		return nil
+
+	case logopCodeStore:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode Store.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		// Decode repeat(OptionalRepeat).
+		var repeat OptionalRepeat
+		if len(rec) > 0 {
+			size, off := binary.Uvarint(rec)
+			if off <= 0 {
+				if off == 0 {
+					return errors.New("decode Store.repeat(OptionalRepeat): record buffer is too small")
+				}
+				return errors.New("decode Store.repeat(OptionalRepeat) - optional repeat timeout: malformed uvarint sequence")
+			}
+
+			repeat = size
+			rec = rec[off:]
+		}
+
+		if len(rec) > 0 {
+			return errors.New("decode Store: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.Store(sid, repeat); err != nil {
+			return errors.Wrap(err, "call Store")
+		}
+
+		return nil
+
+	case logopCodeStorePriority:
+		// Decode sid(types.Index).
+		var sid types.Index
+		if len(rec) < 16 {
+			return errors.New("decode StorePriority.sid(types.Index): record buffer is too small").Uint64("length-required", uint64(16)).Int("length-actual", len(rec))
+		}
+		types.IndexDecode(&sid, rec)
+		rec = rec[16:]
+
+		// Decode priority(uint8).
+		var priority uint8
+		if len(rec) < 1 {
+			return errors.New("decode StorePriority.priority(uint8): record buffer is too small").Uint64("length-required", uint64(1)).Int("length-actual", len(rec))
+		}
+		priority = rec[0]
+		rec = rec[1:]
+
+		// Decode repeat(OptionalRepeat).
+		var repeat OptionalRepeat
+		if len(rec) > 0 {
+			size, off := binary.Uvarint(rec)
+			if off <= 0 {
+				if off == 0 {
+					return errors.New("decode StorePriority.repeat(OptionalRepeat): record buffer is too small")
+				}
+				return errors.New("decode StorePriority.repeat(OptionalRepeat) - optional repeat timeout: malformed uvarint sequence")
+			}
+
+			repeat = size
+			rec = rec[off:]
+		}
+
+		if len(rec) > 0 {
+			return errors.New("decode StorePriority: the record was not emptied after the last argument decoded").Int("record-bytes-left", len(rec))
+		}
+
+		if err := disp.StorePriority(sid, repeat, priority); err != nil {
+			return errors.Wrap(err, "call StorePriority")
+		}
+
+		return nil
+
+	default:
+		return errors.Newf("invalid branch code %d", branch).Uint32("invalid-branch-code", branch)
+	}
+}
@@ -1,6 +1,10 @@
 package options
 
-import "log"
+import (
+	"log"
+
+	"github.com/sirkon/mpy6a/internal/fsio"
+)
 
 // SimWriterBufferSize задание длины буфера записи. Длина по умолчанию – 4096 байт.
 const SimWriterBufferSize int = 4096
@@ -12,3 +16,6 @@ var SimWriterWritePosition uint64
 func SimWriterLogger(err error) {
 	log.Println(err)
 }
+
+// SimWriterFS задание файловой системы. По умолчанию – файловая система ОС.
+var SimWriterFS fsio.FS
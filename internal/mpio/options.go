@@ -2,7 +2,10 @@
 
 package mpio
 
-import "github.com/sirkon/mpy6a/internal/mpio/internal/options"
+import (
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/mpio/internal/options"
+)
 
 // BufReaderOptionsType for type BufReader
 type BufReaderOptionsType struct {
@@ -122,6 +125,14 @@ func (o SimWriterOptionsType) Logger(v func(err error)) SimWriterOptionsType {
 	return o
 }
 
+// FS задание файловой системы. По умолчанию – файловая система ОС.
+func (o SimWriterOptionsType) FS(v fsio.FS) SimWriterOptionsType {
+	o.opts = append(o.opts, func(vv *SimWriter) {
+		vv.setFS(v)
+	})
+	return o
+}
+
 func (o SimWriterOptionsType) apply(vv *SimWriter) {
 	for _, opt := range o.opts {
 		opt(vv)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 )
 
 // NewSimReader конструктор SimReader.
@@ -17,7 +18,7 @@ func NewSimReader(w *SimWriter, opts SimReaderOptionsType) (_ *SimReader, err er
 	}
 	opts.apply(res)
 
-	file, err := os.Open(w.file.Name())
+	file, err := w.fs.Open(w.file.Name())
 	if err != nil {
 		return nil, errors.Wrap(err, "open source file")
 	}
@@ -56,7 +57,7 @@ func NewSimReader(w *SimWriter, opts SimReaderOptionsType) (_ *SimReader, err er
 
 // SimReader примитив конкурентного чтения из записываемого источника.
 type SimReader struct {
-	src *os.File
+	src fsio.File
 	w   *SimWriter
 
 	logger   func(err error)
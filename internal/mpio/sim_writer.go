@@ -1,22 +1,38 @@
 package mpio
 
 import (
+	"io"
 	"os"
 	"sync"
 	"sync/atomic"
 
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 )
 
+// File файл в который пишет SimWriter. Обычно это fsio.File, другие
+// реализации нужны, например, для внесения сбоев при тестировании.
+type File interface {
+	io.Writer
+	io.Closer
+	Sync() error
+	Name() string
+}
+
 // SimWriter примитив позволяющий конкурентно осуществлять
 // чтение и запись с одним файлом.
 type SimWriter struct {
-	file *os.File
+	file File
+	fs   fsio.FS // Файловая система для открытия файла на чтение, см. SimReader.
 	lock *sync.RWMutex
 
 	failed atomic.Bool
 	done   atomic.Bool
 
+	// Сбой синхронизации: после него неизвестно, какие из сброшенных
+	// в файл данных дошли до диска, поэтому запись не восстанавливается.
+	syncFailed bool
+
 	size  int64 // Текущее количество байт скинутых из буфера в файл.
 	total int64 // Общее количество байт в файле и в буфере.
 	buf   []byte
@@ -27,11 +43,12 @@ type SimWriter struct {
 // NewSimWriter конструктор SimWriter.
 func NewSimWriter(name string, opts SimWriterOptionsType) (res *SimWriter, err error) {
 	res = &SimWriter{
+		fs:   fsio.OS{},
 		lock: &sync.RWMutex{},
 	}
 	opts.apply(res)
 
-	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+	file, err := res.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return nil, errors.Wrap(err, "open file")
 	}
@@ -60,9 +77,11 @@ func NewSimWriter(name string, opts SimWriterOptionsType) (res *SimWriter, err e
 
 // NewSimWriterFile альтернативный конструктор с использованием готового файлового объекта.
 // Необходимо ручное задание позиции. Позиция заданная через опцию игнорируется.
-func NewSimWriterFile(file *os.File, pos uint64, opts SimWriterOptionsType) *SimWriter {
+// Файловая система заданная опцией должна быть той, в которой находится файл.
+func NewSimWriterFile(file File, pos uint64, opts SimWriterOptionsType) *SimWriter {
 	res := &SimWriter{
 		file: file,
+		fs:   fsio.OS{},
 		lock: &sync.RWMutex{},
 	}
 	opts.apply(res)
@@ -139,11 +158,21 @@ func (w *SimWriter) WriteFA(p []byte) (flushed bool, err error) {
 	return flushed, nil
 }
 
-// Close закрывает файл после сброса буфера.
+// Close закрывает файл после сброса буфера. После сбоя записи буфер
+// не сбрасывается: позиция записи в файле в этом случае неизвестна.
 func (w *SimWriter) Close() error {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
+	if w.failed.Load() {
+		if err := w.file.Close(); err != nil {
+			return errors.Wrap(err, "close file after write failure")
+		}
+
+		w.done.Store(true)
+		return errors.New("buffered data is dropped after write failure").Int("dropped-length", len(w.buf))
+	}
+
 	if err := w.flush(); err != nil {
 		return errors.Wrap(err, "flush buffer")
 	}
@@ -165,6 +194,64 @@ func (w *SimWriter) Flush() error {
 	return w.flush()
 }
 
+// Sync сброс буферизованных данных и их синхронизация с диском.
+func (w *SimWriter) Sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.flush(); err != nil {
+		return errors.Wrap(err, "flush buffer")
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.failed.Store(true)
+		w.syncFailed = true
+		return errors.Wrap(err, "sync file")
+	}
+
+	return nil
+}
+
+// Failed проверка того, что запись остановлена сбоем, см. Recover.
+func (w *SimWriter) Failed() bool {
+	return w.failed.Load()
+}
+
+// Recover восстановление записи после сбоя сброса буфера, например при
+// нехватке места на диске. Частично записанные при сбое данные
+// отрезаются, буфер сохраняется и будет записан следующим сбросом.
+// Сбой синхронизации восстановлению не подлежит. Файл должен позволять
+// обрезку и смену позиции записи, как fsio.File.
+func (w *SimWriter) Recover() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.failed.Load() {
+		return nil
+	}
+	if w.syncFailed {
+		return errors.New("sync failure is not recoverable")
+	}
+
+	file, ok := w.file.(interface {
+		io.Seeker
+		Truncate(size int64) error
+	})
+	if !ok {
+		return errors.New("file does not support truncation")
+	}
+
+	if err := file.Truncate(w.size); err != nil {
+		return errors.Wrap(err, "cut partially written data").Int64("file-size", w.size)
+	}
+	if _, err := file.Seek(w.size, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek write to position").Int64("desired-position", w.size)
+	}
+
+	w.failed.Store(false)
+	return nil
+}
+
 // Name возврат имени файла.
 func (w *SimWriter) Name() string {
 	return w.file.Name()
@@ -221,6 +308,12 @@ func (w *SimWriter) setLogger(v func(err error)) {
 	w.errlog = v
 }
 
+func (w *SimWriter) setFS(v fsio.FS) {
+	if v != nil {
+		w.fs = v
+	}
+}
+
 // EnsureBufferSpace попытка высвободить достаточно места
 // в буфере для записи длиной n. Может возвратить ошибку
 // слишком короткого буфера или ошибку сброса данных на диск.
@@ -0,0 +1,30 @@
+// Package oplog запись лога операций с деградированным режимом работы
+// при недоступности диска.
+//
+// Сбой записи или синхронизации лога, см. Log, не останавливает узел, а
+// переводит его в деградированный режим с сохранением причины, см. Status:
+//
+//   - новые сессии отклоняются без обращения к диску с кодом
+//     staterr.CodeStorageUnavailable;
+//   - прочие операции перед записью пытаются восстановить запись лога и
+//     отклоняются с тем же кодом, если это не удалось;
+//   - выдача на повтор проводится операцией лога и ведёт себя так же:
+//     при неудаче восстановления она откладывается, сессии остаются
+//     сохранёнными и выдаются первой выдачей после восстановления;
+//   - чтение состояния лога не касается и продолжает работать.
+//
+// Отклонённая операция не записана и не должна применяться к состоянию.
+// Событие принятое в буфер до сбоя его сброса, напротив, считается
+// записанным: оно будет сброшено в файл при восстановлении.
+//
+// Восстановление начинается с пробной записи временного файла в директорию
+// с данными. Если она прошла, то есть место на диске освободилось, частично
+// записанный при сбое хвост лога отрезается и буфер событий сбрасывается в
+// файл повторно, после чего узел возвращается к нормальной работе. Попытки
+// делаются при операциях над существующими сессиями и периодически, см.
+// Log.Run.
+//
+// Сбой синхронизации файла лога автоматически не восстанавливается: после
+// него неизвестно, какие из записанных данных дошли до диска. Узел в этом
+// случае остаётся в деградированном режиме до перезапуска.
+package oplog
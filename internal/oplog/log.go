@@ -0,0 +1,290 @@
+package oplog
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// probeSize объём пробной записи при восстановлении.
+const probeSize = 64 * 1024
+
+// Status состояние записи лога операций.
+type Status struct {
+	// Reason причина перехода в деградированный режим, nil при
+	// нормальной работе.
+	Reason error
+
+	// Since момент перехода в деградированный режим.
+	Since time.Time
+
+	// Permanent запись не восстанавливается без перезапуска узла.
+	Permanent bool
+}
+
+// Degraded проверка нахождения в деградированном режиме.
+func (s Status) Degraded() bool {
+	return s.Reason != nil
+}
+
+// NoSpace причиной перехода в деградированный режим является нехватка
+// места на диске.
+func (s Status) NoSpace() bool {
+	return errors.Is(s.Reason, syscall.ENOSPC)
+}
+
+// Log запись лога операций с переходом в деградированный режим при
+// сбоях, см. описание пакета.
+type Log struct {
+	fs  fsio.FS
+	dir string
+	w   *logio.Writer
+
+	lock   sync.Mutex
+	status Status
+}
+
+// New конструктор Log для писалки w лога в директории dir файловой
+// системы fsys.
+func New(fsys fsio.FS, dir string, w *logio.Writer) *Log {
+	return &Log{
+		fs:  fsys,
+		dir: dir,
+		w:   w,
+	}
+}
+
+// WriteEvent запись события id с операцией op. В деградированном режиме
+// новые сессии отклоняются сразу, для остальных операций сначала делается
+// попытка восстановить запись. Возвращает размер записанных данных.
+func (l *Log) WriteEvent(id types.Index, op []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.status.Degraded() {
+		isNew, err := isNewSession(op)
+		if err != nil {
+			return 0, errors.Wrap(err, "decode operation").Stg("event-id", id)
+		}
+		if isNew {
+			return 0, errors.Wrap(l.unavailable(), "reject new session").Stg("event-id", id)
+		}
+
+		if err := l.resume(); err != nil {
+			return 0, errors.Wrap(l.unavailable(), "resume log writing").
+				Stg("event-id", id).
+				Str("resume-error", err.Error())
+		}
+	}
+
+	n, err := l.w.WriteEvent(id, op)
+	if err != nil {
+		l.degrade(err)
+		return 0, errors.Wrap(l.unavailable(), "write event").Stg("event-id", id)
+	}
+
+	return n, nil
+}
+
+// Flush сброс буфера лога в файл, см. logio.Writer.Flush.
+func (l *Log) Flush() error {
+	return l.sync("flush", l.w.Flush)
+}
+
+// Sync сброс буфера лога с синхронизацией файла с диском, см.
+// logio.Writer.Sync.
+func (l *Log) Sync() error {
+	return l.sync("sync", l.w.Sync)
+}
+
+// Status текущее состояние записи лога.
+func (l *Log) Status() Status {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.status
+}
+
+// Resume попытка восстановить запись лога в деградированном режиме.
+// При нормальной работе ничего не делает.
+func (l *Log) Resume() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.resume()
+}
+
+// Run попытки восстановить запись лога в деградированном режиме раз в
+// interval, до отмены ctx.
+func (l *Log) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Причина неудачи остаётся в состоянии, см. Status.
+			_ = l.Resume()
+		}
+	}
+}
+
+// Writer писалка лога, например для поиска событий или слежения за логом.
+// Запись в неё в обход Log недопустима.
+func (l *Log) Writer() *logio.Writer {
+	return l.w
+}
+
+// Close закрытие лога.
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.w.Close()
+}
+
+func (l *Log) sync(op string, fn func() error) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.resume(); err != nil {
+		return errors.Wrap(l.unavailable(), "resume log writing").Str("resume-error", err.Error())
+	}
+
+	if err := fn(); err != nil {
+		l.degrade(err)
+		return errors.Wrap(l.unavailable(), op+" log")
+	}
+
+	return nil
+}
+
+// resume восстановление записи: пробная запись, отрезание частично
+// записанного хвоста и повторный сброс буфера.
+func (l *Log) resume() error {
+	if !l.status.Degraded() {
+		return nil
+	}
+	if l.status.Permanent {
+		return errors.New("log writing is not recoverable, restart is required")
+	}
+
+	if err := l.probe(); err != nil {
+		return errors.Wrap(err, "probe write")
+	}
+
+	if err := l.w.Recover(); err != nil {
+		l.status.Permanent = true
+		return errors.Wrap(err, "recover log writer")
+	}
+
+	if err := l.w.Flush(); err != nil {
+		l.degrade(err)
+		return errors.Wrap(err, "flush buffered events")
+	}
+
+	l.status = Status{}
+	return nil
+}
+
+// degrade переход в деградированный режим по причине err. Момент
+// перехода при повторных сбоях не меняется.
+func (l *Log) degrade(err error) {
+	if !l.status.Degraded() {
+		l.status.Since = time.Now()
+	}
+	l.status.Reason = err
+}
+
+// unavailable ошибка отказа в деградированном режиме.
+func (l *Log) unavailable() error {
+	return staterr.NewStorageUnavailable(l.status.Reason.Error())
+}
+
+// probe пробная запись с синхронизацией во временный файл, который
+// затем удаляется.
+func (l *Log) probe() error {
+	name := datadir.TempName(l.dir, datadir.TempProbe)
+	file, err := l.fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "create probe file")
+	}
+
+	err = writeProbe(file)
+	if cErr := file.Close(); cErr != nil && err == nil {
+		err = errors.Wrap(cErr, "close probe file")
+	}
+	if rErr := l.fs.Remove(name); rErr != nil {
+		if err != nil {
+			return errors.Wrap(err, "write probe file").Str("remove-error", rErr.Error())
+		}
+
+		return errors.Wrap(rErr, "remove probe file")
+	}
+
+	return err
+}
+
+func writeProbe(file fsio.File) error {
+	if _, err := file.Write(make([]byte, probeSize)); err != nil {
+		return errors.Wrap(err, "write probe data")
+	}
+
+	if err := file.Sync(); err != nil {
+		return errors.Wrap(err, "sync probe file")
+	}
+
+	return nil
+}
+
+// isNewSession проверка того, что op создаёт новую сессию.
+func isNewSession(op []byte) (bool, error) {
+	var k opKind
+	if err := logop.RecorderDispatch(&k, op); err != nil {
+		return false, err
+	}
+
+	return k.new, nil
+}
+
+// opKind определение вида операции.
+type opKind struct {
+	new bool
+}
+
+func (k *opKind) New(uint32) error {
+	k.new = true
+	return nil
+}
+
+func (k *opKind) Record(types.Index, []byte) error { return nil }
+
+func (k *opKind) Restore(uint32) error { return nil }
+
+func (k *opKind) RestoreSessions([]types.Index) error { return nil }
+
+func (k *opKind) Delete(types.Index) error { return nil }
+
+func (k *opKind) Store(types.Index, logop.OptionalRepeat) error { return nil }
+
+func (k *opKind) StorePriority(types.Index, logop.OptionalRepeat, uint8) error { return nil }
+
+func (k *opKind) Cancel(types.Index) error { return nil }
+
+func (k *opKind) Reschedule(types.Index, uint64) error { return nil }
+
+func (k *opKind) Import(types.Index, uint32) error { return nil }
+
+var _ logop.Logop = &opKind{}
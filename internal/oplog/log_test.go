@@ -0,0 +1,176 @@
+package oplog_test
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/mpio"
+	"github.com/sirkon/mpy6a/internal/oplog"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestLogNoSpace(t *testing.T) {
+	const dir = "/data"
+	fsys := fsio.NewMem()
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		tlog.Error(t, errors.Wrap(err, "create directory"))
+		return
+	}
+
+	name := datadir.LogName(dir, types.NewIndex(1, 1))
+	w, err := logio.NewWriterFS(fsys, name, 1024, 128)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+	l := oplog.New(fsys, dir, w)
+
+	var rec logop.Recorder
+	write := func(seq uint64, op []byte) error {
+		_, err := l.WriteEvent(types.NewIndex(1, seq), op)
+		return err
+	}
+
+	if err := write(1, rec.New(1)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write new session"))
+		return
+	}
+	if err := l.Flush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "flush log"))
+		return
+	}
+
+	// Место заканчивается при сбросе буфера со вторым событием.
+	fsys.SetLimit(fsys.Used() + 10)
+	if err := write(2, rec.Record(types.NewIndex(1, 1), make([]byte, 64))); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write record into buffer"))
+		return
+	}
+	if err := l.Flush(); staterr.AsCode(err) != staterr.CodeStorageUnavailable {
+		t.Errorf("expected storage unavailable error on flush, got %v", err)
+	}
+
+	status := l.Status()
+	if !status.Degraded() || !status.NoSpace() || status.Permanent {
+		t.Errorf("expected recoverable degraded mode due to lack of space, got %+v", status)
+	}
+
+	// Новые сессии отклоняются без обращения к диску.
+	used := fsys.Used()
+	if err := write(3, rec.New(2)); staterr.AsCode(err) != staterr.CodeStorageUnavailable {
+		t.Errorf("expected new session to be rejected, got %v", err)
+	}
+	if fsys.Used() != used {
+		t.Errorf("expected no disk usage change on rejected new session, got %d bytes instead of %d", fsys.Used(), used)
+	}
+
+	// Прочие операции пытаются восстановить запись, но места всё ещё нет.
+	if err := write(4, rec.Delete(types.NewIndex(1, 1))); staterr.AsCode(err) != staterr.CodeStorageUnavailable {
+		t.Errorf("expected operation to be rejected while there is no space, got %v", err)
+	}
+
+	fsys.SetLimit(0)
+	if err := l.Resume(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "resume log writing"))
+		return
+	}
+	if status := l.Status(); status.Degraded() {
+		t.Errorf("expected normal mode after resume, got %+v", status)
+	}
+
+	if err := write(5, rec.New(3)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write new session after resume"))
+		return
+	}
+	if err := l.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close log"))
+		return
+	}
+
+	// Принятое до сбоя событие не потеряно, отклонённые не записаны.
+	it, err := logio.NewReaderFS(fsys, name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open log"))
+		return
+	}
+	defer func() {
+		if err := it.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close log reader"))
+		}
+	}()
+
+	var got []uint64
+	for it.Next() {
+		id, _, _ := it.Event()
+		got = append(got, id.Index)
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read log"))
+		return
+	}
+
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 5 {
+		t.Errorf("expected events 1, 2, 5 in the log, got %v", got)
+	}
+}
+
+func TestLogSyncFailure(t *testing.T) {
+	const dir = "/data"
+	fsys := fsio.NewMem()
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		tlog.Error(t, errors.Wrap(err, "create directory"))
+		return
+	}
+
+	w, err := logio.NewWriterFS(
+		fsys,
+		datadir.LogName(dir, types.NewIndex(1, 1)),
+		1024,
+		128,
+		logio.WriterFileWrap(func(file fsio.File) (mpio.File, error) {
+			return failingSync{File: file}, nil
+		}),
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create writer"))
+		return
+	}
+	l := oplog.New(fsys, dir, w)
+
+	var rec logop.Recorder
+	if _, err := l.WriteEvent(types.NewIndex(1, 1), rec.New(1)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write new session"))
+		return
+	}
+	if err := l.Sync(); staterr.AsCode(err) != staterr.CodeStorageUnavailable {
+		t.Errorf("expected storage unavailable error on sync, got %v", err)
+	}
+
+	// После сбоя синхронизации запись не восстанавливается.
+	if err := l.Resume(); err == nil {
+		t.Error("expected resume to fail after sync failure")
+	}
+	status := l.Status()
+	if !status.Degraded() || !status.Permanent || status.NoSpace() {
+		t.Errorf("expected permanent degraded mode due to I/O error, got %+v", status)
+	}
+	if !errors.Is(status.Reason, syscall.EIO) {
+		t.Errorf("expected I/O error as the reason, got %v", status.Reason)
+	}
+}
+
+// failingSync файл, синхронизация которого всегда заканчивается ошибкой.
+type failingSync struct {
+	fsio.File
+}
+
+func (failingSync) Sync() error {
+	return syscall.EIO
+}
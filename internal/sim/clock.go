@@ -0,0 +1,24 @@
+package sim
+
+import "time"
+
+// Clock виртуальное время симуляции, идёт только при явном сдвиге.
+type Clock struct {
+	now time.Time
+}
+
+// NewClock конструктор времени начинающегося с момента start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now текущее время.
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Advance сдвиг времени на d, возвращает новое текущее время.
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.now = c.now.Add(d)
+	return c.now
+}
@@ -0,0 +1,31 @@
+// Package sim детерминированное симуляционное тестирование состояния.
+//
+// Симуляция, см. Run, управляется одним генератором случайных чисел с
+// заданным зерном: им выбираются операции над сессиями, сдвиги времени,
+// синхронизации лога, слепки, аварии узла и сбои файловых операций. Так
+// что любой найденный сбой воспроизводится повторным запуском с тем же
+// зерном.
+//
+// Время симуляции виртуальное, см. Clock, и передаётся состоянию через
+// State.SetTime. Лог операций пишется через FaultyFile: запись и
+// синхронизация могут завершаться ошибкой, а при аварии несинхронизированный
+// хвост файла теряется частично. После аварии узел запускается обычным
+// путём: обрезкой оборванного хвоста лога и загрузкой последнего слепка
+// с применением лога после него, см. state.Open.
+//
+// Параллельно с узлом операции применяются к эталонной модели, после
+// каждой операции повтора и каждого перезапуска проверяются инварианты:
+//
+//   - подтверждённые синхронизацией лога события не теряются при аварии;
+//   - состояние узла совпадает с моделью с тем же набором событий;
+//   - на повтор выдаются ровно те сессии, время повтора которых наступило,
+//     без потерь и повторов, в порядке времени повтора, приоритета и
+//     сохранения (FIFO) при их совпадении.
+//
+// Сохранённые сессии сбрасываются в источники, а два соседних по
+// старшинству источника сливаются в один. Запись файла и его
+// регистрация идут отдельными шагами, между ними возможны слепки и
+// аварии: источник не попавший в слепок удаляется при перезапуске, а
+// его сессии восстанавливаются из лога. Повтор и сверка с моделью выдают сессии
+// из источников, сбрасываемого дерева и памяти вместе.
+package sim
@@ -0,0 +1,112 @@
+package sim
+
+import (
+	"math/rand"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+)
+
+// Faults вероятности сбоев файловых операций, от 0 до 1.
+type Faults struct {
+	// Write сбой записи: записывается только случайная часть данных.
+	Write float64
+
+	// Sync сбой синхронизации: данные остаются несинхронизированными.
+	Sync float64
+}
+
+// ErrorInjected ошибка внесённого сбоя.
+type ErrorInjected struct {
+	Op string
+}
+
+func (e ErrorInjected) Error() string {
+	return "injected " + e.Op + " fault"
+}
+
+// FaultyFile обёртка файла с внесением сбоев, см. Faults. Данные
+// записанные после последней успешной синхронизации считаются
+// находящимися в кеше системы и при аварии теряются частично, см. Crash.
+type FaultyFile struct {
+	file   fsio.File
+	rnd    *rand.Rand
+	faults Faults
+
+	size   int64
+	synced int64
+}
+
+// NewFaultyFile конструктор обёртки файла file. Уже записанные в него
+// данные считаются синхронизированными.
+func NewFaultyFile(file fsio.File, rnd *rand.Rand, faults Faults) (*FaultyFile, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "stat file")
+	}
+
+	return &FaultyFile{
+		file:   file,
+		rnd:    rnd,
+		faults: faults,
+		size:   stat.Size(),
+		synced: stat.Size(),
+	}, nil
+}
+
+// Write запись данных.
+func (f *FaultyFile) Write(p []byte) (int, error) {
+	if f.rnd.Float64() < f.faults.Write {
+		n, err := f.file.Write(p[:f.rnd.Intn(len(p)+1)])
+		f.size += int64(n)
+		if err != nil {
+			return n, err
+		}
+
+		return n, ErrorInjected{Op: "write"}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync синхронизация данных с диском.
+func (f *FaultyFile) Sync() error {
+	if f.rnd.Float64() < f.faults.Sync {
+		return ErrorInjected{Op: "sync"}
+	}
+
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	f.synced = f.size
+	return nil
+}
+
+// Close закрытие файла. Несинхронизированные данные остаются в кеше
+// системы, т.е. при аварии процесса они не теряются.
+func (f *FaultyFile) Close() error {
+	return f.file.Close()
+}
+
+// Name имя файла.
+func (f *FaultyFile) Name() string {
+	return f.file.Name()
+}
+
+// Crash авария системы: из несинхронизированных данных на диске
+// остаётся случайное начало, файл закрывается.
+func (f *FaultyFile) Crash() error {
+	keep := f.synced + f.rnd.Int63n(f.size-f.synced+1)
+
+	if err := f.file.Truncate(keep); err != nil {
+		return errors.Wrap(err, "truncate file").Int64("keep-size", keep)
+	}
+
+	// Закрытие нужно только для освобождения дескриптора, данные после
+	// аварии уже определены обрезкой файла.
+	_ = f.file.Close()
+	return nil
+}
@@ -0,0 +1,222 @@
+package sim
+
+import (
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// modelSession сессия эталонной модели.
+type modelSession struct {
+	ID      types.Index
+	Theme   uint32
+	Size    int
+	Repeats uint32
+
+	// Время повтора и приоритет сохранённой сессии.
+	Repeat   uint64
+	Priority uint8
+}
+
+// model эталонная модель состояния: активные сессии и очередь
+// сохранённых сессий в порядке повтора.
+type model struct {
+	active map[types.Index]*modelSession
+	saved  []*modelSession
+
+	// restored сессии выданные на повтор последней операцией Restore.
+	restored []types.Index
+}
+
+func newModel() *model {
+	return &model{
+		active: map[types.Index]*modelSession{},
+	}
+}
+
+// apply применение операции op события id.
+func (m *model) apply(id types.Index, op []byte) error {
+	m.restored = m.restored[:0]
+	return logop.RecorderDispatch(&modelApplier{m: m, id: id}, op)
+}
+
+// due число сохранённых сессий с временем повтора не больше repeat.
+func (m *model) due(repeat uint64) int {
+	return sort.Search(len(m.saved), func(i int) bool {
+		return m.saved[i].Repeat > repeat
+	})
+}
+
+// activeIDs идентификаторы активных сессий по возрастанию.
+func (m *model) activeIDs() []types.Index {
+	res := make([]types.Index, 0, len(m.active))
+	for sid := range m.active {
+		res = append(res, sid)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return types.IndexLess(res[i], res[j])
+	})
+
+	return res
+}
+
+// save постановка сессии в очередь: после всех сессий с более ранним
+// повтором и сессий с тем же повтором и не меньшим приоритетом.
+func (m *model) save(sess *modelSession) {
+	i := sort.Search(len(m.saved), func(i int) bool {
+		s := m.saved[i]
+		if s.Repeat != sess.Repeat {
+			return s.Repeat > sess.Repeat
+		}
+
+		return s.Priority < sess.Priority
+	})
+
+	m.saved = append(m.saved, nil)
+	copy(m.saved[i+1:], m.saved[i:])
+	m.saved[i] = sess
+}
+
+// unsave изъятие сохранённой сессии sid из очереди.
+func (m *model) unsave(sid types.Index) (*modelSession, bool) {
+	for i, sess := range m.saved {
+		if types.IndexEqual(sess.ID, sid) {
+			m.saved = append(m.saved[:i], m.saved[i+1:]...)
+			return sess, true
+		}
+	}
+
+	return nil, false
+}
+
+// modelApplier применение операции одного события к модели.
+type modelApplier struct {
+	m  *model
+	id types.Index
+}
+
+func (a *modelApplier) New(theme uint32) error {
+	a.m.active[a.id] = &modelSession{
+		ID:    a.id,
+		Theme: theme,
+	}
+	return nil
+}
+
+func (a *modelApplier) Record(sid types.Index, data []byte) error {
+	sess, ok := a.m.active[sid]
+	if !ok {
+		return errors.New("active session not found").SessionID(sid)
+	}
+
+	sess.Size += len(data)
+	return nil
+}
+
+func (a *modelApplier) Restore(n uint32) error {
+	if int(n) > len(a.m.saved) {
+		return errors.New("not enough saved sessions").Uint32("restore-requested", n)
+	}
+
+	for _, sess := range a.m.saved[:n] {
+		a.restore(sess)
+	}
+	a.m.saved = append(a.m.saved[:0], a.m.saved[n:]...)
+	return nil
+}
+
+func (a *modelApplier) RestoreSessions(sids []types.Index) error {
+	seen := map[types.Index]struct{}{}
+	for _, sid := range sids {
+		if _, ok := seen[sid]; ok {
+			return errors.New("session is listed twice").SessionID(sid)
+		}
+		seen[sid] = struct{}{}
+	}
+
+	var found int
+	for _, sess := range a.m.saved {
+		if _, ok := seen[sess.ID]; ok {
+			found++
+		}
+	}
+	if found != len(sids) {
+		return errors.New("saved session not found")
+	}
+
+	for _, sid := range sids {
+		sess, _ := a.m.unsave(sid)
+		a.restore(sess)
+	}
+	return nil
+}
+
+// restore перевод выданной на повтор сессии в активные.
+func (a *modelApplier) restore(sess *modelSession) {
+	sess.Repeats++
+	sess.Repeat = 0
+	sess.Priority = 0
+	a.m.active[sess.ID] = sess
+	a.m.restored = append(a.m.restored, sess.ID)
+}
+
+func (a *modelApplier) Delete(sid types.Index) error {
+	if _, ok := a.m.active[sid]; !ok {
+		return errors.New("active session not found").SessionID(sid)
+	}
+
+	delete(a.m.active, sid)
+	return nil
+}
+
+func (a *modelApplier) Store(sid types.Index, repeat logop.OptionalRepeat) error {
+	return a.StorePriority(sid, repeat, uint8(types.PriorityNormal))
+}
+
+func (a *modelApplier) StorePriority(sid types.Index, repeat logop.OptionalRepeat, priority uint8) error {
+	sess, ok := a.m.active[sid]
+	if !ok {
+		return errors.New("active session not found").SessionID(sid)
+	}
+	if repeat == 0 {
+		return errors.New("repeat time is required").SessionID(sid)
+	}
+
+	delete(a.m.active, sid)
+	sess.Repeat = uint64(repeat)
+	sess.Priority = priority
+	a.m.save(sess)
+	return nil
+}
+
+func (a *modelApplier) Cancel(sid types.Index) error {
+	if _, ok := a.m.unsave(sid); !ok {
+		return errors.New("saved session not found").SessionID(sid)
+	}
+
+	return nil
+}
+
+func (a *modelApplier) Reschedule(sid types.Index, repeat uint64) error {
+	for _, sess := range a.m.saved {
+		if types.IndexEqual(sess.ID, sid) && sess.Repeat == repeat {
+			// Перенос на прежнее время оставляет сессию на её месте.
+			return nil
+		}
+	}
+
+	sess, ok := a.m.unsave(sid)
+	if !ok {
+		return errors.New("saved session not found").SessionID(sid)
+	}
+
+	sess.Repeat = repeat
+	a.m.save(sess)
+	return nil
+}
+
+func (a *modelApplier) Import(src types.Index, _ uint32) error {
+	return errors.New("imports are not simulated").Stg("source-id", src)
+}
@@ -0,0 +1,732 @@
+package sim
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/mpio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/state"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Config параметры симуляции.
+type Config struct {
+	// Dir директория с данными узла.
+	Dir string
+
+	// FS файловая система узла, по умолчанию – файловая система ОС.
+	FS fsio.FS
+
+	// Seed зерно генератора случайных чисел симуляции.
+	Seed int64
+
+	// Steps число шагов симуляции.
+	Steps int
+
+	// Faults вероятности сбоев записи и синхронизации лога.
+	Faults Faults
+
+	// Вероятности синхронизации лога, записи слепка и аварии узла на
+	// каждом шаге.
+	SyncRate     float64
+	SnapshotRate float64
+	CrashRate    float64
+
+	// Вероятности сброса сохранённых сессий в новый источник и слияния
+	// двух соседних по старшинству источников на каждом шаге. И сброс,
+	// и слияние идут в два шага: запись файла и его регистрация. Между
+	// ними узел продолжает работу, пишет слепки и может пережить аварию.
+	FlushRate float64
+	MergeRate float64
+
+	// MaxActive наибольшее число активных сессий.
+	MaxActive int
+
+	// MaxDelay наибольшая задержка повтора сохраняемых сессий.
+	MaxDelay time.Duration
+
+	// MaxStep наибольший сдвиг времени за шаг.
+	MaxStep time.Duration
+
+	// RestoreBatch наибольшее число сессий выдаваемых на повтор разом.
+	RestoreBatch int
+}
+
+// DefaultConfig параметры симуляции по умолчанию в директории dir с
+// зерном seed.
+func DefaultConfig(dir string, seed int64) Config {
+	return Config{
+		Dir:   dir,
+		Seed:  seed,
+		Steps: 2000,
+		Faults: Faults{
+			Write: 0.002,
+			Sync:  0.01,
+		},
+		SyncRate:     0.2,
+		SnapshotRate: 0.01,
+		CrashRate:    0.005,
+		FlushRate:    0.02,
+		MergeRate:    0.01,
+		MaxActive:    32,
+		MaxDelay:     2 * time.Second,
+		MaxStep:      100 * time.Millisecond,
+		RestoreBatch: 8,
+	}
+}
+
+// Report итоги симуляции.
+type Report struct {
+	Steps  int
+	Events int // Записанные в лог события, включая потерянные при авариях.
+
+	Crashes   int
+	Faults    int // Аварии вызванные сбоями файловых операций.
+	TornTails int // Перезапуски с обрезкой оборванного хвоста лога.
+	Lost      int // Неподтверждённые события потерянные при авариях.
+
+	Syncs     int
+	Snapshots int
+	Flushes   int // Зарегистрированные источники из сброшенных сессий.
+	Merges    int // Зарегистрированные слияния источников.
+	Restored  int // Сессии выданные на повтор.
+}
+
+// repeatQuantum шаг времени повтора сохраняемых сессий.
+const repeatQuantum = 250 * time.Millisecond
+
+// simStart начало времени прогона: современная дата, время повтора
+// для которой в миллисекундах не умещается в 32 бита.
+var simStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Run симуляция с параметрами cfg. Ошибка возвращается как при
+// нарушении инвариантов, так и при невозможности провести симуляцию,
+// в обоих случаях она содержит зерно и номер шага.
+func Run(cfg Config) (*Report, error) {
+	if cfg.FS == nil {
+		cfg.FS = fsio.OS{}
+	}
+
+	sim := &simulation{
+		cfg:   cfg,
+		rnd:   rand.New(rand.NewSource(cfg.Seed)),
+		clock: NewClock(simStart),
+		res:   types.RepeatMillisecond,
+		model: newModel(),
+		snaps: logio.NewSnapshotsFS(cfg.FS, datadir.SnapshotsLogName(cfg.Dir), func(error) {}),
+	}
+
+	if err := sim.run(); err != nil {
+		return &sim.report, errors.Wrap(err, "simulate").Int64("seed", cfg.Seed).Int("step", sim.step)
+	}
+
+	return &sim.report, nil
+}
+
+// logID идентификатор единственного лога узла.
+var logID = types.NewIndex(1, 1)
+
+// sourceBlockSize размер блока источников узла.
+const sourceBlockSize = 64 * 1024
+
+// simulation состояние симуляции: узел и эталонная модель.
+type simulation struct {
+	cfg   Config
+	rnd   *rand.Rand
+	clock *Clock
+	res   types.RepeatResolution
+	rec   logop.Recorder
+
+	state   *state.State
+	descs   *state.Descriptors
+	applier *state.Applier
+	log     *logio.Writer
+	file    *FaultyFile
+	snaps   *logio.Snapshots
+
+	model *model
+
+	// history операции записанных событий, i-я относится к событию с
+	// индексом i+1.
+	history [][]byte
+	acked   types.Index
+
+	// srcseq номер последнего созданного источника. Номера не
+	// переиспользуются и после аварий, хотя незарегистрированные
+	// источники при перезапуске удаляются.
+	srcseq uint64
+
+	// pending регистрация файла записанного первым шагом сброса или
+	// слияния, nil если такого нет.
+	pending func() error
+
+	step   int
+	report Report
+}
+
+func (s *simulation) run() error {
+	if err := s.init(); err != nil {
+		return errors.Wrap(err, "init node")
+	}
+
+	for s.step = 1; s.step <= s.cfg.Steps; s.step++ {
+		if err := s.next(); err != nil {
+			return err
+		}
+		s.report.Steps++
+	}
+
+	// Сбой при закрытии лога это такая же авария: неподтверждённые
+	// выдачи откатываются, и выдача повторяется.
+	for {
+		if err := s.drain(); err != nil {
+			return errors.Wrap(err, "drain saved sessions")
+		}
+
+		err := s.log.Close()
+		if err == nil {
+			return nil
+		}
+		if err := s.fault(errors.Wrap(err, "close log")); err != nil {
+			return err
+		}
+	}
+}
+
+// init создание узла с пустым состоянием.
+func (s *simulation) init() error {
+	st, err := state.NewState(s.res, state.MemoryLimits{})
+	if err != nil {
+		return errors.Wrap(err, "create state")
+	}
+
+	descs := &state.Descriptors{}
+	descs.SetFS(s.cfg.FS)
+	if err := descs.StartLog(logID, logID); err != nil {
+		return errors.Wrap(err, "register log")
+	}
+
+	s.state = st
+	s.descs = descs
+	if err := s.state.LoadSources(s.cfg.Dir, s.descs); err != nil {
+		return errors.Wrap(err, "load sources")
+	}
+	if err := s.writeSnapshot(); err != nil {
+		return errors.Wrap(err, "write initial snapshot")
+	}
+
+	return s.start()
+}
+
+// start запуск узла с уже загруженным состоянием.
+func (s *simulation) start() error {
+	s.state.SetTime(s.clock.Now())
+	s.applier = state.NewApplier(s.state, s.cfg.Dir, s.descs, nil)
+
+	log, err := logio.NewWriterFS(
+		s.cfg.FS,
+		datadir.LogName(s.cfg.Dir, logID),
+		64*1024,
+		1024,
+		logio.WriterFileWrap(func(file fsio.File) (mpio.File, error) {
+			f, err := NewFaultyFile(file, s.rnd, s.cfg.Faults)
+			if err != nil {
+				return nil, err
+			}
+
+			s.file = f
+			return f, nil
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "open log")
+	}
+	s.log = log
+
+	return nil
+}
+
+// next очередной шаг симуляции.
+func (s *simulation) next() error {
+	steps := []struct {
+		rate float64
+		run  func() error
+	}{
+		{s.cfg.CrashRate, s.crash},
+		{s.cfg.SnapshotRate, s.snapshot},
+		{s.cfg.SyncRate, s.sync},
+		{s.cfg.FlushRate, s.flush},
+		{s.cfg.MergeRate, s.merge},
+	}
+	p := s.rnd.Float64()
+	for _, step := range steps {
+		if p < step.rate {
+			return step.run()
+		}
+		p -= step.rate
+	}
+
+	if s.cfg.MaxStep > 0 && s.rnd.Intn(4) == 0 {
+		s.state.SetTime(s.clock.Advance(time.Duration(s.rnd.Int63n(int64(s.cfg.MaxStep)))))
+	}
+
+	op, restore := s.generate()
+	return s.apply(op, restore)
+}
+
+// generate операция над сессиями допустимая в текущем состоянии модели,
+// restore означает выдачу сессий на повтор.
+func (s *simulation) generate() (op []byte, restore bool) {
+	m := s.model
+
+	if due := m.due(s.res.Repeat(s.clock.Now())); due > 0 && s.rnd.Intn(3) == 0 {
+		n := 1 + s.rnd.Intn(s.cfg.RestoreBatch)
+		if n > due {
+			n = due
+		}
+		if s.rnd.Intn(2) == 0 {
+			return s.rec.Restore(uint32(n)), true
+		}
+
+		// Выдача вразнобой, как при ограничении скорости выдачи по темам.
+		var sids []types.Index
+		for _, i := range s.rnd.Perm(due)[:n] {
+			sids = append(sids, m.saved[i].ID)
+		}
+		return s.rec.RestoreSessions(sids), true
+	}
+
+	if len(m.saved) > 0 && s.rnd.Intn(10) == 0 {
+		sid := m.saved[s.rnd.Intn(len(m.saved))].ID
+		if s.rnd.Intn(2) == 0 {
+			return s.rec.Cancel(sid), false
+		}
+		return s.rec.Reschedule(sid, s.repeatTime()), false
+	}
+
+	if len(m.active) == 0 || len(m.active) < s.cfg.MaxActive && s.rnd.Intn(4) == 0 {
+		return s.rec.New(uint32(s.rnd.Intn(4))), false
+	}
+
+	ids := m.activeIDs()
+	sid := ids[s.rnd.Intn(len(ids))]
+	switch p := s.rnd.Intn(10); {
+	case p < 5:
+		data := make([]byte, 1+s.rnd.Intn(64))
+		s.rnd.Read(data)
+		return s.rec.Record(sid, data), false
+	case p < 7:
+		return s.rec.Store(sid, s.repeatTime()), false
+	case p < 9:
+		return s.rec.StorePriority(sid, s.repeatTime(), uint8(s.rnd.Intn(3))), false
+	default:
+		return s.rec.Delete(sid), false
+	}
+}
+
+// repeatTime случайное время повтора не раньше текущего.
+func (s *simulation) repeatTime() uint64 {
+	// Время повтора огрубляется, чтобы сессии с равным временем повтора
+	// чаще попадали в разные источники.
+	repeat := s.res.After(s.clock.Now(), time.Duration(s.rnd.Int63n(int64(s.cfg.MaxDelay)+1)))
+	quantum := uint64(repeatQuantum / s.res.Interval())
+	return (repeat/quantum + 1) * quantum
+}
+
+// apply запись в лог и применение к узлу и модели операции op, restore
+// означает выдачу сессий на повтор.
+func (s *simulation) apply(op []byte, restore bool) error {
+	id := types.NewIndex(1, uint64(len(s.history))+1)
+	s.history = append(s.history, op)
+	s.report.Events++
+
+	if _, err := s.log.WriteEvent(id, op); err != nil {
+		return s.fault(errors.Wrap(err, "write event").Stg("event-id", id))
+	}
+
+	var before []types.Index
+	if restore {
+		before = s.state.ActiveSessions()
+	}
+
+	if err := s.model.apply(id, op); err != nil {
+		return errors.Wrap(err, "apply operation to model").Stg("event-id", id)
+	}
+	if err := s.applier.Apply(id, op); err != nil {
+		return errors.Wrap(err, "invariant violated: valid operation rejected").Stg("event-id", id)
+	}
+
+	if !restore {
+		return nil
+	}
+
+	restored := newIDs(before, s.state.ActiveSessions())
+	if err := compareIDs(sortedIDs(s.model.restored), restored); err != nil {
+		return errors.Wrap(err, "invariant violated: unexpected sessions restored").Stg("event-id", id)
+	}
+	for _, sid := range s.model.restored {
+		if sess := s.model.active[sid]; sess.Repeats == 0 {
+			return errors.New("invariant violated: restored session has no repeats").SessionID(sid)
+		}
+	}
+	s.report.Restored += len(restored)
+
+	if err := s.compare(); err != nil {
+		return errors.Wrap(err, "invariant violated after restore").Stg("event-id", id)
+	}
+
+	return nil
+}
+
+// sync синхронизация лога, после неё записанные события подтверждены.
+func (s *simulation) sync() error {
+	if err := s.log.Sync(); err != nil {
+		return s.fault(errors.Wrap(err, "sync log"))
+	}
+
+	last := types.NewIndex(1, uint64(len(s.history)))
+	if len(s.history) == 0 {
+		last = types.Index{}
+	}
+	s.acked = last
+	s.report.Syncs++
+
+	if err := s.descs.LogWritten(last, s.log.Pos()); err != nil {
+		return errors.Wrap(err, "update log descriptor")
+	}
+
+	return nil
+}
+
+// snapshot запись слепка после синхронизации лога: слепок не должен
+// опережать лог.
+func (s *simulation) snapshot() error {
+	crashes := s.report.Crashes
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if s.report.Crashes != crashes {
+		// Синхронизация не удалась и узел был перезапущен.
+		return nil
+	}
+
+	if err := s.writeSnapshot(); err != nil {
+		return errors.Wrap(err, "write snapshot")
+	}
+	s.report.Snapshots++
+
+	return nil
+}
+
+// flush сброс сохранённых сессий в новый источник. Первый шаг
+// записывает файл источника, следующий шаг сброса или слияния
+// регистрирует его.
+func (s *simulation) flush() error {
+	if s.pending != nil {
+		return s.register()
+	}
+
+	tree, err := s.state.StartFlush()
+	if err != nil {
+		return errors.Wrap(err, "start flush")
+	}
+
+	id := s.nextSource()
+	footer, err := tree.DumpFileFS(s.cfg.FS, s.cfg.Dir, id, s.res)
+	if err != nil {
+		return errors.Wrap(err, "dump saved sessions").Stg("source-id", id)
+	}
+
+	s.pending = func() error {
+		length, err := s.sourceLength(id)
+		if err != nil {
+			return err
+		}
+
+		s.state.FinishFlush(id, footer)
+		if err := s.descs.AddSource(id, length); err != nil {
+			return errors.Wrap(err, "register flushed source").Stg("source-id", id)
+		}
+		s.report.Flushes++
+		return nil
+	}
+
+	return nil
+}
+
+// merge слияние двух случайных соседних по старшинству источников, см.
+// state.Descriptors.Sources. Первый шаг записывает файл, следующий шаг
+// сброса или слияния регистрирует его.
+func (s *simulation) merge() error {
+	if s.pending != nil {
+		return s.register()
+	}
+
+	srcs := s.descs.Sources()
+	if len(srcs) < 2 {
+		return nil
+	}
+
+	i := s.rnd.Intn(len(srcs) - 1)
+	a, b := srcs[i], srcs[i+1]
+	id := s.nextSource()
+	footer, err := sourceio.MergeFilesFS(
+		s.cfg.FS,
+		datadir.TempName(s.cfg.Dir, datadir.TempMerge),
+		datadir.SourceName(s.cfg.Dir, id),
+		datadir.SourceName(s.cfg.Dir, a),
+		datadir.SourceName(s.cfg.Dir, b),
+		sourceBlockSize,
+		s.res,
+	)
+	if err != nil {
+		return errors.Wrap(err, "merge sources").Stg("source-a-id", a).Stg("source-b-id", b)
+	}
+
+	s.pending = func() error {
+		length, err := s.sourceLength(id)
+		if err != nil {
+			return err
+		}
+
+		if err := s.descs.SourcesMerged(id, length, a, b); err != nil {
+			return errors.Wrap(err, "register merged source").Stg("source-id", id)
+		}
+		if err := s.state.SourcesMerged(s.cfg.Dir, s.descs, id, footer, a, b); err != nil {
+			return errors.Wrap(err, "account merged source").Stg("source-id", id)
+		}
+		s.report.Merges++
+		return nil
+	}
+
+	return nil
+}
+
+// register регистрация файла записанного первым шагом сброса или
+// слияния.
+func (s *simulation) register() error {
+	register := s.pending
+	s.pending = nil
+	return register()
+}
+
+// nextSource идентификатор нового источника.
+func (s *simulation) nextSource() types.Index {
+	s.srcseq++
+	return types.NewIndex(2, s.srcseq)
+}
+
+// sourceLength длина файла источника id.
+func (s *simulation) sourceLength(id types.Index) (uint64, error) {
+	stat, err := s.cfg.FS.Stat(datadir.SourceName(s.cfg.Dir, id))
+	if err != nil {
+		return 0, errors.Wrap(err, "stat source").Stg("source-id", id)
+	}
+
+	return uint64(stat.Size()), nil
+}
+
+func (s *simulation) writeSnapshot() error {
+	name, err := s.state.WriteSnapshot(s.cfg.Dir, s.descs)
+	if err != nil {
+		return errors.Wrap(err, "write snapshot file")
+	}
+
+	if err := s.snaps.WriteName(filepath.Base(name)); err != nil {
+		return errors.Wrap(err, "register snapshot").Str("snapshot-name", name)
+	}
+
+	return nil
+}
+
+// fault авария узла вызванная сбоем файловой операции err. Сбои не
+// внесённые симуляцией возвращаются как есть.
+func (s *simulation) fault(err error) error {
+	var injected ErrorInjected
+	if !errors.As(err, &injected) {
+		return err
+	}
+
+	s.report.Faults++
+	return s.crash()
+}
+
+// crash авария узла и его перезапуск.
+func (s *simulation) crash() error {
+	if err := s.file.Crash(); err != nil {
+		return errors.Wrap(err, "crash log file")
+	}
+	s.log = nil
+	s.pending = nil
+	s.report.Crashes++
+
+	return s.restart()
+}
+
+// restart запуск узла после аварии и проверка восстановленного
+// состояния.
+func (s *simulation) restart() error {
+	logger := func(error) {
+		s.report.TornTails++
+	}
+
+	last, size, err := logio.TrimTailFS(s.cfg.FS, datadir.LogName(s.cfg.Dir, logID), logger)
+	if err != nil {
+		return errors.Wrap(err, "trim log tail")
+	}
+	if types.IndexLess(last, s.acked) {
+		return errors.New("invariant violated: acknowledged events lost").
+			Stg("acknowledged-id", s.acked).
+			Stg("last-logged-id", last)
+	}
+
+	st, descs, err := state.OpenFS(s.cfg.FS, s.cfg.Dir, state.MemoryLimits{}, nil, func(error) {})
+	if err != nil {
+		return errors.Wrap(err, "open state")
+	}
+	if !types.IndexEqual(st.ID(), last) {
+		return errors.New("invariant violated: state is not at the last logged event").
+			Stg("state-id", st.ID()).
+			Stg("last-logged-id", last)
+	}
+	if err := descs.LogWritten(last, size); err != nil {
+		return errors.Wrap(err, "update log descriptor")
+	}
+
+	s.report.Lost += len(s.history) - int(last.Index)
+	s.history = s.history[:last.Index]
+	s.acked = last
+	s.model = newModel()
+	for i, op := range s.history {
+		if err := s.model.apply(types.NewIndex(1, uint64(i)+1), op); err != nil {
+			return errors.Wrap(err, "replay operation to model").Int("event-number", i+1)
+		}
+	}
+
+	s.state = st
+	s.descs = descs
+	if err := s.compare(); err != nil {
+		return errors.Wrap(err, "invariant violated after restart").Stg("state-id", last)
+	}
+
+	return s.start()
+}
+
+// drain выдача на повтор всех сохранённых сессий по окончании шагов.
+func (s *simulation) drain() error {
+	s.state.SetTime(s.clock.Advance(s.cfg.MaxDelay + s.res.Interval()))
+	for len(s.model.saved) > 0 {
+		n := len(s.model.saved)
+		if n > s.cfg.RestoreBatch {
+			n = s.cfg.RestoreBatch
+		}
+		// При аварии модель восстанавливается вместе с узлом, и
+		// выдача продолжается с восстановленного состояния.
+		if err := s.apply(s.rec.Restore(uint32(n)), true); err != nil {
+			return err
+		}
+	}
+
+	return s.compare()
+}
+
+// compare проверка совпадения состояния узла с моделью.
+func (s *simulation) compare() error {
+	if err := compareIDs(s.model.activeIDs(), s.state.ActiveSessions()); err != nil {
+		return errors.Wrap(err, "compare active sessions")
+	}
+
+	var i int
+	err := s.state.ScanSaved(s.cfg.Dir, s.descs, func(repeat uint64, prio types.Priority, sess *types.Session) error {
+		if i >= len(s.model.saved) {
+			return errors.New("unexpected saved session").SessionID(sess.ID)
+		}
+
+		got := modelSession{
+			ID:       sess.ID,
+			Theme:    uint32(sess.Theme),
+			Size:     sess.Data.Size(),
+			Repeats:  uint32(sess.Repeats),
+			Repeat:   repeat,
+			Priority: uint8(prio),
+		}
+		if want := *s.model.saved[i]; got != want {
+			return errors.New("saved session mismatch").
+				Int("queue-position", i).
+				SessionID(sess.ID).
+				Stg("expected-session-id", want.ID).
+				Uint64("repeat-time", got.Repeat).
+				Uint64("expected-repeat-time", want.Repeat).
+				Int("priority", int(got.Priority)).
+				Int("expected-priority", int(want.Priority)).
+				Int("data-size", got.Size).
+				Int("expected-data-size", want.Size).
+				Uint32("repeats", got.Repeats).
+				Uint32("expected-repeats", want.Repeats)
+		}
+
+		i++
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "compare saved sessions")
+	}
+	if i != len(s.model.saved) {
+		return errors.New("saved sessions missing").
+			Int("saved-sessions", i).
+			Int("expected-saved-sessions", len(s.model.saved))
+	}
+
+	return nil
+}
+
+// newIDs идентификаторы из after отсутствующие в before, оба среза
+// упорядочены по возрастанию.
+func newIDs(before, after []types.Index) []types.Index {
+	var res []types.Index
+	var i int
+	for _, id := range after {
+		for i < len(before) && types.IndexLess(before[i], id) {
+			i++
+		}
+		if i < len(before) && types.IndexEqual(before[i], id) {
+			continue
+		}
+		res = append(res, id)
+	}
+
+	return res
+}
+
+func sortedIDs(ids []types.Index) []types.Index {
+	res := append([]types.Index(nil), ids...)
+	sort.Slice(res, func(i, j int) bool {
+		return types.IndexLess(res[i], res[j])
+	})
+
+	return res
+}
+
+// compareIDs проверка совпадения упорядоченных наборов идентификаторов.
+func compareIDs(want, got []types.Index) error {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return errors.New("session is missing").SessionID(want[i])
+		case i >= len(want):
+			return errors.New("unexpected session").SessionID(got[i])
+		case !types.IndexEqual(want[i], got[i]):
+			return errors.New("session mismatch").SessionID(got[i]).Stg("expected-session-id", want[i])
+		}
+	}
+
+	return nil
+}
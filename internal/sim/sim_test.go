@@ -0,0 +1,49 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+)
+
+func TestSimulation(t *testing.T) {
+	var flushes, merges int
+	for seed := int64(1); seed <= 20; seed++ {
+		cfg := DefaultConfig(t.TempDir(), seed)
+		report, err := Run(cfg)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "run simulation"))
+			return
+		}
+
+		t.Logf("seed %d: %+v", seed, *report)
+		flushes += report.Flushes
+		merges += report.Merges
+	}
+
+	if flushes == 0 || merges == 0 {
+		t.Errorf("expected sources to be flushed and merged, got %d flushes and %d merges", flushes, merges)
+	}
+}
+
+func TestSimulationInMemory(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		mem := fsio.NewMem()
+		if err := mem.MkdirAll("/data", 0755); err != nil {
+			tlog.Error(t, errors.Wrap(err, "create data directory"))
+			return
+		}
+
+		cfg := DefaultConfig("/data", seed)
+		cfg.FS = mem
+		report, err := Run(cfg)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "run simulation"))
+			return
+		}
+
+		t.Logf("seed %d: %+v", seed, *report)
+	}
+}
@@ -0,0 +1,102 @@
+package sourceio
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/uvarints"
+)
+
+// blockReader вычитка блоков файла источника с проверкой их
+// контрольных сумм.
+type blockReader struct {
+	src    io.Reader
+	offset uint64 // Смещение следующего блока от начала файла.
+	cur    uint64 // Смещение текущего блока.
+	buf    []byte
+}
+
+// next вычитка следующего блока. Возвращает io.EOF после блока
+// нулевой длины, отмечающего конец сессий.
+func (r *blockReader) next() ([]byte, error) {
+	r.cur = r.offset
+
+	var head [blockHeaderSize]byte
+	if _, err := io.ReadFull(r.src, head[:]); err != nil {
+		return nil, r.readError(err, "read block header")
+	}
+
+	length := binary.LittleEndian.Uint32(head[:4])
+	crc := binary.LittleEndian.Uint32(head[4:])
+	if length == 0 {
+		if crc != 0 {
+			return nil, errors.Wrap(ErrorSourceCorrupted{Offset: r.cur}, "invalid end of sessions block").
+				Uint32("invalid-crc", crc)
+		}
+
+		return nil, io.EOF
+	}
+	if length > blockSizeHardLimit {
+		return nil, errors.Wrap(ErrorSourceCorrupted{Offset: r.cur}, "block length is out of limit").
+			Uint32("invalid-block-length", length).
+			Int("block-length-limit", blockSizeHardLimit)
+	}
+
+	if uint32(cap(r.buf)) < length {
+		r.buf = make([]byte, length)
+	}
+	r.buf = r.buf[:length]
+	if _, err := io.ReadFull(r.src, r.buf); err != nil {
+		return nil, r.readError(err, "read block data")
+	}
+
+	if err := checkBlock(r.cur, r.buf, crc); err != nil {
+		return nil, err
+	}
+
+	r.offset += blockHeaderSize + uint64(length)
+	return r.buf, nil
+}
+
+// reset переход к блоку со смещением offset, src уже должен
+// указывать на него.
+func (r *blockReader) reset(offset uint64) {
+	r.offset = offset
+	r.cur = offset
+}
+
+// readError оборачивание ошибок чтения: преждевременный конец
+// файла означает его повреждение.
+func (r *blockReader) readError(err error, msg string) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.Wrap(ErrorSourceCorrupted{Offset: r.cur}, msg).Str("read-error", err.Error())
+	}
+
+	return errors.Wrap(err, msg)
+}
+
+// splitRecord разбор записи сессии в начале данных блока со смещением off.
+func splitRecord(block []byte, off uint64) (repeat uint64, data []byte, rest []byte, err error) {
+	if len(block) < 8 {
+		return 0, nil, nil, errors.Wrap(ErrorSourceCorrupted{Offset: off}, "missing repeat time")
+	}
+
+	repeat = binary.LittleEndian.Uint64(block)
+	if repeat == 0 {
+		return 0, nil, nil, errors.Wrap(ErrorSourceCorrupted{Offset: off}, "zero repeat time in a block")
+	}
+
+	length, rest, err := uvarints.Read(block[8:])
+	if err != nil {
+		return 0, nil, nil, errors.Wrap(ErrorSourceCorrupted{Offset: off}, "read session length").
+			Str("read-error", err.Error())
+	}
+	if length > uint64(len(rest)) {
+		return 0, nil, nil, errors.Wrap(ErrorSourceCorrupted{Offset: off}, "session is out of the block").
+			Uint64("session-length", length).
+			Int("block-rest", len(rest))
+	}
+
+	return repeat, rest[:length], rest[length:], nil
+}
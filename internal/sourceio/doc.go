@@ -4,4 +4,6 @@
 //  - Сохранения сессий в источник.
 //  - Чтение существующих источников.
 //  - Объединение источников.
+//  - Подвал источника с индексом блоков по временам повтора.
+//  - Проверка целостности файлов источников и карантин повреждённых.
 package sourceio
@@ -0,0 +1,263 @@
+package sourceio
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// quarantineSuffix расширение повреждённых файлов источников.
+const quarantineSuffix = ".corrupted"
+
+// File файл источника открытый на чтение.
+type File struct {
+	src    io.ReaderAt
+	file   fsio.File
+	size   int64
+	framed bool
+	header sourceHeader
+	footer *Footer
+
+	// Разрешение времени повтора отдаваемого итераторами, см. Rescale.
+	target types.RepeatResolution
+}
+
+// Open открытие файла источника на чтение. Проверяются заголовок
+// и подвал файла, повреждения отдаются как ErrorSourceCorrupted.
+// Файлы старого формата без заголовка открываются без проверок.
+func Open(name string) (*File, error) {
+	return OpenFS(fsio.OS{}, name)
+}
+
+// OpenFS открытие файла источника name файловой системы fsys, см. Open.
+func OpenFS(fsys fsio.FS, name string) (*File, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open source file")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		if cErr := file.Close(); cErr != nil {
+			return nil, errors.Wrap(err, "get file info").Str("close-error", cErr.Error())
+		}
+
+		return nil, errors.Wrap(err, "get file info")
+	}
+
+	res, err := NewFile(file, stat.Size())
+	if err != nil {
+		if cErr := file.Close(); cErr != nil {
+			return nil, errors.Wrap(err, "check source file").Str("close-error", cErr.Error())
+		}
+
+		return nil, errors.Wrap(err, "check source file")
+	}
+	res.file = file
+
+	return res, nil
+}
+
+// NewFile источник из данных src размера size, проверки аналогичны Open.
+func NewFile(src io.ReaderAt, size int64) (*File, error) {
+	res := &File{
+		src:  src,
+		size: size,
+	}
+
+	header, framed, err := readSourceHeader(src, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	res.header = header
+	res.framed = framed
+	res.target = header.resolution
+
+	footer, err := ReadFooter(src, res.size)
+	switch {
+	case err == nil:
+		res.footer = footer
+	case errors.Is(err, ErrorNoFooter):
+	default:
+		return nil, errors.Wrap(err, "read footer")
+	}
+
+	return res, nil
+}
+
+// Resolution разрешение времени повтора в файле. Для простых
+// потоков сессий без заголовка оно неизвестно и равно нулю.
+func (f *File) Resolution() types.RepeatResolution {
+	return f.header.resolution
+}
+
+// Rescale задаёт разрешение времени повтора в котором итераторы будут
+// отдавать сессии, по-умолчанию это разрешение самого файла. Так файлы
+// с повтором в секундах читаются системой работающей с более точным
+// временем. Для файлов без заголовка пересчёт не делается.
+func (f *File) Rescale(to types.RepeatResolution) error {
+	if err := to.Check(); err != nil {
+		return errors.Wrap(err, "check target resolution")
+	}
+
+	if f.framed {
+		f.target = to
+	}
+	return nil
+}
+
+// BlockOffset смещение блока с которого следует искать сессии с временем
+// повтора repeat, заданным в разрешении итераторов, см. Rescale. Без
+// подвала возвращается ноль.
+func (f *File) BlockOffset(repeat uint64) uint64 {
+	if f.footer == nil {
+		return 0
+	}
+
+	return f.footer.Offset(fileRepeat(repeat, f.header.resolution, f.target))
+}
+
+// Footer возвращает подвал источника, nil для файлов старого
+// формата записанных без подвала.
+func (f *File) Footer() *Footer {
+	return f.footer
+}
+
+// Iterator итератор по сессиям источника с данным размером буфера.
+// Итератор поддерживает Seek. Ошибки чтения повреждённых данных
+// отдаются как ErrorSourceCorrupted.
+func (f *File) Iterator(size int) (*Iterator, error) {
+	src := io.NewSectionReader(f.src, 0, f.size)
+	if !f.framed {
+		return NewIteratorSize(src, size), nil
+	}
+
+	start := f.header.size()
+	if _, err := src.Seek(int64(start), io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "seek to the first block")
+	}
+
+	return &Iterator{
+		src:    src,
+		framed: true,
+		start:  start,
+		from:   f.header.resolution,
+		to:     f.target,
+		blocks: blockReader{
+			src:    src,
+			offset: start,
+		},
+	}, nil
+}
+
+// Verify полная проверка файла: контрольных сумм всех блоков,
+// декодирования сессий и соответствия подвалу.
+func (f *File) Verify() error {
+	it, err := f.Iterator(0)
+	if err != nil {
+		return errors.Wrap(err, "create iterator")
+	}
+
+	var count uint64
+	for it.Next() {
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "read sessions")
+	}
+
+	if f.footer != nil && f.footer.Count != count {
+		return errors.Wrap(ErrorSourceCorrupted{Offset: uint64(f.size)}, "sessions count mismatch").
+			Uint64("footer-count", f.footer.Count).
+			Uint64("actual-count", count)
+	}
+
+	return nil
+}
+
+// reader последовательное чтение всего файла.
+func (f *File) reader() *bufio.Reader {
+	return bufio.NewReader(io.NewSectionReader(f.src, 0, f.size))
+}
+
+// rawIterator итератор по сырым записям файла с временем повтора
+// в разрешении итераторов.
+func (f *File) rawIterator() *rawIterator {
+	return &rawIterator{
+		src: f.reader(),
+		to:  f.target,
+	}
+}
+
+// readSourceHeader вычитка заголовка из данных размера size. Для простых
+// потоков сессий без заголовка framed равен false.
+func readSourceHeader(src io.ReaderAt, size int64) (h sourceHeader, framed bool, err error) {
+	if size < sourceHeaderSize {
+		return h, false, nil
+	}
+
+	data := make([]byte, sourceHeaderMaxSize)
+	if size < sourceHeaderMaxSize {
+		data = data[:size]
+	}
+	if _, err := src.ReadAt(data, 0); err != nil {
+		return h, false, errors.Wrap(err, "read header data")
+	}
+	if !isSourceHeader(data) {
+		return h, false, nil
+	}
+
+	h, err = decodeSourceHeader(data)
+	if err != nil {
+		return h, true, errors.Wrap(err, "decode source header")
+	}
+
+	return h, true, nil
+}
+
+// Close закрытие файла открытого с помощью Open.
+func (f *File) Close() error {
+	if f.file == nil {
+		return nil
+	}
+
+	return f.file.Close()
+}
+
+// Quarantine убирает повреждённый файл источника с глаз долой,
+// переименовывая его. Возвращает новое имя файла.
+func Quarantine(name string) (string, error) {
+	return QuarantineFS(fsio.OS{}, name)
+}
+
+// QuarantineFS убирает повреждённый файл источника name файловой
+// системы fsys, см. Quarantine.
+func QuarantineFS(fsys fsio.FS, name string) (string, error) {
+	dst := name + quarantineSuffix
+	if err := fsys.Rename(name, dst); err != nil {
+		return "", errors.Wrap(err, "rename source file").Str("quarantine-name", dst)
+	}
+
+	return dst, nil
+}
+
+// QuarantineCorruptedFS помещение в карантин файла источника name
+// файловой системы fsys, если ошибка err говорит о его повреждении,
+// см. ErrorSourceCorrupted. Возвращаемая ошибка дополняется новым именем
+// файла, прочие ошибки отдаются как есть.
+func QuarantineCorruptedFS(fsys fsio.FS, name string, err error) error {
+	var corrupted ErrorSourceCorrupted
+	if !errors.As(err, &corrupted) {
+		return err
+	}
+
+	qname, qErr := QuarantineFS(fsys, name)
+	if qErr != nil {
+		return errors.Wrap(err, "damaged source").Str("quarantine-error", qErr.Error())
+	}
+
+	return errors.Wrap(err, "damaged source").Str("quarantine-name", qname)
+}
@@ -0,0 +1,67 @@
+package sourceio
+
+import (
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+const (
+	// filterBitsPerSession количество бит фильтра сессий подвала на одну
+	// сессию. Вместе с filterHashes даёт около процента ложных срабатываний.
+	filterBitsPerSession = 10
+
+	// filterHashes количество хешей сессии в фильтре.
+	filterHashes = 7
+)
+
+// MayContain проверка, может ли источник содержать сессию sid. Фильтр
+// допускает ложные срабатывания, но не пропуски.
+func (f *Footer) MayContain(sid types.Index) bool {
+	if len(f.Filter) == 0 {
+		return false
+	}
+
+	bits := uint64(len(f.Filter)) * 8
+	h := sessionHash(sid)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := filterBit(h, i, bits)
+		if f.Filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// buildFilter построение фильтра сессий по хешам их идентификаторов.
+func (f *Footer) buildFilter(hashes []uint64) {
+	f.Filter = nil
+	if len(hashes) == 0 {
+		return
+	}
+
+	f.Filter = make([]byte, (len(hashes)*filterBitsPerSession+7)/8)
+	bits := uint64(len(f.Filter)) * 8
+	for _, h := range hashes {
+		for i := uint64(0); i < filterHashes; i++ {
+			bit := filterBit(h, i, bits)
+			f.Filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+}
+
+// filterBit номер i-го бита фильтра длиной bits для хеша h, хеши
+// получаются двойным хешированием из половин h.
+func filterBit(h, i, bits uint64) uint64 {
+	return ((h & 0xffffffff) + i*(h>>32|1)) % bits
+}
+
+// sessionHash хеш идентификатора сессии для фильтра.
+func sessionHash(sid types.Index) uint64 {
+	h := sid.Term*0x9e3779b97f4a7c15 ^ sid.Index
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,328 @@
+package sourceio
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/uvarints"
+)
+
+const (
+	// ErrorNoFooter источник не содержит подвала. Таковы источники
+	// записанные без вызова Writer.Finish.
+	ErrorNoFooter errors.Const = "source has no footer"
+
+	// HistogramBuckets количество столбцов гистограммы времён повтора.
+	HistogramBuckets = 16
+
+	// footerTrailerSize размер концевика источника: смещение подвала
+	// и сигнатура.
+	footerTrailerSize = 16
+
+	// footerMagic сигнатура концевика источника.
+	footerMagic uint64 = 0x7274666136797066 // "fpy6aftr"
+
+	// defaultBlockSize размер блока источника по умолчанию.
+	defaultBlockSize = 64 * 1024
+)
+
+// Footer подвал источника. Содержит сводную информацию о сохранённых
+// сессиях и позволяет планировать работу с источником без его вычитки.
+type Footer struct {
+	// Count количество сохранённых сессий.
+	Count uint64
+
+	// MinRepeat наименьшее время повтора.
+	MinRepeat uint64
+
+	// MaxRepeat наибольшее время повтора.
+	MaxRepeat uint64
+
+	// Blocks разреженный индекс источника по временам повтора.
+	Blocks []FooterBlock
+
+	// Histogram грубая гистограмма количества сессий по равным
+	// интервалам [MinRepeat, MaxRepeat], см. HistogramWidth. Сессии
+	// относятся к столбцу по времени повтора первой сессии своего блока.
+	Histogram []uint64
+
+	// Filter фильтр Блума идентификаторов сохранённых сессий, см.
+	// MayContain.
+	Filter []byte
+}
+
+// FooterBlock описание блока сессий источника.
+type FooterBlock struct {
+	// Repeat время повтора первой сессии блока.
+	Repeat uint64
+
+	// Offset смещение блока от начала источника: заголовка блока
+	// для файлов источников, первой сессии для простых потоков.
+	Offset uint64
+
+	// Count количество сессий в блоке.
+	Count uint64
+}
+
+// HistogramWidth ширина интервала времён повтора одного столбца гистограммы.
+func (f *Footer) HistogramWidth() uint64 {
+	return (f.MaxRepeat-f.MinRepeat)/HistogramBuckets + 1
+}
+
+// Offset смещение блока с которого нужно начинать вычитку, чтобы
+// получить первую сессию с временем повтора не меньше repeat.
+func (f *Footer) Offset(repeat uint64) uint64 {
+	// Ищем последний блок начинающийся строго раньше repeat: сессии с
+	// временем repeat могут начинаться в его конце.
+	i := sort.Search(len(f.Blocks), func(i int) bool {
+		return f.Blocks[i].Repeat >= repeat
+	})
+	if i == 0 {
+		if len(f.Blocks) == 0 {
+			return 0
+		}
+
+		return f.Blocks[0].Offset
+	}
+
+	return f.Blocks[i-1].Offset
+}
+
+// CountBefore оценка сверху количества сессий с временем повтора
+// меньше repeat по гистограмме: учитываются все столбцы, интервал
+// которых начинается раньше repeat.
+func (f *Footer) CountBefore(repeat uint64) uint64 {
+	if repeat <= f.MinRepeat {
+		return 0
+	}
+
+	width := f.HistogramWidth()
+	var res uint64
+	for i, v := range f.Histogram {
+		if f.MinRepeat+uint64(i)*width >= repeat {
+			break
+		}
+		res += v
+	}
+
+	return res
+}
+
+// PlanMerge выбор двух соседних по старшинству источников для слияния
+// по одним их подвалам footers, упорядоченным от более старых к более
+// новым. Сессии с повтором раньше horizon скоро будут выданы и их
+// перезапись при слиянии напрасна, поэтому выбирается пара с наименьшим
+// числом сессий повторяемых позже, см. CountBefore. Возвращает индекс
+// более старого источника пары или -1, если источников меньше двух.
+func PlanMerge(footers []*Footer, horizon uint64) int {
+	res := -1
+	var best uint64
+	for i := 0; i+1 < len(footers); i++ {
+		var live uint64
+		for _, f := range footers[i : i+2] {
+			live += f.Count - f.CountBefore(horizon)
+		}
+
+		if res < 0 || live < best {
+			res = i
+			best = live
+		}
+	}
+
+	return res
+}
+
+// ReadFooter вычитка подвала источника размера size. Для простых потоков
+// сессий без подвала возвращается ошибка ErrorNoFooter, у файлов источников
+// проверяется контрольная сумма подвала.
+func ReadFooter(src io.ReaderAt, size int64) (*Footer, error) {
+	_, framed, err := readSourceHeader(src, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "read source header")
+	}
+
+	if size < footerTrailerSize+sourceHeaderSize {
+		if framed {
+			return nil, errors.Wrap(ErrorSourceCorrupted{Offset: uint64(size)}, "source file is incomplete")
+		}
+		if size < footerTrailerSize {
+			return nil, ErrorNoFooter
+		}
+	}
+
+	var trailer [footerTrailerSize]byte
+	if _, err := src.ReadAt(trailer[:], size-footerTrailerSize); err != nil {
+		return nil, errors.Wrap(err, "read source trailer")
+	}
+	if binary.LittleEndian.Uint64(trailer[8:]) != footerMagic {
+		if framed {
+			return nil, errors.Wrap(ErrorSourceCorrupted{Offset: uint64(size)}, "source file is incomplete")
+		}
+		return nil, ErrorNoFooter
+	}
+
+	off := binary.LittleEndian.Uint64(trailer[:8])
+	end := uint64(size - footerTrailerSize)
+	if framed {
+		end -= 4
+	}
+	if off > end {
+		return nil, errors.Wrap(ErrorSourceCorrupted{Offset: end}, "footer offset is out of the source").
+			Uint64("footer-offset", off).
+			Int64("source-size", size)
+	}
+
+	data := make([]byte, uint64(size-footerTrailerSize)-off)
+	if _, err := src.ReadAt(data, int64(off)); err != nil {
+		return nil, errors.Wrap(err, "read footer data")
+	}
+	if framed {
+		crc := binary.LittleEndian.Uint32(data[len(data)-4:])
+		data = data[:len(data)-4]
+		if err := checkBlock(off, data, crc); err != nil {
+			return nil, errors.Wrap(err, "check footer")
+		}
+	}
+
+	var f Footer
+	if err := f.decode(data); err != nil {
+		return nil, errors.Wrap(ErrorSourceCorrupted{Offset: off}, "decode footer").Str("decode-error", err.Error())
+	}
+
+	return &f, nil
+}
+
+// account учёт сессии, newBlock означает начало нового блока
+// со смещением off от начала источника.
+func (f *Footer) account(repeat uint64, off uint64, newBlock bool) {
+	if f.Count == 0 {
+		f.MinRepeat = repeat
+	}
+	f.MaxRepeat = repeat
+	f.Count++
+
+	if newBlock {
+		f.Blocks = append(f.Blocks, FooterBlock{
+			Repeat: repeat,
+			Offset: off,
+		})
+	}
+	f.Blocks[len(f.Blocks)-1].Count++
+}
+
+// accountTombstone учёт надгробия: оно может начинать новый блок,
+// но не учитывается среди сессий.
+func (f *Footer) accountTombstone(repeat uint64, off uint64, newBlock bool) {
+	if newBlock {
+		f.Blocks = append(f.Blocks, FooterBlock{
+			Repeat: repeat,
+			Offset: off,
+		})
+	}
+}
+
+// buildHistogram построение гистограммы по блокам.
+func (f *Footer) buildHistogram() {
+	f.Histogram = make([]uint64, HistogramBuckets)
+	if f.Count == 0 {
+		return
+	}
+
+	width := f.HistogramWidth()
+	for _, b := range f.Blocks {
+		if b.Count == 0 {
+			// Блок из одних надгробий.
+			continue
+		}
+
+		// Блок может начинаться с надгробия повтора раньше всех сессий.
+		repeat := b.Repeat
+		if repeat < f.MinRepeat {
+			repeat = f.MinRepeat
+		}
+		f.Histogram[(repeat-f.MinRepeat)/width] += b.Count
+	}
+}
+
+func (f *Footer) encode(dst []byte) []byte {
+	dst = binary.AppendUvarint(dst, f.Count)
+	dst = binary.LittleEndian.AppendUint64(dst, f.MinRepeat)
+	dst = binary.LittleEndian.AppendUint64(dst, f.MaxRepeat)
+
+	dst = binary.AppendUvarint(dst, uint64(len(f.Blocks)))
+	for _, b := range f.Blocks {
+		dst = binary.LittleEndian.AppendUint64(dst, b.Repeat)
+		dst = binary.AppendUvarint(dst, b.Offset)
+		dst = binary.AppendUvarint(dst, b.Count)
+	}
+
+	dst = binary.AppendUvarint(dst, uint64(len(f.Histogram)))
+	for _, v := range f.Histogram {
+		dst = binary.AppendUvarint(dst, v)
+	}
+
+	dst = binary.AppendUvarint(dst, uint64(len(f.Filter)))
+	dst = append(dst, f.Filter...)
+
+	return dst
+}
+
+func (f *Footer) decode(src []byte) (err error) {
+	if f.Count, src, err = uvarints.Read(src); err != nil {
+		return errors.Wrap(err, "read sessions count")
+	}
+	if len(src) < 16 {
+		return errors.New("missing repeat times range")
+	}
+	f.MinRepeat = binary.LittleEndian.Uint64(src)
+	f.MaxRepeat = binary.LittleEndian.Uint64(src[8:])
+	src = src[16:]
+
+	var count uint64
+	if count, src, err = uvarints.Read(src); err != nil {
+		return errors.Wrap(err, "read blocks count")
+	}
+	if count > uint64(len(src)) {
+		return errors.New("blocks count is out of the footer").Uint64("blocks-count", count)
+	}
+	f.Blocks = make([]FooterBlock, count)
+	for i := range f.Blocks {
+		b := &f.Blocks[i]
+		if len(src) < 8 {
+			return errors.New("missing block repeat time").Int("block-no", i)
+		}
+		b.Repeat = binary.LittleEndian.Uint64(src)
+		src = src[8:]
+		if b.Offset, src, err = uvarints.Read(src); err != nil {
+			return errors.Wrap(err, "read block offset").Int("block-no", i)
+		}
+		if b.Count, src, err = uvarints.Read(src); err != nil {
+			return errors.Wrap(err, "read block sessions count").Int("block-no", i)
+		}
+	}
+
+	if count, src, err = uvarints.Read(src); err != nil {
+		return errors.Wrap(err, "read histogram length")
+	}
+	if count > uint64(len(src)) {
+		return errors.New("histogram length is out of the footer").Uint64("histogram-length", count)
+	}
+	f.Histogram = make([]uint64, count)
+	for i := range f.Histogram {
+		if f.Histogram[i], src, err = uvarints.Read(src); err != nil {
+			return errors.Wrap(err, "read histogram bucket").Int("bucket-no", i)
+		}
+	}
+
+	if count, src, err = uvarints.Read(src); err != nil {
+		return errors.Wrap(err, "read filter length")
+	}
+	if count > uint64(len(src)) {
+		return errors.New("filter length is out of the footer").Uint64("filter-length", count)
+	}
+	f.Filter = append([]byte(nil), src[:count]...)
+
+	return nil
+}
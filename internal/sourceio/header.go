@@ -0,0 +1,143 @@
+package sourceio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Формат файла источника версии 3:
+//
+//   - Заголовок: сигнатура (8 байт), версия (4 байта), размер блока (4 байта),
+//     разрешение времени повтора в наносекундах (8 байт).
+//   - Блоки сессий: длина данных блока (4 байта), CRC32 данных (4 байта),
+//     данные – записи сессий целиком. Блок нулевой длины означает конец сессий.
+//     Поле времени повтора записи может содержать приоритет сессии.
+//   - Подвал с CRC32 (4 байта) и концевик, см. Footer.
+//
+// Файлы версии 2 отличаются лишь отсутствием приоритетов. Файлы версии 1
+// к тому же не содержат разрешения в заголовке, время повтора в них
+// хранится в секундах.
+//
+// Файлы без заголовка – это простой поток записей сессий, возможно с
+// подвалом без контрольной суммы. Они по-прежнему читаются, но без проверок.
+
+const (
+	// sourceVersion текущая версия формата файлов источников.
+	sourceVersion = 3
+
+	// sourceVersionNoPriority версия формата без приоритетов сессий.
+	sourceVersionNoPriority = 2
+
+	// sourceVersionSeconds версия формата с временем повтора в секундах.
+	sourceVersionSeconds = 1
+
+	// sourceHeaderSize размер общей для всех версий части заголовка.
+	sourceHeaderSize = 16
+
+	// sourceHeaderMaxSize размер заголовка текущей версии.
+	sourceHeaderMaxSize = sourceHeaderSize + 8
+
+	// sourceMagic сигнатура файла источника.
+	sourceMagic uint64 = 0x6372736136797066 // "fpy6asrc"
+
+	// blockHeaderSize размер заголовка блока.
+	blockHeaderSize = 8
+
+	// blockSizeHardLimit наибольший допустимый размер данных блока. Заодно
+	// ограничивает и размер одной сессии в файле.
+	blockSizeHardLimit = 64 * 1024 * 1024
+)
+
+// crcTable таблица для вычисления контрольных сумм.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrorSourceCorrupted возвращается при обнаружении повреждений в файле
+// источника. Такой файл нельзя использовать для повторов, его следует
+// поместить в карантин, см. Quarantine.
+type ErrorSourceCorrupted struct {
+	// Offset смещение повреждённого участка от начала файла.
+	Offset uint64
+}
+
+func (e ErrorSourceCorrupted) Error() string {
+	return fmt.Sprintf("source corrupted at offset %d", e.Offset)
+}
+
+// sourceHeader данные заголовка файла источника.
+type sourceHeader struct {
+	version    uint32
+	block      uint32
+	resolution types.RepeatResolution
+}
+
+// size размер заголовка.
+func (h sourceHeader) size() uint64 {
+	if h.version == sourceVersionSeconds {
+		return sourceHeaderSize
+	}
+
+	return sourceHeaderMaxSize
+}
+
+func appendSourceHeader(dst []byte, block int, res types.RepeatResolution) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, sourceMagic)
+	dst = binary.LittleEndian.AppendUint32(dst, sourceVersion)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(block))
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(res))
+	return dst
+}
+
+// isSourceHeader проверка, начинаются ли данные с сигнатуры источника.
+func isSourceHeader(data []byte) bool {
+	return len(data) >= 8 && binary.LittleEndian.Uint64(data) == sourceMagic
+}
+
+// decodeSourceHeader разбор заголовка, сигнатура должна быть уже проверена.
+// Данные должны содержать заголовок целиком, его размер зависит от версии,
+// при потоковом чтении остаток после общей части дочитывается для всех
+// версий кроме первой.
+func decodeSourceHeader(data []byte) (sourceHeader, error) {
+	h := sourceHeader{
+		version:    binary.LittleEndian.Uint32(data[8:]),
+		block:      binary.LittleEndian.Uint32(data[12:]),
+		resolution: types.RepeatSecond,
+	}
+
+	switch h.version {
+	case sourceVersionSeconds:
+	case sourceVersionNoPriority, sourceVersion:
+		if uint64(len(data)) < h.size() {
+			return h, errors.Wrap(ErrorSourceCorrupted{Offset: uint64(len(data))}, "source header is incomplete")
+		}
+
+		h.resolution = types.RepeatResolution(binary.LittleEndian.Uint64(data[16:]))
+		if err := h.resolution.Check(); err != nil {
+			return h, errors.Wrap(ErrorSourceCorrupted{Offset: 16}, "invalid repeat resolution").
+				Str("check-error", err.Error())
+		}
+	default:
+		return h, errors.Wrap(ErrorSourceCorrupted{Offset: 8}, "unsupported source version").
+			Uint32("invalid-version", h.version)
+	}
+	if h.block == 0 || h.block > blockSizeHardLimit {
+		return h, errors.Wrap(ErrorSourceCorrupted{Offset: 12}, "invalid block size").
+			Uint32("invalid-block-size", h.block)
+	}
+
+	return h, nil
+}
+
+// checkBlock проверка данных блока начинающегося по смещению off.
+func checkBlock(off uint64, data []byte, crc uint32) error {
+	if actual := crc32.Checksum(data, crcTable); actual != crc {
+		return errors.Wrap(ErrorSourceCorrupted{Offset: off}, "block checksum mismatch").
+			Uint32("expected-crc", crc).
+			Uint32("actual-crc", actual)
+	}
+
+	return nil
+}
@@ -29,11 +29,32 @@ type Iterator struct {
 	size int
 
 	record struct {
-		len     uint64
-		repeat  uint64
-		session types.Session
+		len      uint64
+		repeat   uint64
+		priority types.Priority
+		session  types.Session
+		skip     bool // Надгробие или отменённая сессия.
 	}
-	err error
+	pending   bool // Сессия уже вычитана при поиске и ещё не отдана.
+	cancelled Tombstones
+	err       error
+
+	// Данные для чтения файлов источников, см. File.
+	framed bool
+	start  uint64 // Смещение первого блока.
+	blocks blockReader
+	block  []byte // Непрочитанный остаток текущего блока.
+
+	// Разрешения времени повтора файла и отдаваемых сессий, см. File.Rescale.
+	from types.RepeatResolution
+	to   types.RepeatResolution
+}
+
+// SkipCancelled задаёт отменённые сессии, итератор будет пропускать
+// их. Встреченные сессии удаляются из cancelled. Надгробия самого
+// источника пропускаются всегда.
+func (it *Iterator) SkipCancelled(cancelled Tombstones) {
+	it.cancelled = cancelled
 }
 
 // Next вычитка следующей сохранённой сессии из источника.
@@ -42,6 +63,31 @@ func (it *Iterator) Next() bool {
 		return false
 	}
 
+	if it.pending {
+		it.pending = false
+		return true
+	}
+
+	// Длина пропущенных записей учитывается в длине отдаваемой.
+	var skipped uint64
+	for it.next() {
+		if !it.record.skip {
+			it.record.len += skipped
+			return true
+		}
+
+		skipped += it.record.len
+	}
+
+	return false
+}
+
+// next вычитка следующей записи источника.
+func (it *Iterator) next() bool {
+	if it.framed {
+		return it.nextFramed()
+	}
+
 	if len(it.rest) == 0 && cap(it.buf) > 0 {
 		n, err := it.src.Read(it.buf)
 		if err != nil {
@@ -74,6 +120,55 @@ func (it *Iterator) RepeatData() (passed uint64, repeat uint64, session types.Se
 	return it.record.len, it.record.repeat, it.record.session
 }
 
+// Priority приоритет последней вычитанной сессии.
+func (it *Iterator) Priority() types.Priority {
+	return it.record.priority
+}
+
+// Seek переход к первой сессии с временем повтора не меньше repeat.
+// Источник итератора должен реализовывать io.Seeker, footer это
+// подвал этого источника. Возвращается смещение найденной сессии
+// от начала источника, для учёта позиции в нём.
+func (it *Iterator) Seek(footer *Footer, repeat uint64) (offset uint64, err error) {
+	return it.SeekOffset(footer.Offset(fileRepeat(repeat, it.from, it.to)), repeat)
+}
+
+// SeekOffset аналогично Seek, но поиск начинается со смещения offset,
+// которое должно указывать на начало блока или записи, например взятое
+// из подвала ранее.
+func (it *Iterator) SeekOffset(offset uint64, repeat uint64) (uint64, error) {
+	seeker, ok := it.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("source is not seekable")
+	}
+
+	if it.framed && offset < it.start {
+		offset = it.start
+	}
+	if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "seek to the block").Uint64("block-offset", offset)
+	}
+
+	it.rest = nil
+	it.block = nil
+	it.blocks.reset(offset)
+	it.pending = false
+	it.err = nil
+	for it.Next() {
+		if it.record.repeat >= repeat {
+			it.pending = true
+			return offset, nil
+		}
+
+		offset += it.record.len
+	}
+	if err := it.Err(); err != nil {
+		return 0, errors.Wrap(err, "look for the repeat time in the block")
+	}
+
+	return offset, nil
+}
+
 // Err возвращает ошибку времени итерации.
 func (it *Iterator) Err() error {
 	if errors.Is(it.err, io.EOF) {
@@ -87,9 +182,21 @@ func (it *Iterator) readRepeat() error {
 	if err := it.required(8); err != nil {
 		return errors.Wrap(err, "claim a place for u64")
 	}
+	if len(it.rest) < 8 {
+		return errors.New("not enough data left in the source for repeat time").
+			Int("actual", len(it.rest))
+	}
+
+	if isSourceHeader(it.rest) {
+		return errors.New("got a source file header, the file must be read with sourceio.File")
+	}
 
 	it.record.repeat = binary.LittleEndian.Uint64(it.rest)
 	it.rest = it.rest[8:]
+	if it.record.repeat == 0 {
+		// Признак конца сессий, дальше идёт подвал.
+		return io.EOF
+	}
 	return nil
 }
 
@@ -103,6 +210,12 @@ func (it *Iterator) readData() error {
 		return errors.Wrap(err, "take session length")
 	}
 
+	if length > blockSizeHardLimit {
+		return errors.New("session length is out of limit").
+			Uint64("invalid-session-length", length).
+			Int("session-length-limit", blockSizeHardLimit)
+	}
+
 	it.record.len = 8 + uint64(varsize.Uint(length)) + length
 	it.rest = rest
 
@@ -117,14 +230,102 @@ func (it *Iterator) readData() error {
 			Int("actual", len(it.rest))
 	}
 
+	raw := it.record.repeat
+	it.record.repeat = raw & repeatMask
+	it.record.priority = recordPriority(raw)
+	if isTombstone(raw) {
+		if length != tombstoneLength {
+			return errors.New("invalid tombstone length").Uint64("tombstone-length", length)
+		}
+
+		it.record.skip = true
+		it.rest = it.rest[length:]
+		return nil
+	}
+
 	if err := types.SessionDecode(&it.record.session, it.rest[:length]); err != nil {
 		return errors.Wrap(err, "decode session session")
 	}
 
+	it.record.skip = it.cancelled.cancels(it.record.session.ID, it.record.repeat)
 	it.rest = it.rest[length:]
 	return nil
 }
 
+// nextFramed вычитка следующей сессии файла источника.
+func (it *Iterator) nextFramed() bool {
+	var head uint64
+	if len(it.block) == 0 {
+		block, err := it.blocks.next()
+		if err != nil {
+			it.err = errors.Wrap(err, "read block")
+			return false
+		}
+
+		it.block = block
+		head = blockHeaderSize
+	}
+
+	raw, data, rest, err := splitRecord(it.block, it.blocks.cur)
+	if err != nil {
+		it.err = errors.Wrap(err, "split session record")
+		return false
+	}
+
+	raw = convertRepeat(raw, it.from, it.to)
+	it.record.repeat = raw & repeatMask
+	it.record.priority = recordPriority(raw)
+	switch {
+	case isTombstone(raw):
+		if len(data) != tombstoneLength {
+			it.err = errors.Wrap(ErrorSourceCorrupted{Offset: it.blocks.cur}, "invalid tombstone length").
+				Int("tombstone-length", len(data))
+			return false
+		}
+
+		it.record.skip = true
+	default:
+		if err := types.SessionDecode(&it.record.session, data); err != nil {
+			it.err = errors.Wrap(ErrorSourceCorrupted{Offset: it.blocks.cur}, "decode session").
+				Str("decode-error", err.Error())
+			return false
+		}
+
+		it.record.skip = it.cancelled.cancels(it.record.session.ID, it.record.repeat)
+	}
+
+	it.record.len = head + uint64(len(it.block)-len(rest))
+	it.block = rest
+	return true
+}
+
+// convertRepeat перевод времени повтора сырой записи из разрешения from
+// в разрешение to с сохранением приоритета и признака надгробия. Нулевые
+// разрешения означают отсутствие пересчёта.
+func convertRepeat(raw uint64, from, to types.RepeatResolution) uint64 {
+	if from == 0 || to == 0 || from == to {
+		return raw
+	}
+
+	return types.RepeatConvert(raw&repeatMask, from, to) | raw&^repeatMask
+}
+
+// fileRepeat наименьшее время повтора в разрешении файла from, которое
+// при переводе в разрешение to даст время не меньше repeat.
+func fileRepeat(repeat uint64, from, to types.RepeatResolution) uint64 {
+	switch {
+	case from == 0 || to == 0 || from == to:
+		return repeat
+	case from > to:
+		return types.RepeatConvert(repeat, to, from)
+	case repeat == 0:
+		return 0
+	default:
+		// Время файла округляется вверх при переводе в более грубое разрешение.
+		return (repeat-1)*uint64(to/from) + 1
+	}
+}
+
 // Ошибка отсюда не требует аннотации.
 func (it *Iterator) required(n int) error {
 	l := len(it.rest)
@@ -3,9 +3,11 @@ package sourceio
 import (
 	"encoding/binary"
 	"io"
+	"sort"
 
 	"github.com/sirkon/mpy6a/internal/errors"
 	"github.com/sirkon/mpy6a/internal/mpio"
+	"github.com/sirkon/mpy6a/internal/types"
 )
 
 // MergeSources сливает два источника в данный приёмник.
@@ -15,62 +17,55 @@ import (
 // Источники при слиянии должны сохранять порядок старшинства,
 // т.е. источник созданный раннее должен быть в переменной a,
 // а не наоборот.
+//
+// Время повтора из файлов источников переводится в разрешение приёмника.
+//
+// Надгробия из b удаляются вместе с отменёнными ими сессиями из a,
+// остальные надгробия переносятся в приёмник как есть: они относятся
+// к сессиям из ещё более старых источников.
 func MergeSources(
 	dst *Writer,
 	a mpio.DataReader,
 	b mpio.DataReader,
 ) error {
-	aIt := rawIterator{src: a}
-	bIt := rawIterator{src: b}
+	aIt := rawIterator{src: a, to: dst.res}
+	bIt := rawIterator{src: b, to: dst.res}
 
-	var aRepeat uint64
-	var bRepeat uint64
-	if aIt.Next() {
-		aRepeat, _ = aIt.Repeat()
+	aOK := aIt.Next()
+	if err := aIt.Err(); err != nil {
+		return errors.Wrap(err, "read a")
 	}
-	if bIt.Next() {
-		bRepeat, _ = bIt.Repeat()
+	bOK := bIt.Next()
+	if err := bIt.Err(); err != nil {
+		return errors.Wrap(err, "read b")
 	}
 
-	for aRepeat > 0 && bRepeat > 0 {
-		if aRepeat <= bRepeat {
-			aDone, err := saveContentUntil(dst, &aIt, bRepeat+1)
-			if err != nil {
-				return errors.Wrap(err, "save content from a before events from b").
-					Uint64("b-repeat", bRepeat+1)
-			}
-
-			if aDone {
-				aRepeat = 0
-			} else {
-				aRepeat, _ = aIt.Repeat()
+	var aGroup recordGroup
+	var bGroup recordGroup
+	for aOK || bOK {
+		var repeat uint64
+		switch {
+		case !bOK:
+			repeat = aIt.repeat()
+		case !aOK:
+			repeat = bIt.repeat()
+		default:
+			repeat = aIt.repeat()
+			if r := bIt.repeat(); r < repeat {
+				repeat = r
 			}
 		}
 
-		// Содержимое b у нас ещё не исчерпалось по-определению, при этом все
-		// события могущие предшествовать им уже были вычитаны из a. Поэтому
-		// мы просто копируем подходящее содержимое из b в приёмник до первого
-		// элемента в a. Ну, или до конца, если был вычитан, т.е. aRepeat = 0.
-		bDone, err := saveContentUntil(dst, &bIt, aRepeat)
-		if err != nil {
-			return errors.Wrap(err, "save content from b before events from a").
-				Uint64("a-repeat", aRepeat)
+		var err error
+		if aOK, err = aGroup.read(&aIt, repeat, aOK); err != nil {
+			return errors.Wrap(err, "read a").Uint64("repeat-time", repeat)
 		}
-
-		if bDone {
-			bRepeat = 0
-		} else {
-			bRepeat, _ = bIt.Repeat()
+		if bOK, err = bGroup.read(&bIt, repeat, bOK); err != nil {
+			return errors.Wrap(err, "read b").Uint64("repeat-time", repeat)
 		}
-	}
 
-	if aRepeat > 0 {
-		if _, err := saveContentUntil(dst, &aIt, 0); err != nil {
-			return errors.Wrap(err, "save content from a after b was drained")
-		}
-	} else if bRepeat > 0 {
-		if _, err := saveContentUntil(dst, &bIt, 0); err != nil {
-			return errors.Wrap(err, "save content from b after a was drained")
+		if err := saveGroups(dst, &aGroup, &bGroup); err != nil {
+			return errors.Wrap(err, "save sessions").Uint64("repeat-time", repeat)
 		}
 	}
 
@@ -81,46 +76,124 @@ func MergeSources(
 	return nil
 }
 
-// saveContentUntil вычитка и сохранение содержимого итератора у которого
-// была сделана предварительная вычитка. Копируется содержимое вплоть
-// до сохранённого события на время until, не включая его.
-//
-// Предварительность вычитки означает, что на итераторе уже был вызван Next
-// и необходимо вначале сохранить первое вычитанное значение и затем уже
-// переходить к последующим чтениям.
-func saveContentUntil(dst *Writer, src *rawIterator, until uint64) (done bool, err error) {
-	repeat, data := src.Repeat()
-	if err := dst.SaveRawSession(repeat, data); err != nil {
-		return false, errors.Wrap(err, "save head session").
-			Pfx("session").
-			Uint64("repeat-time", repeat).
-			Int("raw-len", len(data))
-	}
-
-	for src.Next() {
-		repeat, data = src.Repeat()
-		if until > 0 && repeat >= until {
-			return false, nil
+// recordGroup записи источника с одним и тем же временем повтора.
+type recordGroup struct {
+	records []groupRecord
+	buf     []byte
+	tombs   int // Количество надгробий среди записей.
+}
+
+type groupRecord struct {
+	repeat uint64
+	start  int
+	end    int
+}
+
+// read вычитка группы записей с временем повтора repeat из итератора
+// у которого уже была сделана предварительная вычитка, ok означает её
+// успешность. Возвращает наличие записей после группы.
+func (g *recordGroup) read(it *rawIterator, repeat uint64, ok bool) (bool, error) {
+	g.records = g.records[:0]
+	g.buf = g.buf[:0]
+	g.tombs = 0
+
+	for ok && it.repeat() == repeat {
+		raw, data := it.Repeat()
+		if isTombstone(raw) {
+			if len(data) != tombstoneLength {
+				return false, errors.New("invalid tombstone data").Int("tombstone-length", len(data))
+			}
+			g.tombs++
 		}
-		if err := dst.SaveRawSession(repeat, data); err != nil {
-			return false, errors.Wrap(err, "save session").
-				Pfx("session").
-				Uint64("repeat-time", repeat).
-				Int("raw-len", len(data))
+
+		start := len(g.buf)
+		g.buf = append(g.buf, data...)
+		g.records = append(g.records, groupRecord{
+			repeat: raw,
+			start:  start,
+			end:    len(g.buf),
+		})
+
+		ok = it.Next()
+	}
+	if err := it.Err(); err != nil {
+		return false, errors.Wrap(err, "iterate over source")
+	}
+
+	return ok, nil
+}
+
+// saveGroups сохранение групп записей с одинаковым временем повтора по
+// убыванию приоритета, при равном приоритете вначале из более старого
+// источника a, затем из b.
+func saveGroups(dst *Writer, a, b *recordGroup) error {
+	var cancelled map[types.Index]bool
+	if b.tombs > 0 {
+		cancelled = make(map[types.Index]bool, b.tombs)
+		for _, r := range b.records {
+			if isTombstone(r.repeat) {
+				sid, _ := recordSessionID(b.buf[r.start:r.end])
+				cancelled[sid] = false
+			}
 		}
 	}
 
-	if err := src.Err(); err != nil {
-		return false, errors.Wrap(err, "iterate over source")
+	records := make([]mergeRecord, 0, len(a.records)+len(b.records))
+	for _, r := range a.records {
+		data := a.buf[r.start:r.end]
+		if !isTombstone(r.repeat) && cancelled != nil {
+			sid, _ := recordSessionID(data)
+			if _, ok := cancelled[sid]; ok {
+				cancelled[sid] = true
+				continue
+			}
+		}
+
+		records = append(records, mergeRecord{repeat: r.repeat, data: data})
 	}
 
-	return true, nil
+	for _, r := range b.records {
+		data := b.buf[r.start:r.end]
+		if isTombstone(r.repeat) {
+			if sid, _ := recordSessionID(data); cancelled[sid] {
+				continue
+			}
+		}
+
+		records = append(records, mergeRecord{repeat: r.repeat, data: data})
+	}
+
+	// Устойчивая сортировка сохраняет порядок сохранения внутри приоритета.
+	sort.SliceStable(records, func(i, j int) bool {
+		return recordPriority(records[i].repeat) > recordPriority(records[j].repeat)
+	})
+
+	for _, r := range records {
+		if err := dst.SaveRawSession(r.repeat, r.data); err != nil {
+			return errors.Wrap(err, "save session").Int("raw-len", len(r.data))
+		}
+	}
+
+	return nil
+}
+
+// mergeRecord запись одной из сливаемых групп.
+type mergeRecord struct {
+	repeat uint64
+	data   []byte
 }
 
 type rawIterator struct {
 	src  mpio.DataReader
 	err  error
 	item repeatIteratorItem
+
+	started bool
+	framed  bool // Источник является файлом источника с блоками.
+	from    types.RepeatResolution
+	to      types.RepeatResolution // Разрешение времени повтора отдаваемых записей.
+	blocks  blockReader
+	block   []byte
 }
 
 // Next смотрит, есть ли следующий элемент и вычитывает, если есть.
@@ -129,12 +202,27 @@ func (it *rawIterator) Next() bool {
 		return false
 	}
 
-	var buf [8]byte
-	if _, err := io.ReadFull(it.src, buf[:]); err != nil {
+	if it.framed {
+		return it.nextFramed()
+	}
+
+	var buf [sourceHeaderMaxSize]byte
+	if _, err := io.ReadFull(it.src, buf[:8]); err != nil {
 		it.err = errors.Wrap(err, "read repeat time")
 		return false
 	}
+	if !it.started {
+		it.started = true
+		if isSourceHeader(buf[:]) {
+			return it.startFramed(buf[:])
+		}
+	}
 	it.item.repeat = binary.LittleEndian.Uint64(buf[:])
+	if it.item.repeat == 0 {
+		// Признак конца сессий, дальше идёт подвал.
+		it.err = io.EOF
+		return false
+	}
 
 	length, err := binary.ReadUvarint(it.src)
 	if err != nil {
@@ -144,6 +232,12 @@ func (it *rawIterator) Next() bool {
 		it.err = errors.Wrap(err, "read session data length")
 		return false
 	}
+	if length > blockSizeHardLimit {
+		it.err = errors.New("session length is out of limit").
+			Uint64("invalid-session-length", length).
+			Int("session-length-limit", blockSizeHardLimit)
+		return false
+	}
 
 	if length > uint64(cap(it.item.data)) {
 		it.item.data = make([]byte, length)
@@ -162,6 +256,65 @@ func (it *rawIterator) Next() bool {
 	return true
 }
 
+// startFramed переход к чтению файла источника, первые 8 байт
+// заголовка уже вычитаны в header.
+func (it *rawIterator) startFramed(header []byte) bool {
+	if _, err := io.ReadFull(it.src, header[8:sourceHeaderSize]); err != nil {
+		it.err = errors.Wrap(ErrorSourceCorrupted{Offset: 8}, "read source header").
+			Str("read-error", err.Error())
+		return false
+	}
+	if binary.LittleEndian.Uint32(header[8:]) != sourceVersionSeconds {
+		if _, err := io.ReadFull(it.src, header[sourceHeaderSize:]); err != nil {
+			it.err = errors.Wrap(ErrorSourceCorrupted{Offset: sourceHeaderSize}, "read source header").
+				Str("read-error", err.Error())
+			return false
+		}
+	}
+
+	h, err := decodeSourceHeader(header)
+	if err != nil {
+		it.err = errors.Wrap(err, "decode source header")
+		return false
+	}
+
+	it.framed = true
+	it.from = h.resolution
+	it.blocks = blockReader{
+		src:    it.src,
+		offset: h.size(),
+	}
+	return it.nextFramed()
+}
+
+// nextFramed вычитка следующей записи файла источника.
+func (it *rawIterator) nextFramed() bool {
+	if len(it.block) == 0 {
+		block, err := it.blocks.next()
+		if err != nil {
+			it.err = errors.Wrap(err, "read block")
+			return false
+		}
+		it.block = block
+	}
+
+	repeat, data, rest, err := splitRecord(it.block, it.blocks.cur)
+	if err != nil {
+		it.err = errors.Wrap(err, "split session record")
+		return false
+	}
+
+	it.item.repeat = convertRepeat(repeat, it.from, it.to)
+	it.item.data = data
+	it.block = rest
+	return true
+}
+
+// repeat время повтора вычитанной записи без приоритета и признака надгробия.
+func (it *rawIterator) repeat() uint64 {
+	return it.item.repeat & repeatMask
+}
+
 // Repeat выдача вычитанных данных.
 func (it *rawIterator) Repeat() (repeat uint64, data []byte) {
 	return it.item.repeat, it.item.data
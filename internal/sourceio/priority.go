@@ -0,0 +1,45 @@
+package sourceio
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Приоритет сессии хранится в битах 55–62 поля времени повтора записи,
+// ниже признака надгробия. У записей без приоритета эти биты нулевые,
+// поэтому записи более старых версий читаются как сессии с приоритетом
+// types.PriorityNormal. Внутри одного времени повтора записи идут по
+// убыванию приоритета, при равном приоритете – в порядке сохранения.
+
+const (
+	// priorityShift сдвиг приоритета в поле времени повтора записи.
+	priorityShift = 55
+
+	// repeatMask маска собственно времени повтора в поле записи.
+	repeatMask = uint64(1)<<priorityShift - 1
+)
+
+// RecordRepeat разбор поля времени повтора сырой записи: время
+// повтора, приоритет и признак надгробия.
+func RecordRepeat(raw uint64) (repeat uint64, prio types.Priority, tombstone bool) {
+	return raw & repeatMask, recordPriority(raw), isTombstone(raw)
+}
+
+// recordPriority приоритет записи с данным полем времени повтора.
+func recordPriority(raw uint64) types.Priority {
+	return types.Priority((raw &^ tombstoneFlag) >> priorityShift)
+}
+
+// withPriority поле времени повтора записи сессии с приоритетом prio.
+func withPriority(repeat uint64, prio types.Priority) uint64 {
+	return repeat | uint64(prio)<<priorityShift
+}
+
+// checkRepeat проверка допустимости времени повтора.
+func checkRepeat(repeat uint64) error {
+	if repeat == 0 || repeat&^repeatMask != 0 {
+		return errors.New("invalid repeat time").Uint64("invalid-repeat-time", repeat)
+	}
+
+	return nil
+}
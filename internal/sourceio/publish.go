@@ -0,0 +1,134 @@
+package sourceio
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// WriteFile запись файла источника name через временный файл tmp. Сессии
+// пишутся функцией write, после чего файл атомарно публикуется под
+// именем name, см. datadir.PendingFile. При ошибке временный файл удаляется.
+// Возвращает подвал записанного файла.
+func WriteFile(
+	tmp string,
+	name string,
+	block int,
+	res types.RepeatResolution,
+	write func(w *Writer) error,
+) (*Footer, error) {
+	return WriteFileFS(fsio.OS{}, tmp, name, block, res, write)
+}
+
+// WriteFileFS запись файла источника name в файловой системе fsys,
+// см. WriteFile.
+func WriteFileFS(
+	fsys fsio.FS,
+	tmp string,
+	name string,
+	block int,
+	res types.RepeatResolution,
+	write func(w *Writer) error,
+) (*Footer, error) {
+	file, err := datadir.CreatePendingFS(fsys, tmp)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary file")
+	}
+
+	footer, err := writeFile(file, block, res, write)
+	if err != nil {
+		if dErr := file.Discard(); dErr != nil {
+			return nil, errors.Wrap(err, "write source").Str("discard-error", dErr.Error())
+		}
+
+		return nil, errors.Wrap(err, "write source")
+	}
+
+	if err := file.Publish(name); err != nil {
+		return nil, errors.Wrap(err, "publish source").Str("source-name", name)
+	}
+
+	return footer, nil
+}
+
+func writeFile(
+	dst io.Writer,
+	block int,
+	res types.RepeatResolution,
+	write func(w *Writer) error,
+) (*Footer, error) {
+	buf := bufio.NewWriter(dst)
+	w, err := NewFileWriter(buf, block, res)
+	if err != nil {
+		return nil, errors.Wrap(err, "create writer")
+	}
+
+	if err := write(w); err != nil {
+		return nil, errors.Wrap(err, "write sessions")
+	}
+
+	if err := w.Finish(); err != nil {
+		return nil, errors.Wrap(err, "finish source")
+	}
+
+	if err := buf.Flush(); err != nil {
+		return nil, errors.Wrap(err, "flush buffered data")
+	}
+
+	return w.Footer(), nil
+}
+
+// MergeFiles слияние файлов источников a и b в новый файл name
+// через временный файл tmp, аналогично MergeSources и WriteFile.
+// Время повтора в новом файле хранится в разрешении res.
+func MergeFiles(tmp, name, a, b string, block int, res types.RepeatResolution) (*Footer, error) {
+	return MergeFilesFS(fsio.OS{}, tmp, name, a, b, block, res)
+}
+
+// MergeFilesFS слияние файлов источников a и b файловой системы fsys,
+// см. MergeFiles. Повреждённые исходные файлы помещаются в карантин,
+// см. QuarantineCorruptedFS.
+func MergeFilesFS(fsys fsio.FS, tmp, name, a, b string, block int, res types.RepeatResolution) (*Footer, error) {
+	// Файлы открываются только на чтение, ошибки их закрытия не важны.
+	aFile, err := OpenFS(fsys, a)
+	if err != nil {
+		return nil, errors.Wrap(QuarantineCorruptedFS(fsys, a, err), "open a").Str("source-name", a)
+	}
+	defer func() {
+		_ = aFile.Close()
+	}()
+
+	bFile, err := OpenFS(fsys, b)
+	if err != nil {
+		return nil, errors.Wrap(QuarantineCorruptedFS(fsys, b, err), "open b").Str("source-name", b)
+	}
+	defer func() {
+		_ = bFile.Close()
+	}()
+
+	footer, err := WriteFileFS(fsys, tmp, name, block, res, func(w *Writer) error {
+		return MergeSources(w, aFile.reader(), bFile.reader())
+	})
+	if err != nil {
+		// Ошибка чтения не говорит, какой из файлов повреждён, поэтому
+		// оба проверяются заново.
+		var corrupted ErrorSourceCorrupted
+		if !errors.As(err, &corrupted) {
+			return nil, err
+		}
+		if aFile.Verify() != nil {
+			err = QuarantineCorruptedFS(fsys, a, err)
+		}
+		if bFile.Verify() != nil {
+			err = QuarantineCorruptedFS(fsys, b, err)
+		}
+
+		return nil, err
+	}
+
+	return footer, nil
+}
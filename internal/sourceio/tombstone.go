@@ -0,0 +1,116 @@
+package sourceio
+
+import (
+	"encoding/binary"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Отмена сохранённой сессии, уже сброшенной в источник, записывается
+// в более новый источник как надгробие: запись с тем же временем повтора
+// у которой выставлен старший бит, а вместо данных сессии лежит её
+// идентификатор. Надгробие всегда относится к сессии из более старого
+// источника, MergeSources удаляет такие пары, а итераторы пропускают
+// надгробия и отменённые ими сессии, см. Tombstones.
+
+const (
+	// tombstoneFlag признак надгробия в поле времени повтора записи.
+	tombstoneFlag = uint64(1) << 63
+
+	// tombstoneLength длина данных надгробия.
+	tombstoneLength = 16
+)
+
+// Tombstone надгробие: идентификатор отменённой сессии и время её
+// повтора. Время нужно, т.к. перенесённая сессия остаётся в старом
+// источнике под прежним временем.
+type Tombstone struct {
+	ID     types.Index
+	Repeat uint64
+}
+
+// Tombstones отменённые сессии ещё не удалённые из источников.
+type Tombstones map[Tombstone]struct{}
+
+// Has проверка отмены сессии sid с повтором в repeat.
+func (t Tombstones) Has(sid types.Index, repeat uint64) bool {
+	_, ok := t[Tombstone{ID: sid, Repeat: repeat}]
+	return ok
+}
+
+// cancels проверка отмены сессии sid с повтором в repeat. Отменённая
+// сессия может встретиться лишь единожды, поэтому надгробие удаляется.
+func (t Tombstones) cancels(sid types.Index, repeat uint64) bool {
+	if !t.Has(sid, repeat) {
+		return false
+	}
+
+	delete(t, Tombstone{ID: sid, Repeat: repeat})
+	return true
+}
+
+// isTombstone проверка, является ли запись с данным полем времени
+// повтора надгробием.
+func isTombstone(repeat uint64) bool {
+	return repeat&tombstoneFlag != 0
+}
+
+// recordSessionID идентификатор сессии из данных записи, это может
+// быть как закодированная сессия, так и надгробие.
+func recordSessionID(data []byte) (types.Index, bool) {
+	var sid types.Index
+	if len(data) < tombstoneLength {
+		return sid, false
+	}
+
+	ok := types.IndexDecodeCheck(&sid, data)
+	return sid, ok
+}
+
+// SaveTombstone сохранение надгробия сессии sid с повтором в repeat.
+func (w *Writer) SaveTombstone(repeat uint64, sid types.Index) error {
+	if err := checkRepeat(repeat); err != nil {
+		return errors.Wrap(err, "check tombstone").SessionID(sid)
+	}
+
+	const ll = 8 + 1 + tombstoneLength
+	if cap(w.buf)-len(w.buf) < ll {
+		if err := w.flush(); err != nil {
+			return errors.Wrap(err, "flush buffer")
+		}
+	}
+
+	if cap(w.buf) < ll {
+		w.buf = make([]byte, 0, ll)
+	}
+
+	w.accountTombstone(repeat)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, repeat|tombstoneFlag)
+	w.buf = binary.AppendUvarint(w.buf, tombstoneLength)
+	w.buf = types.IndexEncodeAppend(w.buf, sid)
+
+	return nil
+}
+
+// Tombstones добавление в dst всех надгробий файла.
+func (f *File) Tombstones(dst Tombstones) error {
+	it := f.rawIterator()
+	for it.Next() {
+		raw, data := it.Repeat()
+		if !isTombstone(raw) {
+			continue
+		}
+
+		sid, ok := recordSessionID(data)
+		if !ok || len(data) != tombstoneLength {
+			return errors.New("invalid tombstone data").Int("tombstone-length", len(data))
+		}
+		dst[Tombstone{ID: sid, Repeat: it.repeat()}] = struct{}{}
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "iterate over source")
+	}
+
+	return nil
+}
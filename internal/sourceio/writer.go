@@ -2,6 +2,7 @@ package sourceio
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 
 	"github.com/sirkon/mpy6a/internal/errors"
@@ -9,22 +10,64 @@ import (
 	"github.com/sirkon/varsize"
 )
 
-// NewWriter конструктор писалки
+// NewWriter конструктор писалки простого потока сессий без заголовка
+// и контрольных сумм, такой поток используется в слепках.
 func NewWriter(dst io.Writer, size int) *Writer {
 	return &Writer{
-		dst: dst,
-		buf: make([]byte, 0, size),
+		dst:   dst,
+		buf:   make([]byte, 0, size),
+		block: defaultBlockSize,
 	}
 }
 
+// NewFileWriter конструктор писалки файла источника. Сессии сохраняются
+// блоками размером около block байт с контрольной суммой у каждого, время
+// повтора задаётся в разрешении res. Запись должна завершаться вызовом Finish.
+func NewFileWriter(dst io.Writer, block int, res types.RepeatResolution) (*Writer, error) {
+	if block <= 0 || block > blockSizeHardLimit {
+		return nil, errors.New("invalid block size").
+			Int("invalid-block-size", block).
+			Int("block-size-limit", blockSizeHardLimit)
+	}
+	if err := res.Check(); err != nil {
+		return nil, errors.Wrap(err, "check repeat resolution")
+	}
+
+	header := appendSourceHeader(nil, block, res)
+	if _, err := dst.Write(header); err != nil {
+		return nil, errors.Wrap(err, "write source header")
+	}
+
+	return &Writer{
+		dst:     dst,
+		buf:     make([]byte, 0, block),
+		written: uint64(len(header)),
+		block:   uint64(block),
+		framed:  true,
+		res:     res,
+	}, nil
+}
+
 // Writer запись данных сохранённых сессий.
 type Writer struct {
 	dst io.Writer
 	buf []byte
+
+	written uint64 // Количество сброшенных в dst байт.
+	block   uint64
+	framed  bool // Данные пишутся блоками с контрольными суммами.
+	res     types.RepeatResolution
+	footer  Footer
+	hashes  []uint64 // Хеши идентификаторов сессий для фильтра подвала.
 }
 
-// Write для реализации io.Writer.
+// Write для реализации io.Writer. Недоступен при записи файла источника,
+// т.к. произвольные данные нарушат разбивку на блоки.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.framed {
+		return 0, errors.New("raw writes are not allowed into a source file")
+	}
+
 	if cap(w.buf)-len(w.buf) < len(p) {
 		if err := w.flush(); err != nil {
 			return 0, errors.Wrap(err, "dump previously collected session")
@@ -33,6 +76,7 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 
 	if len(p) > cap(w.buf) {
 		write, err := w.dst.Write(p)
+		w.written += uint64(write)
 		if err != nil {
 			return write, errors.Wrap(err, "write session straight")
 		}
@@ -45,9 +89,22 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 }
 
 // SaveRawSession сохранение закодированных данных сессии с заданным
-// временем повтора.
+// полем времени повтора, включающим приоритет. Надгробия, вычитанные
+// как сырые записи, тоже сохраняются с его помощью.
 func (w *Writer) SaveRawSession(repeat uint64, data []byte) error {
+	if isTombstone(repeat) {
+		sid, ok := recordSessionID(data)
+		if !ok || len(data) != tombstoneLength {
+			return errors.New("invalid tombstone data").Int("tombstone-length", len(data))
+		}
+
+		return w.SaveTombstone(repeat&repeatMask, sid)
+	}
+
 	ll := 8 + varsize.Len(data) + len(data)
+	if err := w.checkRecord(ll); err != nil {
+		return errors.Wrap(err, "check session").Uint64("repeat-time", repeat)
+	}
 	if cap(w.buf)-len(w.buf) < ll {
 		if err := w.flush(); err != nil {
 			return errors.Wrap(err, "flush buffer")
@@ -58,6 +115,12 @@ func (w *Writer) SaveRawSession(repeat uint64, data []byte) error {
 		w.buf = make([]byte, 0, ll)
 	}
 
+	sid, ok := recordSessionID(data)
+	if !ok {
+		return errors.New("invalid session data").Int("session-length", len(data))
+	}
+
+	w.account(repeat&repeatMask, sid)
 	w.buf = binary.LittleEndian.AppendUint64(w.buf, repeat)
 	w.buf = binary.AppendUvarint(w.buf, uint64(len(data)))
 	w.buf = append(w.buf, data...)
@@ -67,8 +130,22 @@ func (w *Writer) SaveRawSession(repeat uint64, data []byte) error {
 
 // SaveSession сохранение сессии с заданным временем повтора.
 func (w *Writer) SaveSession(repeat uint64, sess *types.Session) error {
+	return w.SaveSessionPriority(repeat, types.PriorityNormal, sess)
+}
+
+// SaveSessionPriority сохранение сессии с заданным временем повтора и
+// приоритетом. Сессии с одним временем повтора должны сохраняться по
+// убыванию приоритета.
+func (w *Writer) SaveSessionPriority(repeat uint64, prio types.Priority, sess *types.Session) error {
+	if err := checkRepeat(repeat); err != nil {
+		return errors.Wrap(err, "check session").SessionID(sess.ID)
+	}
+
 	l := types.SessionRawLen(sess)
 	ll := 8 + varsize.Uint(uint64(l)) + l
+	if err := w.checkRecord(ll); err != nil {
+		return errors.Wrap(err, "check session").SessionID(sess.ID)
+	}
 	if cap(w.buf)-len(w.buf) < ll {
 		// Остатка буфера не хватает для вмещения записи целиком.
 		// Далее есть два варианта:
@@ -85,7 +162,8 @@ func (w *Writer) SaveSession(repeat uint64, sess *types.Session) error {
 		}
 	}
 
-	w.buf = binary.LittleEndian.AppendUint64(w.buf, repeat)
+	w.account(repeat, sess.ID)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, withPriority(repeat, prio))
 	w.buf = binary.AppendUvarint(w.buf, uint64(l))
 	w.buf = types.SessionEncode(w.buf, sess)
 
@@ -101,15 +179,145 @@ func (w *Writer) Flush() error {
 	return nil
 }
 
+// Finish завершение записи источника: записывается признак конца
+// сессий и подвал, после чего данные сбрасываются.
+func (w *Writer) Finish() error {
+	if w.framed {
+		return w.finishFile()
+	}
+
+	// Нулевое время повтора означает конец сессий.
+	var marker [8]byte
+	if _, err := w.Write(marker[:]); err != nil {
+		return errors.Wrap(err, "write sessions end marker")
+	}
+
+	off := w.offset()
+	w.footer.buildHistogram()
+	w.footer.buildFilter(w.hashes)
+	data := w.footer.encode(nil)
+	data = binary.LittleEndian.AppendUint64(data, off)
+	data = binary.LittleEndian.AppendUint64(data, footerMagic)
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "write footer")
+	}
+
+	if err := w.flush(); err != nil {
+		return errors.Wrap(err, "dump collected data")
+	}
+
+	return nil
+}
+
+// Footer возвращает подвал записанных к данному моменту сессий.
+// Гистограмма и фильтр сессий строятся только при вызове Finish.
+func (w *Writer) Footer() *Footer {
+	return &w.footer
+}
+
 func (w *Writer) flush() error {
 	if len(w.buf) == 0 {
 		return nil
 	}
 
-	if _, err := w.dst.Write(w.buf); err != nil {
+	if w.framed {
+		return w.flushBlock()
+	}
+
+	n, err := w.dst.Write(w.buf)
+	w.written += uint64(n)
+	if err != nil {
 		return err
 	}
 
 	w.buf = w.buf[:0]
 	return nil
 }
+
+// offset смещение следующей записи от начала источника.
+func (w *Writer) offset() uint64 {
+	return w.written + uint64(len(w.buf))
+}
+
+// account учёт сессии sid в подвале. Блоки подвала файла источника
+// совпадают с блоками самого файла.
+func (w *Writer) account(repeat uint64, sid types.Index) {
+	off, newBlock := w.nextRecord()
+	w.footer.account(repeat, off, newBlock)
+	w.hashes = append(w.hashes, sessionHash(sid))
+}
+
+// accountTombstone учёт надгробия в подвале: оно учитывается в блоках,
+// но не в числе сессий.
+func (w *Writer) accountTombstone(repeat uint64) {
+	off, newBlock := w.nextRecord()
+	w.footer.accountTombstone(repeat, off, newBlock)
+}
+
+// nextRecord смещение блока следующей записи и признак начала ею нового блока.
+func (w *Writer) nextRecord() (off uint64, newBlock bool) {
+	if w.framed {
+		return w.written, len(w.buf) == 0
+	}
+
+	off = w.offset()
+	blocks := w.footer.Blocks
+	return off, len(blocks) == 0 || off-blocks[len(blocks)-1].Offset >= w.block
+}
+
+// checkRecord проверка, что запись данной длины может быть сохранена.
+func (w *Writer) checkRecord(ll int) error {
+	if w.framed && ll > blockSizeHardLimit {
+		return errors.New("session record is too large for a source file").
+			Int("record-length", ll).
+			Int("record-length-limit", blockSizeHardLimit)
+	}
+
+	return nil
+}
+
+// flushBlock запись собранных данных отдельным блоком.
+func (w *Writer) flushBlock() error {
+	var head [blockHeaderSize]byte
+	binary.LittleEndian.PutUint32(head[:4], uint32(len(w.buf)))
+	binary.LittleEndian.PutUint32(head[4:], crc32.Checksum(w.buf, crcTable))
+	n, err := w.dst.Write(head[:])
+	w.written += uint64(n)
+	if err != nil {
+		return errors.Wrap(err, "write block header")
+	}
+
+	n, err = w.dst.Write(w.buf)
+	w.written += uint64(n)
+	if err != nil {
+		return errors.Wrap(err, "write block data")
+	}
+
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// finishFile завершение записи файла источника: блок нулевой длины,
+// подвал с контрольной суммой и концевик.
+func (w *Writer) finishFile() error {
+	if err := w.flush(); err != nil {
+		return errors.Wrap(err, "flush the last block")
+	}
+
+	off := w.written + blockHeaderSize
+	w.footer.buildHistogram()
+	w.footer.buildFilter(w.hashes)
+	data := make([]byte, blockHeaderSize, 256)
+	data = w.footer.encode(data)
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data[blockHeaderSize:], crcTable))
+	data = binary.LittleEndian.AppendUint64(data, off)
+	data = binary.LittleEndian.AppendUint64(data, footerMagic)
+
+	n, err := w.dst.Write(data)
+	w.written += uint64(n)
+	if err != nil {
+		return errors.Wrap(err, "write footer")
+	}
+
+	return nil
+}
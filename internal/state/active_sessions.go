@@ -3,6 +3,7 @@ package state
 import (
 	"encoding/binary"
 	"io"
+	"sort"
 
 	"github.com/sirkon/mpy6a/internal/errors"
 	"github.com/sirkon/mpy6a/internal/mpio"
@@ -12,6 +13,19 @@ import (
 
 type activeSessions map[types.Index]*types.Session
 
+// ActiveSessions идентификаторы активных сессий по возрастанию.
+func (s *State) ActiveSessions() []types.Index {
+	res := make([]types.Index, 0, len(s.active))
+	for sid := range s.active {
+		res = append(res, sid)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return types.IndexLess(res[i], res[j])
+	})
+
+	return res
+}
+
 // Encode кодирование активных сессий.
 func (a activeSessions) Encode(dst mpio.DataWriter) error {
 	if _, err := uvarints.Write(dst, uint64(len(a))); err != nil {
@@ -0,0 +1,209 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// ID идентификатор состояния: индекс последнего применённого события.
+func (s *State) ID() types.Index {
+	return s.id
+}
+
+// Applier применение событий лога операций к состоянию, см. logop.
+type Applier struct {
+	s     *State
+	dir   string
+	descs *Descriptors
+
+	defaultRepeat func(sess *types.Session) uint64
+
+	// consumed сессии изъятые из сохранённых применёнными операциями:
+	// выданные на повтор и отменённые. Заполняется только при
+	// восстановлении, см. Repair.
+	consumed map[types.Index]struct{}
+}
+
+// NewApplier конструктор Applier для состояния s с файлами из
+// описаний descs лежащими в директории dir. Функция defaultRepeat
+// задаёт время повтора сохраняемой сессии, когда оно не указано в
+// операции, nil означает немедленный повтор.
+func NewApplier(
+	s *State,
+	dir string,
+	descs *Descriptors,
+	defaultRepeat func(sess *types.Session) uint64,
+) *Applier {
+	return &Applier{
+		s:             s,
+		dir:           dir,
+		descs:         descs,
+		defaultRepeat: defaultRepeat,
+	}
+}
+
+// Apply применение операции op события id. События должны
+// применяться строго по возрастанию индексов.
+//
+// Применение операции зависит только от состояния, поэтому одинаково на
+// всех узлах. Операция недопустимая для состояния отклоняется, см.
+// Rejected: состояние не меняется, но событие считается применённым.
+// Прочие ошибки это локальные сбои узла, например ошибки чтения файлов
+// источников или отсутствие размещённой загрузки. Событие при этом не
+// применяется, а состояние узла следует восстановить заново, см. Open.
+func (a *Applier) Apply(id types.Index, op []byte) error {
+	if !types.IndexLess(a.s.id, id) {
+		return errors.New("event is not after the state").
+			Stg("state-id", a.s.id).
+			Stg("event-id", id)
+	}
+
+	if err := logop.RecorderDispatch(&opApplier{a: a, id: id}, op); err != nil {
+		if Rejected(err) {
+			a.s.id = id
+		}
+
+		return errors.Wrap(err, "apply operation").Stg("event-id", id)
+	}
+
+	a.s.id = id
+	return nil
+}
+
+// Rejected проверка, что ошибка применения события err это отклонение
+// операции, а не локальный сбой, см. Applier.Apply.
+func Rejected(err error) bool {
+	switch staterr.AsCode(err) {
+	case staterr.CodeSessionLengthOverflow,
+		staterr.CodeSessionRepeatLimitReached,
+		staterr.CodeSessionInvalidRequest,
+		staterr.CodeSessionIDCollision:
+		return true
+	default:
+		return false
+	}
+}
+
+// opApplier применение операции одного события.
+type opApplier struct {
+	a  *Applier
+	id types.Index
+}
+
+func (o *opApplier) New(theme uint32) error {
+	s := o.a.s
+	if _, ok := s.active[o.id]; ok {
+		return staterr.NewSessionInvalidRequest("session already exists")
+	}
+
+	s.active[o.id] = &types.Session{
+		ID:       o.id,
+		ChangeID: o.id,
+		Theme:    theme,
+	}
+	return nil
+}
+
+func (o *opApplier) Record(sid types.Index, data []byte) error {
+	sess, ok := o.a.s.active[sid]
+	if !ok {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("session not found"), "look for active session").
+			SessionID(sid)
+	}
+
+	sess.Data.Append(append([]byte(nil), data...))
+	sess.ChangeID = o.id
+	return nil
+}
+
+// Restore изымает n сохранённых сессий с наиболее ранним повтором
+// независимо от текущего времени и делает их активными, см. State.Restore.
+func (o *opApplier) Restore(n uint32) error {
+	s := o.a.s
+	sessions, err := s.Restore(o.a.dir, o.a.descs, int(n))
+	if err != nil {
+		return err
+	}
+
+	o.activate(sessions)
+	return nil
+}
+
+// RestoreSessions изымает сохранённые сессии sids и делает их
+// активными, см. State.RestoreSessions.
+func (o *opApplier) RestoreSessions(sids []types.Index) error {
+	sessions, err := o.a.s.RestoreSessions(sids)
+	if err != nil {
+		return err
+	}
+
+	o.activate(sessions)
+	return nil
+}
+
+// activate перевод выданных на повтор сессий в активные.
+func (o *opApplier) activate(sessions []types.Session) {
+	for i := range sessions {
+		sess := sessions[i]
+		sess.Repeats++
+		sess.ChangeID = o.id
+		o.a.s.active[sess.ID] = &sess
+		o.a.consume(sess.ID)
+	}
+}
+
+func (o *opApplier) Delete(sid types.Index) error {
+	if _, ok := o.a.s.active[sid]; !ok {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("session not found"), "look for active session").
+			SessionID(sid)
+	}
+
+	delete(o.a.s.active, sid)
+	return nil
+}
+
+func (o *opApplier) Store(sid types.Index, repeat logop.OptionalRepeat) error {
+	return o.StorePriority(sid, repeat, uint8(types.PriorityNormal))
+}
+
+func (o *opApplier) StorePriority(sid types.Index, repeat logop.OptionalRepeat, priority uint8) error {
+	s := o.a.s
+	sess, ok := s.active[sid]
+	if !ok {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("session not found"), "look for active session").
+			SessionID(sid)
+	}
+
+	r := uint64(repeat)
+	if repeat == 0 && o.a.defaultRepeat != nil {
+		r = o.a.defaultRepeat(sess)
+	}
+
+	return s.StorePriority(sid, r, types.Priority(priority))
+}
+
+func (o *opApplier) Cancel(sid types.Index) error {
+	if err := o.a.s.Cancel(sid); err != nil {
+		return err
+	}
+
+	o.a.consume(sid)
+	return nil
+}
+
+func (o *opApplier) Reschedule(sid types.Index, repeat uint64) error {
+	return o.a.s.Reschedule(sid, repeat)
+}
+
+func (o *opApplier) Import(src types.Index, digest uint32) error {
+	return o.a.s.Import(o.a.dir, o.a.descs, src, digest)
+}
+
+// consume учёт изъятия сохранённой сессии sid, если он ведётся.
+func (a *Applier) consume(sid types.Index) {
+	if a.consumed != nil {
+		a.consumed[sid] = struct{}{}
+	}
+}
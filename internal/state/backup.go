@@ -0,0 +1,371 @@
+package state
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// BackupFile файл резервной копии из её манифеста.
+type BackupFile struct {
+	// Name имя файла в директории копии.
+	Name string
+
+	// Size размер файла.
+	Size int64
+
+	// Checksum контрольная сумма SHA-256 содержимого файла.
+	Checksum [sha256.Size]byte
+}
+
+// Backup резервная копия в процессе создания, см. StartBackup.
+type Backup struct {
+	fs     fsio.FS
+	target string
+	id     types.Index
+
+	// copies файлы, которые не удалось связать жёсткими ссылками и
+	// которые копируются в Complete.
+	copies []backupCopy
+	done   atomic.Bool
+}
+
+// backupCopy копирование файла в резервную копию.
+type backupCopy struct {
+	kind FileKind
+	id   types.Index
+	src  string
+	dst  string
+
+	// size копируемый размер начала файла, для текущего лога –
+	// до события копии включительно.
+	size uint64
+}
+
+// StartBackup начало создания резервной копии состояния s с описаниями
+// файлов descs директории dir в пустую директорию target. Индекс копии
+// совпадает с текущим индексом состояния: запуск из копии обычным путём,
+// см. Open, восстанавливает состояние ровно на этот индекс.
+//
+// Вызов делается там же, где состояние меняется, и блокирует его ненадолго:
+// в target записывается слепок и лог имён слепков, а файлы источников и
+// завершённых логов связываются жёсткими ссылками. Файлы, которые связать
+// не удалось, а также начало текущего лога по индекс копии, копируются
+// потом, в Backup.Complete, параллельно с работой узла. До окончания
+// копирования эти файлы следует придержать от удаления, см. Backup.Hold.
+//
+// Все события по индекс состояния должны быть уже сброшены в файл
+// текущего лога, см. logio.Writer.Flush. При ошибке создания копия
+// остаётся без манифеста и считается незавершённой.
+func (s *State) StartBackup(dir string, descs *Descriptors, target string, logger func(error)) (*Backup, error) {
+	fsys := descs.FS()
+	if err := fsys.MkdirAll(target, 0755); err != nil {
+		return nil, errors.Wrap(err, "create backup directory")
+	}
+	entries, err := fsys.ReadDir(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "read backup directory")
+	}
+	if len(entries) > 0 {
+		return nil, errors.New("backup directory is not empty").Int("files-count", len(entries))
+	}
+
+	b := &Backup{
+		fs:     fsys,
+		target: target,
+		id:     s.id,
+	}
+
+	copied := descs.clone()
+	if l := copied.log; l != nil {
+		name := datadir.LogName(dir, l.id)
+		head, size, err := logio.HeadFS(fsys, name, s.id)
+		if err != nil {
+			return nil, errors.Wrap(err, "look for the state event in the current log").Str("log-name", name)
+		}
+		if !types.IndexLess(s.id, l.firstID) && !types.IndexEqual(head, s.id) {
+			return nil, errors.New("state event is not flushed into the current log").
+				Str("log-name", name).
+				Stg("last-flushed-id", head)
+		}
+
+		if head.Term != 0 {
+			l.lastID = head
+		}
+		l.len = size
+	}
+
+	for _, f := range copied.Files() {
+		src, dst := datadir.SourceName(dir, f.ID), datadir.SourceName(target, f.ID)
+		if f.Kind == FileKindLog {
+			src, dst = datadir.LogName(dir, f.ID), datadir.LogName(target, f.ID)
+		}
+
+		if f.Current {
+			b.copies = append(b.copies, backupCopy{kind: f.Kind, id: f.ID, src: src, dst: dst, size: f.Len})
+			continue
+		}
+
+		// Источники и завершённые логи больше не меняются, поэтому
+		// копии достаточно ссылки на них.
+		if err := fsys.Link(src, dst); err != nil {
+			logger(errors.Wrap(err, "link file, it will be copied").Str("file-name", src))
+			b.copies = append(b.copies, backupCopy{kind: f.Kind, id: f.ID, src: src, dst: dst})
+		}
+	}
+
+	name, err := s.WriteSnapshot(target, copied)
+	if err != nil {
+		return nil, errors.Wrap(err, "write snapshot")
+	}
+	snaps := logio.NewSnapshotsFS(fsys, datadir.SnapshotsLogName(target), logger)
+	if err := snaps.WriteName(filepath.Base(name)); err != nil {
+		return nil, errors.Wrap(err, "write snapshot name")
+	}
+
+	return b, nil
+}
+
+// ID индекс состояния резервной копии.
+func (b *Backup) ID() types.Index {
+	return b.id
+}
+
+// Hold проверка того, что файл ещё не скопирован в резервную копию и
+// не может быть удалён, предназначен для RetentionPolicy.Hold.
+func (b *Backup) Hold(file RetainedFile) bool {
+	if b.done.Load() {
+		return false
+	}
+
+	for _, c := range b.copies {
+		if c.kind == file.Kind && types.IndexEqual(c.id, file.ID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Complete завершение создания резервной копии: копирование оставшихся
+// файлов и запись манифеста с контрольными суммами всех файлов копии,
+// см. datadir.BackupManifestName. Манифест пишется последним, так что
+// копия без него не завершена. Может выполняться параллельно с работой
+// узла. Возвращаются описания файлов из манифеста.
+func (b *Backup) Complete() ([]BackupFile, error) {
+	err := b.copyFiles()
+	b.done.Store(true)
+	if err != nil {
+		return nil, errors.Wrap(err, "copy files")
+	}
+
+	entries, err := b.fs.ReadDir(b.target)
+	if err != nil {
+		return nil, errors.Wrap(err, "read backup directory")
+	}
+
+	var files []BackupFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		f, err := checksumFile(b.fs, b.target, e.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "compute checksum").Str("file-name", e.Name())
+		}
+		files = append(files, f)
+	}
+
+	if err := writeBackupManifest(b.fs, b.target, files); err != nil {
+		return nil, errors.Wrap(err, "write manifest")
+	}
+
+	return files, nil
+}
+
+func (b *Backup) copyFiles() error {
+	for _, c := range b.copies {
+		if err := copyFile(b.fs, datadir.TempName(b.target, datadir.TempBackup), c); err != nil {
+			return errors.Wrap(err, "copy file").Str("file-name", c.src)
+		}
+	}
+
+	return nil
+}
+
+// copyFile копирование файла c через временный файл tmp.
+func copyFile(fsys fsio.FS, tmp string, c backupCopy) error {
+	src, err := fsys.Open(c.src)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = src.Close()
+	}()
+
+	var r io.Reader = src
+	if c.size > 0 {
+		r = io.LimitReader(src, int64(c.size))
+	}
+
+	dst, err := datadir.CreatePendingFS(fsys, tmp)
+	if err != nil {
+		return errors.Wrap(err, "create copy")
+	}
+
+	n, err := io.Copy(dst, r)
+	if err == nil && c.size > 0 && uint64(n) != c.size {
+		err = errors.New("file is shorter than expected").Uint64("expected-length", c.size).Int64("length", n)
+	}
+	if err != nil {
+		if dErr := dst.Discard(); dErr != nil {
+			return errors.Wrap(err, "copy data").Str("discard-error", dErr.Error())
+		}
+
+		return errors.Wrap(err, "copy data")
+	}
+
+	if err := dst.Publish(c.dst); err != nil {
+		return errors.Wrap(err, "publish copy")
+	}
+
+	return nil
+}
+
+// CheckBackup проверка резервной копии в директории dir: все файлы из её
+// манифеста должны иметь совпадающие с ним контрольные суммы. Возвращаются
+// описания файлов из манифеста.
+func CheckBackup(dir string) ([]BackupFile, error) {
+	return CheckBackupFS(fsio.OS{}, dir)
+}
+
+// CheckBackupFS проверка резервной копии в директории dir файловой
+// системы fsys, см. CheckBackup.
+func CheckBackupFS(fsys fsio.FS, dir string) ([]BackupFile, error) {
+	files, err := readBackupManifest(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+
+	for i, f := range files {
+		actual, err := checksumFile(fsys, dir, f.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "compute checksum").Str("file-name", f.Name)
+		}
+		if actual.Checksum != f.Checksum {
+			return nil, errors.New("checksum mismatch").
+				Str("file-name", f.Name).
+				Str("checksum", hex.EncodeToString(f.Checksum[:])).
+				Str("checksum-actual", hex.EncodeToString(actual.Checksum[:]))
+		}
+
+		files[i].Size = actual.Size
+	}
+
+	return files, nil
+}
+
+// checksumFile контрольная сумма и размер файла name директории dir.
+func checksumFile(fsys fsio.FS, dir, name string) (BackupFile, error) {
+	file, err := fsys.Open(filepath.Join(dir, name))
+	if err != nil {
+		return BackupFile{}, errors.Wrap(err, "open file")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = file.Close()
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return BackupFile{}, errors.Wrap(err, "read file")
+	}
+
+	res := BackupFile{
+		Name: name,
+		Size: n,
+	}
+	h.Sum(res.Checksum[:0])
+	return res, nil
+}
+
+// writeBackupManifest запись манифеста в формате sha256sum, так что
+// копию можно проверить и командой sha256sum -c.
+func writeBackupManifest(fsys fsio.FS, dir string, files []BackupFile) error {
+	file, err := datadir.CreatePendingFS(fsys, datadir.TempName(dir, datadir.TempBackup))
+	if err != nil {
+		return errors.Wrap(err, "create manifest")
+	}
+
+	buf := bufio.NewWriter(file)
+	for _, f := range files {
+		if _, err = fmt.Fprintf(buf, "%x  %s\n", f.Checksum, f.Name); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		if dErr := file.Discard(); dErr != nil {
+			return errors.Wrap(err, "write manifest data").Str("discard-error", dErr.Error())
+		}
+
+		return errors.Wrap(err, "write manifest data")
+	}
+
+	if err := file.Publish(datadir.BackupManifestName(dir)); err != nil {
+		return errors.Wrap(err, "publish manifest")
+	}
+
+	return nil
+}
+
+func readBackupManifest(fsys fsio.FS, dir string) ([]BackupFile, error) {
+	file, err := fsys.Open(datadir.BackupManifestName(dir))
+	if err != nil {
+		return nil, errors.Wrap(err, "open manifest")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = file.Close()
+	}()
+
+	var res []BackupFile
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		var f BackupFile
+		if ok && len(sum) == hex.EncodedLen(sha256.Size) && name != "" {
+			_, err := hex.Decode(f.Checksum[:], []byte(sum))
+			ok = err == nil
+		} else {
+			ok = false
+		}
+		if !ok {
+			return nil, errors.New("malformed manifest line").Int("line", line)
+		}
+
+		f.Name = name
+		res = append(res, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read manifest data")
+	}
+
+	return res, nil
+}
@@ -0,0 +1,220 @@
+package state
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestBackup(t *testing.T) {
+	var rec logop.Recorder
+	dir := t.TempDir()
+	target := t.TempDir()
+	logger := func(err error) {
+		tlog.Log(t, err)
+	}
+
+	firstLog := types.NewIndex(2, 0)
+	firstLast := writeOpsLog(t, dir, firstLog, [][]byte{
+		rec.New(1),
+		rec.Record(types.NewIndex(2, 0), []byte("data")),
+		rec.Store(types.NewIndex(2, 0), 10),
+		rec.New(2),
+	})
+	if t.Failed() {
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{}
+	if err := descs.StartLog(firstLog, firstLog); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start first log"))
+		return
+	}
+	if err := replayLogs(s, dir, descs, nil, logger, func(_ types.Index, rejected error) error { return rejected }); err != nil {
+		tlog.Error(t, errors.Wrap(err, "apply first log"))
+		return
+	}
+
+	// Сохранённая сессия уходит в источник.
+	srcID := types.NewIndex(2, 3)
+	tree, err := s.StartFlush()
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	footer, err := tree.DumpFile(dir, srcID, types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump saved sessions"))
+		return
+	}
+	s.FinishFlush(srcID, footer)
+	stat, err := os.Stat(datadir.SourceName(dir, srcID))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat source"))
+		return
+	}
+	if err := descs.AddSource(srcID, uint64(stat.Size())); err != nil {
+		tlog.Error(t, errors.Wrap(err, "add source"))
+		return
+	}
+
+	// Текущий лог продолжает писаться и после начала копирования.
+	curLog := types.IndexIncIndex(firstLast)
+	if err := descs.LogWritten(firstLast, 0); err != nil {
+		tlog.Error(t, errors.Wrap(err, "account first log"))
+		return
+	}
+	if err := descs.StartLog(curLog, curLog); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start current log"))
+		return
+	}
+	w, err := logio.NewWriter(datadir.LogName(dir, curLog), 512, 128)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create log writer"))
+		return
+	}
+	defer func() {
+		if err := w.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close log writer"))
+		}
+	}()
+
+	a := NewApplier(s, dir, descs, nil)
+	id := curLog
+	write := func(ops ...[]byte) bool {
+		for _, op := range ops {
+			if _, err := w.WriteEvent(id, op); err != nil {
+				tlog.Error(t, errors.Wrap(err, "write event").Stg("event-id", id))
+				return false
+			}
+			if err := a.Apply(id, op); err != nil {
+				tlog.Error(t, errors.Wrap(err, "apply event").Stg("event-id", id))
+				return false
+			}
+			id = types.IndexIncIndex(id)
+		}
+
+		return true
+	}
+	if !write(rec.Record(types.NewIndex(2, 3), []byte("more")), rec.New(3)) {
+		return
+	}
+
+	// События состояния ещё в буфере лога.
+	if _, err := s.StartBackup(dir, descs, target, logger); err == nil {
+		t.Error("expected backup to fail with unflushed state events")
+	}
+	if err := os.RemoveAll(target); err != nil {
+		tlog.Error(t, errors.Wrap(err, "clean backup directory"))
+		return
+	}
+	if err := w.Flush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "flush log"))
+		return
+	}
+
+	b, err := s.StartBackup(dir, descs, target, logger)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "start backup"))
+		return
+	}
+	backupID := s.ID()
+	active := s.ActiveSessions()
+
+	if !write(rec.Delete(types.NewIndex(2, 3)), rec.New(4)) {
+		return
+	}
+	if err := w.Flush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "flush log after backup start"))
+		return
+	}
+
+	current := RetainedFile{Kind: FileKindLog, ID: curLog}
+	if !b.Hold(current) {
+		t.Error("expected current log to be held until the backup is complete")
+	}
+	files, err := b.Complete()
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "complete backup"))
+		return
+	}
+	if b.Hold(current) {
+		t.Error("expected current log to be released after the backup is complete")
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	deepequal.SideBySide(t, "backup files", []string{
+		firstLog.String() + ".log",
+		srcID.String() + ".src",
+		curLog.String() + ".log",
+		backupID.String() + ".snap",
+		"snapshots.journal",
+	}, names)
+
+	// Источники связываются жёсткими ссылками, а не копируются.
+	orig, err := os.Stat(datadir.SourceName(dir, srcID))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat original source"))
+		return
+	}
+	linked, err := os.Stat(datadir.SourceName(target, srcID))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat backup source"))
+		return
+	}
+	if !os.SameFile(orig, linked) {
+		t.Error("expected source to be hard linked into the backup")
+	}
+
+	if _, err := CheckBackup(target); err != nil {
+		tlog.Error(t, errors.Wrap(err, "check backup"))
+		return
+	}
+
+	restored, _, err := Open(target, MemoryLimits{}, nil, logger)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open backup"))
+		return
+	}
+	if !types.IndexEqual(restored.ID(), backupID) {
+		t.Errorf("expected restored state %s, got %s", backupID, restored.ID())
+	}
+	deepequal.SideBySide(t, "active sessions", active, restored.ActiveSessions())
+
+	report, err := Verify(target, nil, logger)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "verify backup"))
+		return
+	}
+	for _, c := range report.Checks {
+		if c.Err != nil {
+			tlog.Error(t, errors.Wrap(c.Err, c.Kind).Str("file-name", c.Name))
+		}
+	}
+
+	// Повреждение любого файла обнаруживается по манифесту.
+	if err := os.WriteFile(datadir.SnapshotsLogName(target), []byte("damaged"), 0644); err != nil {
+		tlog.Error(t, errors.Wrap(err, "damage backup file"))
+		return
+	}
+	if _, err := CheckBackup(target); err == nil {
+		t.Error("expected damaged backup check to fail")
+	} else {
+		tlog.Log(t, err)
+	}
+}
@@ -0,0 +1,545 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// SessionLocator поиск сохранённых сессий сброшенных в источники.
+// Копии сессий отменённые по cancelled не должны находиться.
+type SessionLocator interface {
+	// LocateSession поиск сессии перебором источников.
+	LocateSession(sid types.Index, cancelled CopyCancelled) (info SessionInfo, found bool, err error)
+
+	// ReadSession вычитка сессии по известному местоположению, см. sessionIndex.
+	ReadSession(sid types.Index, place SessionPlace) (sess types.Session, found bool, err error)
+}
+
+// CopyCancelled проверка отмены копии сессии sid с повтором в repeat
+// лежащей в источнике со старшинством age, см. Descriptors.Sources.
+type CopyCancelled func(age, sid types.Index, repeat uint64) bool
+
+// Cancel отмена сохранённой сессии sid, она не будет повторена.
+// Сессия из памяти удаляется сразу, для сессии из источника
+// сохраняется надгробие. Отменённая сессия более не находится,
+// повторная отмена отклоняется как запрос несуществующей сессии.
+func (s *State) Cancel(sid types.Index) error {
+	loc, err := s.locate(sid)
+	if err != nil {
+		return errors.Wrap(err, "locate session").SessionID(sid)
+	}
+
+	s.remove(sid, loc)
+	return nil
+}
+
+// Reschedule перенос повтора сохранённой сессии sid на время repeat,
+// как более раннее, так и более позднее. Приоритет сессии сохраняется,
+// перенос на прежнее время не меняет и места сессии в очереди. Надгробие
+// отменяет только копии из более старых источников, так что перенос на
+// время повтора прежней отменённой копии сессии допустим.
+func (s *State) Reschedule(sid types.Index, repeat uint64) error {
+	if repeat == 0 {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("zero repeat time"), "check repeat time").
+			SessionID(sid)
+	}
+
+	loc, err := s.locate(sid)
+	if err != nil {
+		return errors.Wrap(err, "locate session").SessionID(sid)
+	}
+
+	if repeat == loc.repeat {
+		// Перенос на то же время ничего не меняет, а для сессии из
+		// источника надгробие и новая копия были бы неразличимы.
+		return nil
+	}
+	s.remove(sid, loc)
+	s.saved.SaveSessionPriority(repeat, loc.prio, loc.sess)
+	s.index.saved(sid, repeat, loc.prio)
+	return nil
+}
+
+// sessionLocation местоположение сохранённой сессии.
+type sessionLocation struct {
+	repeat   uint64
+	prio     types.Priority
+	sess     types.Session
+	source   types.Index // Источник сессии, нулевое значение для сессий в памяти.
+	offset   uint64
+	memtable bool // Сессия находится в дереве сохранённых сессий.
+}
+
+// locate поиск сохранённой сессии: вначале в памяти, затем в источниках.
+func (s *State) locate(sid types.Index) (loc sessionLocation, err error) {
+	if _, ok := s.active[sid]; ok {
+		return loc, staterr.NewSessionInvalidRequest("session is not stored")
+	}
+
+	if s.index != nil {
+		return s.locateIndexed(sid)
+	}
+
+	var found bool
+	if loc.repeat, loc.prio, loc.sess, found = s.saved.FindSession(sid); found {
+		loc.memtable = true
+		return loc, nil
+	}
+
+	if s.flushing != nil {
+		loc.repeat, loc.prio, loc.sess, found = s.flushing.FindSession(sid)
+		if found && !s.flushingCopyCancelled(sid, loc.repeat) {
+			return loc, nil
+		}
+	}
+
+	var info SessionInfo
+	found = false
+	if s.sources != nil {
+		info, found, err = s.sources.LocateSession(sid, s.sourceCopyCancelled)
+		if err != nil {
+			return loc, errors.Wrap(err, "look for session in sources")
+		}
+	}
+	if !found {
+		return loc, staterr.NewSessionInvalidRequest("session not found")
+	}
+
+	loc.repeat = info.Repeat
+	loc.prio = info.Priority
+	loc.sess = info.Session
+	loc.source = info.Source
+	loc.offset = info.Offset
+	return loc, nil
+}
+
+// remove удаление найденной сессии. Сессии из сбрасываемого дерева и
+// источников удаляются надгробием в дереве сохранённых сессий: оно будет
+// сброшено в более новый источник чем тот, где находится сессия.
+func (s *State) remove(sid types.Index, loc sessionLocation) {
+	s.index.remove(sid)
+	if loc.memtable {
+		s.saved.RemoveSession(sid)
+		return
+	}
+
+	s.saved.SaveTombstone(loc.repeat, sid)
+	s.cancelled[sourceio.Tombstone{ID: sid, Repeat: loc.repeat}] = struct{}{}
+}
+
+// Cancelled отменённые сессии из источников, чьи отменённые копии ещё не
+// удалены слиянием. Надгробие отменяет копии только из источников
+// старше того, где оно записано, так что итератору по источнику
+// следует отдавать его долю, см. sourceio.Iterator.SkipCancelled и
+// State.sourceCancelled.
+func (s *State) Cancelled() sourceio.Tombstones {
+	return s.cancelled
+}
+
+// tombstoneRecord запись надгробия в источник src со старшинством age.
+type tombstoneRecord struct {
+	src types.Index
+	age types.Index
+}
+
+// sourceCopyCancelled проверка отмены копии сессии sid с повтором в
+// repeat из источника со старшинством age: надгробие должно лежать в
+// памяти или в более новом источнике.
+func (s *State) sourceCopyCancelled(age, sid types.Index, repeat uint64) bool {
+	t := sourceio.Tombstone{ID: sid, Repeat: repeat}
+	if _, ok := s.cancelled[t]; !ok {
+		return false
+	}
+
+	for _, rec := range s.cancelledIn[t] {
+		if types.IndexLess(age, rec.age) {
+			return true
+		}
+	}
+
+	return s.memoryTombstone(sid, repeat)
+}
+
+// flushingCopyCancelled проверка отмены копии сессии sid с повтором в
+// repeat из сбрасываемого дерева: надгробие должно лежать в дереве
+// сохранённых сессий.
+func (s *State) flushingCopyCancelled(sid types.Index, repeat uint64) bool {
+	return s.cancelled.Has(sid, repeat) && s.saved.HasTombstone(repeat, sid)
+}
+
+// memoryTombstone проверка наличия надгробия сессии sid с повтором в
+// repeat в памяти.
+func (s *State) memoryTombstone(sid types.Index, repeat uint64) bool {
+	if s.saved.HasTombstone(repeat, sid) {
+		return true
+	}
+
+	return s.flushing != nil && s.flushing.HasTombstone(repeat, sid)
+}
+
+// sourceCancelled отменённые копии сессий в источнике со
+// старшинством age.
+func (s *State) sourceCancelled(age types.Index) sourceio.Tombstones {
+	res := sourceio.Tombstones{}
+	for t := range s.cancelled {
+		if s.sourceCopyCancelled(age, t.ID, t.Repeat) {
+			res[t] = struct{}{}
+		}
+	}
+
+	return res
+}
+
+// sourceAgeCancelled проверка отмены копий сессий в источнике со
+// старшинством age для scanSource.
+func (s *State) sourceAgeCancelled(age types.Index) func(sid types.Index, repeat uint64) bool {
+	return func(sid types.Index, repeat uint64) bool {
+		return s.sourceCopyCancelled(age, sid, repeat)
+	}
+}
+
+// LoadSources подключение источников из описаний descs лежащих в
+// директории dir: по ним будет идти поиск сессий для отмены и переноса,
+// а их надгробия и надгробия дерева сохранённых сессий будут учтены
+// как отменённые сессии. При включённом индексе сессий в него
+// добавляются сессии источников. Повреждённый источник помещается в
+// карантин, см. sourceio.QuarantineCorruptedFS, а его ошибка
+// возвращается.
+func (s *State) LoadSources(dir string, descs *Descriptors) error {
+	for id := range descs.srcs {
+		name := datadir.SourceName(dir, id)
+		tombs := sourceio.Tombstones{}
+		if err := loadTombstones(descs.FS(), name, s.resolution, tombs); err != nil {
+			err = sourceio.QuarantineCorruptedFS(descs.FS(), name, err)
+			return errors.Wrap(err, "load source tombstones").Stg("source-id", id)
+		}
+		s.tombstonesRecorded(id, descs.srcs[id].age(), tombs)
+	}
+
+	if s.index != nil {
+		for id, desc := range descs.srcs {
+			name := datadir.SourceName(dir, id)
+			cancelled := s.sourceAgeCancelled(desc.age())
+			if err := s.index.loadSource(descs.FS(), name, id, s.resolution, cancelled); err != nil {
+				err = sourceio.QuarantineCorruptedFS(descs.FS(), name, err)
+				return errors.Wrap(err, "index source sessions").Stg("source-id", id)
+			}
+		}
+	}
+
+	for _, t := range []*rbTree{s.saved, s.flushing} {
+		if t == nil {
+			continue
+		}
+
+		iter := t.Iter()
+		for iter.Next() {
+			item := iter.Item()
+			for _, sid := range item.Tombstones {
+				s.cancelled[sourceio.Tombstone{ID: sid, Repeat: item.Repeat}] = struct{}{}
+			}
+		}
+	}
+
+	s.sources = NewSourcesLocator(dir, descs, s.resolution)
+	return nil
+}
+
+// tombstonesRecorded учёт надгробий tombs записанных в источник src
+// со старшинством age.
+func (s *State) tombstonesRecorded(src, age types.Index, tombs sourceio.Tombstones) {
+	if s.cancelledIn == nil {
+		s.cancelledIn = map[sourceio.Tombstone][]tombstoneRecord{}
+	}
+
+	for t := range tombs {
+		s.cancelled[t] = struct{}{}
+		s.cancelledIn[t] = append(s.cancelledIn[t], tombstoneRecord{src: src, age: age})
+	}
+}
+
+// mergeTombstones учёт надгробий при слиянии источников merged в
+// источник dst лежащий в директории dir. Записи надгробий в слитые
+// источники удаляются, а уцелевшие при слиянии, то есть чьи копии
+// лежат в других источниках, вычитываются из dst. Надгробие перестаёт
+// быть отменённой сессией, когда у него не остаётся записей.
+func (s *State) mergeTombstones(dir string, descs *Descriptors, dst types.Index, merged map[types.Index]struct{}) error {
+	// Старшинство dst известно, если он уже зарегистрирован в descs,
+	// иначе берётся старшинство слитых источников с надгробиями: копии
+	// уцелевших надгробий всё равно старше любого из слитых.
+	var age types.Index
+	desc, registered := descs.srcs[dst]
+	if registered {
+		age = desc.age()
+	}
+
+	for t, recs := range s.cancelledIn {
+		kept := recs[:0]
+		for _, rec := range recs {
+			if _, ok := merged[rec.src]; !ok {
+				kept = append(kept, rec)
+				continue
+			}
+
+			if !registered && (age.Term == 0 || types.IndexLess(rec.age, age)) {
+				age = rec.age
+			}
+		}
+		if len(kept) > 0 {
+			s.cancelledIn[t] = kept
+			continue
+		}
+
+		delete(s.cancelledIn, t)
+		if !s.memoryTombstone(t.ID, t.Repeat) {
+			delete(s.cancelled, t)
+		}
+	}
+	if age.Term == 0 {
+		age = dst
+	}
+
+	tombs := sourceio.Tombstones{}
+	if err := loadTombstones(descs.FS(), datadir.SourceName(dir, dst), s.resolution, tombs); err != nil {
+		return errors.Wrap(err, "load tombstones").Stg("source-id", dst)
+	}
+	s.tombstonesRecorded(dst, age, tombs)
+
+	return nil
+}
+
+func loadTombstones(fsys fsio.FS, name string, res types.RepeatResolution, dst sourceio.Tombstones) error {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := openSource(fsys, name, res)
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := f.Tombstones(dst); err != nil {
+		return errors.Wrap(err, "read tombstones")
+	}
+
+	return nil
+}
+
+// openSource открытие файла источника name файловой системы fsys
+// с временем повтора отдаваемым в разрешении res.
+func openSource(fsys fsio.FS, name string, res types.RepeatResolution) (*sourceio.File, error) {
+	f, err := sourceio.OpenFS(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Rescale(res); err != nil {
+		if cErr := f.Close(); cErr != nil {
+			return nil, errors.Wrap(err, "set repeat resolution").Str("close-error", cErr.Error())
+		}
+
+		return nil, errors.Wrap(err, "set repeat resolution")
+	}
+
+	return f, nil
+}
+
+// SourcesLocator поиск сессий перебором файлов источников из
+// описаний файлов. Источники, которые по фильтру сессий своего подвала
+// не содержат искомой сессии, не вычитываются.
+type SourcesLocator struct {
+	dir   string
+	descs *Descriptors
+	res   types.RepeatResolution
+
+	// footers подвалы уже открывавшихся источников, nil для файлов без
+	// подвала. Файлы источников не меняются, так что подвалы читаются
+	// однажды.
+	footers map[types.Index]*sourceio.Footer
+}
+
+// NewSourcesLocator конструктор поиска по источникам из descs
+// лежащим в директории dir. Время повтора сессий отдаётся в
+// разрешении res.
+func NewSourcesLocator(dir string, descs *Descriptors, res types.RepeatResolution) *SourcesLocator {
+	return &SourcesLocator{
+		dir:     dir,
+		descs:   descs,
+		res:     res,
+		footers: map[types.Index]*sourceio.Footer{},
+	}
+}
+
+// LocateSession для реализации SessionLocator.
+func (l *SourcesLocator) LocateSession(
+	sid types.Index,
+	cancelled CopyCancelled,
+) (info SessionInfo, found bool, err error) {
+	for id := range l.footers {
+		if _, ok := l.descs.srcs[id]; !ok {
+			// Источник слит с другим или удалён.
+			delete(l.footers, id)
+		}
+	}
+
+	// Источники перебираются в одном и том же порядке, чтобы результат
+	// и ошибки поиска не зависели от порядка обхода словаря.
+	for _, id := range l.descs.Sources() {
+		footer, ok := l.footers[id]
+		if !ok {
+			footer, err = readSourceFooter(l.descs.FS(), datadir.SourceName(l.dir, id), l.res)
+			if err != nil {
+				return info, false, errors.Wrap(err, "read source footer").Stg("source-id", id)
+			}
+			l.footers[id] = footer
+		}
+		if footer != nil && !footer.MayContain(sid) {
+			continue
+		}
+
+		age := l.descs.srcs[id].age()
+		info, found, err = locateInSource(
+			l.descs.FS(),
+			datadir.SourceName(l.dir, id),
+			l.res,
+			sid,
+			func(sid types.Index, repeat uint64) bool {
+				return cancelled(age, sid, repeat)
+			},
+		)
+		if err != nil {
+			return info, false, errors.Wrap(err, "look for session in source").Stg("source-id", id)
+		}
+
+		if found {
+			info.Source = id
+			return info, true, nil
+		}
+	}
+
+	return info, false, nil
+}
+
+// ReadSession для реализации SessionLocator.
+func (l *SourcesLocator) ReadSession(sid types.Index, place SessionPlace) (sess types.Session, found bool, err error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := openSource(l.descs.FS(), datadir.SourceName(l.dir, place.Source), l.res)
+	if err != nil {
+		return sess, false, errors.Wrap(err, "open source").Stg("source-id", place.Source)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		return sess, false, errors.Wrap(err, "create iterator")
+	}
+	if _, err := it.SeekOffset(place.Offset, place.Repeat); err != nil {
+		return sess, false, errors.Wrap(err, "seek to the session block").Uint64("block-offset", place.Offset)
+	}
+
+	for it.Next() {
+		_, repeat, s := it.RepeatData()
+		if repeat != place.Repeat {
+			break
+		}
+
+		if s.ID == sid {
+			return s, true, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return sess, false, errors.Wrap(err, "iterate over source")
+	}
+
+	return sess, false, nil
+}
+
+// readSourceFooter подвал источника name, nil для файлов без подвала.
+func readSourceFooter(fsys fsio.FS, name string, res types.RepeatResolution) (*sourceio.Footer, error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := openSource(fsys, name, res)
+	if err != nil {
+		return nil, errors.Wrap(err, "open source")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return f.Footer(), nil
+}
+
+func locateInSource(
+	fsys fsio.FS,
+	name string,
+	res types.RepeatResolution,
+	sid types.Index,
+	cancelled func(sid types.Index, repeat uint64) bool,
+) (info SessionInfo, found bool, err error) {
+	found, err = scanSource(fsys, name, res, cancelled, func(place SessionPlace, sess *types.Session) bool {
+		if sess.ID != sid {
+			return true
+		}
+
+		info.SessionPlace = place
+		info.Session = *sess
+		return false
+	})
+
+	return info, found, err
+}
+
+// scanSource перебор неотменённых сессий источника name файловой системы
+// fsys до тех пор, пока fn возвращает true. Копии сессий отменённые по
+// cancelled пропускаются, nil означает отсутствие отмен. Источник в
+// местоположении сессии не заполняется. Возвращает признак остановки
+// перебора по требованию fn.
+func scanSource(
+	fsys fsio.FS,
+	name string,
+	res types.RepeatResolution,
+	cancelled func(sid types.Index, repeat uint64) bool,
+	fn func(place SessionPlace, sess *types.Session) bool,
+) (stopped bool, err error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := openSource(fsys, name, res)
+	if err != nil {
+		return false, errors.Wrap(err, "open source")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		return false, errors.Wrap(err, "create iterator")
+	}
+
+	for it.Next() {
+		_, repeat, sess := it.RepeatData()
+
+		// Перенесённая сессия может оставаться в более старом источнике.
+		if cancelled != nil && cancelled(sess.ID, repeat) {
+			continue
+		}
+
+		place := SessionPlace{
+			Repeat:   repeat,
+			Priority: it.Priority(),
+			Offset:   f.BlockOffset(repeat),
+		}
+		if !fn(place, &sess) {
+			return true, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return false, errors.Wrap(err, "iterate over source")
+	}
+
+	return false, nil
+}
@@ -0,0 +1,378 @@
+package state
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestMergeSourcesTombstones(t *testing.T) {
+	dir := t.TempDir()
+	ids := []types.Index{
+		types.NewIndex(1, 10),
+		types.NewIndex(1, 20),
+		types.NewIndex(1, 30),
+	}
+
+	older := newRBTree()
+	older.SaveSession(10, types.NewSession(types.NewIndex(1, 1), 1, []byte("cancelled")))
+	older.SaveSession(10, types.NewSession(types.NewIndex(1, 2), 1, []byte("kept")))
+	older.SaveSession(20, types.NewSession(types.NewIndex(1, 3), 1, []byte("later")))
+	newer := newRBTree()
+	newer.SaveTombstone(10, types.NewIndex(1, 1))
+	newer.SaveTombstone(5, types.NewIndex(1, 100))
+	newer.SaveSession(10, types.NewSession(types.NewIndex(1, 4), 1, []byte("newer")))
+
+	for i, rb := range []*rbTree{older, newer} {
+		if _, err := rb.DumpFile(dir, ids[i], types.RepeatSecond); err != nil {
+			tlog.Error(t, errors.Wrap(err, "dump source").Stg("source-id", ids[i]))
+			return
+		}
+	}
+
+	_, err := sourceio.MergeFiles(
+		datadir.TempName(dir, datadir.TempMerge),
+		datadir.SourceName(dir, ids[2]),
+		datadir.SourceName(dir, ids[0]),
+		datadir.SourceName(dir, ids[1]),
+		128,
+		types.RepeatSecond,
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "merge sources"))
+		return
+	}
+
+	f, err := sourceio.Open(datadir.SourceName(dir, ids[2]))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open merged source"))
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := f.Verify(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "verify merged source"))
+		return
+	}
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create iterator"))
+		return
+	}
+	var sids []types.Index
+	for it.Next() {
+		_, _, sess := it.RepeatData()
+		sids = append(sids, sess.ID)
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "iterate over merged source"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"merged sessions",
+		[]types.Index{types.NewIndex(1, 2), types.NewIndex(1, 4), types.NewIndex(1, 3)},
+		sids,
+	)
+	for _, sid := range sids {
+		if !f.Footer().MayContain(sid) {
+			t.Errorf("merged session %s is filtered out", sid)
+		}
+	}
+	if f.Footer().MayContain(types.NewIndex(1, 1)) {
+		t.Error("cancelled session is expected to be filtered out of merged source")
+	}
+
+	tombs := sourceio.Tombstones{}
+	if err := f.Tombstones(tombs); err != nil {
+		tlog.Error(t, errors.Wrap(err, "read tombstones"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"unmatched tombstones",
+		sourceio.Tombstones{{ID: types.NewIndex(1, 100), Repeat: 5}: {}},
+		tombs,
+	)
+}
+
+func TestStateCancelReschedule(t *testing.T) {
+	dir := t.TempDir()
+	srcID := types.NewIndex(1, 10)
+
+	flushed := sampleTree()
+	if _, err := flushed.DumpFile(dir, srcID, types.RepeatSecond); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump source"))
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	d := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			srcID: {
+				id: srcID,
+			},
+		},
+	}
+	if err := s.LoadSources(dir, d); err != nil {
+		tlog.Error(t, errors.Wrap(err, "load sources"))
+		return
+	}
+
+	memSession := types.NewSession(types.NewIndex(2, 1), 1, []byte("memory"))
+	s.saved.SaveSession(50, memSession)
+
+	// Сессии из источника отменяются надгробием.
+	if err := s.Cancel(types.NewIndex(1, 1)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel session from source"))
+		return
+	}
+	if err := s.Reschedule(types.NewIndex(1, 4), 5); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session from source"))
+		return
+	}
+	// Надгробие отменяет лишь копию из более старого источника, новая
+	// копия на прежнем времени повтора остаётся.
+	if err := s.Reschedule(types.NewIndex(1, 4), 200); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session back to the cancelled repeat time"))
+		return
+	}
+
+	// Сессии из памяти удаляются сразу.
+	if err := s.Reschedule(memSession.ID, 60); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session from memory"))
+		return
+	}
+	if err := s.Cancel(memSession.ID); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel session from memory"))
+		return
+	}
+
+	for _, sid := range []types.Index{types.NewIndex(1, 1), memSession.ID} {
+		err := s.Cancel(sid)
+		if code := staterr.AsCode(err); code != staterr.CodeSessionInvalidRequest {
+			t.Errorf("unexpected error code %s on cancelled session %s", code, sid)
+		}
+	}
+
+	type repeatSession struct {
+		Repeat uint64
+		ID     types.Index
+	}
+	var saved []repeatSession
+	iter := s.saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for _, sess := range item.Sessions {
+			saved = append(saved, repeatSession{Repeat: item.Repeat, ID: sess.ID})
+		}
+	}
+	deepequal.SideBySide(t, "saved sessions", []repeatSession{{Repeat: 200, ID: types.NewIndex(1, 4)}}, saved)
+
+	f, err := sourceio.Open(datadir.SourceName(dir, srcID))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open source"))
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create iterator"))
+		return
+	}
+	it.SkipCancelled(s.Cancelled())
+	var delivered []repeatSession
+	for it.Next() {
+		_, repeat, sess := it.RepeatData()
+		delivered = append(delivered, repeatSession{Repeat: repeat, ID: sess.ID})
+	}
+	if err := it.Err(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "iterate over source"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"delivered sessions",
+		[]repeatSession{
+			{Repeat: 10, ID: types.NewIndex(1, 2)},
+			{Repeat: 300, ID: types.NewIndex(1, 3)},
+		},
+		delivered,
+	)
+	if len(s.Cancelled()) != 0 {
+		t.Errorf("all tombstones must be consumed, got %d left", len(s.Cancelled()))
+	}
+}
+
+func TestStateCancelledMerged(t *testing.T) {
+	dir := t.TempDir()
+	srcID := types.NewIndex(1, 10)
+	if _, err := sampleTree().DumpFile(dir, srcID, types.RepeatSecond); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump source"))
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	d := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			srcID: {
+				id: srcID,
+			},
+		},
+	}
+	if err := s.LoadSources(dir, d); err != nil {
+		tlog.Error(t, errors.Wrap(err, "load sources"))
+		return
+	}
+
+	// Надгробия двух отмен сбрасываются в один источник, третьей – в
+	// другой. Одна из отменённых копий переносится туда и обратно: её
+	// новая копия уходит в один источник с надгробием старой.
+	if err := s.Reschedule(types.NewIndex(1, 4), 5); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session"))
+		return
+	}
+	if err := s.Reschedule(types.NewIndex(1, 4), 200); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session back"))
+		return
+	}
+	register := func(id types.Index, merged ...types.Index) bool {
+		stat, err := os.Stat(datadir.SourceName(dir, id))
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "stat source").Stg("source-id", id))
+			return false
+		}
+
+		if len(merged) == 0 {
+			err = d.AddSource(id, uint64(stat.Size()))
+		} else {
+			err = d.SourcesMerged(id, uint64(stat.Size()), merged...)
+		}
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "register source").Stg("source-id", id))
+			return false
+		}
+
+		return true
+	}
+	flush := func(id types.Index, cancel ...types.Index) bool {
+		for _, sid := range cancel {
+			if err := s.Cancel(sid); err != nil {
+				tlog.Error(t, errors.Wrap(err, "cancel session").SessionID(sid))
+				return false
+			}
+		}
+
+		flushing, err := s.StartFlush()
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "start flush"))
+			return false
+		}
+		footer, err := flushing.DumpFile(dir, id, types.RepeatSecond)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "dump flushed sessions").Stg("source-id", id))
+			return false
+		}
+		s.FinishFlush(id, footer)
+		return register(id)
+	}
+	if !flush(types.NewIndex(1, 20), types.NewIndex(1, 1)) {
+		return
+	}
+	if !flush(types.NewIndex(1, 30), types.NewIndex(1, 3)) {
+		return
+	}
+
+	merge := func(dst, a, b types.Index) bool {
+		footer, err := sourceio.MergeFiles(
+			datadir.TempName(dir, datadir.TempMerge),
+			datadir.SourceName(dir, dst),
+			datadir.SourceName(dir, a),
+			datadir.SourceName(dir, b),
+			64,
+			types.RepeatSecond,
+		)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "merge sources").Stg("source-id", dst))
+			return false
+		}
+		if !register(dst, a, b) {
+			return false
+		}
+		if err := s.SourcesMerged(dir, d, dst, footer, a, b); err != nil {
+			tlog.Error(t, errors.Wrap(err, "account merged sources").Stg("source-id", dst))
+			return false
+		}
+
+		return true
+	}
+	cancelled := func() []sourceio.Tombstone {
+		var res []sourceio.Tombstone
+		for t := range s.Cancelled() {
+			res = append(res, t)
+		}
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].Repeat < res[j].Repeat
+		})
+		return res
+	}
+	// Новая копия не отменяется надгробием старой.
+	located := func(src types.Index) {
+		info, err := s.Inspect(types.NewIndex(1, 4))
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "inspect rescheduled session"))
+			return
+		}
+
+		deepequal.SideBySide(
+			t,
+			"rescheduled session place",
+			SessionPlace{Source: src, Repeat: 200, Priority: info.Priority, Offset: info.Offset},
+			info.SessionPlace,
+		)
+	}
+	located(types.NewIndex(1, 20))
+
+	// Отменённые копии лежат в источнике не участвующем в слиянии,
+	// надгробия переходят в результат слияния.
+	if !merge(types.NewIndex(1, 40), types.NewIndex(1, 20), types.NewIndex(1, 30)) {
+		return
+	}
+	deepequal.SideBySide(t, "kept tombstones", []sourceio.Tombstone{
+		{ID: types.NewIndex(1, 1), Repeat: 10},
+		{ID: types.NewIndex(1, 4), Repeat: 200},
+		{ID: types.NewIndex(1, 3), Repeat: 300},
+	}, cancelled())
+	located(types.NewIndex(1, 40))
+
+	// Слияние с источником отменённых копий удаляет их вместе с надгробиями.
+	if !merge(types.NewIndex(1, 50), srcID, types.NewIndex(1, 40)) {
+		return
+	}
+	if res := cancelled(); len(res) != 0 {
+		t.Errorf("merged tombstones must be dropped, got %v", res)
+	}
+	located(types.NewIndex(1, 50))
+}
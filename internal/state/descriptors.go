@@ -1,11 +1,18 @@
 package state
 
-import "github.com/sirkon/mpy6a/internal/types"
+import (
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
 
 // Descriptors хранилище описаний файлов. Хранит как
 // описания файлов находящихся в использовании, так и
 // более не используемых.
 type Descriptors struct {
+	fs       fsio.FS
 	srcs     map[types.Index]*srcDescriptor
 	log      *logDescriptor
 	usedSrcs []usedSrc
@@ -17,6 +24,20 @@ type srcDescriptor struct {
 	id     types.Index
 	curPos uint64
 	len    uint64
+
+	// origin старшинство источника: идентификатор самого старого из
+	// слитых в него источников. Нулевое значение означает, что источник
+	// не сливался и его старшинство задаётся идентификатором.
+	origin types.Index
+}
+
+// age старшинство источника, см. Descriptors.Sources.
+func (s *srcDescriptor) age() types.Index {
+	if s.origin.Term == 0 {
+		return s.id
+	}
+
+	return s.origin
 }
 
 // logDescriptor описание лога.
@@ -31,3 +52,148 @@ type usedSrc struct {
 	id  types.Index
 	len uint64
 }
+
+// SetFS задание файловой системы в которой лежат описываемые файлы.
+// По умолчанию это файловая система ОС.
+func (d *Descriptors) SetFS(fsys fsio.FS) {
+	d.fs = fsys
+}
+
+// FS файловая система в которой лежат описываемые файлы.
+func (d *Descriptors) FS() fsio.FS {
+	if d.fs == nil {
+		return fsio.OS{}
+	}
+
+	return d.fs
+}
+
+// AddSource регистрация нового источника id длиной length байт.
+func (d *Descriptors) AddSource(id types.Index, length uint64) error {
+	if d.sourceRegistered(id) {
+		return errors.New("source is already registered").Stg("source-id", id)
+	}
+
+	if d.srcs == nil {
+		d.srcs = map[types.Index]*srcDescriptor{}
+	}
+	d.srcs[id] = &srcDescriptor{
+		id:  id,
+		len: length,
+	}
+	return nil
+}
+
+// SourcesMerged регистрация источника id длиной length байт полученного
+// слиянием источников srcs. Слитые источники переходят в
+// использованные и будут удалены при сборке, см. Collect.
+//
+// Слитые источники должны идти подряд по старшинству, см. Sources:
+// результат занимает их место среди прочих источников, иначе сессии с
+// равным временем повтора выдавались бы не в порядке сохранения.
+func (d *Descriptors) SourcesMerged(id types.Index, length uint64, srcs ...types.Index) error {
+	merged := make(map[types.Index]struct{}, len(srcs))
+	for _, src := range srcs {
+		if _, ok := d.srcs[src]; !ok {
+			return errors.New("merged source is not in use").Stg("source-id", src)
+		}
+		merged[src] = struct{}{}
+	}
+
+	ordered := d.Sources()
+	first, last := -1, -1
+	for i, src := range ordered {
+		if _, ok := merged[src]; !ok {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if last-first+1 != len(merged) {
+		return errors.New("merged sources are not adjacent by age").Stg("source-id", id)
+	}
+
+	if err := d.AddSource(id, length); err != nil {
+		return errors.Wrap(err, "register merged source")
+	}
+	d.srcs[id].origin = d.srcs[ordered[first]].age()
+
+	for _, src := range srcs {
+		d.usedSrcs = append(d.usedSrcs, usedSrc{
+			id:  src,
+			len: d.srcs[src].len,
+		})
+		delete(d.srcs, src)
+	}
+
+	return nil
+}
+
+// Sources используемые источники от более старых к более новым.
+// Старшинство источника задаётся его идентификатором, а у полученного
+// слиянием – старшинством самого старого из слитых.
+func (d *Descriptors) Sources() []types.Index {
+	res := make([]types.Index, 0, len(d.srcs))
+	for id := range d.srcs {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return types.IndexLess(d.srcs[res[i]].age(), d.srcs[res[j]].age())
+	})
+
+	return res
+}
+
+// StartLog регистрация нового текущего лога id, события в котором
+// начинаются с first. Прежний текущий лог переходит в использованные.
+func (d *Descriptors) StartLog(id, first types.Index) error {
+	if d.log != nil {
+		if types.IndexEqual(d.log.id, id) {
+			return errors.New("log is already current").Stg("log-id", id)
+		}
+		d.usedLogs = append(d.usedLogs, d.log)
+	}
+
+	d.log = &logDescriptor{
+		id:      id,
+		firstID: first,
+	}
+	return nil
+}
+
+// LogWritten учёт записи в текущий лог событий до last включительно,
+// length – длина лога в байтах после их записи.
+func (d *Descriptors) LogWritten(last types.Index, length uint64) error {
+	if d.log == nil {
+		return errors.New("no current log")
+	}
+
+	d.log.lastID = last
+	d.log.len = length
+	return nil
+}
+
+// clone копия описаний, независимая от оригинала.
+func (d *Descriptors) clone() *Descriptors {
+	res := &Descriptors{
+		fs:       d.fs,
+		srcs:     make(map[types.Index]*srcDescriptor, len(d.srcs)),
+		usedSrcs: append([]usedSrc(nil), d.usedSrcs...),
+	}
+	for id, src := range d.srcs {
+		cp := *src
+		res.srcs[id] = &cp
+	}
+	if d.log != nil {
+		cp := *d.log
+		res.log = &cp
+	}
+	for _, l := range d.usedLogs {
+		cp := *l
+		res.usedLogs = append(res.usedLogs, &cp)
+	}
+
+	return res
+}
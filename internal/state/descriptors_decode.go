@@ -40,7 +40,7 @@ func (d *Descriptors) decodeSources(src mpio.DataReader) error {
 	}
 
 	d.srcs = make(map[types.Index]*srcDescriptor, int(noOfSources))
-	var buf [32]byte
+	var buf [48]byte
 	for i := uint64(0); i < noOfSources; i++ {
 		s := srcDescriptor{}
 		if _, err := io.ReadFull(src, buf[:]); err != nil {
@@ -51,6 +51,7 @@ func (d *Descriptors) decodeSources(src mpio.DataReader) error {
 		}
 		s.curPos = binary.LittleEndian.Uint64(buf[16:])
 		s.len = binary.LittleEndian.Uint64(buf[24:])
+		types.IndexDecode(&s.origin, buf[32:])
 		d.srcs[s.id] = &s
 	}
 
@@ -70,7 +71,8 @@ func (d *Descriptors) decodeLog(src mpio.DataReader, buf []byte) (*logDescriptor
 	if !types.IndexDecodeCheck(&l.firstID, buf[16:]) {
 		return nil, errors.Wrap(errorInvalidIndex, "decode first id")
 	}
-	if !types.IndexDecodeCheck(&l.lastID, buf[32:]) {
+	// У ещё пустого текущего лога последнего события нет.
+	if !types.IndexDecodeCheck(&l.lastID, buf[32:]) && l.lastID.Index != 0 {
 		return nil, errors.Wrap(errorInvalidIndex, "decode last id")
 	}
 	l.len = binary.LittleEndian.Uint64(buf[48:])
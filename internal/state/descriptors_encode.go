@@ -36,12 +36,13 @@ func (d *Descriptors) encodeSources(dst mpio.DataWriter) error {
 		return errors.Wrap(err, "write sources count")
 	}
 
-	var buf [32]byte
+	var buf [48]byte
 	for _, descr := range d.srcs {
 		types.IndexEncode(buf[:16], descr.id)
 		binary.LittleEndian.PutUint64(buf[16:24], descr.curPos)
 		binary.LittleEndian.PutUint64(buf[24:32], descr.len)
-		if _, err := dst.Write(buf[:32]); err != nil {
+		types.IndexEncode(buf[32:48], descr.origin)
+		if _, err := dst.Write(buf[:48]); err != nil {
 			return errors.Wrap(err, "write descriptor").Stg("descriptor-id", descr.id)
 		}
 	}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// LogRange часть диапазона событий находящаяся в одном файле лога.
+type LogRange struct {
+	// Name имя файла лога.
+	Name string
+
+	// ID идентификатор лога.
+	ID types.Index
+
+	logio.LookupRangeResult
+}
+
+// LookupRange поиск диапазона событий [from, to] по цепочке логов
+// операций: как уже ротированных, так и текущего. Логи в dir
+// должны быть названы согласно datadir.LogName.
+//
+// Возвращаются только части диапазона из логов содержащих события из него,
+// в порядке следования событий.
+func (d *Descriptors) LookupRange(dir string, from, to types.Index, logger func(error)) ([]LogRange, error) {
+	if types.IndexLess(to, from) {
+		return nil, errors.New("invalid range, the end is before the start").
+			Stg("range-start", from).
+			Stg("range-finish", to)
+	}
+
+	var res []LogRange
+	for _, l := range d.logsChain() {
+		if types.IndexLess(to, l.firstID) {
+			break
+		}
+
+		// Для текущего лога lastID не обязан быть актуальным, поэтому
+		// его приходится смотреть всегда.
+		if l != d.log && types.IndexLess(l.lastID, from) {
+			continue
+		}
+
+		name := datadir.LogName(dir, l.id)
+		r, err := logio.LookupRangeFS(d.FS(), name, from, to, logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "look for the range in the log").Stg("log-id", l.id)
+		}
+
+		if r.Empty() {
+			continue
+		}
+
+		res = append(res, LogRange{
+			Name:              name,
+			ID:                l.id,
+			LookupRangeResult: *r,
+		})
+	}
+
+	return res, nil
+}
+
+// NewLogsReader создаёт итератор по событиям цепочки логов операций
+// начиная с первого события не раньше start. Логи в dir должны быть
+// названы согласно datadir.LogName.
+func (d *Descriptors) NewLogsReader(dir string, start types.Index, logger func(error)) (*logio.ChainIterator, error) {
+	var logs []logio.ChainLog
+	for _, l := range d.logsChain() {
+		logs = append(logs, logio.ChainLog{
+			Name:  datadir.LogName(dir, l.id),
+			First: l.firstID,
+			Last:  l.lastID,
+		})
+	}
+	if len(logs) > 0 && d.log != nil {
+		// Текущий лог всё ещё пополняется.
+		logs[len(logs)-1].Last = types.Index{}
+	}
+
+	res, err := logio.NewChainReaderFS(d.FS(), logs, start, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "open logs chain")
+	}
+
+	return res, nil
+}
+
+// logsChain возвращает логи в порядке следования событий в них.
+func (d *Descriptors) logsChain() []*logDescriptor {
+	res := make([]*logDescriptor, 0, len(d.usedLogs)+1)
+	res = append(res, d.usedLogs...)
+	sort.Slice(res, func(i, j int) bool {
+		return types.IndexLess(res[i].firstID, res[j].firstID)
+	})
+	if d.log != nil {
+		res = append(res, d.log)
+	}
+
+	return res
+}
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestDescriptorsLookupRange(t *testing.T) {
+	dir := t.TempDir()
+
+	logs := []*logDescriptor{
+		{
+			id:      types.NewIndex(1, 0),
+			firstID: types.NewIndex(1, 0),
+			lastID:  types.NewIndex(1, 49),
+		},
+		{
+			id:      types.NewIndex(1, 50),
+			firstID: types.NewIndex(1, 50),
+			lastID:  types.NewIndex(1, 99),
+		},
+		{
+			id:      types.NewIndex(2, 0),
+			firstID: types.NewIndex(2, 0),
+		},
+	}
+	for _, l := range logs {
+		if err := writeTestLog(datadir.LogName(dir, l.id), l.firstID, 50); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write log").Stg("log-id", l.id))
+			return
+		}
+	}
+
+	d := Descriptors{
+		log:      logs[2],
+		usedLogs: []*logDescriptor{logs[1], logs[0]},
+	}
+
+	res, err := d.LookupRange(dir, types.NewIndex(1, 49), types.NewIndex(2, 10), func(err error) {
+		tlog.Error(t, err)
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "look for range"))
+		return
+	}
+
+	type span struct {
+		ID    types.Index
+		First types.Index
+		Last  types.Index
+	}
+	var actual []span
+	for _, r := range res {
+		actual = append(actual, span{
+			ID:    r.ID,
+			First: r.First,
+			Last:  r.Last,
+		})
+	}
+
+	expected := []span{
+		{
+			ID:    types.NewIndex(1, 0),
+			First: types.NewIndex(1, 49),
+			Last:  types.NewIndex(1, 49),
+		},
+		{
+			ID:    types.NewIndex(1, 50),
+			First: types.NewIndex(1, 50),
+			Last:  types.NewIndex(1, 99),
+		},
+		{
+			ID:    types.NewIndex(2, 0),
+			First: types.NewIndex(2, 0),
+			Last:  types.NewIndex(2, 10),
+		},
+	}
+	deepequal.SideBySide(t, "log ranges", expected, actual)
+}
+
+// writeTestLog запись count событий в лог начиная с first.
+func writeTestLog(name string, first types.Index, count int) error {
+	w, err := logio.NewWriter(name, 128, 32, logio.WriterBufferSize(256))
+	if err != nil {
+		return errors.Wrap(err, "create writer")
+	}
+
+	id := first
+	for i := 0; i < count; i++ {
+		if _, err := w.WriteEvent(id, []byte("data")); err != nil {
+			return errors.Wrap(err, "write event").Stg("event-id", id)
+		}
+
+		id = types.IndexIncIndex(id)
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "close writer")
+	}
+
+	return nil
+}
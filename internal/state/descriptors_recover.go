@@ -0,0 +1,63 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// CleanDataDir приведение директории с данными в соответствие дескрипторам
+// при запуске: удаляются временные файлы прерванных записей и файлы
+// источников, которые так и не были зарегистрированы. Вызывается после
+// применения событий логов, см. Open: источники из событий после слепка
+// регистрируются только при их применении. Возвращаются имена удалённых
+// файлов.
+func (d *Descriptors) CleanDataDir(dir string) ([]string, error) {
+	res, err := datadir.RemoveTemporaryFS(d.FS(), dir)
+	if err != nil {
+		return res, errors.Wrap(err, "remove temporary files")
+	}
+
+	entries, err := d.FS().ReadDir(dir)
+	if err != nil {
+		return res, errors.Wrap(err, "read directory")
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		id, ok := datadir.ParseSourceName(e.Name())
+		if !ok || d.sourceRegistered(id) {
+			continue
+		}
+
+		name := filepath.Join(dir, e.Name())
+		if err := d.FS().Remove(name); err != nil && !os.IsNotExist(err) {
+			return res, errors.Wrap(err, "remove unregistered source").Str("file-name", name)
+		}
+		res = append(res, name)
+	}
+
+	return res, nil
+}
+
+// sourceRegistered проверка, известен ли источник: используемый
+// или ожидающий удаления.
+func (d *Descriptors) sourceRegistered(id types.Index) bool {
+	if _, ok := d.srcs[id]; ok {
+		return true
+	}
+
+	for _, s := range d.usedSrcs {
+		if types.IndexEqual(s.id, id) {
+			return true
+		}
+	}
+
+	return false
+}
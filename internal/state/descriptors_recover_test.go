@@ -0,0 +1,204 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestDescriptorsCleanDataDir(t *testing.T) {
+	dir := t.TempDir()
+
+	rb := sampleTree()
+	ids := []types.Index{
+		types.NewIndex(1, 10),
+		types.NewIndex(1, 20),
+	}
+	for _, id := range ids {
+		if _, err := rb.DumpFile(dir, id, types.RepeatSecond); err != nil {
+			tlog.Error(t, errors.Wrap(err, "dump source").Stg("source-id", id))
+			return
+		}
+	}
+
+	merged := types.NewIndex(1, 30)
+	_, err := sourceio.MergeFiles(
+		datadir.TempName(dir, datadir.TempMerge),
+		datadir.SourceName(dir, merged),
+		datadir.SourceName(dir, ids[0]),
+		datadir.SourceName(dir, ids[1]),
+		128,
+		types.RepeatSecond,
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "merge sources"))
+		return
+	}
+
+	f, err := sourceio.Open(datadir.SourceName(dir, merged))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open merged source"))
+		return
+	}
+	if err := f.Verify(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "verify merged source"))
+	}
+	if f.Footer().Count != 2*uint64(rb.size) {
+		t.Errorf("unexpected count of merged sessions %d", f.Footer().Count)
+	}
+	if err := f.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close merged source"))
+		return
+	}
+
+	// Остатки прерванной записи.
+	tmp := datadir.TempName(dir, datadir.TempFlush)
+	if err := os.WriteFile(tmp, []byte("garbage"), 0644); err != nil {
+		tlog.Error(t, errors.Wrap(err, "create temporary file"))
+		return
+	}
+
+	d := Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			merged: {
+				id: merged,
+			},
+		},
+		usedSrcs: []usedSrc{
+			{
+				id: ids[0],
+			},
+		},
+	}
+	removed, err := d.CleanDataDir(dir)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "clean data directory"))
+		return
+	}
+	sort.Strings(removed)
+	deepequal.SideBySide(t, "removed files", []string{datadir.SourceName(dir, ids[1]), tmp}, removed)
+
+	var left []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read directory"))
+		return
+	}
+	for _, e := range entries {
+		left = append(left, filepath.Join(dir, e.Name()))
+	}
+	deepequal.SideBySide(
+		t,
+		"left files",
+		[]string{datadir.SourceName(dir, ids[0]), datadir.SourceName(dir, merged)},
+		left,
+	)
+}
+
+func TestOpenCleanDataDir(t *testing.T) {
+	var rec logop.Recorder
+	dir := t.TempDir()
+
+	// Источник загружается событием после слепка.
+	e, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create exported state"))
+		return
+	}
+	e.saved.SaveSession(10, types.NewSession(types.NewIndex(9, 1), 1, []byte("imported")))
+	export := filepath.Join(t.TempDir(), "export.src")
+	if _, err := e.Export(dir, &Descriptors{}, export); err != nil {
+		tlog.Error(t, errors.Wrap(err, "export sessions"))
+		return
+	}
+	importID := types.NewIndex(1, 5)
+	digest, err := StageImport(dir, export, importID)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stage import"))
+		return
+	}
+
+	logID := types.NewIndex(1, 0)
+	ops := [][]byte{rec.New(1), rec.Import(importID, digest)}
+	last := writeOpsLog(t, dir, logID, ops)
+	if t.Failed() {
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{}
+	if err := descs.StartLog(logID, logID); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start log"))
+		return
+	}
+	if err := descs.LogWritten(last, 0); err != nil {
+		tlog.Error(t, errors.Wrap(err, "account log"))
+		return
+	}
+	if err := NewApplier(s, dir, descs, nil).Apply(logID, ops[0]); err != nil {
+		tlog.Error(t, errors.Wrap(err, "apply snapshot operation"))
+		return
+	}
+	logger := func(err error) {
+		tlog.Log(t, err)
+	}
+	name, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+	if err := logio.NewSnapshots(datadir.SnapshotsLogName(dir), logger).WriteName(filepath.Base(name)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot name"))
+		return
+	}
+
+	// Остатки прерванных записей.
+	leftovers := []string{
+		datadir.SourceName(dir, types.NewIndex(1, 3)),
+		datadir.TempName(dir, datadir.TempFlush),
+	}
+	for _, name := range leftovers {
+		if err := os.WriteFile(name, []byte("garbage"), 0644); err != nil {
+			tlog.Error(t, errors.Wrap(err, "create leftover file"))
+			return
+		}
+	}
+
+	var logged int
+	_, rdescs, err := Open(dir, MemoryLimits{}, nil, func(err error) {
+		logged++
+		tlog.Log(t, err)
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open data directory"))
+		return
+	}
+	if !rdescs.sourceRegistered(importID) {
+		t.Error("imported source must be registered")
+	}
+	if _, err := os.Stat(datadir.SourceName(dir, importID)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat imported source"))
+	}
+	for _, name := range leftovers {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("file %s must be removed", name)
+		}
+	}
+	if logged != len(leftovers) {
+		t.Errorf("expected %d removed files to be logged, got %d", len(leftovers), logged)
+	}
+}
@@ -0,0 +1,202 @@
+package state
+
+import (
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// FileKind вид файла данных.
+type FileKind uint8
+
+const (
+	// FileKindLog лог операций.
+	FileKindLog FileKind = iota + 1
+
+	// FileKindSource источник.
+	FileKindSource
+)
+
+func (k FileKind) String() string {
+	switch k {
+	case FileKindLog:
+		return "log"
+	case FileKindSource:
+		return "source"
+	default:
+		return "unknown"
+	}
+}
+
+// RetainedFile описание более не используемого файла.
+type RetainedFile struct {
+	Kind FileKind
+	ID   types.Index
+	Name string
+	Len  uint64
+}
+
+// RetentionPolicy правила хранения более не используемых файлов.
+//
+// Окно истории задаваемое KeepFrom и KeepBytes относится только к логам
+// операций: они нужны для догона отстающих последователей. Удаляются
+// только самые старые логи, так что оставшиеся образуют непрерывную цепочку.
+type RetentionPolicy struct {
+	// KeepFrom логи содержащие события с этим индексом и позже
+	// не удаляются. Нулевое значение снимает ограничение.
+	KeepFrom types.Index
+
+	// KeepBytes не удаляются самые свежие логи суммарным
+	// объёмом до KeepBytes включительно.
+	KeepBytes uint64
+
+	// Hold позволяет придержать файл, например до окончания его
+	// резервного копирования. Файл не удаляется если возвращено true.
+	Hold func(file RetainedFile) bool
+}
+
+// Collect удаление более не используемых файлов не нужных для
+// восстановления из слепка с индексом snapshot, с учётом политики хранения.
+// Дескрипторы должны соответствовать этому слепку. Каждое удаление
+// предварительно записывается в журнал, удалённые файлы убираются
+// из дескрипторов.
+//
+// Возвращаются описания удалённых файлов.
+func (d *Descriptors) Collect(
+	dir string,
+	snapshot types.Index,
+	policy RetentionPolicy,
+	journal *RetentionJournal,
+) ([]RetainedFile, error) {
+	var res []RetainedFile
+
+	logs := d.collectableLogs(dir, snapshot, policy)
+	for _, l := range logs {
+		if err := journal.remove(l); err != nil {
+			return res, errors.Wrap(err, "remove log").Stg("log-id", l.ID)
+		}
+
+		d.dropUsedLog(l.ID)
+		res = append(res, l)
+	}
+
+	for _, s := range d.collectableSources(dir, snapshot, policy) {
+		if err := journal.remove(s); err != nil {
+			return res, errors.Wrap(err, "remove source").Stg("source-id", s.ID)
+		}
+
+		d.dropUsedSource(s.ID)
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+// ApplyRetentionJournal убирает из дескрипторов файлы удалённые согласно
+// журналу и дочищает файлы, удаление которых не было завершено.
+// Вызывается при запуске сразу после загрузки дескрипторов из слепка, см. Open.
+func (d *Descriptors) ApplyRetentionJournal(dir string, journal *RetentionJournal) error {
+	for _, r := range journal.records {
+		var name string
+		switch r.kind {
+		case FileKindLog:
+			d.dropUsedLog(r.id)
+			name = datadir.LogName(dir, r.id)
+		case FileKindSource:
+			d.dropUsedSource(r.id)
+			name = datadir.SourceName(dir, r.id)
+		}
+
+		if err := d.FS().Remove(name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove file").
+				Stg("file-kind", r.kind).
+				Str("file-name", name)
+		}
+	}
+
+	return nil
+}
+
+// collectableLogs выбор старейших логов, которые можно удалить.
+func (d *Descriptors) collectableLogs(dir string, snapshot types.Index, policy RetentionPolicy) []RetainedFile {
+	chain := d.logsChain()
+	if d.log != nil {
+		chain = chain[:len(chain)-1]
+	}
+
+	// Логи из окна истории по объёму не удаляются.
+	limit := len(chain)
+	var size uint64
+	for limit > 0 && size+chain[limit-1].len <= policy.KeepBytes {
+		size += chain[limit-1].len
+		limit--
+	}
+
+	var res []RetainedFile
+	for _, l := range chain[:limit] {
+		if types.IndexLess(snapshot, l.lastID) {
+			break
+		}
+		if policy.KeepFrom.Term != 0 && !types.IndexLess(l.lastID, policy.KeepFrom) {
+			break
+		}
+
+		f := RetainedFile{
+			Kind: FileKindLog,
+			ID:   l.id,
+			Name: datadir.LogName(dir, l.id),
+			Len:  l.len,
+		}
+		if policy.Hold != nil && policy.Hold(f) {
+			break
+		}
+
+		res = append(res, f)
+	}
+
+	return res
+}
+
+// collectableSources выбор источников, которые можно удалить.
+func (d *Descriptors) collectableSources(dir string, snapshot types.Index, policy RetentionPolicy) []RetainedFile {
+	var res []RetainedFile
+	for _, s := range d.usedSrcs {
+		if !types.IndexLess(s.id, snapshot) {
+			continue
+		}
+
+		f := RetainedFile{
+			Kind: FileKindSource,
+			ID:   s.id,
+			Name: datadir.SourceName(dir, s.id),
+			Len:  s.len,
+		}
+		if policy.Hold != nil && policy.Hold(f) {
+			continue
+		}
+
+		res = append(res, f)
+	}
+
+	return res
+}
+
+func (d *Descriptors) dropUsedLog(id types.Index) {
+	for i, l := range d.usedLogs {
+		if types.IndexEqual(l.id, id) {
+			d.usedLogs = append(d.usedLogs[:i], d.usedLogs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Descriptors) dropUsedSource(id types.Index) {
+	for i, s := range d.usedSrcs {
+		if types.IndexEqual(s.id, id) {
+			d.usedSrcs = append(d.usedSrcs[:i], d.usedSrcs[i+1:]...)
+			return
+		}
+	}
+}
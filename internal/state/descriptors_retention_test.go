@@ -0,0 +1,277 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestDescriptorsCollect(t *testing.T) {
+	newDescriptors := func() *Descriptors {
+		return &Descriptors{
+			log: &logDescriptor{
+				id:      types.NewIndex(2, 100),
+				firstID: types.NewIndex(2, 100),
+			},
+			usedLogs: []*logDescriptor{
+				{
+					id:      types.NewIndex(2, 0),
+					firstID: types.NewIndex(2, 0),
+					lastID:  types.NewIndex(2, 99),
+					len:     100,
+				},
+				{
+					id:      types.NewIndex(1, 0),
+					firstID: types.NewIndex(1, 0),
+					lastID:  types.NewIndex(1, 99),
+					len:     100,
+				},
+				{
+					id:      types.NewIndex(1, 100),
+					firstID: types.NewIndex(1, 100),
+					lastID:  types.NewIndex(1, 199),
+					len:     100,
+				},
+			},
+			usedSrcs: []usedSrc{
+				{
+					id:  types.NewIndex(1, 10),
+					len: 10,
+				},
+				{
+					id:  types.NewIndex(2, 150),
+					len: 20,
+				},
+			},
+		}
+	}
+
+	type test struct {
+		name     string
+		snapshot types.Index
+		policy   RetentionPolicy
+		want     []types.Index
+	}
+
+	tests := []test{
+		{
+			name:     "everything before the snapshot",
+			snapshot: types.NewIndex(2, 120),
+			want: []types.Index{
+				types.NewIndex(1, 0),
+				types.NewIndex(1, 100),
+				types.NewIndex(2, 0),
+				types.NewIndex(1, 10),
+			},
+		},
+		{
+			name:     "snapshot in the middle of a log",
+			snapshot: types.NewIndex(1, 150),
+			want: []types.Index{
+				types.NewIndex(1, 0),
+				types.NewIndex(1, 10),
+			},
+		},
+		{
+			name:     "history window by index",
+			snapshot: types.NewIndex(2, 120),
+			policy: RetentionPolicy{
+				KeepFrom: types.NewIndex(1, 150),
+			},
+			want: []types.Index{
+				types.NewIndex(1, 0),
+				types.NewIndex(1, 10),
+			},
+		},
+		{
+			name:     "history window by size",
+			snapshot: types.NewIndex(2, 120),
+			policy: RetentionPolicy{
+				KeepBytes: 250,
+			},
+			want: []types.Index{
+				types.NewIndex(1, 0),
+				types.NewIndex(1, 10),
+			},
+		},
+		{
+			name:     "hold for backup",
+			snapshot: types.NewIndex(2, 120),
+			policy: RetentionPolicy{
+				Hold: func(file RetainedFile) bool {
+					return types.IndexEqual(file.ID, types.NewIndex(1, 100)) ||
+						types.IndexEqual(file.ID, types.NewIndex(1, 10))
+				},
+			},
+			want: []types.Index{
+				types.NewIndex(1, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := newDescriptors()
+			if err := createDescriptorsFiles(dir, d); err != nil {
+				tlog.Error(t, errors.Wrap(err, "create files"))
+				return
+			}
+
+			journal, err := OpenRetentionJournal(datadir.RetentionJournalName(dir))
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "open journal"))
+				return
+			}
+			defer func() {
+				if err := journal.Close(); err != nil {
+					tlog.Error(t, errors.Wrap(err, "close journal"))
+				}
+			}()
+
+			removed, err := d.Collect(dir, tt.snapshot, tt.policy, journal)
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "collect files"))
+				return
+			}
+
+			var got []types.Index
+			for _, f := range removed {
+				got = append(got, f.ID)
+				if _, err := os.Stat(f.Name); !os.IsNotExist(err) {
+					t.Errorf("file %s must be removed", f.Name)
+				}
+			}
+			deepequal.SideBySide(t, "removed files", tt.want, got)
+
+			// Перезапуск из слепка сделанного до удаления.
+			if err := journal.Close(); err != nil {
+				tlog.Error(t, errors.Wrap(err, "close journal"))
+				return
+			}
+			journal, err = OpenRetentionJournal(datadir.RetentionJournalName(dir))
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "reopen journal"))
+				return
+			}
+
+			restored := newDescriptors()
+			if err := restored.ApplyRetentionJournal(dir, journal); err != nil {
+				tlog.Error(t, errors.Wrap(err, "apply journal"))
+				return
+			}
+			deepequal.SideBySide(t, "used logs", d.usedLogs, restored.usedLogs)
+			deepequal.SideBySide(t, "used sources", d.usedSrcs, restored.usedSrcs)
+		})
+	}
+}
+
+func createDescriptorsFiles(dir string, d *Descriptors) error {
+	var names []string
+	for _, l := range d.usedLogs {
+		names = append(names, datadir.LogName(dir, l.id))
+	}
+	for _, s := range d.usedSrcs {
+		names = append(names, datadir.SourceName(dir, s.id))
+	}
+
+	for _, name := range names {
+		if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+			return errors.Wrap(err, "create file").Str("file-name", name)
+		}
+	}
+
+	return nil
+}
+
+func TestOpenRetentionJournal(t *testing.T) {
+	var rec logop.Recorder
+	dir := t.TempDir()
+	logger := func(err error) {
+		tlog.Log(t, err)
+	}
+
+	firstLog := types.NewIndex(1, 0)
+	firstLast := writeOpsLog(t, dir, firstLog, [][]byte{rec.New(1), rec.New(2)})
+	curLog := types.IndexIncIndex(firstLast)
+	curLast := writeOpsLog(t, dir, curLog, [][]byte{rec.Record(firstLog, []byte("data"))})
+	if t.Failed() {
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{}
+	if err := descs.StartLog(firstLog, firstLog); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start first log"))
+		return
+	}
+	if err := descs.LogWritten(firstLast, 100); err != nil {
+		tlog.Error(t, errors.Wrap(err, "account first log"))
+		return
+	}
+	if err := descs.StartLog(curLog, curLog); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start current log"))
+		return
+	}
+	if err := descs.LogWritten(curLast, 100); err != nil {
+		tlog.Error(t, errors.Wrap(err, "account current log"))
+		return
+	}
+	if err := replayLogs(s, dir, descs, nil, logger, func(_ types.Index, rejected error) error { return rejected }); err != nil {
+		tlog.Error(t, errors.Wrap(err, "apply logs"))
+		return
+	}
+
+	// Слепок делается до удаления первого лога и остаётся последним.
+	name, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+	if err := logio.NewSnapshots(datadir.SnapshotsLogName(dir), logger).WriteName(filepath.Base(name)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot name"))
+		return
+	}
+
+	journal, err := OpenRetentionJournal(datadir.RetentionJournalName(dir))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open journal"))
+		return
+	}
+	removed, err := descs.Collect(dir, s.ID(), RetentionPolicy{}, journal)
+	if cErr := journal.Close(); cErr != nil {
+		tlog.Error(t, errors.Wrap(cErr, "close journal"))
+	}
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "collect files"))
+		return
+	}
+	if len(removed) != 1 || !types.IndexEqual(removed[0].ID, firstLog) {
+		t.Errorf("expected the first log to be removed, got %+v", removed)
+		return
+	}
+
+	// Удалённый лог не возвращается в описания при запуске.
+	restored, rdescs, err := Open(dir, MemoryLimits{}, nil, logger)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open data directory"))
+		return
+	}
+	if !types.IndexEqual(restored.ID(), curLast) {
+		t.Errorf("unexpected restored state %s", restored.ID())
+	}
+	deepequal.SideBySide(t, "files", descs.Files(), rdescs.Files())
+}
@@ -22,6 +22,7 @@ func TestDescriptors_EncodeDecode(t *testing.T) {
 				id:     types.NewIndex(2, 1),
 				curPos: 100,
 				len:    1000,
+				origin: types.NewIndex(1, 5),
 			},
 		},
 		log: &logDescriptor{
@@ -64,3 +65,43 @@ func TestDescriptors_EncodeDecode(t *testing.T) {
 
 	deepequal.SideBySide(t, "descriptors", &d, &e)
 }
+
+func TestDescriptorsSourcesMerged(t *testing.T) {
+	var d Descriptors
+	for i, id := range []types.Index{types.NewIndex(2, 1), types.NewIndex(2, 2), types.NewIndex(2, 3)} {
+		if err := d.AddSource(id, uint64(100*(i+1))); err != nil {
+			tlog.Error(t, errors.Wrap(err, "add source").Stg("source-id", id))
+			return
+		}
+	}
+
+	if err := d.SourcesMerged(types.NewIndex(2, 4), 450, types.NewIndex(2, 2), types.NewIndex(2, 5)); err == nil {
+		t.Error("expected error on merging a source not in use")
+	}
+	if err := d.SourcesMerged(types.NewIndex(2, 4), 400, types.NewIndex(2, 1), types.NewIndex(2, 3)); err == nil {
+		t.Error("expected error on merging sources that are not adjacent by age")
+	}
+	if err := d.SourcesMerged(types.NewIndex(2, 4), 450, types.NewIndex(2, 2), types.NewIndex(2, 3)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "register merged source"))
+		return
+	}
+
+	// Слитый источник занимает место слитых, а не встаёт в конец.
+	if err := d.AddSource(types.NewIndex(2, 5), 50); err != nil {
+		tlog.Error(t, errors.Wrap(err, "add source"))
+		return
+	}
+	deepequal.SideBySide(t, "sources", []types.Index{
+		types.NewIndex(2, 1),
+		types.NewIndex(2, 4),
+		types.NewIndex(2, 5),
+	}, d.Sources())
+
+	deepequal.SideBySide(t, "files", []FileDescriptor{
+		{Kind: FileKindSource, ID: types.NewIndex(2, 1), Len: 100},
+		{Kind: FileKindSource, ID: types.NewIndex(2, 2), Used: true, Len: 200},
+		{Kind: FileKindSource, ID: types.NewIndex(2, 3), Used: true, Len: 300},
+		{Kind: FileKindSource, ID: types.NewIndex(2, 4), Len: 450},
+		{Kind: FileKindSource, ID: types.NewIndex(2, 5), Len: 50},
+	}, d.Files())
+}
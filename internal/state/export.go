@@ -0,0 +1,208 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Export выгрузка всех сохранённых сессий: из источников в описаниях
+// descs лежащих в директории dir и из памяти, в один переносимый файл
+// источника name в порядке повтора. Отменённые сессии и надгробия не
+// выгружаются, так что файл самодостаточен и может быть загружен в
+// другое состояние, см. Import. Возвращает подвал записанного файла.
+func (s *State) Export(dir string, descs *Descriptors, name string) (*sourceio.Footer, error) {
+	streams, closeAll, err := s.exportStreams(dir, descs)
+	if err != nil {
+		return nil, errors.Wrap(err, "open session streams")
+	}
+	defer closeAll()
+
+	tmp := datadir.TempName(dir, datadir.TempExport)
+	footer, err := sourceio.WriteFileFS(descs.FS(), tmp, name, sourceBlockSize, s.resolution, func(w *sourceio.Writer) error {
+		return mergeStreams(streams, w.SaveSessionPriority)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write export file").Str("export-name", name)
+	}
+
+	return footer, nil
+}
+
+// exportStreams потоки сохранённых сессий от более старых к более новым:
+// источники по старшинству, см. Descriptors.Sources, затем сбрасываемое
+// и основное деревья.
+func (s *State) exportStreams(dir string, descs *Descriptors) (_ []sessionStream, closeAll func(), err error) {
+	var files []*sourceio.File
+	closeAll = func() {
+		// Файлы открываются только на чтение, ошибки их закрытия не важны.
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	ids := descs.Sources()
+
+	var streams []sessionStream
+	for _, id := range ids {
+		f, err := openSource(descs.FS(), datadir.SourceName(dir, id), s.resolution)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "open source").Stg("source-id", id)
+		}
+		files = append(files, f)
+
+		it, err := f.Iterator(0)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "create source iterator").Stg("source-id", id)
+		}
+		it.SkipCancelled(s.sourceCancelled(descs.srcs[id].age()))
+		streams = append(streams, &sourceStream{it: it})
+	}
+
+	if s.flushing != nil {
+		streams = append(streams, &treeStream{iter: s.flushing.Iter(), cancelled: s.flushingCopyCancelled})
+	}
+	streams = append(streams, &treeStream{iter: s.saved.Iter()})
+
+	return streams, closeAll, nil
+}
+
+// ScanSaved обход всех сохранённых сессий в порядке повтора: из источников
+// в описаниях descs лежащих в директории dir и из памяти. Отменённые
+// сессии пропускаются. Время повтора отдаётся в разрешении состояния.
+// Обход прекращается при первой ошибке fn.
+func (s *State) ScanSaved(
+	dir string,
+	descs *Descriptors,
+	fn func(repeat uint64, prio types.Priority, sess *types.Session) error,
+) error {
+	streams, closeAll, err := s.exportStreams(dir, descs)
+	if err != nil {
+		return errors.Wrap(err, "open session streams")
+	}
+	defer closeAll()
+
+	return mergeStreams(streams, fn)
+}
+
+// mergeStreams слияние потоков сессий в порядке повтора. При равном
+// времени повтора сессии идут по убыванию приоритета, при равном
+// приоритете – из более старого потока.
+func mergeStreams(
+	streams []sessionStream,
+	fn func(repeat uint64, prio types.Priority, sess *types.Session) error,
+) error {
+	var heads []sessionStream
+	for _, st := range streams {
+		if st.Next() {
+			heads = append(heads, st)
+			continue
+		}
+		if err := st.Err(); err != nil {
+			return errors.Wrap(err, "read sessions")
+		}
+	}
+
+	for len(heads) > 0 {
+		k := 0
+		for i := 1; i < len(heads); i++ {
+			if streamBefore(heads[i], heads[k]) {
+				k = i
+			}
+		}
+
+		repeat, prio, sess := heads[k].Session()
+		if err := fn(repeat, prio, sess); err != nil {
+			return errors.Wrap(err, "handle session").SessionID(sess.ID)
+		}
+
+		if heads[k].Next() {
+			continue
+		}
+		if err := heads[k].Err(); err != nil {
+			return errors.Wrap(err, "read sessions")
+		}
+		heads = append(heads[:k], heads[k+1:]...)
+	}
+
+	return nil
+}
+
+// sessionStream поток сохранённых сессий в порядке повтора.
+type sessionStream interface {
+	Next() bool
+	Session() (repeat uint64, prio types.Priority, sess *types.Session)
+	Err() error
+}
+
+// streamBefore проверка, что текущая сессия потока a повторяется раньше
+// текущей сессии потока b.
+func streamBefore(a, b sessionStream) bool {
+	aRepeat, aPrio, _ := a.Session()
+	bRepeat, bPrio, _ := b.Session()
+	if aRepeat != bRepeat {
+		return aRepeat < bRepeat
+	}
+
+	return aPrio > bPrio
+}
+
+// sourceStream поток сессий файла источника.
+type sourceStream struct {
+	it *sourceio.Iterator
+}
+
+func (s *sourceStream) Next() bool {
+	return s.it.Next()
+}
+
+func (s *sourceStream) Session() (repeat uint64, prio types.Priority, sess *types.Session) {
+	_, repeat, session := s.it.RepeatData()
+	return repeat, s.it.Priority(), &session
+}
+
+func (s *sourceStream) Err() error {
+	return s.it.Err()
+}
+
+// treeStream поток сессий дерева. Сессии отменённые по cancelled
+// пропускаются.
+type treeStream struct {
+	iter      *rbTreeIterator
+	item      *savedSessionsData
+	i         int
+	cancelled func(sid types.Index, repeat uint64) bool
+}
+
+func (s *treeStream) Next() bool {
+	for {
+		if s.item != nil {
+			s.i++
+			for ; s.i < len(s.item.Sessions); s.i++ {
+				if s.cancelled == nil || !s.cancelled(s.item.Sessions[s.i].ID, s.item.Repeat) {
+					return true
+				}
+			}
+		}
+
+		if !s.iter.Next() {
+			return false
+		}
+		s.item = s.iter.Item()
+		s.i = -1
+	}
+}
+
+func (s *treeStream) Session() (repeat uint64, prio types.Priority, sess *types.Session) {
+	return s.item.Repeat, s.item.Priority(s.i), &s.item.Sessions[s.i]
+}
+
+func (s *treeStream) Err() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestStateExportImport(t *testing.T) {
+	dir := t.TempDir()
+	srcID := types.NewIndex(1, 10)
+	if _, err := sampleTree().DumpFile(dir, srcID, types.RepeatSecond); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump source"))
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			srcID: {
+				id: srcID,
+			},
+		},
+	}
+	if err := s.LoadSources(dir, descs); err != nil {
+		tlog.Error(t, errors.Wrap(err, "load sources"))
+		return
+	}
+
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 1), 1, []byte("flushing")))
+	if _, err := s.StartFlush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	s.saved.SaveSessionPriority(10, 1, types.NewSession(types.NewIndex(2, 2), 1, []byte("urgent")))
+	s.saved.SaveSession(250, types.NewSession(types.NewIndex(2, 3), 1, []byte("memory")))
+	if err := s.Cancel(types.NewIndex(1, 4)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel session"))
+		return
+	}
+
+	export := filepath.Join(t.TempDir(), "export.src")
+	if _, err := s.Export(dir, descs, export); err != nil {
+		tlog.Error(t, errors.Wrap(err, "export sessions"))
+		return
+	}
+
+	var sessions []prioritySession
+	_, err = scanSource(fsio.OS{}, export, types.RepeatSecond, nil, func(place SessionPlace, sess *types.Session) bool {
+		sessions = append(sessions, prioritySession{
+			Repeat:   place.Repeat,
+			Priority: place.Priority,
+			ID:       sess.ID,
+		})
+		return true
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "scan export file"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"exported sessions",
+		[]prioritySession{
+			{Repeat: 10, Priority: 1, ID: types.NewIndex(2, 2)},
+			{Repeat: 10, ID: types.NewIndex(1, 1)},
+			{Repeat: 10, ID: types.NewIndex(1, 2)},
+			{Repeat: 10, ID: types.NewIndex(2, 1)},
+			{Repeat: 250, ID: types.NewIndex(2, 3)},
+			{Repeat: 300, ID: types.NewIndex(1, 3)},
+		},
+		sessions,
+	)
+
+	// Загрузка в пустое состояние.
+	target := t.TempDir()
+	importID := types.NewIndex(5, 1)
+	digest, err := StageImport(target, export, importID)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stage import"))
+		return
+	}
+	imported, err := NewState(types.RepeatMillisecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create target state"))
+		return
+	}
+	imported.EnableSessionIndex()
+	importDescs := &Descriptors{}
+	if err := imported.Import(target, importDescs, importID, digest); err != nil {
+		tlog.Error(t, errors.Wrap(err, "import sessions"))
+		return
+	}
+	info, err := imported.Inspect(types.NewIndex(2, 2))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "inspect imported session"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"imported session place",
+		SessionPlace{
+			Repeat:   10000,
+			Priority: 1,
+			Source:   importID,
+			Offset:   info.Offset,
+		},
+		info.SessionPlace,
+	)
+
+	// Повторная загрузка тех же сессий.
+	againID := types.NewIndex(5, 2)
+	againDigest, err := StageImport(target, export, againID)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stage import again"))
+		return
+	}
+
+	// Размещённый файл отличается от загружаемого: это сбой узла, а не
+	// отклонение операции.
+	err = imported.Import(target, importDescs, againID, againDigest+1)
+	if err == nil || Rejected(err) {
+		t.Errorf("staged file mismatch must be a local failure, got %v", err)
+		return
+	}
+	tlog.Log(t, err)
+
+	err = imported.Import(target, importDescs, againID, againDigest)
+	if code := staterr.AsCode(err); code != staterr.CodeSessionIDCollision {
+		t.Errorf("unexpected error code %s", code)
+		return
+	}
+	tlog.Log(t, err)
+	if importDescs.sourceRegistered(againID) {
+		t.Error("colliding source must not be registered")
+	}
+
+	// Загруженный файл стал источником, отклонённый остался размещённым и
+	// не удаляется при очистке директории.
+	if _, err := os.Stat(datadir.SourceName(target, importID)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat imported source"))
+	}
+	if _, err := os.Stat(datadir.ImportName(target, importID)); !os.IsNotExist(err) {
+		t.Errorf("staged file of the imported source must be renamed, got %v", err)
+	}
+	removed, err := importDescs.CleanDataDir(target)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "clean data dir"))
+		return
+	}
+	deepequal.SideBySide(t, "removed files", []string(nil), removed)
+	if _, err := os.Stat(datadir.ImportName(target, againID)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat rejected staged source"))
+	}
+}
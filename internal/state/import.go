@@ -0,0 +1,260 @@
+package state
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// StageImport размещение файла выгрузки src, см. Export, в директории
+// dir для загрузки источником id. Файл проверяется и копируется через
+// временный под именем datadir.ImportName: источником он становится
+// только при загрузке, которая проводится реплицируемой операцией, см.
+// Import. Поэтому файл должен быть размещён на каждом узле до её применения.
+// Возвращается контрольная сумма содержимого файла для операции загрузки.
+func StageImport(dir string, src string, id types.Index) (uint32, error) {
+	return StageImportFS(fsio.OS{}, dir, src, id)
+}
+
+// StageImportFS размещение файла выгрузки src в директории dir файловой
+// системы fsys, см. StageImport.
+func StageImportFS(fsys fsio.FS, dir string, src string, id types.Index) (uint32, error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := sourceio.OpenFS(fsys, src)
+	if err != nil {
+		return 0, errors.Wrap(err, "open export file")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := f.Verify(); err != nil {
+		return 0, errors.Wrap(err, "verify export file")
+	}
+
+	in, err := fsys.Open(src)
+	if err != nil {
+		return 0, errors.Wrap(err, "open export file data")
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := datadir.CreatePendingFS(fsys, datadir.TempName(dir, datadir.TempImport))
+	if err != nil {
+		return 0, errors.Wrap(err, "create temporary file")
+	}
+	digest := crc32.New(importDigestTable)
+	if _, err := io.Copy(io.MultiWriter(out, digest), in); err != nil {
+		if dErr := out.Discard(); dErr != nil {
+			return 0, errors.Wrap(err, "copy export file").Str("discard-error", dErr.Error())
+		}
+
+		return 0, errors.Wrap(err, "copy export file")
+	}
+
+	if err := out.Publish(datadir.ImportName(dir, id)); err != nil {
+		return 0, errors.Wrap(err, "publish staged source").Stg("source-id", id)
+	}
+
+	return digest.Sum32(), nil
+}
+
+// importDigestTable таблица контрольных сумм содержимого загружаемых
+// источников, см. StageImport.
+var importDigestTable = crc32.MakeTable(crc32.Castagnoli)
+
+// importDigest контрольная сумма содержимого файла name файловой
+// системы fsys, см. StageImport.
+func importDigest(fsys fsio.FS, name string) (uint32, error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, errors.Wrap(err, "open file")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	digest := crc32.New(importDigestTable)
+	if _, err := io.Copy(digest, f); err != nil {
+		return 0, errors.Wrap(err, "read file")
+	}
+
+	return digest.Sum32(), nil
+}
+
+// Import загрузка источника id с контрольной суммой содержимого digest
+// размещённого в директории dir, см. StageImport: файл переименовывается
+// в источник и регистрируется в descs. Загрузка отклоняется с кодом staterr.CodeSessionIDCollision, если
+// идентификаторы сессий источника совпадают между собой или с
+// идентификаторами существующих сессий. Источник с надгробиями не является
+// выгрузкой и тоже отклоняется. Отклонённый файл остаётся размещённым и
+// может быть удалён вручную.
+//
+// При повторном применении операции после перезапуска размещённого файла
+// может уже не быть: он был переименован, но источник ещё не попал в
+// слепок. Тогда загружается сам файл источника.
+//
+// Отсутствие файла или несовпадение его контрольной суммы с digest это
+// локальный сбой узла, а не отклонение: файл размещён на узле не тот,
+// что на остальных, см. Applier.Apply.
+func (s *State) Import(dir string, descs *Descriptors, id types.Index, digest uint32) error {
+	if descs.sourceRegistered(id) {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("source is already registered"), "check source").
+			Stg("source-id", id)
+	}
+
+	fsys := descs.FS()
+	name := datadir.ImportName(dir, id)
+	stat, err := fsys.Stat(name)
+	if os.IsNotExist(err) {
+		name = datadir.SourceName(dir, id)
+		stat, err = fsys.Stat(name)
+	}
+	if err != nil {
+		return errors.Wrap(err, "stat staged source").Stg("source-id", id)
+	}
+
+	actual, err := importDigest(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "compute staged source digest").Stg("source-id", id)
+	}
+	if actual != digest {
+		return errors.New("staged source differs from the imported one").
+			Stg("source-id", id).
+			Uint32("digest-expected", digest).
+			Uint32("digest-actual", actual)
+	}
+
+	places, err := s.importPlaces(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "read imported sessions").Stg("source-id", id)
+	}
+
+	if err := s.checkCollisions(dir, descs, places); err != nil {
+		return errors.Wrap(err, "check session ids").Stg("source-id", id)
+	}
+
+	if srcName := datadir.SourceName(dir, id); name != srcName {
+		if err := fsys.Rename(name, srcName); err != nil {
+			return errors.Wrap(err, "rename staged source").Stg("source-id", id)
+		}
+	}
+
+	if err := descs.AddSource(id, uint64(stat.Size())); err != nil {
+		return errors.Wrap(err, "register source")
+	}
+
+	if s.index != nil {
+		for sid, place := range places {
+			place.Source = id
+			s.index[sid] = place
+		}
+	}
+	if s.sources == nil {
+		s.sources = NewSourcesLocator(dir, descs, s.resolution)
+	}
+
+	return nil
+}
+
+// importPlaces местоположения сессий загружаемого источника name.
+func (s *State) importPlaces(fsys fsio.FS, name string) (map[types.Index]SessionPlace, error) {
+	tombs := sourceio.Tombstones{}
+	if err := loadTombstones(fsys, name, s.resolution, tombs); err != nil {
+		return nil, errors.Wrap(err, "read tombstones")
+	}
+	if len(tombs) > 0 {
+		return nil, staterr.NewSessionInvalidRequest("imported source must not contain tombstones")
+	}
+
+	places := map[types.Index]SessionPlace{}
+	var dup types.Index
+	duplicated, err := scanSource(fsys, name, s.resolution, nil, func(place SessionPlace, sess *types.Session) bool {
+		if _, ok := places[sess.ID]; ok {
+			dup = sess.ID
+			return false
+		}
+
+		places[sess.ID] = place
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "scan source")
+	}
+	if duplicated {
+		return nil, errors.Wrap(staterr.NewSessionIDCollision("duplicate session in imported source"), "check session").
+			SessionID(dup)
+	}
+
+	return places, nil
+}
+
+// checkCollisions проверка отсутствия существующих сессий с
+// идентификаторами из imported.
+func (s *State) checkCollisions(dir string, descs *Descriptors, imported map[types.Index]SessionPlace) error {
+	collision := func(sid types.Index, where string) errors.Error {
+		return errors.Wrap(staterr.NewSessionIDCollision("session already exists"), "check session").
+			SessionID(sid).
+			Str("existing-session-location", where)
+	}
+
+	for sid := range s.active {
+		if _, ok := imported[sid]; ok {
+			return collision(sid, "active")
+		}
+	}
+
+	for _, t := range []*rbTree{s.saved, s.flushing} {
+		if t == nil {
+			continue
+		}
+
+		iter := t.Iter()
+		for iter.Next() {
+			for _, sess := range iter.Item().Sessions {
+				if _, ok := imported[sess.ID]; ok {
+					return collision(sess.ID, "memory")
+				}
+			}
+		}
+	}
+
+	if s.index != nil {
+		for sid := range imported {
+			if _, ok := s.index[sid]; ok {
+				return collision(sid, "source")
+			}
+		}
+
+		return nil
+	}
+
+	for _, id := range descs.Sources() {
+		var sid types.Index
+		found, err := scanSource(descs.FS(), datadir.SourceName(dir, id), s.resolution, s.sourceAgeCancelled(descs.srcs[id].age()), func(_ SessionPlace, sess *types.Session) bool {
+			if _, ok := imported[sess.ID]; ok {
+				sid = sess.ID
+				return false
+			}
+
+			return true
+		})
+		if err != nil {
+			return errors.Wrap(err, "scan source").Stg("source-id", id)
+		}
+		if found {
+			return collision(sid, "source").Stg("existing-source-id", id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// MemoryLimits ограничения объёма памяти под сохранённые сессии. Объём
+// считается по размеру кодированных данных сессий, см. types.SessionRawLen.
+// Нулевое значение ограничения означает его отсутствие.
+type MemoryLimits struct {
+	// Flush объём сохранённых в памяти сессий, при достижении
+	// которого их следует сбросить на диск.
+	Flush int
+
+	// Hard жёсткое ограничение на суммарный объём сохранённых в памяти
+	// сессий и сессий сбрасываемых на диск в данный момент. Операции
+	// сохранения сессий сверх него отклоняются при их предложении с кодом
+	// staterr.CodeMemoryLimitReached, см. State.Admit.
+	Hard int
+}
+
+// NewState создание пустого состояния с разрешением времени повтора res
+// и данными ограничениями памяти.
+func NewState(res types.RepeatResolution, limits MemoryLimits) (*State, error) {
+	if err := res.Check(); err != nil {
+		return nil, errors.Wrap(err, "check repeat resolution")
+	}
+
+	return &State{
+		resolution: res,
+		systime:    types.NewTimeAtomic(),
+		saved:      newRBTree(),
+		active:     activeSessions{},
+		limits:     limits,
+		cancelled:  sourceio.Tombstones{},
+	}, nil
+}
+
+// Store перевод активной сессии sid в сохранённые с повтором в момент
+// repeat, заданный в разрешении состояния. Ограничение памяти здесь не
+// проверяется, это делается до записи операции в лог, см. Admit.
+func (s *State) Store(sid types.Index, repeat uint64) error {
+	return s.StorePriority(sid, repeat, types.PriorityNormal)
+}
+
+// StorePriority аналогично Store, но сессия сохраняется с приоритетом
+// prio: среди сессий с тем же временем повтора она будет повторена
+// раньше сессий с меньшим приоритетом.
+func (s *State) StorePriority(sid types.Index, repeat uint64, prio types.Priority) error {
+	sess, ok := s.active[sid]
+	if !ok {
+		return errors.Wrap(staterr.NewSessionInvalidRequest("session not found"), "look for active session").
+			SessionID(sid)
+	}
+	delete(s.active, sid)
+	s.saved.SaveSessionPriority(repeat, prio, *sess)
+	s.index.saved(sid, repeat, prio)
+	return nil
+}
+
+// Admit проверка возможности применить операцию op не превысив жёсткого
+// ограничения памяти. Вызывается при предложении операции до её записи в
+// лог: применение записанной операции не должно зависеть от состояния
+// ресурсов узла, иначе узлы могли бы разойтись. Отказ отдаётся с кодом
+// staterr.CodeMemoryLimitReached. Ограничение проверяется по текущему
+// состоянию, поэтому ещё не применённые операции могут немного превысить его.
+func (s *State) Admit(op []byte) error {
+	if s.limits.Hard <= 0 {
+		return nil
+	}
+
+	return logop.RecorderDispatch(&opAdmitter{s: s}, op)
+}
+
+// admit проверка возможности сохранить в памяти ещё size байт.
+func (s *State) admit(size int) error {
+	if s.limits.Hard <= 0 {
+		return nil
+	}
+
+	if usage := s.MemoryUsage(); usage+size > s.limits.Hard {
+		return errors.Wrap(staterr.NewMemoryLimitReached(), "memory limit reached").
+			Int("memory-usage", usage).
+			Int("session-length", size).
+			Int("memory-limit", s.limits.Hard)
+	}
+
+	return nil
+}
+
+// MemoryUsage объём памяти занимаемый сохранёнными сессиями, включая
+// сбрасываемые на диск в данный момент.
+func (s *State) MemoryUsage() int {
+	usage := s.saved.Bytes()
+	if s.flushing != nil {
+		usage += s.flushing.Bytes()
+	}
+
+	return usage
+}
+
+// MemoryPressure степень заполнения памяти относительно жёсткого
+// ограничения: 0 – память свободна, 1 и больше – сохранение сессий
+// отклоняется. Без жёсткого ограничения всегда 0.
+func (s *State) MemoryPressure() float64 {
+	if s.limits.Hard <= 0 {
+		return 0
+	}
+
+	return float64(s.MemoryUsage()) / float64(s.limits.Hard)
+}
+
+// NeedFlush проверка необходимости сбросить сохранённые сессии на диск.
+func (s *State) NeedFlush() bool {
+	if s.flushing != nil || s.saved.Len() == 0 {
+		return false
+	}
+
+	return s.limits.Flush > 0 && s.saved.Bytes() >= s.limits.Flush
+}
+
+// StartFlush начало сброса сохранённых сессий на диск. Дерево с ними
+// отдаётся для записи и продолжает учитываться в занятой памяти до
+// вызова FinishFlush, новые сессии сохраняются в пустое дерево.
+func (s *State) StartFlush() (*rbTree, error) {
+	if s.flushing != nil {
+		return nil, errors.New("flush is already in progress")
+	}
+
+	s.flushing = s.saved
+	s.saved = newRBTree()
+	return s.flushing, nil
+}
+
+// FinishFlush окончание сброса сохранённых сессий на диск в источник
+// src с подвалом footer, память занятая ими освобождается.
+func (s *State) FinishFlush(src types.Index, footer *sourceio.Footer) {
+	if s.flushing != nil {
+		s.index.flushed(s.flushing, src, footer)
+
+		tombs := sourceio.Tombstones{}
+		iter := s.flushing.Iter()
+		for iter.Next() {
+			item := iter.Item()
+			for _, sid := range item.Tombstones {
+				tombs[sourceio.Tombstone{ID: sid, Repeat: item.Repeat}] = struct{}{}
+			}
+		}
+		s.tombstonesRecorded(src, src, tombs)
+	}
+
+	s.flushing = nil
+}
+
+// opAdmitter проверка ограничения памяти для операции, см. State.Admit.
+type opAdmitter struct {
+	s *State
+}
+
+func (a *opAdmitter) New(uint32) error { return nil }
+
+func (a *opAdmitter) Record(types.Index, []byte) error { return nil }
+
+func (a *opAdmitter) Restore(uint32) error { return nil }
+
+func (a *opAdmitter) RestoreSessions([]types.Index) error { return nil }
+
+func (a *opAdmitter) Delete(types.Index) error { return nil }
+
+func (a *opAdmitter) Store(sid types.Index, repeat logop.OptionalRepeat) error {
+	return a.StorePriority(sid, repeat, uint8(types.PriorityNormal))
+}
+
+func (a *opAdmitter) StorePriority(sid types.Index, _ logop.OptionalRepeat, _ uint8) error {
+	sess, ok := a.s.active[sid]
+	if !ok {
+		// Отсутствие сессии обнаружится при применении.
+		return nil
+	}
+
+	if err := a.s.admit(types.SessionRawLen(sess)); err != nil {
+		return errors.Wrap(err, "admit session").SessionID(sid)
+	}
+
+	return nil
+}
+
+func (a *opAdmitter) Cancel(types.Index) error { return nil }
+
+// Reschedule сессии из источника возвращает её в память.
+func (a *opAdmitter) Reschedule(sid types.Index, repeat uint64) error {
+	loc, err := a.s.locate(sid)
+	if err != nil || loc.memtable || loc.repeat == repeat {
+		// Ошибки поиска обнаружатся при применении.
+		return nil
+	}
+
+	if err := a.s.admit(types.SessionRawLen(&loc.sess)); err != nil {
+		return errors.Wrap(err, "admit session").SessionID(sid)
+	}
+
+	return nil
+}
+
+func (a *opAdmitter) Import(types.Index, uint32) error { return nil }
+
+var _ logop.Logop = &opAdmitter{}
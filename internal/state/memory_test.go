@@ -0,0 +1,106 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestRBTreeBytes(t *testing.T) {
+	rb := sampleTree()
+
+	var expected int
+	iter := rb.Iter()
+	for iter.Next() {
+		for i := range iter.n.value.Sessions {
+			expected += types.SessionRawLen(&iter.n.value.Sessions[i])
+		}
+	}
+	if rb.Bytes() != expected {
+		t.Errorf("unexpected tree bytes %d, expected %d", rb.Bytes(), expected)
+	}
+
+	if !rb.DeleteSessions(10) {
+		t.Error("sessions with repeat 10 must be deleted")
+		return
+	}
+	for _, repeat := range []uint64{200, 300} {
+		if !rb.DeleteSessions(repeat) {
+			t.Errorf("sessions with repeat %d must be deleted", repeat)
+			return
+		}
+	}
+
+	if rb.Len() != 0 || rb.Bytes() != 0 {
+		t.Errorf("empty tree expected, got %d sessions of %d bytes", rb.Len(), rb.Bytes())
+	}
+}
+
+func TestStateStoreAdmission(t *testing.T) {
+	sessions := []types.Session{
+		types.NewSession(types.NewIndex(1, 1), 12, []byte("Hello")),
+		types.NewSession(types.NewIndex(1, 2), 13, []byte("World!")),
+		types.NewSession(types.NewIndex(1, 3), 14, []byte("1234")),
+	}
+	size := types.SessionRawLen(&sessions[0]) + types.SessionRawLen(&sessions[1])
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{
+		Flush: size,
+		Hard:  size + types.SessionRawLen(&sessions[2]) - 1,
+	})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	for i := range sessions {
+		s.active[sessions[i].ID] = &sessions[i]
+	}
+
+	for _, sess := range sessions[:2] {
+		if err := s.Store(sess.ID, 100); err != nil {
+			tlog.Error(t, errors.Wrap(err, "store session").SessionID(sess.ID))
+			return
+		}
+	}
+	if !s.NeedFlush() {
+		t.Error("flush must be needed")
+	}
+
+	flushing, err := s.StartFlush()
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	if flushing.Len() != 2 || s.MemoryUsage() != size {
+		t.Errorf("unexpected flushing state: %d sessions, %d bytes used", flushing.Len(), s.MemoryUsage())
+	}
+
+	var rec logop.Recorder
+	store := rec.Store(sessions[2].ID, 200)
+	err = s.Admit(store)
+	if code := staterr.AsCode(err); code != staterr.CodeMemoryLimitReached {
+		t.Errorf("unexpected error code %s", code)
+		return
+	}
+	tlog.Log(t, err)
+	if s.MemoryPressure() < 0.5 {
+		t.Errorf("unexpected memory pressure %f", s.MemoryPressure())
+	}
+
+	s.FinishFlush(types.NewIndex(1, 100), nil)
+	if err := s.Admit(store); err != nil {
+		tlog.Error(t, errors.Wrap(err, "admit session after flush"))
+		return
+	}
+	if err := s.Store(sessions[2].ID, 200); err != nil {
+		tlog.Error(t, errors.Wrap(err, "store session after flush"))
+		return
+	}
+	if s.saved.Len() != 1 || len(s.active) != 0 {
+		t.Errorf("unexpected state: %d saved, %d active", s.saved.Len(), len(s.active))
+	}
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Open восстановление состояния директории dir при запуске: читается
+// последний слепок из лога имён слепков, см. LatestSnapshot, из его
+// описаний файлов убираются удалённые согласно журналу удалённых файлов,
+// см. RetentionJournal, и к нему применяются все события логов операций
+// после него. Затем из директории удаляются временные файлы прерванных
+// записей и незарегистрированные источники, см. Descriptors.CleanDataDir,
+// имена удалённых файлов отдаются в logger. Ограничения памяти
+// limits и defaultRepeat аналогичны NewState и NewApplier.
+//
+// Последнее событие текущего лога в возвращаемых описаниях файлов может
+// отставать от применённых, его следует обновить перед записью новых
+// событий, см. Descriptors.LogWritten.
+func Open(
+	dir string,
+	limits MemoryLimits,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*State, *Descriptors, error) {
+	return OpenFS(fsio.OS{}, dir, limits, defaultRepeat, logger)
+}
+
+// OpenFS восстановление состояния директории dir файловой системы fsys,
+// см. Open. Возвращаемые описания файлов работают с fsys.
+func OpenFS(
+	fsys fsio.FS,
+	dir string,
+	limits MemoryLimits,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*State, *Descriptors, error) {
+	name, err := LatestSnapshotFS(fsys, dir, logger)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "look for the latest snapshot")
+	}
+
+	s, descs, err := ReadSnapshotFS(fsys, name, limits)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read snapshot")
+	}
+
+	if err := applyRetentionJournal(fsys, dir, descs); err != nil {
+		return nil, nil, errors.Wrap(err, "apply retention journal")
+	}
+
+	err = replayLogs(s, dir, descs, defaultRepeat, logger, func(_ types.Index, rejected error) error {
+		if rejected != nil {
+			logger(errors.Wrap(rejected, "operation rejected"))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "replay logs").Stg("snapshot-id", s.id)
+	}
+
+	// Источники из событий после слепка уже зарегистрированы.
+	removed, err := descs.CleanDataDir(dir)
+	for _, name := range removed {
+		logger(errors.New("removed leftover file").Str("file-name", name))
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "clean data directory")
+	}
+
+	return s, descs, nil
+}
+
+// applyRetentionJournal применение журнала удалённых файлов директории
+// dir к описаниям descs, если журнал есть.
+func applyRetentionJournal(fsys fsio.FS, dir string, descs *Descriptors) error {
+	name := datadir.RetentionJournalName(dir)
+	if _, err := fsys.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.Wrap(err, "stat journal").Str("journal-name", name)
+	}
+
+	journal, err := OpenRetentionJournalFS(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "open journal").Str("journal-name", name)
+	}
+
+	err = descs.ApplyRetentionJournal(dir, journal)
+	if cErr := journal.Close(); cErr != nil {
+		if err != nil {
+			return errors.Wrap(err, "apply journal").Str("close-error", cErr.Error())
+		}
+
+		return errors.Wrap(cErr, "close journal")
+	}
+	if err != nil {
+		return errors.Wrap(err, "apply journal")
+	}
+
+	return nil
+}
+
+// replayLogs применение к состоянию s всех событий логов из описаний
+// descs после него, fn вызывается для каждого применённого события с
+// ошибкой отклонения его операции, см. Rejected. Ошибка fn прерывает
+// применение.
+func replayLogs(
+	s *State,
+	dir string,
+	descs *Descriptors,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+	fn func(id types.Index, rejected error) error,
+) error {
+	if err := s.LoadSources(dir, descs); err != nil {
+		return errors.Wrap(err, "load sources")
+	}
+
+	chain := descs.logsChain()
+	if len(chain) == 0 {
+		return nil
+	}
+
+	start := chain[0].firstID
+	if s.id.Term != 0 {
+		start = types.IndexIncIndex(s.id)
+	}
+
+	it, err := descs.NewLogsReader(dir, start, logger)
+	if err != nil {
+		return errors.Wrap(err, "open logs").Stg("start-id", start)
+	}
+	defer func() {
+		// Файлы открыты только на чтение, ошибка закрытия не важна.
+		_ = it.Close()
+	}()
+
+	a := NewApplier(s, dir, descs, defaultRepeat)
+	for it.Next() {
+		id, data, _ := it.Event()
+		err := a.Apply(id, data)
+		if err != nil && !Rejected(err) {
+			return errors.Wrap(err, "apply event").Str("log-name", it.Name())
+		}
+
+		// Отклонение одинаково на всех узлах, событие применено.
+		if err := fn(id, err); err != nil {
+			return errors.Wrap(err, "operation rejected").Str("log-name", it.Name())
+		}
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "read logs")
+	}
+
+	return nil
+}
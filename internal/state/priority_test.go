@@ -0,0 +1,201 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+type prioritySession struct {
+	Repeat   uint64
+	Priority types.Priority
+	ID       types.Index
+}
+
+func treePrioritySessions(rb *rbTree) []prioritySession {
+	var res []prioritySession
+	iter := rb.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			res = append(res, prioritySession{
+				Repeat:   item.Repeat,
+				Priority: item.Priority(i),
+				ID:       sess.ID,
+			})
+		}
+	}
+
+	return res
+}
+
+func TestRBTreePriorities(t *testing.T) {
+	rb := newRBTree()
+	for i, prio := range []types.Priority{0, 5, 1, 5, 0} {
+		id := types.NewIndex(1, uint64(i+1))
+		rb.SaveSessionPriority(10, prio, types.NewSession(id, 1, []byte(id.String())))
+	}
+	rb.SaveSession(20, types.NewSession(types.NewIndex(2, 1), 1, []byte("normal")))
+
+	expected := []prioritySession{
+		{Repeat: 10, Priority: 5, ID: types.NewIndex(1, 2)},
+		{Repeat: 10, Priority: 5, ID: types.NewIndex(1, 4)},
+		{Repeat: 10, Priority: 1, ID: types.NewIndex(1, 3)},
+		{Repeat: 10, Priority: 0, ID: types.NewIndex(1, 1)},
+		{Repeat: 10, Priority: 0, ID: types.NewIndex(1, 5)},
+		{Repeat: 20, Priority: 0, ID: types.NewIndex(2, 1)},
+	}
+	deepequal.SideBySide(t, "tree sessions", expected, treePrioritySessions(rb))
+
+	var buf bytes.Buffer
+	w := sourceio.NewWriter(&buf, 1024)
+	if err := rb.Encode(w); err != nil {
+		tlog.Error(t, errors.Wrap(err, "encode tree"))
+		return
+	}
+	if err := w.Flush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "flush encoded tree"))
+		return
+	}
+	decoded := newRBTree()
+	if err := decoded.Decode(&buf); err != nil {
+		tlog.Error(t, errors.Wrap(err, "decode tree"))
+		return
+	}
+	deepequal.SideBySide(t, "decoded tree sessions", expected, treePrioritySessions(decoded))
+
+	if _, _, found := rb.RemoveSession(types.NewIndex(1, 4)); !found {
+		t.Error("session must be removed")
+		return
+	}
+	if _, prio, _, found := rb.FindSession(types.NewIndex(1, 3)); !found || prio != 1 {
+		t.Errorf("unexpected session priority %d", prio)
+	}
+}
+
+func TestMergeSourcesPriorities(t *testing.T) {
+	dir := t.TempDir()
+	ids := []types.Index{
+		types.NewIndex(1, 10),
+		types.NewIndex(1, 20),
+		types.NewIndex(1, 30),
+	}
+
+	older := newRBTree()
+	older.SaveSession(10, types.NewSession(types.NewIndex(1, 1), 1, []byte("older normal")))
+	older.SaveSessionPriority(10, 2, types.NewSession(types.NewIndex(1, 2), 1, []byte("older urgent")))
+	newer := newRBTree()
+	newer.SaveSessionPriority(10, 2, types.NewSession(types.NewIndex(1, 3), 1, []byte("newer urgent")))
+	newer.SaveSessionPriority(10, 7, types.NewSession(types.NewIndex(1, 4), 1, []byte("newer critical")))
+	newer.SaveSession(20, types.NewSession(types.NewIndex(1, 5), 1, []byte("later")))
+
+	for i, rb := range []*rbTree{older, newer} {
+		if _, err := rb.DumpFile(dir, ids[i], types.RepeatSecond); err != nil {
+			tlog.Error(t, errors.Wrap(err, "dump source").Stg("source-id", ids[i]))
+			return
+		}
+	}
+
+	_, err := sourceio.MergeFiles(
+		datadir.TempName(dir, datadir.TempMerge),
+		datadir.SourceName(dir, ids[2]),
+		datadir.SourceName(dir, ids[0]),
+		datadir.SourceName(dir, ids[1]),
+		128,
+		types.RepeatSecond,
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "merge sources"))
+		return
+	}
+
+	var sessions []prioritySession
+	_, err = scanSource(
+		fsio.OS{},
+		datadir.SourceName(dir, ids[2]),
+		types.RepeatSecond,
+		nil,
+		func(place SessionPlace, sess *types.Session) bool {
+			sessions = append(sessions, prioritySession{
+				Repeat:   place.Repeat,
+				Priority: place.Priority,
+				ID:       sess.ID,
+			})
+			return true
+		},
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "scan merged source"))
+		return
+	}
+
+	deepequal.SideBySide(
+		t,
+		"merged sessions",
+		[]prioritySession{
+			{Repeat: 10, Priority: 7, ID: types.NewIndex(1, 4)},
+			{Repeat: 10, Priority: 2, ID: types.NewIndex(1, 2)},
+			{Repeat: 10, Priority: 2, ID: types.NewIndex(1, 3)},
+			{Repeat: 10, Priority: 0, ID: types.NewIndex(1, 1)},
+			{Repeat: 20, Priority: 0, ID: types.NewIndex(1, 5)},
+		},
+		sessions,
+	)
+}
+
+func TestStateStorePriority(t *testing.T) {
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.EnableSessionIndex()
+
+	sessions := []types.Session{
+		types.NewSession(types.NewIndex(1, 1), 1, []byte("normal")),
+		types.NewSession(types.NewIndex(1, 2), 1, []byte("urgent")),
+	}
+	for i := range sessions {
+		s.active[sessions[i].ID] = &sessions[i]
+	}
+	if err := s.Store(sessions[0].ID, 10); err != nil {
+		tlog.Error(t, errors.Wrap(err, "store normal session"))
+		return
+	}
+	if err := s.StorePriority(sessions[1].ID, 20, 3); err != nil {
+		tlog.Error(t, errors.Wrap(err, "store urgent session"))
+		return
+	}
+
+	// Перенос сохраняет приоритет.
+	if err := s.Reschedule(sessions[1].ID, 10); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule urgent session"))
+		return
+	}
+
+	deepequal.SideBySide(
+		t,
+		"saved sessions",
+		[]prioritySession{
+			{Repeat: 10, Priority: 3, ID: sessions[1].ID},
+			{Repeat: 10, Priority: 0, ID: sessions[0].ID},
+		},
+		treePrioritySessions(s.saved),
+	)
+
+	info, err := s.Inspect(sessions[1].ID)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "inspect urgent session"))
+		return
+	}
+	if info.Priority != 3 {
+		t.Errorf("unexpected inspected priority %d", info.Priority)
+	}
+}
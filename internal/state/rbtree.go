@@ -7,14 +7,77 @@ func newRBTree() *rbTree {
 }
 
 type rbTree struct {
-	root *rbTreeNode
-	size int
+	root  *rbTreeNode
+	size  int
+	bytes int // Суммарный размер сохранённых сессий, см. types.SessionRawLen.
 }
 
-// savedSessionsData структура данных сохранённых сессий с повтором в заданное время
+// tombstoneBytes размер надгробия в памяти.
+const tombstoneBytes = 16
+
+// savedSessionsData структура данных сохранённых сессий с повтором в заданное время.
+// Сессии упорядочены по убыванию приоритета, при равном приоритете – по
+// времени сохранения. Priorities – приоритеты сессий, пусто пока все
+// сессии имеют приоритет types.PriorityNormal. Tombstones – надгробия
+// сессий из источников с тем же временем повтора.
 type savedSessionsData struct {
-	Repeat   uint64
-	Sessions []types.Session
+	Repeat     uint64
+	Sessions   []types.Session
+	Priorities []types.Priority
+	Tombstones []types.Index
+}
+
+// Priority приоритет i-й сессии.
+func (d *savedSessionsData) Priority(i int) types.Priority {
+	if d.Priorities == nil {
+		return types.PriorityNormal
+	}
+
+	return d.Priorities[i]
+}
+
+// insert добавление сессии после всех сессий с приоритетом не ниже prio.
+func (d *savedSessionsData) insert(sess types.Session, prio types.Priority) {
+	if d.Priorities == nil {
+		if prio == types.PriorityNormal {
+			d.Sessions = append(d.Sessions, sess)
+			return
+		}
+
+		d.Priorities = make([]types.Priority, len(d.Sessions), len(d.Sessions)+1)
+	}
+
+	i := len(d.Sessions)
+	for i > 0 && d.Priorities[i-1] < prio {
+		i--
+	}
+	if i == len(d.Sessions) {
+		d.Sessions = append(d.Sessions, sess)
+		d.Priorities = append(d.Priorities, prio)
+		return
+	}
+
+	// Срезы могут разделяться с копиями дерева, см. Clone, поэтому
+	// вставка в середину делается в новые срезы.
+	sessions := make([]types.Session, 0, len(d.Sessions)+1)
+	sessions = append(sessions, d.Sessions[:i]...)
+	sessions = append(sessions, sess)
+	d.Sessions = append(sessions, d.Sessions[i:]...)
+
+	priorities := make([]types.Priority, 0, len(d.Priorities)+1)
+	priorities = append(priorities, d.Priorities[:i]...)
+	priorities = append(priorities, prio)
+	d.Priorities = append(priorities, d.Priorities[i:]...)
+}
+
+// remove удаление i-й сессии.
+func (d *savedSessionsData) remove(i int) {
+	// Срезы могут разделяться с копиями дерева, см. Clone, поэтому
+	// они не изменяются на месте.
+	d.Sessions = append(d.Sessions[:i:i], d.Sessions[i+1:]...)
+	if d.Priorities != nil {
+		d.Priorities = append(d.Priorities[:i:i], d.Priorities[i+1:]...)
+	}
 }
 
 // Iter отдача итератора по дереву.
@@ -29,6 +92,11 @@ func (t *rbTree) Len() int {
 	return t.size
 }
 
+// Bytes возвращает суммарный размер сохранённых в дереве сессий.
+func (t *rbTree) Bytes() int {
+	return t.bytes
+}
+
 // Min возвращает сессии начинающиеся раньше всех.
 func (t *rbTree) Min() (val *savedSessionsData, exists bool) {
 	if t.root == nil {
@@ -76,18 +144,26 @@ func (t *rbTree) Clone() *rbTree {
 	}
 
 	return &rbTree{
-		root: mapping[t.root],
-		size: t.size,
+		root:  mapping[t.root],
+		size:  t.size,
+		bytes: t.bytes,
 	}
 }
 
-// DeleteSessions удаляет первую сессию с повтором в заданное время.
+// DeleteSessions удаляет сессии с повтором в заданное время.
 func (t *rbTree) DeleteSessions(repeat uint64) (deleted bool) {
 	n := rbTreeLookupForValue(t.root, repeat)
 	if n == nil {
 		return false
 	}
 
+	// Значение запоминается до возможного обмена значениями вершин ниже.
+	t.size -= len(n.value.Sessions) + len(n.value.Tombstones)
+	t.bytes -= len(n.value.Tombstones) * tombstoneBytes
+	for i := range n.value.Sessions {
+		t.bytes -= types.SessionRawLen(&n.value.Sessions[i])
+	}
+
 	p := n.parent
 	defer func() {
 		// очищаем связи для уменьшения нагрузки на GC
@@ -96,11 +172,8 @@ func (t *rbTree) DeleteSessions(repeat uint64) (deleted bool) {
 		n.right = nil
 		n = nil
 		p = nil
-
-		if deleted {
-			t.size--
-		}
 	}()
+	deleted = true
 
 	isRight := n.isRight()
 
@@ -193,29 +266,44 @@ func (t *rbTree) DeleteSessions(repeat uint64) (deleted bool) {
 
 // SaveSession сохранение сессии.
 func (t *rbTree) SaveSession(repeat uint64, sess types.Session) {
+	t.SaveSessionPriority(repeat, types.PriorityNormal, sess)
+}
+
+// SaveSessionPriority сохранение сессии с приоритетом prio.
+func (t *rbTree) SaveSessionPriority(repeat uint64, prio types.Priority, sess types.Session) {
+	t.item(repeat).insert(sess, prio)
+	t.size++
+	t.bytes += types.SessionRawLen(&sess)
+}
+
+// SaveTombstone сохранение надгробия сессии sid из источника, см. sourceio.Tombstones.
+func (t *rbTree) SaveTombstone(repeat uint64, sid types.Index) {
+	item := t.item(repeat)
+	item.Tombstones = append(item.Tombstones, sid)
+	t.size++
+	t.bytes += tombstoneBytes
+}
+
+// item возвращает данные для времени повтора repeat, создавая их при отсутствии.
+func (t *rbTree) item(repeat uint64) *savedSessionsData {
 	if t.root == nil {
 		t.root = &rbTreeNode{
 			value: &savedSessionsData{
-				Repeat:   repeat,
-				Sessions: []types.Session{sess},
+				Repeat: repeat,
 			},
 		}
-		t.size = 1
-		return
+		return t.root.value
 	}
-	t.size++
 
 	p, isRight, alreadyExist := rbTreeLookupForFreeValueParent(t.root, repeat)
 	if alreadyExist {
-		p.value.Sessions = append(p.value.Sessions, sess)
-		return
+		return p.value
 	}
 
 	// добавляем в дерево узел красного цвета
 	n := &rbTreeNode{
 		value: &savedSessionsData{
-			Repeat:   repeat,
-			Sessions: []types.Session{sess},
+			Repeat: repeat,
 		},
 		parent: p,
 		red:    true,
@@ -227,6 +315,89 @@ func (t *rbTree) SaveSession(repeat uint64, sess types.Session) {
 	}
 
 	t.rebalanceInserted(p, n)
+	return n.value
+}
+
+// FindSession поиск сохранённой сессии по идентификатору. Деревья
+// упорядочены по времени повтора, поэтому поиск идёт перебором.
+func (t *rbTree) FindSession(sid types.Index) (repeat uint64, prio types.Priority, sess types.Session, found bool) {
+	iter := t.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, s := range item.Sessions {
+			if s.ID == sid {
+				return item.Repeat, item.Priority(i), s, true
+			}
+		}
+	}
+
+	return 0, prio, sess, false
+}
+
+// FindSessionAt поиск сохранённой сессии по идентификатору среди
+// сессий с повтором в repeat.
+func (t *rbTree) FindSessionAt(repeat uint64, sid types.Index) (sess types.Session, found bool) {
+	if t.root == nil {
+		return sess, false
+	}
+
+	n := rbTreeLookupForValue(t.root, repeat)
+	if n == nil {
+		return sess, false
+	}
+
+	for _, s := range n.value.Sessions {
+		if s.ID == sid {
+			return s, true
+		}
+	}
+
+	return sess, false
+}
+
+// HasTombstone проверка наличия надгробия сессии sid с повтором в repeat.
+func (t *rbTree) HasTombstone(repeat uint64, sid types.Index) bool {
+	if t.root == nil {
+		return false
+	}
+
+	n := rbTreeLookupForValue(t.root, repeat)
+	if n == nil {
+		return false
+	}
+
+	for _, id := range n.value.Tombstones {
+		if id == sid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoveSession удаление сохранённой сессии по идентификатору.
+func (t *rbTree) RemoveSession(sid types.Index) (repeat uint64, sess types.Session, found bool) {
+	iter := t.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, s := range item.Sessions {
+			if s.ID != sid {
+				continue
+			}
+
+			if len(item.Sessions) == 1 && len(item.Tombstones) == 0 {
+				t.DeleteSessions(item.Repeat)
+				return item.Repeat, s, true
+			}
+
+			item.remove(i)
+			t.size--
+			t.bytes -= types.SessionRawLen(&s)
+			return item.Repeat, s, true
+		}
+	}
+
+	return 0, sess, false
 }
 
 // swapChild поменять потомка в родителе с from на to.
@@ -691,6 +862,7 @@ func rbTreeAllocNode(
 	nitem = &nodes[arenaIndex]
 	nitem.value = &values[arenaIndex]
 	nitem.value.Sessions = item.value.Sessions
+	nitem.value.Tombstones = item.value.Tombstones
 	nitem.value.Repeat = item.value.Repeat
 	mapping[item] = nitem
 
@@ -6,6 +6,7 @@ import (
 
 	"github.com/sirkon/mpy6a/internal/errors"
 	"github.com/sirkon/mpy6a/internal/mpio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
 	"github.com/sirkon/mpy6a/internal/types"
 )
 
@@ -22,7 +23,7 @@ func (t *rbTree) Decode(src mpio.DataReader) error {
 		if _, err := io.ReadFull(src, repbuf[:8]); err != nil {
 			return errors.Wrap(err, "read session repeat time data")
 		}
-		repeat := binary.LittleEndian.Uint64(repbuf[:])
+		repeat, prio, tombstone := sourceio.RecordRepeat(binary.LittleEndian.Uint64(repbuf[:]))
 
 		datalen, err := binary.ReadUvarint(src)
 		if err != nil {
@@ -38,12 +39,22 @@ func (t *rbTree) Decode(src mpio.DataReader) error {
 			return errors.Wrap(err, "read session encoded data")
 		}
 
+		if tombstone {
+			var sid types.Index
+			if len(buf) != tombstoneBytes || !types.IndexDecodeCheck(&sid, buf) {
+				return errors.New("invalid tombstone data").Int("tombstone-length", len(buf))
+			}
+
+			t.SaveTombstone(repeat, sid)
+			continue
+		}
+
 		var s types.Session
 		if err := types.SessionDecode(&s, buf); err != nil {
 			return errors.Wrap(err, "decode session data")
 		}
 
-		t.SaveSession(repeat, s)
+		t.SaveSessionPriority(repeat, prio, s)
 	}
 
 	return nil
@@ -1,11 +1,17 @@
 package state
 
 import (
+	"github.com/sirkon/mpy6a/internal/datadir"
 	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
 	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/types"
 	"github.com/sirkon/mpy6a/internal/uvarints"
 )
 
+// sourceBlockSize размер блока файлов источников.
+const sourceBlockSize = 64 * 1024
+
 // Encode кодирование данных дерева для создания слепка.
 func (t *rbTree) Encode(dst *sourceio.Writer) error {
 	if _, err := uvarints.Write(dst, uint64(t.size)); err != nil {
@@ -24,15 +30,45 @@ func (t *rbTree) Dump(w *sourceio.Writer) error {
 	return t.write(w)
 }
 
+// DumpFile сброс данных состояния в новый файл источника id в директории
+// dir, время повтора в дереве задано в разрешении res. Файл пишется под
+// временным именем и публикуется только целиком. Возвращает подвал
+// записанного файла.
+func (t *rbTree) DumpFile(dir string, id types.Index, res types.RepeatResolution) (*sourceio.Footer, error) {
+	return t.DumpFileFS(fsio.OS{}, dir, id, res)
+}
+
+// DumpFileFS сброс данных состояния в новый файл источника id в директории
+// dir файловой системы fsys, см. DumpFile.
+func (t *rbTree) DumpFileFS(
+	fsys fsio.FS,
+	dir string,
+	id types.Index,
+	res types.RepeatResolution,
+) (*sourceio.Footer, error) {
+	tmp := datadir.TempName(dir, datadir.TempFlush)
+	footer, err := sourceio.WriteFileFS(fsys, tmp, datadir.SourceName(dir, id), sourceBlockSize, res, t.Dump)
+	if err != nil {
+		return nil, errors.Wrap(err, "write source file").Stg("source-id", id)
+	}
+
+	return footer, nil
+}
+
 func (t *rbTree) write(w *sourceio.Writer) error {
 	iter := t.Iter()
 	for iter.Next() {
 		item := iter.Item()
-		for _, sess := range item.Sessions {
-			if err := w.SaveSession(item.Repeat, &sess); err != nil {
+		for i, sess := range item.Sessions {
+			if err := w.SaveSessionPriority(item.Repeat, item.Priority(i), &sess); err != nil {
 				return errors.Wrap(err, "save session").SessionID(sess.ID)
 			}
 		}
+		for _, sid := range item.Tombstones {
+			if err := w.SaveTombstone(item.Repeat, sid); err != nil {
+				return errors.Wrap(err, "save tombstone").SessionID(sid)
+			}
+		}
 	}
 
 	return nil
@@ -2,9 +2,13 @@ package state
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
 	"github.com/sirkon/mpy6a/internal/errors"
 	"github.com/sirkon/mpy6a/internal/sourceio"
 	"github.com/sirkon/mpy6a/internal/tlog"
@@ -106,6 +110,273 @@ func TestMergeIterators(t *testing.T) {
 	}
 }
 
+func TestSourceFooterSeek(t *testing.T) {
+	rb := newRBTree()
+	for i := uint64(1); i <= 100; i++ {
+		for j := uint64(0); j < 3; j++ {
+			id := types.NewIndex(i, j)
+			rb.SaveSession(i*10, types.NewSession(id, uint32(i), []byte(id.String())))
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := sourceio.NewFileWriter(&buf, 256, types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create source writer"))
+		return
+	}
+	if err := rb.Dump(w); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump session data"))
+		return
+	}
+	if err := w.Finish(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "finish source"))
+		return
+	}
+
+	src := bytes.NewReader(buf.Bytes())
+	file, err := sourceio.NewFile(src, src.Size())
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "open source"))
+		return
+	}
+	footer := file.Footer()
+
+	var histogram uint64
+	for _, v := range footer.Histogram {
+		histogram += v
+	}
+	type summary struct {
+		Count     uint64
+		MinRepeat uint64
+		MaxRepeat uint64
+		Histogram uint64
+	}
+	deepequal.SideBySide(
+		t,
+		"footer summary",
+		summary{
+			Count:     300,
+			MinRepeat: 10,
+			MaxRepeat: 1000,
+			Histogram: 300,
+		},
+		summary{
+			Count:     footer.Count,
+			MinRepeat: footer.MinRepeat,
+			MaxRepeat: footer.MaxRepeat,
+			Histogram: histogram,
+		},
+	)
+	if len(footer.Blocks) < 2 {
+		t.Errorf("expected several blocks, got %d", len(footer.Blocks))
+	}
+
+	// Фильтр сессий находит все сохранённые сессии и отсеивает почти все
+	// прочие.
+	var falsePositives int
+	for i := uint64(1); i <= 100; i++ {
+		for j := uint64(0); j < 3; j++ {
+			if id := types.NewIndex(i, j); !footer.MayContain(id) {
+				t.Errorf("session %s is filtered out", id)
+			}
+		}
+		if footer.MayContain(types.NewIndex(i, 3)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 10 {
+		t.Errorf("too many false positives of the session filter: %d", falsePositives)
+	}
+
+	for _, repeat := range []uint64{1, 10, 505, 510, 1000} {
+		it, err := file.Iterator(64)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "create iterator"))
+			return
+		}
+		if _, err := it.Seek(footer, repeat); err != nil {
+			tlog.Error(t, errors.Wrap(err, "seek").Uint64("repeat", repeat))
+			return
+		}
+
+		var count int
+		for it.Next() {
+			_, r, s := it.RepeatData()
+			if count == 0 {
+				expected := (repeat + 9) / 10 * 10
+				if r != expected || s.ID.Index != 0 {
+					tlog.Error(t, errors.New("unexpected first session after seek").
+						Uint64("repeat", repeat).
+						Uint64("session-repeat", r).
+						Stg("session-id", s.ID))
+					return
+				}
+			}
+			count++
+		}
+		if err := it.Err(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "iterate after seek").Uint64("repeat", repeat))
+			return
+		}
+		if uint64(count) != 300-3*((repeat-1)/10) {
+			tlog.Error(t, errors.New("unexpected count of sessions after seek").
+				Uint64("repeat", repeat).
+				Int("count", count))
+		}
+	}
+}
+
+func TestSourceFileCorruption(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "source")
+
+	rb := sampleTree()
+	var buf bytes.Buffer
+	w, err := sourceio.NewFileWriter(&buf, 32, types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create source writer"))
+		return
+	}
+	if err := rb.Dump(w); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump session data"))
+		return
+	}
+	if err := w.Finish(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "finish source"))
+		return
+	}
+
+	verify := func(data []byte) error {
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			return errors.Wrap(err, "write source file")
+		}
+
+		f, err := sourceio.Open(name)
+		if err != nil {
+			return errors.Wrap(err, "open source file")
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				tlog.Error(t, errors.Wrap(err, "close source file"))
+			}
+		}()
+
+		return f.Verify()
+	}
+
+	if err := verify(buf.Bytes()); err != nil {
+		tlog.Error(t, errors.Wrap(err, "verify intact source"))
+		return
+	}
+
+	for _, off := range []int{8, 20, 40, len(buf.Bytes()) - 20} {
+		data := bytes.Clone(buf.Bytes())
+		data[off] ^= 0xff
+
+		err := verify(data)
+		var corrupted sourceio.ErrorSourceCorrupted
+		if !errors.As(err, &corrupted) {
+			tlog.Error(t, errors.New("corruption was not detected").Int("corrupted-offset", off).Str("error", fmt.Sprint(err)))
+			continue
+		}
+		tlog.Log(t, errors.Wrap(err, "expected error").Int("corrupted-offset", off))
+	}
+
+	qname, err := sourceio.Quarantine(name)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "quarantine source"))
+		return
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("source file %s must be moved to %s", name, qname)
+	}
+
+	// Файлы старого формата остаются читаемыми.
+	buf.Reset()
+	lw := sourceio.NewWriter(&buf, 1024)
+	if err := rb.Dump(lw); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump legacy session data"))
+		return
+	}
+	if err := lw.Flush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "flush legacy session data"))
+		return
+	}
+	if err := verify(buf.Bytes()); err != nil {
+		tlog.Error(t, errors.Wrap(err, "verify legacy source"))
+	}
+}
+
+func TestSourceQuarantineCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	aID := types.NewIndex(1, 10)
+	bID := types.NewIndex(1, 11)
+	for _, id := range []types.Index{aID, bID} {
+		if _, err := sampleTree().DumpFile(dir, id, types.RepeatSecond); err != nil {
+			tlog.Error(t, errors.Wrap(err, "dump source").Stg("source-id", id))
+			return
+		}
+	}
+
+	bName := datadir.SourceName(dir, bID)
+	data, err := os.ReadFile(bName)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read source"))
+		return
+	}
+	data[40] ^= 0xff
+	if err := os.WriteFile(bName, data, 0644); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write corrupted source"))
+		return
+	}
+
+	// Слияние помещает в карантин только повреждённый файл.
+	_, err = sourceio.MergeFiles(
+		filepath.Join(dir, "merge.tmp"),
+		filepath.Join(dir, "merged"),
+		datadir.SourceName(dir, aID),
+		bName,
+		32,
+		types.RepeatSecond,
+	)
+	var corrupted sourceio.ErrorSourceCorrupted
+	if !errors.As(err, &corrupted) {
+		t.Errorf("expected source corruption error on merge, got %v", err)
+	}
+	tlog.Log(t, errors.Wrap(err, "expected merge error"))
+	if _, err := os.Stat(bName + ".corrupted"); err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat quarantined source"))
+	}
+	if _, err := os.Stat(datadir.SourceName(dir, aID)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat intact source"))
+	}
+
+	// Подключение повреждённого зарегистрированного источника.
+	if err := os.WriteFile(bName, data, 0644); err != nil {
+		tlog.Error(t, errors.Wrap(err, "write corrupted source"))
+		return
+	}
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{}
+	if err := descs.AddSource(bID, uint64(len(data))); err != nil {
+		tlog.Error(t, errors.Wrap(err, "add source"))
+		return
+	}
+	err = s.LoadSources(dir, descs)
+	if !errors.As(err, &corrupted) {
+		t.Errorf("expected source corruption error on load, got %v", err)
+	}
+	tlog.Log(t, errors.Wrap(err, "expected load error"))
+	if _, err := os.Stat(bName); !os.IsNotExist(err) {
+		t.Errorf("source file %s must be quarantined", bName)
+	}
+}
+
 func modifyRBTree(t *rbTreeNode) {
 	if t == nil {
 		return
@@ -128,3 +399,55 @@ func sampleTree() *rbTree {
 	rb.SaveSession(200, types.NewSession(types.NewIndex(1, 4), 200, []byte("qwerty")))
 	return rb
 }
+
+func TestSourcePlanMerge(t *testing.T) {
+	// Подвал источника с 10 сессиями на каждое время повтора из repeats.
+	// Каждая сессия пишется в свой блок, так что гистограмма точна.
+	var seq uint64
+	footer := func(repeats ...uint64) *sourceio.Footer {
+		w, err := sourceio.NewFileWriter(&bytes.Buffer{}, 1, types.RepeatSecond)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "create source writer"))
+			t.FailNow()
+		}
+		for _, r := range repeats {
+			for i := 0; i < 10; i++ {
+				seq++
+				sess := types.NewSession(types.NewIndex(1, seq), 1, []byte("data"))
+				if err := w.SaveSession(r, &sess); err != nil {
+					tlog.Error(t, errors.Wrap(err, "save session").Uint64("repeat", r))
+					t.FailNow()
+				}
+			}
+		}
+		if err := w.Finish(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "finish source"))
+			t.FailNow()
+		}
+
+		return w.Footer()
+	}
+
+	footers := []*sourceio.Footer{
+		footer(10, 20, 30),
+		footer(100),
+		footer(5, 50),
+		footer(300, 400, 500),
+	}
+	if i := sourceio.PlanMerge(footers[:1], 0); i != -1 {
+		t.Errorf("expected no merge of a single source, got pair %d", i)
+	}
+	// Без наступивших сессий сливаются самые маленькие соседи.
+	if i := sourceio.PlanMerge(footers, 0); i != 1 {
+		t.Errorf("expected pair 1 to be merged, got %d", i)
+	}
+	// Первый источник будет выдан целиком, выгоднее слить его.
+	if i := sourceio.PlanMerge(footers, 31); i != 0 {
+		t.Errorf("expected pair 0 to be merged, got %d", i)
+	}
+	// Сессии источника с повтором не позже горизонта считаются по
+	// гистограмме.
+	if n := footers[2].CountBefore(31); n != 10 {
+		t.Errorf("expected 10 sessions of source 2 before the horizon, got %d", n)
+	}
+}
@@ -0,0 +1,230 @@
+package state
+
+import (
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// ReleaseLimit ограничение скорости выдачи сессий темы на повтор.
+// Нулевое значение означает отсутствие ограничения.
+type ReleaseLimit struct {
+	// Rate число сессий в секунду.
+	Rate float64
+
+	// Burst наибольшее число сессий выдаваемых разом, не меньше 1.
+	Burst int
+}
+
+// ReleaseLimiter ограничение скорости выдачи сессий на повтор по темам:
+// у каждой темы своя корзина токенов. Сессии не прошедшие ограничение
+// остаются ждать следующей выдачи, т.е. повторяются позже, но никогда
+// не раньше своего времени повтора, см. State.ReleaseDue. Токены
+// расходуются только за проведённую выдачу, см. State.ReleaseCommitted.
+type ReleaseLimiter struct {
+	def     ReleaseLimit
+	themes  map[uint32]ReleaseLimit
+	buckets map[uint32]*tokenBucket
+}
+
+// NewReleaseLimiter конструктор ограничения с ограничением def для всех
+// тем кроме перечисленных в themes.
+func NewReleaseLimiter(def ReleaseLimit, themes map[uint32]ReleaseLimit) *ReleaseLimiter {
+	return &ReleaseLimiter{
+		def:     def,
+		themes:  themes,
+		buckets: map[uint32]*tokenBucket{},
+	}
+}
+
+// Available число сессий темы theme, которые можно выдать в момент now,
+// отрицательное значение означает отсутствие ограничения. Токены не
+// расходуются. Допускается вызов на nil.
+func (l *ReleaseLimiter) Available(theme uint32, now time.Time) int {
+	b := l.bucket(theme, now)
+	if b == nil {
+		return -1
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		// Корзина может уйти в долг, см. Take.
+		return 0
+	}
+
+	return int(b.tokens)
+}
+
+// Take расход токена темы theme за сессию выданную в момент now. Токен
+// расходуется даже из пустой корзины: выдача уже проведена, и долг
+// покрывается последующим пополнением. Допускается вызов на nil.
+func (l *ReleaseLimiter) Take(theme uint32, now time.Time) {
+	b := l.bucket(theme, now)
+	if b == nil {
+		return
+	}
+
+	b.refill(now)
+	b.tokens--
+}
+
+// bucket корзина темы theme, nil если выдача темы не ограничена.
+func (l *ReleaseLimiter) bucket(theme uint32, now time.Time) *tokenBucket {
+	if l == nil {
+		return nil
+	}
+
+	b, ok := l.buckets[theme]
+	if !ok {
+		limit, ok := l.themes[theme]
+		if !ok {
+			limit = l.def
+		}
+		if limit.Rate <= 0 {
+			return nil
+		}
+
+		b = newTokenBucket(limit, now)
+		l.buckets[theme] = b
+	}
+
+	return b
+}
+
+// tokenBucket корзина токенов одной темы.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit ReleaseLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// refill пополнение корзины на момент now.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// SetReleaseLimiter установка ограничения скорости выдачи сессий на
+// повтор, nil снимает ограничение.
+func (s *State) SetReleaseLimiter(l *ReleaseLimiter) {
+	s.release = l
+}
+
+// ReleaseLimiter ограничение скорости выдачи сессий на повтор.
+func (s *State) ReleaseLimiter() *ReleaseLimiter {
+	return s.release
+}
+
+// releaseScanFactor во сколько раз число просмотренных при поиске выдачи
+// наступивших сессий может превышать число выдаваемых, см. ReleaseDue.
+const releaseScanFactor = 16
+
+// ReleaseDue сессии для выдачи на повтор: не более limit сохранённых
+// сессий с наиболее ранним повтором, время повтора которых наступило по
+// системному времени состояния, с учётом ограничения скорости по темам.
+// Сессии ищутся так же, как в Restore, в памяти и в источниках описаний
+// descs лежащих в директории dir.
+//
+// Сессии тем исчерпавших ограничение пропускаются, и поиск идёт дальше,
+// так что они не задерживают более поздние сессии других тем. Чтобы
+// поиск оставался дешёвым, просматривается не более releaseScanFactor*limit
+// наступивших сессий.
+//
+// Состояние не меняется: выдача проводится реплицируемой операцией
+// RestoreSessions с идентификаторами найденных сессий. Токены ограничения
+// при поиске не расходуются, это делает ReleaseCommitted после фиксации
+// операции.
+func (s *State) ReleaseDue(dir string, descs *Descriptors, limit int) ([]types.Session, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	now := s.systime.Get()
+	for n := limit; ; n *= 2 {
+		candidates, err := s.restoreCandidates(dir, descs, n)
+		if err != nil {
+			return nil, errors.Wrap(err, "look for due sessions")
+		}
+
+		res, done := s.releaseCandidates(candidates, n, limit, now)
+		if done || n >= releaseScanFactor*limit {
+			return res, nil
+		}
+	}
+}
+
+// releaseCandidates отбор до limit наступивших к моменту now сессий
+// среди первых n кандидатов на выдачу с учётом ограничения скорости.
+// Флаг done означает, что просмотр большего числа кандидатов ничего не
+// добавит.
+func (s *State) releaseCandidates(
+	candidates []sessionLocation,
+	n int,
+	limit int,
+	now time.Time,
+) (res []types.Session, done bool) {
+	// Кандидатов меньше n, только если сохранённые сессии кончились.
+	done = len(candidates) < n
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	repeat := s.resolution.Repeat(now)
+	available := map[uint32]int{}
+	for _, c := range candidates {
+		if c.repeat > repeat {
+			return res, true
+		}
+
+		theme := c.sess.Theme
+		tokens, ok := available[theme]
+		if !ok {
+			tokens = s.release.Available(theme, now)
+		}
+		if tokens == 0 {
+			continue
+		}
+		if tokens > 0 {
+			tokens--
+		}
+		available[theme] = tokens
+
+		res = append(res, c.sess)
+		if len(res) == limit {
+			return res, true
+		}
+	}
+
+	return res, done
+}
+
+// ReleaseCommitted учёт выданных на повтор сессий sessions в ограничении
+// скорости, см. ReleaseDue. Вызывается после фиксации операции выдачи:
+// несостоявшаяся выдача не должна расходовать токены.
+func (s *State) ReleaseCommitted(sessions []types.Session) {
+	now := s.systime.Get()
+	for i := range sessions {
+		s.release.Take(sessions[i].Theme, now)
+	}
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestStateReleaseDue(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.SetReleaseLimiter(NewReleaseLimiter(ReleaseLimit{}, map[uint32]ReleaseLimit{
+		1: {Rate: 1, Burst: 2},
+	}))
+
+	sessions := []types.Session{
+		types.NewSession(types.NewIndex(1, 1), 1, []byte("limited 1")),
+		types.NewSession(types.NewIndex(1, 2), 1, []byte("limited 2")),
+		types.NewSession(types.NewIndex(1, 3), 1, []byte("limited 3")),
+		types.NewSession(types.NewIndex(1, 4), 2, []byte("unlimited")),
+		types.NewSession(types.NewIndex(1, 5), 2, []byte("not yet")),
+	}
+	for i := range sessions {
+		s.active[sessions[i].ID] = &sessions[i]
+	}
+	for i, sess := range sessions {
+		repeat := uint64(10)
+		if i == len(sessions)-1 {
+			repeat = 20
+		}
+		if err := s.Store(sess.ID, repeat); err != nil {
+			tlog.Error(t, errors.Wrap(err, "store session").SessionID(sess.ID))
+			return
+		}
+	}
+
+	// Выдача проводится операцией лога.
+	var rec logop.Recorder
+	descs := &Descriptors{}
+	a := NewApplier(s, dir, descs, nil)
+	id := types.NewIndex(2, 0)
+	due := func(limit int) []types.Session {
+		due, err := s.ReleaseDue(dir, descs, limit)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "look for due sessions"))
+			return nil
+		}
+		return due
+	}
+	release := func(limit int) []types.Index {
+		due := due(limit)
+		if len(due) == 0 {
+			return nil
+		}
+
+		var res []types.Index
+		for _, sess := range due {
+			res = append(res, sess.ID)
+		}
+
+		id = types.IndexIncIndex(id)
+		if err := a.Apply(id, rec.RestoreSessions(res)); err != nil {
+			tlog.Error(t, errors.Wrap(err, "apply restore"))
+			return nil
+		}
+		s.ReleaseCommitted(due)
+
+		for _, sid := range res {
+			if _, ok := s.active[sid]; !ok {
+				t.Errorf("session %s must be restored", sid)
+			}
+		}
+		return res
+	}
+
+	s.systime.Set(time.Unix(9, 0))
+	deepequal.SideBySide(t, "before repeat time", []types.Index(nil), release(10))
+
+	s.systime.Set(time.Unix(10, 0))
+	// Без фиксации выдачи токены не расходуются.
+	if n := len(due(10)); n != 3 {
+		t.Errorf("expected 3 due sessions, got %d", n)
+	}
+	// Сессия исчерпавшей ограничение темы не задерживает другие темы.
+	deepequal.SideBySide(t, "burst", []types.Index{sessions[0].ID, sessions[1].ID, sessions[3].ID}, release(10))
+	deepequal.SideBySide(t, "limited", []types.Index(nil), release(10))
+
+	s.systime.Set(time.Unix(11, 0))
+	deepequal.SideBySide(t, "refilled", []types.Index{sessions[2].ID}, release(10))
+
+	if s.saved.Len() != 1 || s.saved.Bytes() != types.SessionRawLen(&sessions[4]) {
+		t.Errorf("unexpected saved sessions: %d sessions of %d bytes", s.saved.Len(), s.saved.Bytes())
+	}
+
+	// Выдача несохранённой или дважды указанной сессии отклоняется целиком.
+	for _, sids := range [][]types.Index{
+		{sessions[4].ID, sessions[0].ID},
+		{sessions[4].ID, sessions[4].ID},
+	} {
+		id = types.IndexIncIndex(id)
+		err := a.Apply(id, rec.RestoreSessions(sids))
+		if code := staterr.AsCode(err); code != staterr.CodeSessionInvalidRequest {
+			t.Errorf("unexpected error code %s on restoring sessions %v", code, sids)
+		}
+	}
+	if s.saved.Len() != 1 {
+		t.Errorf("rejected restore must not change saved sessions, got %d", s.saved.Len())
+	}
+}
+
+func TestStateStoreJitter(t *testing.T) {
+	s, err := NewState(types.RepeatMillisecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.SetStoreJitter(time.Second)
+
+	const repeat = 10000
+	for i := uint64(1); i <= 100; i++ {
+		sess := types.NewSession(types.NewIndex(1, i), 1, []byte("data"))
+		s.active[sess.ID] = &sess
+		if err := s.Store(sess.ID, s.StoreRepeat(sess.ID, repeat)); err != nil {
+			tlog.Error(t, errors.Wrap(err, "store session").SessionID(sess.ID))
+			return
+		}
+	}
+
+	repeats := map[uint64]struct{}{}
+	iter := s.saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		if item.Repeat < repeat || item.Repeat > repeat+1000 {
+			t.Errorf("repeat time %d is out of jitter range", item.Repeat)
+		}
+		repeats[item.Repeat] = struct{}{}
+	}
+	if len(repeats) < 50 {
+		t.Errorf("sessions are not spread enough: %d distinct repeat times", len(repeats))
+	}
+
+	// Повторное предложение даёт то же время, применение разброс не добавляет.
+	if s.StoreRepeat(types.NewIndex(1, 1), repeat) != s.StoreRepeat(types.NewIndex(1, 1), repeat) {
+		t.Error("jitter must be deterministic")
+	}
+	sess := types.NewSession(types.NewIndex(1, 101), 1, []byte("data"))
+	s.active[sess.ID] = &sess
+	if err := s.Store(sess.ID, 2*repeat); err != nil {
+		tlog.Error(t, errors.Wrap(err, "store session without jitter"))
+		return
+	}
+	if _, found := s.saved.FindSessionAt(2*repeat, sess.ID); !found {
+		t.Error("stored session repeat time must not be changed")
+	}
+}
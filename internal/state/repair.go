@@ -0,0 +1,455 @@
+package state
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Результаты обработки источников при восстановлении, см. Repair.
+const (
+	// RepairSourceRegistered источник зарегистрирован.
+	RepairSourceRegistered = "registered"
+
+	// RepairSourceImported источник зарегистрирован применённой
+	// операцией загрузки.
+	RepairSourceImported = "imported"
+
+	// RepairSourceDuplicate сессии источника уже есть в восстановленном
+	// состоянии: это сброшенные на диск сессии из лога или результат
+	// слияния уже зарегистрированных источников.
+	RepairSourceDuplicate = "duplicate"
+
+	// RepairSourceDamaged источник повреждён и убран в карантин,
+	// см. sourceio.Quarantine.
+	RepairSourceDamaged = "damaged"
+
+	// RepairSourceConsumed все сессии источника уже изъяты применёнными
+	// операциями лога: выданы на повтор или отменены. Источник
+	// использован и не регистрируется.
+	RepairSourceConsumed = "consumed"
+
+	// RepairSourceSuspicious часть сессий источника изъята применёнными
+	// операциями лога, а часть в логах не встречается. Так не бывает
+	// при целых логах, поэтому источник не регистрируется, а решение о
+	// нём остаётся за оператором.
+	RepairSourceSuspicious = "suspicious"
+)
+
+// RepairLog найденный лог операций.
+type RepairLog struct {
+	Name  string
+	ID    types.Index
+	First types.Index
+	Last  types.Index
+
+	// Err ошибка чтения лога, события после неё не применены.
+	Err error
+}
+
+// RepairGap разрыв между событиями логов, который не удалось преодолеть.
+type RepairGap struct {
+	After  types.Index
+	Before types.Index
+}
+
+// RepairEvent событие, которое не удалось применить.
+type RepairEvent struct {
+	ID  types.Index
+	Err error
+}
+
+// RepairSource найденный источник.
+type RepairSource struct {
+	Name   string
+	ID     types.Index
+	Status string
+	Err    error
+}
+
+// RepairReport отчёт о восстановлении состояния.
+type RepairReport struct {
+	// Snapshot имя записанного слепка.
+	Snapshot string
+
+	// ID идентификатор восстановленного состояния.
+	ID types.Index
+
+	// Events количество применённых событий.
+	Events uint64
+
+	Logs    []RepairLog
+	Gaps    []RepairGap
+	Failed  []RepairEvent
+	Sources []RepairSource
+}
+
+// Clean проверка, что восстановление прошло без потерь: логи прочитаны
+// целиком, разрывов нет, все события применены, источники не повреждены.
+func (r *RepairReport) Clean() bool {
+	if len(r.Gaps) > 0 || len(r.Failed) > 0 {
+		return false
+	}
+
+	for _, l := range r.Logs {
+		if l.Err != nil {
+			return false
+		}
+	}
+	for _, s := range r.Sources {
+		if s.Status == RepairSourceDamaged || s.Status == RepairSourceSuspicious {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Repair восстановление состояния директории dir без слепка. Состояние
+// с разрешением времени повтора res строится применением всех событий
+// уцелевших логов операций начиная с самого раннего, см. Applier, время
+// повтора сохраняемых сессий без явного его указания задаётся функцией
+// defaultRepeat. После этого регистрируются исправные источники, сессий
+// которых нет в построенном состоянии и которые не были изъяты
+// применёнными операциями. Повреждённые источники убираются в карантин. Результат записывается новым слепком, имя которого
+// добавляется в лог имён слепков.
+//
+// Разрывы между логами и события, которые не удалось применить,
+// попадают в отчёт, ошибка возвращается только если восстановление
+// провести невозможно.
+func Repair(
+	dir string,
+	res types.RepeatResolution,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*RepairReport, error) {
+	return RepairFS(fsio.OS{}, dir, res, defaultRepeat, logger)
+}
+
+// RepairFS восстановление состояния директории dir файловой системы
+// fsys, см. Repair.
+func RepairFS(
+	fsys fsio.FS,
+	dir string,
+	res types.RepeatResolution,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*RepairReport, error) {
+	s, err := NewState(res, MemoryLimits{})
+	if err != nil {
+		return nil, errors.Wrap(err, "create state")
+	}
+
+	report := &RepairReport{}
+	logs, sources, err := repairScanDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "scan data directory")
+	}
+
+	descs := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{},
+	}
+	descs.SetFS(fsys)
+	for _, l := range logs {
+		rl, desc := repairLogRange(fsys, datadir.LogName(dir, l), l)
+		report.Logs = append(report.Logs, rl)
+		if desc == nil {
+			continue
+		}
+
+		descs.usedLogs = append(descs.usedLogs, desc)
+	}
+	if len(descs.usedLogs) == 0 {
+		return nil, errors.New("no readable oplogs in the data directory")
+	}
+
+	sort.Slice(descs.usedLogs, func(i, j int) bool {
+		return types.IndexLess(descs.usedLogs[i].firstID, descs.usedLogs[j].firstID)
+	})
+	descs.log = descs.usedLogs[len(descs.usedLogs)-1]
+	descs.usedLogs = descs.usedLogs[:len(descs.usedLogs)-1]
+
+	a := NewApplier(s, dir, descs, defaultRepeat)
+	a.consumed = map[types.Index]struct{}{}
+	for _, l := range descs.logsChain() {
+		if err := repairReplayLog(report, a, fsys, datadir.LogName(dir, l.id), l.lastID); err != nil {
+			return nil, errors.Wrap(err, "replay log").Stg("log-id", l.id)
+		}
+	}
+
+	if err := repairSources(report, s, dir, descs, sources, a.consumed); err != nil {
+		return nil, errors.Wrap(err, "register sources")
+	}
+
+	if err := s.LoadSources(dir, descs); err != nil {
+		return nil, errors.Wrap(err, "load sources")
+	}
+
+	name, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		return nil, errors.Wrap(err, "write snapshot")
+	}
+	snaps := logio.NewSnapshotsFS(fsys, datadir.SnapshotsLogName(dir), logger)
+	if err := snaps.WriteName(filepath.Base(name)); err != nil {
+		return nil, errors.Wrap(err, "record snapshot name").Str("snapshot-name", name)
+	}
+
+	report.Snapshot = name
+	report.ID = s.id
+	return report, nil
+}
+
+// repairScanDir поиск логов и источников в директории dir файловой
+// системы fsys.
+func repairScanDir(fsys fsio.FS, dir string) (logs []types.Index, sources []types.Index, err error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read directory")
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		if id, ok := datadir.ParseLogName(e.Name()); ok {
+			logs = append(logs, id)
+			continue
+		}
+		if id, ok := datadir.ParseSourceName(e.Name()); ok {
+			sources = append(sources, id)
+		}
+	}
+
+	sortIndices(sources)
+	return logs, sources, nil
+}
+
+// repairLogRange вычитка диапазона событий лога id. Описание лога
+// не возвращается, если в нём нет ни одного события.
+func repairLogRange(fsys fsio.FS, name string, id types.Index) (RepairLog, *logDescriptor) {
+	res := RepairLog{
+		Name: name,
+		ID:   id,
+	}
+
+	stat, err := fsys.Stat(name)
+	if err != nil {
+		res.Err = errors.Wrap(err, "stat log")
+		return res, nil
+	}
+
+	it, err := logio.NewReaderFS(fsys, name)
+	if err != nil {
+		res.Err = errors.Wrap(err, "open log")
+		return res, nil
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = it.Close()
+	}()
+
+	for it.Next() {
+		eid, _, _ := it.Event()
+		if res.First.Term == 0 {
+			res.First = eid
+		}
+		res.Last = eid
+	}
+	if err := it.Err(); err != nil {
+		res.Err = errors.Wrap(err, "read events").Stg("last-read-id", res.Last)
+	}
+
+	if res.First.Term == 0 {
+		return res, nil
+	}
+
+	return res, &logDescriptor{
+		id:      id,
+		firstID: res.First,
+		lastID:  res.Last,
+		len:     uint64(stat.Size()),
+	}
+}
+
+// repairReplayLog применение событий лога name до last включительно.
+// События не позже состояния пропускаются, их уже применили из других
+// логов. Пропуски событий перед применяемым попадают в отчёт.
+func repairReplayLog(report *RepairReport, a *Applier, fsys fsio.FS, name string, last types.Index) error {
+	it, err := logio.NewReaderFS(fsys, name, logio.ReaderReadTo(last))
+	if err != nil {
+		return errors.Wrap(err, "open log")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = it.Close()
+	}()
+
+	for it.Next() {
+		id, data, _ := it.Event()
+		if !types.IndexLess(a.s.id, id) {
+			continue
+		}
+
+		if a.s.id.Term != 0 && !types.IndexFollows(a.s.id, id) {
+			report.Gaps = append(report.Gaps, RepairGap{
+				After:  a.s.id,
+				Before: id,
+			})
+		}
+
+		if err := a.Apply(id, data); err != nil {
+			report.Failed = append(report.Failed, RepairEvent{
+				ID:  id,
+				Err: err,
+			})
+
+			// Событие пропускается, но состояние всё равно
+			// относится к нему.
+			a.s.id = id
+			continue
+		}
+
+		report.Events++
+	}
+
+	// Ошибка чтения уже учтена при вычитке диапазона лога.
+	return nil
+}
+
+// repairSources регистрация источников sources: исправных, не
+// пересекающихся по сессиям с состоянием и не содержащих сессий
+// изъятых применёнными операциями consumed.
+func repairSources(
+	report *RepairReport,
+	s *State,
+	dir string,
+	descs *Descriptors,
+	sources []types.Index,
+	consumed map[types.Index]struct{},
+) error {
+	for _, id := range sources {
+		name := datadir.SourceName(dir, id)
+		if descs.sourceRegistered(id) {
+			report.Sources = append(report.Sources, RepairSource{
+				Name:   name,
+				ID:     id,
+				Status: RepairSourceImported,
+			})
+			continue
+		}
+
+		size, err := verifySourceFile(descs.FS(), name)
+		if err != nil {
+			qname, qErr := sourceio.QuarantineFS(descs.FS(), name)
+			if qErr != nil {
+				return errors.Wrap(qErr, "quarantine damaged source").
+					Stg("source-id", id).
+					Str("damage", err.Error())
+			}
+
+			report.Sources = append(report.Sources, RepairSource{
+				Name:   qname,
+				ID:     id,
+				Status: RepairSourceDamaged,
+				Err:    err,
+			})
+			continue
+		}
+
+		places := map[types.Index]SessionPlace{}
+		if _, err := scanSource(descs.FS(), name, s.resolution, nil, func(place SessionPlace, sess *types.Session) bool {
+			places[sess.ID] = place
+			return true
+		}); err != nil {
+			return errors.Wrap(err, "scan source").Stg("source-id", id)
+		}
+
+		status := RepairSourceRegistered
+		var cErr error
+		if err := s.checkCollisions(dir, descs, places); err != nil {
+			if staterr.AsCode(err) != staterr.CodeSessionIDCollision {
+				return errors.Wrap(err, "check session ids").Stg("source-id", id)
+			}
+
+			status = RepairSourceDuplicate
+			cErr = err
+		} else {
+			status, cErr = repairSourceConsumption(places, consumed)
+		}
+		if status == RepairSourceRegistered {
+			if err := descs.AddSource(id, size); err != nil {
+				return errors.Wrap(err, "register source")
+			}
+		}
+
+		report.Sources = append(report.Sources, RepairSource{
+			Name:   name,
+			ID:     id,
+			Status: status,
+			Err:    cErr,
+		})
+	}
+
+	return nil
+}
+
+// repairSourceConsumption статус источника с сессиями places по изъятым
+// применёнными операциями сессиям consumed: RepairSourceRegistered,
+// если изъятых среди них нет, RepairSourceConsumed, если изъяты все, и
+// RepairSourceSuspicious с описанием ошибки в остальных случаях.
+func repairSourceConsumption(
+	places map[types.Index]SessionPlace,
+	consumed map[types.Index]struct{},
+) (string, error) {
+	var used []types.Index
+	for sid := range places {
+		if _, ok := consumed[sid]; ok {
+			used = append(used, sid)
+		}
+	}
+
+	switch len(used) {
+	case 0:
+		return RepairSourceRegistered, nil
+	case len(places):
+		return RepairSourceConsumed, nil
+	}
+
+	sortIndices(used)
+	return RepairSourceSuspicious, errors.New("some of source sessions were already consumed by replayed operations").
+		Int("consumed-sessions", len(used)).
+		Int("source-sessions", len(places)).
+		SessionID(used[0])
+}
+
+// verifySourceFile полная проверка файла источника name файловой системы
+// fsys, см. sourceio.File.Verify. Возвращает длину файла.
+func verifySourceFile(fsys fsio.FS, name string) (uint64, error) {
+	f, err := sourceio.OpenFS(fsys, name)
+	if err != nil {
+		return 0, errors.Wrap(err, "open source")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = f.Close()
+	}()
+
+	if err := f.Verify(); err != nil {
+		return 0, errors.Wrap(err, "verify source")
+	}
+
+	stat, err := fsys.Stat(name)
+	if err != nil {
+		return 0, errors.Wrap(err, "stat source")
+	}
+
+	return uint64(stat.Size()), nil
+}
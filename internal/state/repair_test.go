@@ -0,0 +1,264 @@
+package state
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestRepair(t *testing.T) {
+	var rec logop.Recorder
+	first := [][]byte{
+		rec.New(1),
+		rec.Record(types.NewIndex(2, 0), []byte("data")),
+		rec.Store(types.NewIndex(2, 0), 10),
+		rec.New(2),
+	}
+
+	writeSourceFS := func(t *testing.T, fsys fsio.FS, dir string, id types.Index, repeat uint64, sess types.Session) {
+		tmp := datadir.TempName(dir, datadir.TempFlush)
+		_, err := sourceio.WriteFileFS(fsys, tmp, datadir.SourceName(dir, id), 1024, types.RepeatSecond, func(w *sourceio.Writer) error {
+			return w.SaveSession(repeat, &sess)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "write source").Stg("source-id", id))
+		}
+	}
+	writeSource := func(t *testing.T, dir string, id types.Index, repeat uint64, sess types.Session) {
+		writeSourceFS(t, fsio.OS{}, dir, id, repeat, sess)
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		dir := t.TempDir()
+		writeOpsLog(t, dir, types.NewIndex(2, 0), first)
+		writeOpsLog(t, dir, types.NewIndex(2, 4), [][]byte{
+			rec.Store(types.NewIndex(2, 3), 20),
+			rec.Restore(1),
+		})
+
+		// Сброшенная копия сохранённой сессии из лога, сессии из времён
+		// до уцелевших логов и повреждённый источник.
+		stored := types.NewSession(types.NewIndex(2, 3), 2, nil)
+		stored.ChangeID = types.NewIndex(2, 3)
+		writeSource(t, dir, types.NewIndex(2, 4), 20, stored)
+		writeSource(t, dir, types.NewIndex(1, 50), 5, types.NewSession(types.NewIndex(1, 5), 3, []byte("old")))
+		if err := os.WriteFile(datadir.SourceName(dir, types.NewIndex(2, 5)), []byte("garbage"), 0644); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write damaged source"))
+			return
+		}
+		if t.Failed() {
+			return
+		}
+
+		report, err := Repair(dir, types.RepeatSecond, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "repair"))
+			return
+		}
+
+		if !types.IndexEqual(report.ID, types.NewIndex(2, 5)) || report.Events != 6 {
+			t.Errorf("unexpected state %s after %d events", report.ID, report.Events)
+		}
+		if len(report.Gaps) > 0 || len(report.Failed) > 0 {
+			t.Errorf("unexpected gaps %v or failed events %v", report.Gaps, report.Failed)
+		}
+
+		var statuses []string
+		for _, s := range report.Sources {
+			statuses = append(statuses, s.ID.String()+" "+s.Status)
+		}
+		deepequal.SideBySide(t, "sources", []string{
+			types.NewIndex(1, 50).String() + " " + RepairSourceRegistered,
+			types.NewIndex(2, 4).String() + " " + RepairSourceDuplicate,
+			types.NewIndex(2, 5).String() + " " + RepairSourceDamaged,
+		}, statuses)
+		if report.Clean() {
+			t.Error("repair with a damaged source must not be clean")
+		}
+
+		verified, err := Verify(dir, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "verify repaired directory"))
+			return
+		}
+		for _, c := range verified.Checks {
+			if c.Err != nil {
+				tlog.Error(t, errors.Wrap(c.Err, c.Kind).Str("file-name", c.Name))
+			}
+		}
+	})
+
+	t.Run("consumed", func(t *testing.T) {
+		dir := t.TempDir()
+		writeOpsLog(t, dir, types.NewIndex(2, 0), first)
+		writeOpsLog(t, dir, types.NewIndex(2, 4), [][]byte{
+			rec.Store(types.NewIndex(2, 3), 20),
+			rec.RestoreSessions([]types.Index{types.NewIndex(2, 0)}),
+			rec.Delete(types.NewIndex(2, 0)),
+		})
+
+		// Сброшенная копия сессии, которую логи выдали на повтор, а затем
+		// удалили, и источник, где с ней лежит сессия неизвестная логам.
+		consumed := types.NewSession(types.NewIndex(2, 0), 1, []byte("data"))
+		consumed.ChangeID = types.NewIndex(2, 2)
+		writeSource(t, dir, types.NewIndex(2, 3), 10, consumed)
+		_, err := sourceio.WriteFile(
+			datadir.TempName(dir, datadir.TempFlush),
+			datadir.SourceName(dir, types.NewIndex(2, 4)),
+			1024,
+			types.RepeatSecond,
+			func(w *sourceio.Writer) error {
+				old := types.NewSession(types.NewIndex(1, 5), 3, []byte("old"))
+				if err := w.SaveSession(5, &old); err != nil {
+					return err
+				}
+
+				return w.SaveSession(10, &consumed)
+			},
+		)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "write mixed source"))
+			return
+		}
+		if t.Failed() {
+			return
+		}
+
+		report, err := Repair(dir, types.RepeatSecond, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "repair"))
+			return
+		}
+
+		if !types.IndexEqual(report.ID, types.NewIndex(2, 6)) || report.Events != 7 {
+			t.Errorf("unexpected state %s after %d events", report.ID, report.Events)
+		}
+
+		var statuses []string
+		for _, s := range report.Sources {
+			statuses = append(statuses, s.ID.String()+" "+s.Status)
+		}
+		deepequal.SideBySide(t, "sources", []string{
+			types.NewIndex(2, 3).String() + " " + RepairSourceConsumed,
+			types.NewIndex(2, 4).String() + " " + RepairSourceSuspicious,
+		}, statuses)
+		if report.Clean() {
+			t.Error("repair with a suspicious source must not be clean")
+		}
+
+		_, descs, err := ReadSnapshot(report.Snapshot, MemoryLimits{})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "read repaired snapshot"))
+			return
+		}
+		if srcs := descs.Sources(); len(srcs) != 0 {
+			t.Errorf("no sources must be registered, got %v", srcs)
+		}
+	})
+
+	t.Run("gap", func(t *testing.T) {
+		dir := t.TempDir()
+		writeOpsLog(t, dir, types.NewIndex(2, 0), first)
+		writeOpsLog(t, dir, types.NewIndex(2, 6), [][]byte{
+			rec.Record(types.NewIndex(2, 4), []byte("lost session")),
+			rec.Store(types.NewIndex(2, 3), 20),
+		})
+		if t.Failed() {
+			return
+		}
+
+		report, err := Repair(dir, types.RepeatSecond, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "repair"))
+			return
+		}
+
+		deepequal.SideBySide(t, "gaps", []RepairGap{
+			{After: types.NewIndex(2, 3), Before: types.NewIndex(2, 6)},
+		}, report.Gaps)
+		if len(report.Failed) != 1 || !types.IndexEqual(report.Failed[0].ID, types.NewIndex(2, 6)) {
+			t.Errorf("unexpected failed events %v", report.Failed)
+		}
+		if !types.IndexEqual(report.ID, types.NewIndex(2, 7)) || report.Events != 5 {
+			t.Errorf("unexpected state %s after %d events", report.ID, report.Events)
+		}
+		if report.Clean() {
+			t.Error("repair with gaps must not be clean")
+		}
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		const dir = "/data"
+		fsys := fsio.NewMem()
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			tlog.Error(t, errors.Wrap(err, "create data directory"))
+			return
+		}
+		writeOpsLogFS(t, fsys, dir, types.NewIndex(2, 0), first)
+		writeSourceFS(t, fsys, dir, types.NewIndex(1, 50), 5, types.NewSession(types.NewIndex(1, 5), 3, []byte("old")))
+		damaged, err := fsys.OpenFile(datadir.SourceName(dir, types.NewIndex(2, 5)), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "create damaged source"))
+			return
+		}
+		if _, err := damaged.Write([]byte("garbage")); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write damaged source"))
+			return
+		}
+		if err := damaged.Close(); err != nil {
+			tlog.Error(t, errors.Wrap(err, "close damaged source"))
+			return
+		}
+		if t.Failed() {
+			return
+		}
+
+		report, err := RepairFS(fsys, dir, types.RepeatSecond, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "repair"))
+			return
+		}
+		if !types.IndexEqual(report.ID, types.NewIndex(2, 3)) || report.Events != 4 {
+			t.Errorf("unexpected state %s after %d events", report.ID, report.Events)
+		}
+		if _, err := fsys.Stat(datadir.SourceName(dir, types.NewIndex(2, 5)) + ".corrupted"); err != nil {
+			tlog.Error(t, errors.Wrap(err, "stat quarantined source"))
+		}
+
+		verified, err := VerifyFS(fsys, dir, nil, func(err error) {
+			tlog.Log(t, err)
+		})
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "verify repaired directory"))
+			return
+		}
+		if verified.Failed() {
+			for _, c := range verified.Checks {
+				if c.Err != nil {
+					tlog.Error(t, errors.Wrap(c.Err, c.Kind).Str("file-name", c.Name))
+				}
+			}
+		}
+		if len(verified.Checks) == 0 {
+			t.Error("no checks were made")
+		}
+	})
+}
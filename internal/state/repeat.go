@@ -0,0 +1,105 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Resolution разрешение времени повтора состояния.
+func (s *State) Resolution() types.RepeatResolution {
+	return s.resolution
+}
+
+// RepeatNow текущее время повтора по системному времени состояния:
+// пора повторять сессии с временем повтора не больше него.
+func (s *State) RepeatNow() uint64 {
+	return s.resolution.Repeat(s.systime.Get())
+}
+
+// StoreAfter аналогично Store, но повтор назначается через delay после
+// текущего системного времени с округлением вверх до деления разрешения.
+func (s *State) StoreAfter(sid types.Index, delay time.Duration) error {
+	return s.Store(sid, s.resolution.After(s.systime.Get(), delay))
+}
+
+// SetStoreJitter задание наибольшего разброса времени повтора при
+// сохранении сессий: сессии сохранённые с одним временем повтора
+// распределяются по интервалу [repeat, repeat+jitter], что сглаживает
+// всплески повтора. Повтор только откладывается, но не ускоряется.
+// Разброс добавляется при предложении операции сохранения, см. StoreRepeat,
+// так что в лог попадает уже итоговое время повтора.
+func (s *State) SetStoreJitter(jitter time.Duration) {
+	s.jitter = uint64(jitter / s.resolution.Interval())
+}
+
+// StoreRepeat время повтора для операции сохранения сессии sid с
+// повтором в repeat с учётом разброса, см. SetStoreJitter. Вызывается
+// при предложении операции, применение операции разброс не добавляет.
+func (s *State) StoreRepeat(sid types.Index, repeat uint64) uint64 {
+	return repeat + s.repeatJitter(sid)
+}
+
+// repeatJitter сдвиг времени повтора сессии sid. Сдвиг выводится из
+// идентификатора сессии, а не случаен, чтобы повторное предложение
+// операции давало то же время повтора.
+func (s *State) repeatJitter(sid types.Index) uint64 {
+	if s.jitter == 0 {
+		return 0
+	}
+
+	// Перемешивание из splitmix64.
+	x := sid.Term*0x9e3779b97f4a7c15 ^ sid.Index
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return x % (s.jitter + 1)
+}
+
+// RunClock обновление системного времени состояния раз в деление
+// разрешения времени повтора, до отмены ctx.
+func (s *State) RunClock(ctx context.Context) {
+	ticker := time.NewTicker(s.resolution.Interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			s.systime.Set(t)
+		}
+	}
+}
+
+// SetTime установка системного времени состояния в t. Используется
+// вместо RunClock, когда ходом времени управляют извне, например при
+// нагрузочном тестировании в виртуальном времени.
+func (s *State) SetTime(t time.Time) {
+	s.systime.Set(t)
+}
+
+// Rescale дерево с временем повтора переведённым из разрешения from
+// в to. Нужно для загрузки слепков записанных в другом разрешении, при
+// переходе к более грубому разрешению время округляется вверх.
+func (t *rbTree) Rescale(from, to types.RepeatResolution) *rbTree {
+	if from == to {
+		return t
+	}
+
+	res := newRBTree()
+	iter := t.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		repeat := types.RepeatConvert(item.Repeat, from, to)
+		for i, sess := range item.Sessions {
+			res.SaveSessionPriority(repeat, item.Priority(i), sess)
+		}
+		for _, sid := range item.Tombstones {
+			res.SaveTombstone(repeat, sid)
+		}
+	}
+
+	return res
+}
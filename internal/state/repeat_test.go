@@ -0,0 +1,188 @@
+package state
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestSourceRepeatResolution(t *testing.T) {
+	dir := t.TempDir()
+	ids := []types.Index{
+		types.NewIndex(1, 10),
+		types.NewIndex(1, 20),
+		types.NewIndex(1, 30),
+	}
+
+	seconds := newRBTree()
+	seconds.SaveSession(10, types.NewSession(types.NewIndex(1, 1), 1, []byte("seconds")))
+	millis := newRBTree()
+	millis.SaveSession(9500, types.NewSession(types.NewIndex(1, 2), 1, []byte("millis")))
+	millis.SaveSession(10000, types.NewSession(types.NewIndex(1, 3), 1, []byte("millis")))
+
+	if _, err := seconds.DumpFile(dir, ids[0], types.RepeatSecond); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump seconds source"))
+		return
+	}
+	if _, err := millis.DumpFile(dir, ids[1], types.RepeatMillisecond); err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump milliseconds source"))
+		return
+	}
+
+	_, err := sourceio.MergeFiles(
+		datadir.TempName(dir, datadir.TempMerge),
+		datadir.SourceName(dir, ids[2]),
+		datadir.SourceName(dir, ids[0]),
+		datadir.SourceName(dir, ids[1]),
+		128,
+		types.RepeatMillisecond,
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "merge sources"))
+		return
+	}
+
+	type repeatSession struct {
+		Repeat uint64
+		ID     types.Index
+	}
+	read := func(res types.RepeatResolution) (sessions []repeatSession, err error) {
+		f, err := openSource(fsio.OS{}, datadir.SourceName(dir, ids[2]), res)
+		if err != nil {
+			return nil, errors.Wrap(err, "open source")
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		it, err := f.Iterator(0)
+		if err != nil {
+			return nil, errors.Wrap(err, "create iterator")
+		}
+		for it.Next() {
+			_, repeat, sess := it.RepeatData()
+			sessions = append(sessions, repeatSession{Repeat: repeat, ID: sess.ID})
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrap(err, "iterate over source")
+		}
+
+		return sessions, nil
+	}
+
+	got, err := read(types.RepeatMillisecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read merged source in milliseconds"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"milliseconds",
+		[]repeatSession{
+			{Repeat: 9500, ID: types.NewIndex(1, 2)},
+			{Repeat: 10000, ID: types.NewIndex(1, 1)},
+			{Repeat: 10000, ID: types.NewIndex(1, 3)},
+		},
+		got,
+	)
+
+	got, err = read(types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read merged source in seconds"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"seconds",
+		[]repeatSession{
+			{Repeat: 10, ID: types.NewIndex(1, 2)},
+			{Repeat: 10, ID: types.NewIndex(1, 1)},
+			{Repeat: 10, ID: types.NewIndex(1, 3)},
+		},
+		got,
+	)
+}
+
+func TestRBTreeRescale(t *testing.T) {
+	rb := newRBTree()
+	rb.SaveSession(1001, types.NewSession(types.NewIndex(1, 1), 1, []byte("a")))
+	rb.SaveSession(1500, types.NewSession(types.NewIndex(1, 2), 1, []byte("b")))
+	rb.SaveTombstone(2000, types.NewIndex(1, 3))
+
+	res := rb.Rescale(types.RepeatMillisecond, types.RepeatSecond)
+	type repeatItem struct {
+		Repeat     uint64
+		Sessions   []types.Index
+		Tombstones []types.Index
+	}
+	var got []repeatItem
+	iter := res.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		ri := repeatItem{Repeat: item.Repeat, Tombstones: item.Tombstones}
+		for _, sess := range item.Sessions {
+			ri.Sessions = append(ri.Sessions, sess.ID)
+		}
+		got = append(got, ri)
+	}
+
+	deepequal.SideBySide(
+		t,
+		"rescaled tree",
+		[]repeatItem{{
+			Repeat:     2,
+			Sessions:   []types.Index{types.NewIndex(1, 1), types.NewIndex(1, 2)},
+			Tombstones: []types.Index{types.NewIndex(1, 3)},
+		}},
+		got,
+	)
+}
+
+func TestApplyStoreMillisecondRepeat(t *testing.T) {
+	s, err := NewState(types.RepeatMillisecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s.SetTime(now)
+
+	// Время повтора в миллисекундах для настоящего времени не умещается
+	// в 32 бита.
+	repeat := types.RepeatMillisecond.After(now, time.Hour)
+	if repeat <= math.MaxUint32 {
+		t.Fatalf("repeat time %d must not fit into 32 bits", repeat)
+	}
+
+	var rec logop.Recorder
+	a := NewApplier(s, t.TempDir(), &Descriptors{}, nil)
+	ops := [][]byte{
+		rec.New(1),
+		rec.Store(types.NewIndex(1, 1), repeat),
+		rec.New(1),
+		rec.StorePriority(types.NewIndex(1, 3), repeat+1, 2),
+	}
+	for i, op := range ops {
+		id := types.NewIndex(1, uint64(i)+1)
+		if err := a.Apply(id, op); err != nil {
+			tlog.Error(t, errors.Wrap(err, "apply operation").Stg("event-id", id))
+			return
+		}
+	}
+
+	if _, found := s.saved.FindSessionAt(repeat, types.NewIndex(1, 1)); !found {
+		t.Errorf("session must be stored at repeat time %d", repeat)
+	}
+	if _, found := s.saved.FindSessionAt(repeat+1, types.NewIndex(1, 3)); !found {
+		t.Errorf("prioritized session must be stored at repeat time %d", repeat+1)
+	}
+}
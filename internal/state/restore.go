@@ -0,0 +1,201 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Restore изъятие n сохранённых сессий с наиболее ранним повтором
+// независимо от текущего времени. Сессии берутся из памяти, из
+// сбрасываемого на диск дерева и из источников описаний descs лежащих в
+// директории dir, отменённые сессии пропускаются. Среди сессий с равными
+// временем повтора и приоритетом раньше выдаются сохранённые раньше:
+// сначала из источников от более старых к более новым, см.
+// Descriptors.Sources, затем из сбрасываемого дерева и лишь потом из
+// памяти.
+//
+// Если сохранённых сессий меньше n, то состояние не меняется и
+// возвращается ошибка с кодом staterr.CodeSessionInvalidRequest.
+// Изъятые сессии из сбрасываемого дерева и источников удаляются
+// надгробием, а позиция чтения источника, см. Descriptors, сдвигается к
+// блоку последней изъятой из него сессии.
+func (s *State) Restore(dir string, descs *Descriptors, n int) ([]types.Session, error) {
+	candidates, err := s.restoreCandidates(dir, descs, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "look for sessions to restore")
+	}
+
+	if len(candidates) < n {
+		return nil, errors.Wrap(staterr.NewSessionInvalidRequest("not enough saved sessions"), "restore sessions").
+			Int("restore-requested", n).
+			Int("restore-available", len(candidates))
+	}
+
+	res := make([]types.Session, 0, n)
+	for _, c := range candidates[:n] {
+		s.remove(c.sess.ID, c)
+		if src, ok := descs.srcs[c.source]; ok {
+			src.curPos = c.offset
+		}
+
+		res = append(res, c.sess)
+	}
+
+	return res, nil
+}
+
+// RestoreSessions изъятие сохранённых сессий sids независимо от их
+// времени повтора и места в очереди. Сессии ищутся так же, как при
+// отмене, см. Cancel, и удаляются так же: из источников и сбрасываемого
+// дерева надгробием. Позиции чтения источников не меняются, ведь более
+// ранние сессии источника могут оставаться в нём.
+//
+// Если какая-то из сессий не найдена или указана дважды, то состояние
+// не меняется и возвращается ошибка с кодом
+// staterr.CodeSessionInvalidRequest.
+func (s *State) RestoreSessions(sids []types.Index) ([]types.Session, error) {
+	locs := make([]sessionLocation, len(sids))
+	seen := make(map[types.Index]struct{}, len(sids))
+	for i, sid := range sids {
+		if _, ok := seen[sid]; ok {
+			return nil, errors.Wrap(staterr.NewSessionInvalidRequest("session is listed twice"), "check sessions").
+				SessionID(sid)
+		}
+		seen[sid] = struct{}{}
+
+		loc, err := s.locate(sid)
+		if err != nil {
+			return nil, errors.Wrap(err, "locate session").SessionID(sid)
+		}
+		locs[i] = loc
+	}
+
+	res := make([]types.Session, 0, len(sids))
+	for i, sid := range sids {
+		s.remove(sid, locs[i])
+		res = append(res, locs[i].sess)
+	}
+
+	return res, nil
+}
+
+// restoreCandidates не более n сессий с наиболее ранним повтором из
+// каждого места хранения сохранённых сессий: источников, сбрасываемого
+// дерева и памяти, упорядоченные по порядку повтора. Первые n из них
+// это n сессий с наиболее ранним повтором вообще.
+func (s *State) restoreCandidates(dir string, descs *Descriptors, n int) ([]sessionLocation, error) {
+	var res []sessionLocation
+
+	for _, id := range descs.Sources() {
+		var err error
+		res, err = s.sourceCandidates(res, dir, descs, id, n)
+		if err != nil {
+			return nil, errors.Wrap(err, "read source sessions").Stg("source-id", id)
+		}
+	}
+
+	if s.flushing != nil {
+		res = s.treeCandidates(res, s.flushing, false, n)
+	}
+
+	res = s.treeCandidates(res, s.saved, true, n)
+
+	// Кандидаты добавлены в порядке времени сохранения, поэтому порядок
+	// внутри одного времени повтора и приоритета уже верный.
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := &res[i], &res[j]
+		if a.repeat != b.repeat {
+			return a.repeat < b.repeat
+		}
+
+		return a.prio > b.prio
+	})
+
+	return res, nil
+}
+
+// treeCandidates добавление в dst не более n первых неотменённых сессий дерева t.
+func (s *State) treeCandidates(dst []sessionLocation, t *rbTree, memtable bool, n int) []sessionLocation {
+	var count int
+	iter := t.Iter()
+	for count < n && iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			if count == n {
+				break
+			}
+			if !memtable && s.flushingCopyCancelled(sess.ID, item.Repeat) {
+				continue
+			}
+
+			dst = append(dst, sessionLocation{
+				repeat:   item.Repeat,
+				prio:     item.Priority(i),
+				sess:     sess,
+				memtable: memtable,
+			})
+			count++
+		}
+	}
+
+	return dst
+}
+
+// sourceCandidates добавление в dst не более n первых неотменённых сессий
+// источника id начиная с его позиции чтения.
+func (s *State) sourceCandidates(
+	dst []sessionLocation,
+	dir string,
+	descs *Descriptors,
+	id types.Index,
+	n int,
+) ([]sessionLocation, error) {
+	// Файл открывается только на чтение, ошибка его закрытия не важна.
+	f, err := openSource(descs.FS(), datadir.SourceName(dir, id), s.resolution)
+	if err != nil {
+		return nil, errors.Wrap(err, "open source")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	it, err := f.Iterator(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "create iterator")
+	}
+	if pos := descs.srcs[id].curPos; pos > 0 {
+		if _, err := it.SeekOffset(pos, 0); err != nil {
+			return nil, errors.Wrap(err, "seek to the read position").Uint64("read-position", pos)
+		}
+	}
+
+	age := descs.srcs[id].age()
+	var count int
+	for count < n && it.Next() {
+		_, repeat, sess := it.RepeatData()
+		if s.sourceCopyCancelled(age, sess.ID, repeat) {
+			continue
+		}
+
+		// Итератор переиспользует список кусков данных при вычитке
+		// следующей записи.
+		sess.Data = sess.Data.Clone()
+		dst = append(dst, sessionLocation{
+			repeat: repeat,
+			prio:   it.Priority(),
+			sess:   sess,
+			source: id,
+			offset: f.BlockOffset(repeat),
+		})
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate over source")
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+
+	// Источник, сбрасываемое дерево и память с сессиями на одно время повтора.
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 1), 1, []byte("source")))
+	s.saved.SaveSession(20, types.NewSession(types.NewIndex(2, 2), 1, []byte("cancelled")))
+	s.saved.SaveSession(30, types.NewSession(types.NewIndex(2, 3), 1, []byte("late")))
+	srcID := types.NewIndex(2, 4)
+	tree, err := s.StartFlush()
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	footer, err := tree.DumpFile(dir, srcID, s.resolution)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump saved sessions"))
+		return
+	}
+	s.FinishFlush(srcID, footer)
+	stat, err := os.Stat(datadir.SourceName(dir, srcID))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "stat source"))
+		return
+	}
+	descs := &Descriptors{}
+	if err := descs.AddSource(srcID, uint64(stat.Size())); err != nil {
+		tlog.Error(t, errors.Wrap(err, "add source"))
+		return
+	}
+	if err := s.LoadSources(dir, descs); err != nil {
+		tlog.Error(t, errors.Wrap(err, "load sources"))
+		return
+	}
+
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 5), 1, []byte("flushing")))
+	if _, err := s.StartFlush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start second flush"))
+		return
+	}
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 6), 1, []byte("memory")))
+	s.saved.SaveSession(5, types.NewSession(types.NewIndex(2, 7), 1, []byte("early")))
+	if err := s.Cancel(types.NewIndex(2, 2)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel source session"))
+		return
+	}
+
+	// Нехватка сессий обнаруживается до изменения состояния.
+	usage := s.MemoryUsage()
+	if _, err := s.Restore(dir, descs, 6); staterr.AsCode(err) != staterr.CodeSessionInvalidRequest {
+		t.Errorf("expected invalid request error on restoring too many sessions, got %v", err)
+	}
+	if s.MemoryUsage() != usage {
+		t.Errorf("expected memory usage %d to stay unchanged, got %d", usage, s.MemoryUsage())
+	}
+
+	sessions, err := s.Restore(dir, descs, 4)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "restore sessions"))
+		return
+	}
+	var got []string
+	for _, sess := range sessions {
+		got = append(got, sess.String())
+	}
+	deepequal.SideBySide(t, "restored", []string{
+		"session(0000000000000002-0000000000000007){Change:0000000000000002-0000000000000007, Chunks: [early]}",
+		"session(0000000000000002-0000000000000001){Change:0000000000000002-0000000000000001, Chunks: [source]}",
+		"session(0000000000000002-0000000000000005){Change:0000000000000002-0000000000000005, Chunks: [flushing]}",
+		"session(0000000000000002-0000000000000006){Change:0000000000000002-0000000000000006, Chunks: [memory]}",
+	}, got)
+	if pos := descs.srcs[srcID].curPos; pos == 0 {
+		t.Error("expected source read position to move forward")
+	}
+
+	// Последняя сессия из источника выдаётся операцией лога.
+	var rec logop.Recorder
+	a := NewApplier(s, dir, descs, nil)
+	if err := a.Apply(types.NewIndex(3, 1), rec.Restore(1)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "apply restore"))
+		return
+	}
+	deepequal.SideBySide(t, "active", []types.Index{types.NewIndex(2, 3)}, s.ActiveSessions())
+	if sess := s.active[types.NewIndex(2, 3)]; sess.Repeats != 1 || !types.IndexEqual(sess.ChangeID, types.NewIndex(3, 1)) {
+		t.Errorf("unexpected restored session state %+v", sess)
+	}
+
+	if err := a.Apply(types.NewIndex(3, 2), rec.Restore(1)); staterr.AsCode(err) != staterr.CodeSessionInvalidRequest {
+		t.Errorf("expected invalid request error with no saved sessions left, got %v", err)
+	}
+}
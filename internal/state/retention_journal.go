@@ -0,0 +1,143 @@
+package state
+
+import (
+	"io"
+	"os"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// retentionRecordSize размер записи журнала: вид файла и его идентификатор.
+const retentionRecordSize = 17
+
+// RetentionJournal журнал удалённых файлов. Удаление файла записывается
+// в журнал до самого удаления, так что после перезапуска из слепка,
+// сделанного до удаления, файлы не «воскресают» в дескрипторах.
+//
+// Журнал можно очистить с помощью Reset после сохранения слепка
+// с дескрипторами, в которых удалённых файлов уже нет.
+type RetentionJournal struct {
+	fs      fsio.FS
+	file    fsio.File
+	records []retentionRecord
+}
+
+type retentionRecord struct {
+	kind FileKind
+	id   types.Index
+}
+
+// OpenRetentionJournal открывает журнал удалённых файлов, создавая его при
+// необходимости. Недописанная последняя запись отбрасывается: удаление
+// файла в этом случае не производилось.
+func OpenRetentionJournal(name string) (*RetentionJournal, error) {
+	return OpenRetentionJournalFS(fsio.OS{}, name)
+}
+
+// OpenRetentionJournalFS открывает журнал удалённых файлов name файловой
+// системы fsys, см. OpenRetentionJournal. Удаляемые файлы должны лежать
+// в той же файловой системе.
+func OpenRetentionJournalFS(fsys fsio.FS, name string) (*RetentionJournal, error) {
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open journal file")
+	}
+
+	res, err := readRetentionJournal(fsys, file)
+	if err != nil {
+		if cErr := file.Close(); cErr != nil {
+			return nil, errors.Wrap(err, "read journal").Str("close-error", cErr.Error())
+		}
+
+		return nil, errors.Wrap(err, "read journal")
+	}
+
+	return res, nil
+}
+
+func readRetentionJournal(fsys fsio.FS, file fsio.File) (*RetentionJournal, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read journal data")
+	}
+
+	res := &RetentionJournal{
+		fs:   fsys,
+		file: file,
+	}
+	rest := len(data) % retentionRecordSize
+	for len(data) >= retentionRecordSize {
+		r := retentionRecord{
+			kind: FileKind(data[0]),
+		}
+		switch r.kind {
+		case FileKindLog, FileKindSource:
+		default:
+			return nil, errors.New("invalid file kind in journal").Uint8("invalid-file-kind", data[0])
+		}
+		if !types.IndexDecodeCheck(&r.id, data[1:]) {
+			return nil, errors.Wrap(errorInvalidIndex, "decode removed file id")
+		}
+
+		res.records = append(res.records, r)
+		data = data[retentionRecordSize:]
+	}
+
+	if rest != 0 {
+		size := int64(len(res.records) * retentionRecordSize)
+		if err := file.Truncate(size); err != nil {
+			return nil, errors.Wrap(err, "cut incomplete journal record")
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return nil, errors.Wrap(err, "seek to the journal end")
+	}
+
+	return res, nil
+}
+
+// Reset очистка журнала.
+func (j *RetentionJournal) Reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return errors.Wrap(err, "truncate journal")
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek to the journal start")
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.Wrap(err, "sync journal")
+	}
+
+	j.records = j.records[:0]
+	return nil
+}
+
+// Close закрытие журнала.
+func (j *RetentionJournal) Close() error {
+	return j.file.Close()
+}
+
+// remove запись удаления файла в журнал и собственно удаление.
+func (j *RetentionJournal) remove(f RetainedFile) error {
+	var buf [retentionRecordSize]byte
+	buf[0] = byte(f.Kind)
+	types.IndexEncode(buf[1:], f.ID)
+	if _, err := j.file.Write(buf[:]); err != nil {
+		return errors.Wrap(err, "write journal record")
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.Wrap(err, "sync journal")
+	}
+	j.records = append(j.records, retentionRecord{
+		kind: f.Kind,
+		id:   f.ID,
+	})
+
+	if err := j.fs.Remove(f.Name); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove file").Str("file-name", f.Name)
+	}
+
+	return nil
+}
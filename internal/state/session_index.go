@@ -0,0 +1,220 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// SessionPlace местоположение сохранённой сессии.
+type SessionPlace struct {
+	// Repeat время повтора сессии.
+	Repeat uint64
+
+	// Priority приоритет сессии среди сессий с тем же временем повтора.
+	Priority types.Priority
+
+	// Source идентификатор источника сессии, нулевое значение означает,
+	// что сессия находится в памяти.
+	Source types.Index
+
+	// Offset смещение блока источника, с которого начинаются сессии
+	// с временем повтора Repeat.
+	Offset uint64
+}
+
+// SessionInfo данные сохранённой сессии.
+type SessionInfo struct {
+	SessionPlace
+	Session types.Session
+}
+
+// sessionIndex индекс местоположения сохранённых сессий по их
+// идентификаторам. Методы допускают вызов на nil, т.е. при
+// выключенном индексе.
+type sessionIndex map[types.Index]SessionPlace
+
+// EnableSessionIndex включение индекса сохранённых сессий по их
+// идентификаторам. Индекс строится по сессиям в памяти, сессии
+// источников добавляются при вызове LoadSources.
+func (s *State) EnableSessionIndex() {
+	if s.index != nil {
+		return
+	}
+
+	s.index = sessionIndex{}
+	for _, t := range []*rbTree{s.flushing, s.saved} {
+		if t == nil {
+			continue
+		}
+
+		iter := t.Iter()
+		for iter.Next() {
+			item := iter.Item()
+			for i, sess := range item.Sessions {
+				s.index.saved(sess.ID, item.Repeat, item.Priority(i))
+			}
+		}
+	}
+}
+
+// Inspect данные сохранённой сессии sid и её местоположение. Без
+// индекса сессий поиск в источниках идёт их перебором.
+func (s *State) Inspect(sid types.Index) (info SessionInfo, err error) {
+	loc, err := s.locate(sid)
+	if err != nil {
+		return info, errors.Wrap(err, "locate session").SessionID(sid)
+	}
+
+	info.Repeat = loc.repeat
+	info.Priority = loc.prio
+	info.Source = loc.source
+	info.Offset = loc.offset
+	info.Session = loc.sess
+	return info, nil
+}
+
+// SourcesMerged учёт слияния источников srcs в источник dst с подвалом
+// footer из описаний descs лежащий в директории dir: надгробия слитых
+// источников, см. Cancelled, заменяются уцелевшими в dst, индекс сессий
+// указывает на dst.
+func (s *State) SourcesMerged(
+	dir string,
+	descs *Descriptors,
+	dst types.Index,
+	footer *sourceio.Footer,
+	srcs ...types.Index,
+) error {
+	merged := make(map[types.Index]struct{}, len(srcs))
+	for _, src := range srcs {
+		merged[src] = struct{}{}
+	}
+
+	if err := s.mergeTombstones(dir, descs, dst, merged); err != nil {
+		return errors.Wrap(err, "account merged tombstones")
+	}
+
+	if s.index == nil {
+		return nil
+	}
+
+	for sid, place := range s.index {
+		if _, ok := merged[place.Source]; !ok {
+			continue
+		}
+
+		place.Source = dst
+		place.Offset = footer.Offset(place.Repeat)
+		s.index[sid] = place
+	}
+
+	return nil
+}
+
+// locateIndexed поиск сохранённой сессии с помощью индекса.
+func (s *State) locateIndexed(sid types.Index) (loc sessionLocation, err error) {
+	place, ok := s.index[sid]
+	if !ok {
+		return loc, staterr.NewSessionInvalidRequest("session not found")
+	}
+
+	loc.repeat = place.Repeat
+	loc.prio = place.Priority
+	loc.source = place.Source
+	loc.offset = place.Offset
+
+	if place.Source == (types.Index{}) {
+		var found bool
+		if loc.sess, found = s.saved.FindSessionAt(place.Repeat, sid); found {
+			loc.memtable = true
+			return loc, nil
+		}
+
+		if s.flushing != nil {
+			if loc.sess, found = s.flushing.FindSessionAt(place.Repeat, sid); found {
+				return loc, nil
+			}
+		}
+
+		return loc, errors.New("indexed session is missing in memory").Uint64("repeat-time", place.Repeat)
+	}
+
+	if s.sources == nil {
+		return loc, errors.New("sources are not loaded")
+	}
+
+	var found bool
+	loc.sess, found, err = s.sources.ReadSession(sid, place)
+	if err != nil {
+		return loc, errors.Wrap(err, "read indexed session")
+	}
+	if !found {
+		return loc, errors.New("indexed session is missing in its source").
+			Stg("source-id", place.Source).
+			Uint64("repeat-time", place.Repeat)
+	}
+
+	return loc, nil
+}
+
+// saved учёт сессии сохранённой в памяти.
+func (idx sessionIndex) saved(sid types.Index, repeat uint64, prio types.Priority) {
+	if idx == nil {
+		return
+	}
+
+	idx[sid] = SessionPlace{
+		Repeat:   repeat,
+		Priority: prio,
+	}
+}
+
+// remove удаление сессии из индекса.
+func (idx sessionIndex) remove(sid types.Index) {
+	delete(idx, sid)
+}
+
+// flushed учёт сброса сессий дерева t в источник src с подвалом footer.
+// Сессии отменённые или перенесённые во время сброса в индексе уже
+// отсутствуют или имеют другое время повтора и не затрагиваются.
+func (idx sessionIndex) flushed(t *rbTree, src types.Index, footer *sourceio.Footer) {
+	if idx == nil {
+		return
+	}
+
+	iter := t.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for _, sess := range item.Sessions {
+			place, ok := idx[sess.ID]
+			if !ok || place.Source != (types.Index{}) || place.Repeat != item.Repeat {
+				continue
+			}
+
+			place.Source = src
+			if footer != nil {
+				place.Offset = footer.Offset(item.Repeat)
+			}
+			idx[sess.ID] = place
+		}
+	}
+}
+
+// loadSource добавление в индекс неотменённых сессий источника name.
+func (idx sessionIndex) loadSource(
+	fsys fsio.FS,
+	name string,
+	src types.Index,
+	res types.RepeatResolution,
+	cancelled func(sid types.Index, repeat uint64) bool,
+) error {
+	_, err := scanSource(fsys, name, res, cancelled, func(place SessionPlace, sess *types.Session) bool {
+		place.Source = src
+		idx[sess.ID] = place
+		return true
+	})
+
+	return err
+}
@@ -0,0 +1,130 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/staterr"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestStateSessionIndex(t *testing.T) {
+	dir := t.TempDir()
+	srcID := types.NewIndex(1, 10)
+	flushID := types.NewIndex(1, 20)
+	mergedID := types.NewIndex(1, 30)
+
+	footer, err := sampleTree().DumpFile(dir, srcID, types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump source"))
+		return
+	}
+
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.EnableSessionIndex()
+	d := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			srcID: {
+				id: srcID,
+			},
+		},
+	}
+	if err := s.LoadSources(dir, d); err != nil {
+		tlog.Error(t, errors.Wrap(err, "load sources"))
+		return
+	}
+
+	info, err := s.Inspect(types.NewIndex(1, 4))
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "inspect source session"))
+		return
+	}
+	deepequal.SideBySide(
+		t,
+		"source session place",
+		SessionPlace{
+			Repeat: 200,
+			Source: srcID,
+			Offset: footer.Offset(200),
+		},
+		info.SessionPlace,
+	)
+	expected := types.NewSession(types.NewIndex(1, 4), 200, []byte("qwerty"))
+	if !bytes.Equal(types.SessionEncode(nil, &expected), types.SessionEncode(nil, &info.Session)) {
+		t.Errorf("unexpected source session %s", info.Session.String())
+	}
+
+	sess := types.NewSession(types.NewIndex(2, 1), 1, []byte("memory"))
+	s.active[sess.ID] = &sess
+	if err := s.Store(sess.ID, 50); err != nil {
+		tlog.Error(t, errors.Wrap(err, "store session"))
+		return
+	}
+	info, err = s.Inspect(sess.ID)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "inspect memory session"))
+		return
+	}
+	deepequal.SideBySide(t, "memory session place", SessionPlace{Repeat: 50}, info.SessionPlace)
+	if info.Session.ID != sess.ID {
+		t.Errorf("unexpected memory session %s", info.Session.ID)
+	}
+
+	// Сброс и слияние переносят сессию в новые источники.
+	flushing, err := s.StartFlush()
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	flushFooter, err := flushing.DumpFile(dir, flushID, types.RepeatSecond)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "dump flushed sessions"))
+		return
+	}
+	s.FinishFlush(flushID, flushFooter)
+
+	mergedFooter, err := sourceio.MergeFiles(
+		datadir.TempName(dir, datadir.TempMerge),
+		datadir.SourceName(dir, mergedID),
+		datadir.SourceName(dir, srcID),
+		datadir.SourceName(dir, flushID),
+		64,
+		types.RepeatSecond,
+	)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "merge sources"))
+		return
+	}
+	if err := s.SourcesMerged(dir, d, mergedID, mergedFooter, srcID, flushID); err != nil {
+		tlog.Error(t, errors.Wrap(err, "account merged sources"))
+		return
+	}
+
+	for _, sid := range []types.Index{sess.ID, types.NewIndex(1, 3)} {
+		info, err = s.Inspect(sid)
+		if err != nil {
+			tlog.Error(t, errors.Wrap(err, "inspect merged session").SessionID(sid))
+			return
+		}
+		if info.Source != mergedID || info.Session.ID != sid {
+			t.Errorf("unexpected merged session location %s in %s", info.Session.ID, info.Source)
+		}
+	}
+
+	if err := s.Cancel(sess.ID); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel session"))
+		return
+	}
+	if _, err := s.Inspect(sess.ID); staterr.AsCode(err) != staterr.CodeSessionInvalidRequest {
+		t.Errorf("cancelled session must not be found, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/mpio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/types"
+	"github.com/sirkon/mpy6a/internal/uvarints"
+)
+
+// snapshotVersion версия формата файла слепка. Во второй версии в
+// описаниях источников хранится их старшинство, см. Descriptors.Sources.
+const snapshotVersion = 2
+
+// snapshotBufferSize размер буфера записи сохранённых сессий слепка.
+const snapshotBufferSize = 64 * 1024
+
+// WriteSnapshot запись слепка состояния вместе с описаниями файлов descs
+// в директорию dir. Файл пишется под временным именем и публикуется под
+// именем datadir.SnapshotName для идентификатора состояния. Возвращает
+// имя файла слепка.
+//
+// Слепок состоит из:
+//
+//   - версии формата, идентификатора состояния и разрешения времени повтора;
+//   - описаний файлов;
+//   - активных сессий;
+//   - сохранённых в памяти сессий и надгробий, включая сбрасываемые на диск
+//     в данный момент: источник с ними ещё не зарегистрирован в descs.
+func (s *State) WriteSnapshot(dir string, descs *Descriptors) (string, error) {
+	file, err := datadir.CreatePendingFS(descs.FS(), datadir.TempName(dir, datadir.TempSnapshot))
+	if err != nil {
+		return "", errors.Wrap(err, "create temporary file")
+	}
+
+	if err := s.writeSnapshot(file, descs); err != nil {
+		if dErr := file.Discard(); dErr != nil {
+			return "", errors.Wrap(err, "write snapshot").Str("discard-error", dErr.Error())
+		}
+
+		return "", errors.Wrap(err, "write snapshot")
+	}
+
+	name := datadir.SnapshotName(dir, s.id)
+	if err := file.Publish(name); err != nil {
+		return "", errors.Wrap(err, "publish snapshot").Str("snapshot-name", name)
+	}
+
+	return name, nil
+}
+
+func (s *State) writeSnapshot(dst io.Writer, descs *Descriptors) error {
+	buf := bufio.NewWriter(dst)
+
+	var header [1 + 16]byte
+	header[0] = snapshotVersion
+	types.IndexEncode(header[1:], s.id)
+	if _, err := buf.Write(header[:]); err != nil {
+		return errors.Wrap(err, "write header")
+	}
+	if _, err := uvarints.Write(buf, uint64(s.resolution)); err != nil {
+		return errors.Wrap(err, "write repeat resolution")
+	}
+
+	if err := descs.Encode(buf); err != nil {
+		return errors.Wrap(err, "encode descriptors")
+	}
+
+	if err := s.active.Encode(buf); err != nil {
+		return errors.Wrap(err, "encode active sessions")
+	}
+
+	w := sourceio.NewWriter(buf, snapshotBufferSize)
+	if err := s.saved.Encode(w); err != nil {
+		return errors.Wrap(err, "encode saved sessions")
+	}
+	flushing := s.flushing
+	if flushing == nil {
+		flushing = newRBTree()
+	}
+	if err := flushing.Encode(w); err != nil {
+		return errors.Wrap(err, "encode flushing sessions")
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "flush saved sessions")
+	}
+
+	if err := buf.Flush(); err != nil {
+		return errors.Wrap(err, "flush buffered data")
+	}
+
+	return nil
+}
+
+// LatestSnapshot имя файла последнего слепка из лога имён слепков
+// директории dir, см. datadir.SnapshotsLogName. Относительные имена
+// в логе отсчитываются от dir.
+func LatestSnapshot(dir string, logger func(error)) (string, error) {
+	return LatestSnapshotFS(fsio.OS{}, dir, logger)
+}
+
+// LatestSnapshotFS имя файла последнего слепка директории dir файловой
+// системы fsys, см. LatestSnapshot.
+func LatestSnapshotFS(fsys fsio.FS, dir string, logger func(error)) (string, error) {
+	snaps := logio.NewSnapshotsFS(fsys, datadir.SnapshotsLogName(dir), logger)
+	name, err := snaps.ReadName()
+	if err != nil {
+		return "", errors.Wrap(err, "read snapshot name")
+	}
+	if name == "" {
+		return "", errors.New("no snapshots in the data directory")
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	return name, nil
+}
+
+// ReadSnapshot восстановление состояния с ограничениями памяти limits
+// и описаний файлов из слепка name, см. WriteSnapshot. Сессии которые
+// сбрасывались на диск при записи слепка попадают в сохранённые перед
+// сохранёнными позже них.
+func ReadSnapshot(name string, limits MemoryLimits) (*State, *Descriptors, error) {
+	return readSnapshotFile(fsio.OS{}, name, limits)
+}
+
+// ReadSnapshotFS восстановление состояния из слепка name файловой системы
+// fsys, см. ReadSnapshot. Возвращаемые описания файлов работают с fsys.
+func ReadSnapshotFS(fsys fsio.FS, name string, limits MemoryLimits) (*State, *Descriptors, error) {
+	s, descs, err := readSnapshotFile(fsys, name, limits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	descs.SetFS(fsys)
+	return s, descs, nil
+}
+
+func readSnapshotFile(fsys fsio.FS, name string, limits MemoryLimits) (_ *State, _ *Descriptors, err error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open snapshot")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = file.Close()
+	}()
+
+	s, descs, err := readSnapshot(bufio.NewReader(file), limits)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read snapshot").Str("snapshot-name", name)
+	}
+
+	return s, descs, nil
+}
+
+func readSnapshot(src mpio.DataReader, limits MemoryLimits) (*State, *Descriptors, error) {
+	var header [1 + 16]byte
+	if _, err := io.ReadFull(src, header[:]); err != nil {
+		return nil, nil, errors.Wrap(err, "read header")
+	}
+	if header[0] != snapshotVersion {
+		return nil, nil, errors.New("unsupported snapshot version").
+			Int("snapshot-version", int(header[0])).
+			Int("snapshot-version-supported", snapshotVersion)
+	}
+
+	// Идентификатор пустого состояния нулевой.
+	var id types.Index
+	types.IndexDecode(&id, header[1:])
+
+	res, err := binary.ReadUvarint(src)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read repeat resolution")
+	}
+
+	s, err := NewState(types.RepeatResolution(time.Duration(res)), limits)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create state")
+	}
+	s.id = id
+
+	var descs Descriptors
+	if err := descs.Decode(src); err != nil {
+		return nil, nil, errors.Wrap(err, "decode descriptors")
+	}
+
+	if err := s.active.Decode(src); err != nil {
+		return nil, nil, errors.Wrap(err, "decode active sessions")
+	}
+
+	saved := newRBTree()
+	if err := saved.Decode(src); err != nil {
+		return nil, nil, errors.Wrap(err, "decode saved sessions")
+	}
+	if err := s.saved.Decode(src); err != nil {
+		return nil, nil, errors.Wrap(err, "decode flushing sessions")
+	}
+
+	// Надгробия сессий из сбрасывавшегося дерева отменяют их прямо в
+	// памяти, остальные относятся к сессиям из источников.
+	iter := saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for _, sid := range item.Tombstones {
+			if _, ok := s.saved.FindSessionAt(item.Repeat, sid); ok {
+				s.saved.RemoveSession(sid)
+				continue
+			}
+
+			s.saved.SaveTombstone(item.Repeat, sid)
+		}
+	}
+
+	// Сбрасывавшиеся на диск сессии были сохранены раньше остальных, поэтому
+	// сохранённые добавляются после них, чтобы не нарушить порядок сессий с
+	// равными временем повтора и приоритетом.
+	iter = saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			s.saved.SaveSessionPriority(item.Repeat, item.Priority(i), sess)
+		}
+	}
+
+	return s, &descs, nil
+}
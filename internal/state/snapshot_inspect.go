@@ -0,0 +1,364 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// FileDescriptor описание файла данных из Descriptors.
+type FileDescriptor struct {
+	Kind FileKind
+	ID   types.Index
+
+	// Current текущий лог операций.
+	Current bool
+
+	// Used файл более не используется и ожидает удаления.
+	Used bool
+
+	// First и Last индексы первого и последнего события лога.
+	First types.Index
+	Last  types.Index
+
+	// Pos позиция вычитки источника.
+	Pos uint64
+
+	// Len длина файла.
+	Len uint64
+}
+
+// Files описания всех файлов: сначала логи в порядке событий,
+// затем источники в порядке идентификаторов.
+func (d *Descriptors) Files() []FileDescriptor {
+	var res []FileDescriptor
+	for _, l := range d.logsChain() {
+		res = append(res, FileDescriptor{
+			Kind:    FileKindLog,
+			ID:      l.id,
+			Current: l == d.log,
+			Used:    l != d.log,
+			First:   l.firstID,
+			Last:    l.lastID,
+			Len:     l.len,
+		})
+	}
+
+	var srcs []FileDescriptor
+	for _, s := range d.srcs {
+		srcs = append(srcs, FileDescriptor{
+			Kind: FileKindSource,
+			ID:   s.id,
+			Pos:  s.curPos,
+			Len:  s.len,
+		})
+	}
+	for _, s := range d.usedSrcs {
+		srcs = append(srcs, FileDescriptor{
+			Kind: FileKindSource,
+			ID:   s.id,
+			Used: true,
+			Len:  s.len,
+		})
+	}
+	sort.SliceStable(srcs, func(i, j int) bool {
+		return types.IndexLess(srcs[i].ID, srcs[j].ID)
+	})
+
+	return append(res, srcs...)
+}
+
+// ThemeSummary сводка по активным сессиям одной темы.
+type ThemeSummary struct {
+	Theme    uint32
+	Sessions int
+
+	// Oldest наименьший идентификатор сессии темы.
+	Oldest types.Index
+
+	// Stalest наименьший идентификатор последнего изменения сессии темы.
+	Stalest types.Index
+
+	// Age распределение возраста сессий в событиях от создания до
+	// состояния слепка, см. AgeBuckets.
+	Age []int
+
+	// Idle распределение простоя сессий в событиях от последнего
+	// изменения до состояния слепка, см. AgeBuckets.
+	Idle []int
+}
+
+// AgeBuckets верхние границы интервалов возраста и простоя сессий в
+// событиях. Последний интервал без границы объединяет более старые
+// сессии и сессии предыдущих сроков, для которых расстояние в событиях
+// неизвестно.
+var AgeBuckets = []uint64{1_000, 10_000, 100_000, 1_000_000}
+
+// SnapshotSummary сводка по слепку.
+type SnapshotSummary struct {
+	ID         types.Index
+	Resolution types.RepeatResolution
+
+	Active int
+	Themes []ThemeSummary
+
+	Saved      int
+	Tombstones int
+	SavedBytes int
+
+	Files []FileDescriptor
+}
+
+// InspectSnapshot сводка по слепку name: активные сессии по темам,
+// их возраст и простой, сохранённые сессии и описания файлов.
+func InspectSnapshot(name string) (*SnapshotSummary, error) {
+	s, descs, err := ReadSnapshot(name, MemoryLimits{})
+	if err != nil {
+		return nil, errors.Wrap(err, "read snapshot")
+	}
+
+	res := &SnapshotSummary{
+		ID:         s.id,
+		Resolution: s.resolution,
+		Active:     len(s.active),
+		Saved:      s.saved.Len(),
+		SavedBytes: s.saved.Bytes(),
+		Files:      descs.Files(),
+	}
+
+	themes := map[uint32]*ThemeSummary{}
+	for _, sess := range s.active {
+		theme := uint32(sess.Theme)
+		t, ok := themes[theme]
+		if !ok {
+			t = &ThemeSummary{
+				Theme:   theme,
+				Oldest:  sess.ID,
+				Stalest: sess.ChangeID,
+				Age:     make([]int, len(AgeBuckets)+1),
+				Idle:    make([]int, len(AgeBuckets)+1),
+			}
+			themes[theme] = t
+		}
+
+		t.Sessions++
+		if types.IndexLess(sess.ID, t.Oldest) {
+			t.Oldest = sess.ID
+		}
+		if types.IndexLess(sess.ChangeID, t.Stalest) {
+			t.Stalest = sess.ChangeID
+		}
+		t.Age[ageBucket(s.id, sess.ID)]++
+		t.Idle[ageBucket(s.id, sess.ChangeID)]++
+	}
+	for _, t := range themes {
+		res.Themes = append(res.Themes, *t)
+	}
+	sort.Slice(res.Themes, func(i, j int) bool {
+		return res.Themes[i].Theme < res.Themes[j].Theme
+	})
+
+	iter := s.saved.Iter()
+	for iter.Next() {
+		res.Tombstones += len(iter.Item().Tombstones)
+	}
+	res.Saved -= res.Tombstones
+
+	return res, nil
+}
+
+// ageBucket номер интервала AgeBuckets для расстояния от события
+// since до состояния id.
+func ageBucket(id, since types.Index) int {
+	if id.Term != since.Term || id.Index < since.Index {
+		return len(AgeBuckets)
+	}
+
+	age := id.Index - since.Index
+	for i, b := range AgeBuckets {
+		if age < b {
+			return i
+		}
+	}
+
+	return len(AgeBuckets)
+}
+
+// SavedSession положение сохранённой сессии.
+type SavedSession struct {
+	ID       types.Index
+	Repeat   uint64
+	Priority types.Priority
+}
+
+// SavedMove перенос сохранённой сессии.
+type SavedMove struct {
+	ID   types.Index
+	From SavedSession
+	To   SavedSession
+}
+
+// DescriptorChange изменение описания файла, для добавленных файлов
+// Old нулевой, для удалённых – New.
+type DescriptorChange struct {
+	Old *FileDescriptor
+	New *FileDescriptor
+}
+
+// SnapshotDiff различия между двумя слепками.
+type SnapshotDiff struct {
+	From types.Index
+	To   types.Index
+
+	ActiveAdded   []types.Index
+	ActiveRemoved []types.Index
+
+	SavedAdded   []SavedSession
+	SavedRemoved []SavedSession
+	SavedMoved   []SavedMove
+
+	Descriptors []DescriptorChange
+}
+
+// Empty проверка отсутствия различий в данных слепков.
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.ActiveAdded) == 0 &&
+		len(d.ActiveRemoved) == 0 &&
+		len(d.SavedAdded) == 0 &&
+		len(d.SavedRemoved) == 0 &&
+		len(d.SavedMoved) == 0 &&
+		len(d.Descriptors) == 0
+}
+
+// DiffSnapshots различия слепка to относительно слепка from: добавленные
+// и удалённые активные сессии, добавленные, удалённые и перенесённые
+// сохранённые в памяти сессии, изменения описаний файлов. Время повтора
+// сессий отдаётся в разрешении слепка from.
+func DiffSnapshots(from, to string) (*SnapshotDiff, error) {
+	a, aDescs, err := ReadSnapshot(from, MemoryLimits{})
+	if err != nil {
+		return nil, errors.Wrap(err, "read snapshot").Str("snapshot-name", from)
+	}
+	b, bDescs, err := ReadSnapshot(to, MemoryLimits{})
+	if err != nil {
+		return nil, errors.Wrap(err, "read snapshot").Str("snapshot-name", to)
+	}
+
+	bSaved := b.saved.Rescale(b.resolution, a.resolution)
+
+	res := &SnapshotDiff{
+		From: a.id,
+		To:   b.id,
+	}
+
+	for sid := range b.active {
+		if _, ok := a.active[sid]; !ok {
+			res.ActiveAdded = append(res.ActiveAdded, sid)
+		}
+	}
+	for sid := range a.active {
+		if _, ok := b.active[sid]; !ok {
+			res.ActiveRemoved = append(res.ActiveRemoved, sid)
+		}
+	}
+	sortIndices(res.ActiveAdded)
+	sortIndices(res.ActiveRemoved)
+
+	aPlaces := savedPlaces(a.saved)
+	bPlaces := savedPlaces(bSaved)
+	for sid, p := range bPlaces {
+		old, ok := aPlaces[sid]
+		switch {
+		case !ok:
+			res.SavedAdded = append(res.SavedAdded, p)
+		case old != p:
+			res.SavedMoved = append(res.SavedMoved, SavedMove{
+				ID:   sid,
+				From: old,
+				To:   p,
+			})
+		}
+	}
+	for sid, p := range aPlaces {
+		if _, ok := bPlaces[sid]; !ok {
+			res.SavedRemoved = append(res.SavedRemoved, p)
+		}
+	}
+	sortSaved(res.SavedAdded)
+	sortSaved(res.SavedRemoved)
+	sort.Slice(res.SavedMoved, func(i, j int) bool {
+		return types.IndexLess(res.SavedMoved[i].ID, res.SavedMoved[j].ID)
+	})
+
+	res.Descriptors = diffFiles(aDescs.Files(), bDescs.Files())
+	return res, nil
+}
+
+// savedPlaces положения сессий дерева.
+func savedPlaces(t *rbTree) map[types.Index]SavedSession {
+	res := make(map[types.Index]SavedSession, t.Len())
+	iter := t.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			res[sess.ID] = SavedSession{
+				ID:       sess.ID,
+				Repeat:   item.Repeat,
+				Priority: item.Priority(i),
+			}
+		}
+	}
+
+	return res
+}
+
+// diffFiles изменения описаний файлов, в порядке Descriptors.Files.
+func diffFiles(from, to []FileDescriptor) []DescriptorChange {
+	type key struct {
+		kind FileKind
+		id   types.Index
+	}
+
+	old := make(map[key]*FileDescriptor, len(from))
+	for i := range from {
+		f := &from[i]
+		old[key{kind: f.Kind, id: f.ID}] = f
+	}
+
+	var res []DescriptorChange
+	for i := range to {
+		f := &to[i]
+		k := key{kind: f.Kind, id: f.ID}
+		prev, ok := old[k]
+		delete(old, k)
+
+		switch {
+		case !ok:
+			res = append(res, DescriptorChange{New: f})
+		case *prev != *f:
+			res = append(res, DescriptorChange{Old: prev, New: f})
+		}
+	}
+
+	for i := range from {
+		f := &from[i]
+		if _, ok := old[key{kind: f.Kind, id: f.ID}]; ok {
+			res = append(res, DescriptorChange{Old: f})
+		}
+	}
+
+	return res
+}
+
+func sortIndices(ids []types.Index) {
+	sort.Slice(ids, func(i, j int) bool {
+		return types.IndexLess(ids[i], ids[j])
+	})
+}
+
+func sortSaved(sessions []SavedSession) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return types.IndexLess(sessions[i].ID, sessions[j].ID)
+	})
+}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestSnapshotInspectDiff(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewState(types.RepeatSecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	descs := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{
+			types.NewIndex(1, 5): {
+				id:  types.NewIndex(1, 5),
+				len: 100,
+			},
+		},
+		log: &logDescriptor{
+			id:      types.NewIndex(1, 0),
+			firstID: types.NewIndex(1, 0),
+			lastID:  types.NewIndex(1, 20),
+		},
+	}
+
+	addActive := func(sid types.Index, theme uint32, change types.Index) {
+		sess := types.NewSession(sid, theme, []byte("data"))
+		sess.ChangeID = change
+		s.active[sid] = &sess
+	}
+	addActive(types.NewIndex(1, 1), 1, types.NewIndex(1, 2000))
+	addActive(types.NewIndex(1, 3), 1, types.NewIndex(1, 15000))
+	addActive(types.NewIndex(1, 7), 2, types.NewIndex(1, 7))
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(1, 2), 1, []byte("moved")))
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(1, 4), 1, []byte("removed")))
+	s.saved.SaveTombstone(10, types.NewIndex(1, 6))
+	s.id = types.NewIndex(1, 20000)
+
+	from, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+
+	summary, err := InspectSnapshot(from)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "inspect snapshot"))
+		return
+	}
+	deepequal.SideBySide(t, "themes", []ThemeSummary{
+		{
+			Theme:    1,
+			Sessions: 2,
+			Oldest:   types.NewIndex(1, 1),
+			Stalest:  types.NewIndex(1, 2000),
+			Age:      []int{0, 0, 2, 0, 0},
+			Idle:     []int{0, 1, 1, 0, 0},
+		},
+		{
+			Theme:    2,
+			Sessions: 1,
+			Oldest:   types.NewIndex(1, 7),
+			Stalest:  types.NewIndex(1, 7),
+			Age:      []int{0, 0, 1, 0, 0},
+			Idle:     []int{0, 0, 1, 0, 0},
+		},
+	}, summary.Themes)
+	if summary.Active != 3 || summary.Saved != 2 || summary.Tombstones != 1 {
+		t.Errorf(
+			"unexpected counts: active=%d saved=%d tombstones=%d",
+			summary.Active,
+			summary.Saved,
+			summary.Tombstones,
+		)
+	}
+
+	delete(s.active, types.NewIndex(1, 1))
+	addActive(types.NewIndex(1, 20001), 3, types.NewIndex(1, 20001))
+	if _, _, found := s.saved.RemoveSession(types.NewIndex(1, 4)); !found {
+		t.Error("session to remove not found")
+		return
+	}
+	if err := s.Reschedule(types.NewIndex(1, 2), 20); err != nil {
+		tlog.Error(t, errors.Wrap(err, "reschedule session"))
+		return
+	}
+	descs.log.lastID = types.NewIndex(1, 20001)
+	if err := descs.AddSource(types.NewIndex(1, 20000), 200); err != nil {
+		tlog.Error(t, errors.Wrap(err, "add source"))
+		return
+	}
+	s.id = types.NewIndex(1, 20001)
+
+	to, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+
+	diff, err := DiffSnapshots(from, to)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "diff snapshots"))
+		return
+	}
+
+	deepequal.SideBySide(t, "diff", &SnapshotDiff{
+		From:          types.NewIndex(1, 20000),
+		To:            types.NewIndex(1, 20001),
+		ActiveAdded:   []types.Index{types.NewIndex(1, 20001)},
+		ActiveRemoved: []types.Index{types.NewIndex(1, 1)},
+		SavedRemoved: []SavedSession{
+			{ID: types.NewIndex(1, 4), Repeat: 10},
+		},
+		SavedMoved: []SavedMove{
+			{
+				ID:   types.NewIndex(1, 2),
+				From: SavedSession{ID: types.NewIndex(1, 2), Repeat: 10},
+				To:   SavedSession{ID: types.NewIndex(1, 2), Repeat: 20},
+			},
+		},
+		Descriptors: []DescriptorChange{
+			{
+				Old: &FileDescriptor{
+					Kind:    FileKindLog,
+					ID:      types.NewIndex(1, 0),
+					Current: true,
+					First:   types.NewIndex(1, 0),
+					Last:    types.NewIndex(1, 20),
+				},
+				New: &FileDescriptor{
+					Kind:    FileKindLog,
+					ID:      types.NewIndex(1, 0),
+					Current: true,
+					First:   types.NewIndex(1, 0),
+					Last:    types.NewIndex(1, 20001),
+				},
+			},
+			{
+				New: &FileDescriptor{
+					Kind: FileKindSource,
+					ID:   types.NewIndex(1, 20000),
+					Len:  200,
+				},
+			},
+		},
+	}, diff)
+}
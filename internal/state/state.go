@@ -1,6 +1,9 @@
 package state
 
-import "github.com/sirkon/mpy6a/internal/types"
+import (
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
 
 // State состояние.
 type State struct {
@@ -8,8 +11,27 @@ type State struct {
 	prevID types.Index
 	repeat uint64
 
-	saved  *rbTree
-	active activeSessions
+	// resolution разрешение времени повтора во всех данных состояния.
+	resolution types.RepeatResolution
+
+	saved    *rbTree
+	flushing *rbTree // Сессии сбрасываемые на диск в данный момент.
+	active   activeSessions
+	limits   MemoryLimits
+
+	// Отменённые сессии из источников и поиск сессий в источниках.
+	// Надгробие хранится, пока его запись есть в памяти или в
+	// источниках из cancelledIn: слияние с источником отменённой копии
+	// удаляет обе, см. SourcesMerged.
+	cancelled   sourceio.Tombstones
+	cancelledIn map[sourceio.Tombstone][]tombstoneRecord
+	sources     SessionLocator
+	index       sessionIndex // Необязательный индекс сессий, см. EnableSessionIndex.
+
+	// Сглаживание всплесков повтора: ограничение выдачи и разброс
+	// времени повтора при сохранении в делениях разрешения.
+	release *ReleaseLimiter
+	jitter  uint64
 
 	systime types.TimeAtomic
 }
@@ -0,0 +1,317 @@
+package state
+
+import (
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/sourceio"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+// Виды проверок директории с данными, см. Verify.
+const (
+	// VerifySnapshot чтение последнего слепка.
+	VerifySnapshot = "snapshot"
+
+	// VerifyLogChain непрерывность диапазонов индексов логов операций.
+	VerifyLogChain = "log-chain"
+
+	// VerifyLog чтение всех событий лога операций.
+	VerifyLog = "log"
+
+	// VerifySource чтение всех записей источника.
+	VerifySource = "source"
+
+	// VerifyReplay применение лога операций к слепку.
+	VerifyReplay = "replay"
+
+	// VerifyConsistency согласованность состояния после применения лога.
+	VerifyConsistency = "consistency"
+)
+
+// VerifyCheck результат одной проверки.
+type VerifyCheck struct {
+	// Kind вид проверки.
+	Kind string
+
+	// Name имя проверенного файла, если проверка относится к файлу.
+	Name string
+
+	// Err ошибка проверки, nil если проверка пройдена.
+	Err error
+}
+
+// VerifyReport отчёт о проверке директории с данными.
+type VerifyReport struct {
+	// Snapshot имя файла проверенного слепка.
+	Snapshot string
+
+	// SnapshotID идентификатор состояния в слепке.
+	SnapshotID types.Index
+
+	// LastID идентификатор состояния после применения лога.
+	LastID types.Index
+
+	// Events количество применённых событий лога.
+	Events uint64
+
+	// Checks результаты всех проведённых проверок.
+	Checks []VerifyCheck
+}
+
+// Failed проверка наличия непройденных проверок.
+func (r *VerifyReport) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *VerifyReport) add(kind, name string, err error) bool {
+	r.Checks = append(r.Checks, VerifyCheck{
+		Kind: kind,
+		Name: name,
+		Err:  err,
+	})
+
+	return err == nil
+}
+
+// Verify проверка директории с данными dir без запуска: загружается
+// слепок из лога имён слепков, см. datadir.SnapshotsLogName, проверяется
+// наличие и читаемость всех описанных в нём логов и источников и
+// непрерывность индексов логов, после чего лог операций применяется к
+// слепку. Время повтора сохраняемых сессий без явного его указания
+// задаётся функцией defaultRepeat, см. NewApplier.
+//
+// Найденные нарушения попадают в отчёт, ошибка возвращается только
+// если проверку провести невозможно.
+func Verify(
+	dir string,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*VerifyReport, error) {
+	return VerifyFS(fsio.OS{}, dir, defaultRepeat, logger)
+}
+
+// VerifyFS проверка директории с данными dir файловой системы fsys,
+// см. Verify.
+func VerifyFS(
+	fsys fsio.FS,
+	dir string,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) (*VerifyReport, error) {
+	name, err := LatestSnapshotFS(fsys, dir, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "look for the latest snapshot")
+	}
+
+	report := &VerifyReport{
+		Snapshot: name,
+	}
+
+	s, descs, err := ReadSnapshotFS(fsys, name, MemoryLimits{})
+	if !report.add(VerifySnapshot, name, err) {
+		return report, nil
+	}
+	report.SnapshotID = s.id
+	report.LastID = s.id
+
+	logsOK := verifyLogs(report, dir, descs)
+	srcsOK := verifySources(report, dir, descs)
+	if !logsOK || !srcsOK {
+		// Применять лог к неполным данным бессмысленно.
+		return report, nil
+	}
+
+	if !report.add(VerifyReplay, "", replay(report, s, dir, descs, defaultRepeat, logger)) {
+		return report, nil
+	}
+
+	report.add(VerifyConsistency, "", s.checkConsistency())
+	return report, nil
+}
+
+// verifyLogs проверка цепочки логов операций.
+func verifyLogs(report *VerifyReport, dir string, descs *Descriptors) bool {
+	ok := true
+	chain := descs.logsChain()
+	for i, l := range chain {
+		if i > 0 && !types.IndexFollows(chain[i-1].lastID, l.firstID) {
+			ok = report.add(
+				VerifyLogChain,
+				datadir.LogName(dir, l.id),
+				errors.Wrap(logio.ErrorLogIntegrityCompromised{}, "gap between logs").
+					Stg("previous-log-id", chain[i-1].id).
+					Stg("previous-log-last-id", chain[i-1].lastID).
+					Stg("log-first-id", l.firstID),
+			) && ok
+		}
+
+		name := datadir.LogName(dir, l.id)
+		ok = report.add(VerifyLog, name, verifyLog(descs.FS(), name, l, l == descs.log)) && ok
+	}
+
+	return ok
+}
+
+// verifyLog вычитка всех событий лога l. Для текущего лога последнее
+// событие в описании может быть устаревшим и не проверяется.
+func verifyLog(fsys fsio.FS, name string, l *logDescriptor, current bool) error {
+	it, err := logio.NewReaderFS(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "open log")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = it.Close()
+	}()
+
+	var prev types.Index
+	for it.Next() {
+		id, _, _ := it.Event()
+		switch {
+		case prev.Term == 0:
+			if !types.IndexEqual(id, l.firstID) {
+				return errors.New("first event does not match the descriptor").
+					Stg("first-id", l.firstID).
+					Stg("event-id", id)
+			}
+		case !types.IndexFollows(prev, id):
+			return errors.Wrap(logio.ErrorLogIntegrityCompromised{}, "gap between events").
+				Stg("previous-event-id", prev).
+				Stg("event-id", id)
+		}
+
+		prev = id
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "read events").Stg("last-read-id", prev)
+	}
+
+	if !current && !types.IndexEqual(prev, l.lastID) {
+		return errors.New("last event does not match the descriptor").
+			Stg("last-id", l.lastID).
+			Stg("last-read-id", prev)
+	}
+
+	return nil
+}
+
+// verifySources проверка всех источников, в том числе ещё не удалённых
+// использованных.
+func verifySources(report *VerifyReport, dir string, descs *Descriptors) bool {
+	ok := true
+	for id, src := range descs.srcs {
+		name := datadir.SourceName(dir, id)
+		ok = report.add(VerifySource, name, verifySource(descs.FS(), name, src.len)) && ok
+	}
+	for _, src := range descs.usedSrcs {
+		name := datadir.SourceName(dir, src.id)
+		ok = report.add(VerifySource, name, verifySource(descs.FS(), name, src.len)) && ok
+	}
+
+	return ok
+}
+
+// verifySource проверка источника name файловой системы fsys с длиной
+// length из описания.
+func verifySource(fsys fsio.FS, name string, length uint64) error {
+	stat, err := fsys.Stat(name)
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+	if uint64(stat.Size()) != length {
+		return errors.New("source length does not match the descriptor").
+			Uint64("length", length).
+			Int64("length-actual", stat.Size())
+	}
+
+	f, err := sourceio.OpenFS(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+	defer func() {
+		// Файл открыт только на чтение, ошибка его закрытия не важна.
+		_ = f.Close()
+	}()
+
+	if err := f.Verify(); err != nil {
+		return errors.Wrap(err, "verify source")
+	}
+
+	return nil
+}
+
+// replay применение к состоянию s всех событий лога после него.
+func replay(
+	report *VerifyReport,
+	s *State,
+	dir string,
+	descs *Descriptors,
+	defaultRepeat func(sess *types.Session) uint64,
+	logger func(error),
+) error {
+	return replayLogs(s, dir, descs, defaultRepeat, logger, func(id types.Index, rejected error) error {
+		if rejected != nil {
+			// Отклонение одинаково на всех узлах, но указывает на
+			// недопустимую операцию в логе.
+			return rejected
+		}
+
+		report.LastID = id
+		report.Events++
+		return nil
+	})
+}
+
+// checkConsistency проверка согласованности данных состояния: все
+// сессии появились не позже состояния и не являются одновременно
+// активными и сохранёнными.
+func (s *State) checkConsistency() error {
+	for sid, sess := range s.active {
+		if !types.IndexEqual(sid, sess.ID) {
+			return errors.New("active session is registered under another id").
+				SessionID(sess.ID).
+				Stg("registered-id", sid)
+		}
+		if types.IndexLess(s.id, sess.ChangeID) {
+			return errors.New("active session changed after the state").
+				SessionID(sid).
+				Stg("change-id", sess.ChangeID).
+				Stg("state-id", s.id)
+		}
+	}
+
+	var size int
+	iter := s.saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		size += len(item.Sessions) + len(item.Tombstones)
+		for _, sess := range item.Sessions {
+			if _, ok := s.active[sess.ID]; ok {
+				return errors.New("session is both active and saved").
+					SessionID(sess.ID).
+					Uint64("repeat", item.Repeat)
+			}
+			if types.IndexLess(s.id, sess.ChangeID) {
+				return errors.New("saved session changed after the state").
+					SessionID(sess.ID).
+					Stg("change-id", sess.ChangeID).
+					Stg("state-id", s.id)
+			}
+		}
+	}
+	if size != s.saved.Len() {
+		return errors.New("saved sessions count mismatch").
+			Int("count", s.saved.Len()).
+			Int("count-actual", size)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirkon/deepequal"
+	"github.com/sirkon/mpy6a/internal/datadir"
+	"github.com/sirkon/mpy6a/internal/errors"
+	"github.com/sirkon/mpy6a/internal/fsio"
+	"github.com/sirkon/mpy6a/internal/logio"
+	"github.com/sirkon/mpy6a/internal/logop"
+	"github.com/sirkon/mpy6a/internal/tlog"
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func TestVerify(t *testing.T) {
+	var rec logop.Recorder
+	logID := types.NewIndex(1, 0)
+	snapshotOps := [][]byte{
+		rec.New(1),
+		rec.Record(types.NewIndex(1, 0), []byte("data")),
+		rec.New(2),
+		rec.StorePriority(types.NewIndex(1, 0), 10, 1),
+	}
+
+	type test struct {
+		name   string
+		ops    [][]byte
+		failed []string
+	}
+
+	tests := []test{
+		{
+			name: "consistent",
+			ops: [][]byte{
+				rec.Record(types.NewIndex(1, 2), []byte("more")),
+				rec.Restore(1),
+				rec.Delete(types.NewIndex(1, 0)),
+			},
+		},
+		{
+			name: "no events after snapshot",
+		},
+		{
+			name: "record into a stored session",
+			ops: [][]byte{
+				rec.Store(types.NewIndex(1, 2), 20),
+				rec.Record(types.NewIndex(1, 2), []byte("more")),
+			},
+			failed: []string{VerifyReplay},
+		},
+		{
+			name: "restore too many",
+			ops: [][]byte{
+				rec.Restore(2),
+			},
+			failed: []string{VerifyReplay},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ops := append(append([][]byte{}, snapshotOps...), tt.ops...)
+			lastID := writeOpsLog(t, dir, logID, ops)
+			if t.Failed() {
+				return
+			}
+
+			descs := &Descriptors{
+				srcs: map[types.Index]*srcDescriptor{},
+				log: &logDescriptor{
+					id:      logID,
+					firstID: logID,
+					lastID:  lastID,
+				},
+			}
+			s, err := NewState(types.RepeatSecond, MemoryLimits{})
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "create state"))
+				return
+			}
+			a := NewApplier(s, dir, descs, nil)
+			for i, op := range snapshotOps {
+				if err := a.Apply(types.NewIndex(1, uint64(i)), op); err != nil {
+					tlog.Error(t, errors.Wrap(err, "apply snapshot operation"))
+					return
+				}
+			}
+
+			name, err := s.WriteSnapshot(dir, descs)
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "write snapshot"))
+				return
+			}
+			snaps := logio.NewSnapshots(datadir.SnapshotsLogName(dir), func(err error) {
+				tlog.Log(t, err)
+			})
+			if err := snaps.WriteName(filepath.Base(name)); err != nil {
+				tlog.Error(t, errors.Wrap(err, "write snapshot name"))
+				return
+			}
+
+			report, err := Verify(dir, nil, func(err error) {
+				tlog.Log(t, err)
+			})
+			if err != nil {
+				tlog.Error(t, errors.Wrap(err, "verify data directory"))
+				return
+			}
+
+			var failed []string
+			for _, c := range report.Checks {
+				if c.Err != nil {
+					tlog.Log(t, errors.Wrap(c.Err, c.Kind))
+					failed = append(failed, c.Kind)
+				}
+			}
+			if !deepequal.Equal(tt.failed, failed) {
+				deepequal.SideBySide(t, "failed checks", tt.failed, failed)
+			}
+			if report.Failed() {
+				return
+			}
+
+			if !types.IndexEqual(report.SnapshotID, types.NewIndex(1, 3)) {
+				t.Errorf("unexpected snapshot id %s", report.SnapshotID)
+			}
+			if !types.IndexEqual(report.LastID, lastID) {
+				t.Errorf("replay stopped at %s, expected %s", report.LastID, lastID)
+			}
+			if report.Events != uint64(len(tt.ops)) {
+				t.Errorf("%d events applied, expected %d", report.Events, len(tt.ops))
+			}
+		})
+	}
+}
+
+func TestSnapshotReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewState(types.RepeatMillisecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.id = types.NewIndex(3, 5)
+	sess := types.NewSession(types.NewIndex(3, 1), 2, []byte("active"))
+	s.active[sess.ID] = &sess
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 1), 1, []byte("flushing")))
+	if _, err := s.StartFlush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	s.saved.SaveSessionPriority(10, 1, types.NewSession(types.NewIndex(2, 2), 1, []byte("urgent")))
+	s.saved.SaveTombstone(20, types.NewIndex(1, 1))
+
+	descs := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{},
+		log: &logDescriptor{
+			id:      types.NewIndex(1, 0),
+			firstID: types.NewIndex(1, 0),
+			lastID:  types.NewIndex(3, 5),
+		},
+		usedSrcs: []usedSrc{},
+		usedLogs: []*logDescriptor{},
+	}
+	name, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+	if name != datadir.SnapshotName(dir, s.id) {
+		t.Errorf("unexpected snapshot name %q", name)
+	}
+
+	r, rdescs, err := ReadSnapshot(name, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read snapshot"))
+		return
+	}
+
+	if !types.IndexEqual(r.ID(), s.id) {
+		t.Errorf("unexpected state id %s", r.ID())
+	}
+	if r.Resolution() != types.RepeatMillisecond {
+		t.Errorf("unexpected resolution %s", r.Resolution().Interval())
+	}
+	deepequal.SideBySide(t, "descriptors", descs, rdescs)
+	deepequal.SideBySide(t, "active", s.active, r.active)
+
+	var sessions []prioritySession
+	iter := r.saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			sessions = append(sessions, prioritySession{
+				Repeat:   item.Repeat,
+				Priority: item.Priority(i),
+				ID:       sess.ID,
+			})
+		}
+	}
+	deepequal.SideBySide(t, "saved", []prioritySession{
+		{Repeat: 10, Priority: 1, ID: types.NewIndex(2, 2)},
+		{Repeat: 10, ID: types.NewIndex(2, 1)},
+	}, sessions)
+	if r.saved.Len() != 3 {
+		t.Errorf("unexpected saved tree size %d", r.saved.Len())
+	}
+}
+
+func TestSnapshotMidFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewState(types.RepeatMillisecond, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create state"))
+		return
+	}
+	s.id = types.NewIndex(3, 5)
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 1), 1, []byte("first")))
+	s.saved.SaveSession(20, types.NewSession(types.NewIndex(2, 2), 1, []byte("second")))
+	if _, err := s.StartFlush(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "start flush"))
+		return
+	}
+	s.saved.SaveSession(10, types.NewSession(types.NewIndex(2, 3), 1, []byte("third")))
+	s.saved.SaveSession(20, types.NewSession(types.NewIndex(2, 4), 1, []byte("fourth")))
+	s.saved.SaveSession(30, types.NewSession(types.NewIndex(2, 5), 1, []byte("fifth")))
+	if err := s.Cancel(types.NewIndex(2, 2)); err != nil {
+		tlog.Error(t, errors.Wrap(err, "cancel flushing session"))
+		return
+	}
+
+	descs := &Descriptors{
+		srcs: map[types.Index]*srcDescriptor{},
+		log: &logDescriptor{
+			id:      types.NewIndex(1, 0),
+			firstID: types.NewIndex(1, 0),
+			lastID:  types.NewIndex(3, 5),
+		},
+	}
+	name, err := s.WriteSnapshot(dir, descs)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "write snapshot"))
+		return
+	}
+	r, _, err := ReadSnapshot(name, MemoryLimits{})
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "read snapshot"))
+		return
+	}
+
+	// Сессии из сбрасывавшегося дерева сохранены раньше и идут первыми,
+	// отменённая из него сессия удаляется вместе с надгробием.
+	var sessions []prioritySession
+	var tombstones int
+	iter := r.saved.Iter()
+	for iter.Next() {
+		item := iter.Item()
+		for i, sess := range item.Sessions {
+			sessions = append(sessions, prioritySession{
+				Repeat:   item.Repeat,
+				Priority: item.Priority(i),
+				ID:       sess.ID,
+			})
+		}
+		tombstones += len(item.Tombstones)
+	}
+	deepequal.SideBySide(t, "saved", []prioritySession{
+		{Repeat: 10, ID: types.NewIndex(2, 1)},
+		{Repeat: 10, ID: types.NewIndex(2, 3)},
+		{Repeat: 20, ID: types.NewIndex(2, 4)},
+		{Repeat: 30, ID: types.NewIndex(2, 5)},
+	}, sessions)
+	if tombstones != 0 {
+		t.Errorf("unexpected %d tombstones left in saved tree", tombstones)
+	}
+
+	want := newRBTree()
+	want.SaveSession(10, types.NewSession(types.NewIndex(2, 1), 1, []byte("first")))
+	want.SaveSession(10, types.NewSession(types.NewIndex(2, 3), 1, []byte("third")))
+	want.SaveSession(20, types.NewSession(types.NewIndex(2, 4), 1, []byte("fourth")))
+	want.SaveSession(30, types.NewSession(types.NewIndex(2, 5), 1, []byte("fifth")))
+	if r.saved.Bytes() != want.Bytes() {
+		t.Errorf("unexpected saved tree bytes %d, expected %d", r.saved.Bytes(), want.Bytes())
+	}
+}
+
+// writeOpsLog запись лога id с операциями ops в событиях начиная с id.
+// Возвращает индекс последнего события.
+func writeOpsLog(t *testing.T, dir string, id types.Index, ops [][]byte) types.Index {
+	return writeOpsLogFS(t, fsio.OS{}, dir, id, ops)
+}
+
+func writeOpsLogFS(t *testing.T, fsys fsio.FS, dir string, id types.Index, ops [][]byte) types.Index {
+	w, err := logio.NewWriterFS(fsys, datadir.LogName(dir, id), 512, 128)
+	if err != nil {
+		tlog.Error(t, errors.Wrap(err, "create log writer"))
+		return types.Index{}
+	}
+
+	last := id
+	for i, op := range ops {
+		last = types.NewIndex(id.Term, id.Index+uint64(i))
+		if _, err := w.WriteEvent(last, op); err != nil {
+			tlog.Error(t, errors.Wrap(err, "write event").Stg("event-id", last))
+			return types.Index{}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		tlog.Error(t, errors.Wrap(err, "close log writer"))
+	}
+
+	return last
+}
@@ -53,7 +53,22 @@ func NewSessionRepeatLimitReached(msg ...string) Error {
 	return newEncodedError(CodeSessionRepeatLimitReached, msg...)
 }
 
+// NewMemoryLimitReached ошибка превышения ограничения памяти.
+func NewMemoryLimitReached(msg ...string) Error {
+	return newEncodedError(CodeMemoryLimitReached, msg...)
+}
+
+// NewStorageUnavailable ошибка недоступности записи лога операций.
+func NewStorageUnavailable(msg ...string) Error {
+	return newEncodedError(CodeStorageUnavailable, msg...)
+}
+
 // NewSessionInvalidRequest неправильный запрос.
 func NewSessionInvalidRequest(msg ...string) Error {
 	return newEncodedError(CodeSessionInvalidRequest, msg...)
 }
+
+// NewSessionIDCollision ошибка совпадения идентификаторов сессий.
+func NewSessionIDCollision(msg ...string) Error {
+	return newEncodedError(CodeSessionIDCollision, msg...)
+}
@@ -35,8 +35,22 @@ const (
 	// CodeSessionRepeatLimitReached превышение максимального числа повторов сессии
 	CodeSessionRepeatLimitReached = 2001
 
+	// CodeMemoryLimitReached сохранённые в памяти сессии вместе со сбрасываемыми
+	// на диск превысили бы жёсткое ограничение памяти. Запрос следует повторить
+	// позднее, после окончания сброса.
+	CodeMemoryLimitReached = 3000
+
+	// CodeStorageUnavailable запись лога операций невозможна из-за нехватки
+	// места на диске или ошибки ввода-вывода. Новые сессии не принимаются
+	// до восстановления записи, запрос следует повторить позднее.
+	CodeStorageUnavailable = 3001
+
 	// CodeSessionInvalidRequest недопустимые параметры операции пришедшие от пользователя.
 	CodeSessionInvalidRequest = 4000
+
+	// CodeSessionIDCollision идентификаторы загружаемых сессий совпадают
+	// с идентификаторами существующих сессий или между собой.
+	CodeSessionIDCollision = 4001
 )
 
 func (c ErrorCode) String() string {
@@ -49,8 +63,14 @@ func (c ErrorCode) String() string {
 		return "SESSION_LENGTH_OVERFLOW"
 	case CodeSessionRepeatLimitReached:
 		return "SESSION_REPEAT_LIMIT_REACHED"
+	case CodeMemoryLimitReached:
+		return "MEMORY_LIMIT_REACHED"
+	case CodeStorageUnavailable:
+		return "STORAGE_UNAVAILABLE"
 	case CodeSessionInvalidRequest:
 		return "SESSION_INVALID_REQUEST"
+	case CodeSessionIDCollision:
+		return "SESSION_ID_COLLISION"
 	default:
 		return "UNKNOWN_ERROR"
 	}
@@ -32,22 +32,6 @@ func main() {
 					return nil
 				}
 
-				return h
-			},
-		),
-		// Поддержка типа OptionalRepeat.
-		fenneg.NewTypeHandler(
-			func(ot types.Type) fenneg.TypeHandler {
-				n := digToNamed(ot)
-				if n == nil {
-					return nil
-				}
-
-				h := handlers.NewOptionalRepeat(n)
-				if h == nil {
-					return nil
-				}
-
 				return h
 			},
 		),
@@ -56,22 +40,16 @@ func main() {
 		message.Critical(errors.Wrap(err, "set up type handlers"))
 	}
 
-	const (
-		mpy6aErrs = "github.com/sirkon/mpy6a/internal/errors"
-		logopPkg  = "github.com/sirkon/mpy6a/internal/logop"
-	)
+	const mpy6aErrs = "github.com/sirkon/mpy6a/internal/errors"
 
 	r, err := fenneg.NewRunner(mpy6aErrs, hnlrs)
 	if err != nil {
 		message.Critical(errors.Wrap(err, "set up codegen runner"))
 	}
 
-	olr := r.OpLog().
-		Source(logopPkg, "Logop").
-		Type(logopPkg, "Recorder")
-	if err := olr.Run(); err != nil {
-		message.Critical(errors.Wrap(err, "run Logop/Recorder codegen"))
-	}
+	// Кодирование операций logop поддерживается вручную: fenneg
+	// нумерует операции по алфавиту, а коды уже записанных в логи
+	// операций меняться не должны.
 
 	if err := r.Struct("github.com/sirkon/mpy6a/internal/types", "Session"); err != nil {
 		message.Critical(errors.Wrap(err, "run Session codegen"))
@@ -4,7 +4,6 @@ import "github.com/sirkon/olrgen"
 
 const (
 	mpy6aTypesPkg = "github.com/sirkon/mpy6a/internal/types"
-	mpy6aLogopPkg = "github.com/sirkon/mpy6a/internal/logop"
 	errorsPkg     = "github.com/sirkon/mpy6a/internal/errors"
 )
 
@@ -76,6 +76,17 @@ func IndexLE(a, b Index) bool {
 	return a.Index <= b.Index
 }
 
+// IndexFollows проверка, что next является непосредственно следующим
+// за prev индексом: либо следующим в рамках срока, либо первым
+// в одном из последующих сроков.
+func IndexFollows(prev, next Index) bool {
+	if next.Term == prev.Term {
+		return next.Index == prev.Index+1
+	}
+
+	return next.Term > prev.Term && next.Index == 0
+}
+
 // IndexCmp сравнение левого и правого индексов.
 // Возвращает:
 //   * -1 если левый индекс относится к более раннему событию
@@ -46,6 +46,25 @@ func ExampleIndexDecode() {
 	// 000000000000000c-000000000000000d
 }
 
+func ExampleIndexFollows() {
+	id := types.NewIndex(1, 2)
+
+	fmt.Println(types.IndexFollows(id, types.IndexIncIndex(id)))
+	fmt.Println(types.IndexFollows(id, types.IndexIncTerm(id)))
+	fmt.Println(types.IndexFollows(id, types.NewIndex(3, 0)))
+	fmt.Println(types.IndexFollows(id, types.NewIndex(1, 4)))
+	fmt.Println(types.IndexFollows(id, types.NewIndex(2, 1)))
+	fmt.Println(types.IndexFollows(id, id))
+
+	// Output:
+	// true
+	// true
+	// true
+	// false
+	// false
+	// false
+}
+
 func TestIndexOps(t *testing.T) {
 	t.Run("small-buffer-encode", func(t *testing.T) {
 		defer func() {
@@ -0,0 +1,10 @@
+package types
+
+// Priority приоритет сохранённой сессии среди сессий с одинаковым
+// временем повтора: сессии с большим приоритетом повторяются раньше,
+// при равном приоритете – в порядке сохранения.
+type Priority uint8
+
+// PriorityNormal приоритет по умолчанию, с ним хранились все сессии до
+// появления приоритетов.
+const PriorityNormal Priority = 0
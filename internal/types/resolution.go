@@ -0,0 +1,79 @@
+package types
+
+import (
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/errors"
+)
+
+// RepeatResolution разрешение времени повтора: длительность одного
+// деления. Время повтора – это число делений от начала эпохи Unix.
+type RepeatResolution time.Duration
+
+const (
+	// RepeatSecond время повтора в секундах, так хранились все данные
+	// до появления настраиваемого разрешения.
+	RepeatSecond = RepeatResolution(time.Second)
+
+	// RepeatMillisecond время повтора в миллисекундах.
+	RepeatMillisecond = RepeatResolution(time.Millisecond)
+
+	// RepeatMicrosecond наиболее точное допустимое разрешение: время
+	// повтора в источниках должно умещаться в 55 бит, старшие биты поля
+	// заняты приоритетом и признаком надгробия.
+	RepeatMicrosecond = RepeatResolution(time.Microsecond)
+)
+
+// Check проверка допустимости разрешения: это должен быть делитель
+// секунды, иначе пересчёт из секунд будет неточным, не мельче микросекунды.
+func (r RepeatResolution) Check() error {
+	if r <= 0 || RepeatSecond%r != 0 {
+		return errors.New("repeat resolution must be a divisor of second").
+			Int64("invalid-repeat-resolution", int64(r))
+	}
+
+	if r < RepeatMicrosecond {
+		return errors.New("repeat resolution must not be finer than microsecond").
+			Int64("invalid-repeat-resolution", int64(r))
+	}
+
+	return nil
+}
+
+// Interval длительность деления, с таким периодом следует проверять
+// наступление времени повтора.
+func (r RepeatResolution) Interval() time.Duration {
+	return time.Duration(r)
+}
+
+// Repeat время повтора соответствующее моменту t: последнее
+// наступившее деление.
+func (r RepeatResolution) Repeat(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(r))
+}
+
+// After время повтора через delay после момента t. Округляется вверх,
+// чтобы повтор не наступил раньше срока.
+func (r RepeatResolution) After(t time.Time, delay time.Duration) uint64 {
+	return uint64((t.UnixNano() + int64(delay) + int64(r) - 1) / int64(r))
+}
+
+// Time момент наступления времени повтора repeat.
+func (r RepeatResolution) Time(repeat uint64) time.Time {
+	return time.Unix(0, int64(repeat)*int64(r))
+}
+
+// RepeatConvert перевод времени повтора repeat из разрешения from в to.
+// При переходе к более грубому разрешению время округляется вверх, чтобы
+// повтор не наступил раньше срока.
+func RepeatConvert(repeat uint64, from, to RepeatResolution) uint64 {
+	switch {
+	case from == to:
+		return repeat
+	case from > to:
+		return repeat * uint64(from/to)
+	default:
+		k := uint64(to / from)
+		return (repeat + k - 1) / k
+	}
+}
@@ -0,0 +1,27 @@
+package types_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirkon/mpy6a/internal/types"
+)
+
+func ExampleRepeatConvert() {
+	fmt.Println(types.RepeatConvert(10, types.RepeatSecond, types.RepeatMillisecond))
+	fmt.Println(types.RepeatConvert(10001, types.RepeatMillisecond, types.RepeatSecond))
+	fmt.Println(types.RepeatConvert(10000, types.RepeatMillisecond, types.RepeatSecond))
+	// Output:
+	// 10000
+	// 11
+	// 10
+}
+
+func ExampleRepeatResolution_After() {
+	t := time.Unix(10, int64(500*time.Microsecond))
+	fmt.Println(types.RepeatMillisecond.After(t, 2*time.Millisecond))
+	fmt.Println(types.RepeatSecond.After(t, time.Second))
+	// Output:
+	// 10003
+	// 12
+}
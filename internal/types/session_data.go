@@ -33,6 +33,31 @@ func (d *SessionData) Len() int {
 	return varsize.Len(d.buf) + d.rawlen
 }
 
+// Chunks возвращает количество кусков данных сессии.
+func (d *SessionData) Chunks() int {
+	return len(d.buf)
+}
+
+// Clone копия данных со своим списком кусков, добавление кусков в неё
+// не затрагивает оригинал. Сами куски не изменяются и не копируются.
+func (d *SessionData) Clone() SessionData {
+	return SessionData{
+		buf:    append([][]byte(nil), d.buf...),
+		rawlen: d.rawlen,
+	}
+}
+
+// Size возвращает суммарный размер кусков данных сессии без учёта
+// кодирования.
+func (d *SessionData) Size() int {
+	var res int
+	for _, b := range d.buf {
+		res += len(b)
+	}
+
+	return res
+}
+
 // Encode метод кодирования данных.
 func (d *SessionData) Encode(dst []byte) []byte {
 	dst = binary.AppendUvarint(dst, uint64(len(d.buf)))
@@ -47,6 +72,7 @@ func (d *SessionData) Encode(dst []byte) []byte {
 // Decode метод декодирования данных.
 func (d *SessionData) Decode(src []byte) ([]byte, error) {
 	d.buf = d.buf[:0]
+	d.rawlen = 0
 
 	chunks, res := binary.Uvarint(src)
 	if res <= 0 {
@@ -8,10 +8,11 @@ import (
 
 // Length возвращает длину в uvarint для длины данного слайса.
 func Length(v []byte) int {
-	return (bits.Len64(uint64(len(v))) + 6) / 7
+	return LengthInt(len(v))
 }
 
 // LengthInt возвращает длину в uvarint для данного целого числа.
+// Ноль тоже занимает один байт.
 func LengthInt[T constraints.Integer](v T) int {
-	return (bits.Len64(uint64(v)) + 6) / 7
+	return (bits.Len64(uint64(v)|1) + 6) / 7
 }